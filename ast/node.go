@@ -126,6 +126,11 @@ type (
 		Value string
 	}
 
+	Char struct {
+		Token *token.Token
+		Value byte
+	}
+
 	Not struct {
 		OpToken *token.Token
 		Child   Expr
@@ -209,6 +214,14 @@ type (
 		Left, Right Expr
 	}
 
+	Pipe struct {
+		Arg, Callee Expr
+	}
+
+	Concat struct {
+		Left, Right Expr
+	}
+
 	If struct {
 		IfToken          *token.Token
 		Cond, Then, Else Expr
@@ -266,6 +279,10 @@ type (
 		Array, Index, Assignee Expr
 	}
 
+	StringGet struct {
+		String, Index Expr
+	}
+
 	Match struct {
 		StartToken     *token.Token
 		Target         Expr
@@ -274,6 +291,13 @@ type (
 		EndPos         locerr.Pos
 	}
 
+	Printf struct {
+		Token   *token.Token
+		Format  *String
+		Args    []Expr
+		Sprintf bool
+	}
+
 	Some struct {
 		StartToken *token.Token
 		Child      Expr
@@ -361,6 +385,13 @@ func (e *String) End() locerr.Pos {
 	return e.Token.End
 }
 
+func (e *Char) Pos() locerr.Pos {
+	return e.Token.Start
+}
+func (e *Char) End() locerr.Pos {
+	return e.Token.End
+}
+
 func (e *Not) Pos() locerr.Pos {
 	return e.OpToken.Start
 }
@@ -501,6 +532,20 @@ func (e *Or) End() locerr.Pos {
 	return e.Right.End()
 }
 
+func (e *Pipe) Pos() locerr.Pos {
+	return e.Arg.Pos()
+}
+func (e *Pipe) End() locerr.Pos {
+	return e.Callee.End()
+}
+
+func (e *Concat) Pos() locerr.Pos {
+	return e.Left.Pos()
+}
+func (e *Concat) End() locerr.Pos {
+	return e.Right.End()
+}
+
 func (e *If) Pos() locerr.Pos {
 	return e.IfToken.Start
 }
@@ -574,6 +619,13 @@ func (e *ArrayGet) End() locerr.Pos {
 	return e.Index.End()
 }
 
+func (e *StringGet) Pos() locerr.Pos {
+	return e.String.Pos()
+}
+func (e *StringGet) End() locerr.Pos {
+	return e.Index.End()
+}
+
 func (e *ArrayPut) Pos() locerr.Pos {
 	return e.Array.Pos()
 }
@@ -588,6 +640,16 @@ func (e *Match) End() locerr.Pos {
 	return e.EndPos
 }
 
+func (e *Printf) Pos() locerr.Pos {
+	return e.Token.Start
+}
+func (e *Printf) End() locerr.Pos {
+	if len(e.Args) == 0 {
+		return e.Format.End()
+	}
+	return e.Args[len(e.Args)-1].End()
+}
+
 func (e *Some) Pos() locerr.Pos {
 	return e.StartToken.Start
 }
@@ -666,6 +728,7 @@ func (e *Bool) Name() string      { return "Bool" }
 func (e *Int) Name() string       { return "Int" }
 func (e *Float) Name() string     { return "Float" }
 func (e *String) Name() string    { return fmt.Sprintf("String (%s)", e.Token.Value()) }
+func (e *Char) Name() string      { return fmt.Sprintf("Char (%s)", e.Token.Value()) }
 func (e *Not) Name() string       { return "Not" }
 func (e *Neg) Name() string       { return "Neg" }
 func (e *Add) Name() string       { return "Add" }
@@ -686,6 +749,8 @@ func (e *Greater) Name() string   { return "Greater" }
 func (e *GreaterEq) Name() string { return "GreaterEq" }
 func (e *And) Name() string       { return "And" }
 func (e *Or) Name() string        { return "Or" }
+func (e *Pipe) Name() string      { return "Pipe" }
+func (e *Concat) Name() string    { return "Concat" }
 func (e *If) Name() string        { return "If" }
 func (e *Let) Name() string       { return fmt.Sprintf("Let (%s)", e.Symbol.DisplayName) }
 func (e *VarRef) Name() string    { return fmt.Sprintf("VarRef (%s)", e.Symbol.DisplayName) }
@@ -709,7 +774,14 @@ func (e *ArrayMake) Name() string { return "ArrayCreate" }
 func (e *ArraySize) Name() string { return "ArraySize" }
 func (e *ArrayGet) Name() string  { return "ArrayGet" }
 func (e *ArrayPut) Name() string  { return "ArrayPut" }
+func (e *StringGet) Name() string { return "StringGet" }
 func (e *Match) Name() string     { return fmt.Sprintf("Match (%s)", e.SomeIdent.DisplayName) }
+func (e *Printf) Name() string {
+	if e.Sprintf {
+		return fmt.Sprintf("Sprintf (%q)", e.Format.Value)
+	}
+	return fmt.Sprintf("Printf (%q)", e.Format.Value)
+}
 func (e *Some) Name() string      { return "Some" }
 func (e *None) Name() string      { return "None" }
 func (e *ArrayLit) Name() string  { return fmt.Sprintf("ArrayLit (%d)", len(e.Elems)) }
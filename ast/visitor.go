@@ -78,6 +78,12 @@ func Visit(vis Visitor, e Expr) {
 	case *Or:
 		Visit(v, n.Left)
 		Visit(v, n.Right)
+	case *Pipe:
+		Visit(v, n.Arg)
+		Visit(v, n.Callee)
+	case *Concat:
+		Visit(v, n.Left)
+		Visit(v, n.Right)
 	case *If:
 		Visit(v, n.Cond)
 		Visit(v, n.Then)
@@ -126,10 +132,18 @@ func Visit(vis Visitor, e Expr) {
 		Visit(v, n.Array)
 		Visit(v, n.Index)
 		Visit(v, n.Assignee)
+	case *StringGet:
+		Visit(v, n.String)
+		Visit(v, n.Index)
 	case *Match:
 		Visit(v, n.Target)
 		Visit(v, n.IfSome)
 		Visit(v, n.IfNone)
+	case *Printf:
+		Visit(v, n.Format)
+		for _, e := range n.Args {
+			Visit(v, e)
+		}
 	case *Some:
 		Visit(v, n.Child)
 	case *ArrayLit:
@@ -81,12 +81,17 @@ func (fvg *freeVarsGatherer) exploreInsn(insn *mir.Insn) {
 		fvg.add(val.RHS)
 	case *mir.ArrLen:
 		fvg.add(val.Array)
+	case *mir.StrLoad:
+		fvg.add(val.From)
+		fvg.add(val.Index)
 	case *mir.Some:
 		fvg.add(val.Elem)
 	case *mir.IsSome:
 		fvg.add(val.OptVal)
 	case *mir.DerefSome:
 		fvg.add(val.SomeVal)
+	case *mir.Show:
+		fvg.add(val.Child)
 	case *mir.Fun:
 		make, ok := fvg.transform.replacedFuns[insn]
 		if !ok {
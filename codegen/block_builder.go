@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/rhysd/gocaml/mir"
 	"github.com/rhysd/gocaml/types"
+	"github.com/rhysd/locerr"
 	"llvm.org/llvm/bindings/go/llvm"
 )
 
@@ -27,16 +28,99 @@ func getOpCmpPredicate(op mir.OperatorKind) (llvm.IntPredicate, llvm.FloatPredic
 	}
 }
 
+// Note: Characters are compared as unsigned values (0-255).
+func getUnsignedPredicate(pred llvm.IntPredicate) llvm.IntPredicate {
+	switch pred {
+	case llvm.IntSLT:
+		return llvm.IntULT
+	case llvm.IntSLE:
+		return llvm.IntULE
+	case llvm.IntSGT:
+		return llvm.IntUGT
+	case llvm.IntSGE:
+		return llvm.IntUGE
+	default:
+		return pred
+	}
+}
+
+// Loop to which self tail calls jump instead of calling the function recursively
+type tailRecLoop struct {
+	funName string
+	header  llvm.BasicBlock
+	params  []llvm.Value // PHI nodes for parameters
+}
+
 type blockBuilder struct {
 	*moduleBuilder
 	registers   map[string]llvm.Value
 	unitVal     llvm.Value
 	allocaBlock llvm.BasicBlock
+	tailRec     *tailRecLoop
+	pos         locerr.Pos // Position of the instruction being built
 }
 
 func newBlockBuilder(b *moduleBuilder, allocaBlock llvm.BasicBlock) *blockBuilder {
 	unit := llvm.Undef(b.typeBuilder.unitT)
-	return &blockBuilder{b, map[string]llvm.Value{}, unit, allocaBlock}
+	return &blockBuilder{b, map[string]llvm.Value{}, unit, allocaBlock, nil, locerr.Pos{}}
+}
+
+// Note:
+// After jumping to loop header or returning from function, current basic block is terminated.
+// Following instructions (e.g. 'br' to the end of 'if') are emitted to a new unreachable block.
+// It is removed by LLVM after all.
+func (b *blockBuilder) continueAtUnreachableBlock() {
+	parent := b.builder.GetInsertBlock().Parent()
+	b.builder.SetInsertPointAtEnd(llvm.AddBasicBlock(parent, "tail.unreachable"))
+}
+
+func (b *blockBuilder) buildSelfTailCall(args []llvm.Value, retTy llvm.Type) llvm.Value {
+	current := b.builder.GetInsertBlock()
+	for i, phi := range b.tailRec.params {
+		phi.AddIncoming([]llvm.Value{args[i]}, []llvm.BasicBlock{current})
+	}
+	b.builder.CreateBr(b.tailRec.header)
+	b.continueAtUnreachableBlock()
+	return llvm.Undef(retTy)
+}
+
+// buildCall builds a call instruction. Calling convention of the call must match to the callee's.
+func (b *blockBuilder) buildCall(kind mir.AppKind, funVal llvm.Value, args []llvm.Value) llvm.Value {
+	if kind == mir.EXTERNAL_CALL {
+		return b.builder.CreateCall(funVal, args, "")
+	}
+	return b.buildGoCamlCall(funVal, args)
+}
+
+func (b *blockBuilder) buildTailCall(kind mir.AppKind, funVal llvm.Value, args []llvm.Value) llvm.Value {
+	// Note:
+	// Tail call of GoCaml function is guaranteed to be optimized. Tail call of external function
+	// is only a hint. Please see tail_call.go.
+	ret := b.buildCall(kind, funVal, args)
+	ret.SetTailCall(true)
+	if ret.Type().TypeKind() == llvm.VoidTypeKind {
+		b.builder.CreateRet(b.unitVal)
+	} else {
+		b.builder.CreateRet(ret)
+	}
+	// Note:
+	// Return value of this instruction is never used because function was already returned.
+	ty := ret.Type()
+	b.continueAtUnreachableBlock()
+	if ty.TypeKind() == llvm.VoidTypeKind {
+		return b.unitVal
+	}
+	return llvm.Undef(ty)
+}
+
+// buildTupleElem returns the element of tuple value at the index. Unboxed tuple is a struct value
+// and boxed tuple is a pointer to struct.
+func (b *blockBuilder) buildTupleElem(ty types.Type, tplVal llvm.Value, index int, name string) llvm.Value {
+	if isUnboxedTuple(ty) {
+		return b.builder.CreateExtractValue(tplVal, index, name)
+	}
+	p := b.builder.CreateStructGEP(tplVal, index, "")
+	return b.builder.CreateLoad(p, name)
 }
 
 func (b *blockBuilder) resolve(ident string) llvm.Value {
@@ -60,22 +144,6 @@ func (b *blockBuilder) typeOf(ident string) types.Type {
 	panic("Type was not found for ident: " + ident)
 }
 
-func (b *blockBuilder) buildMallocRaw(ty llvm.Type, sizeVal llvm.Value, name string) llvm.Value {
-	mallocVal, ok := b.globalTable["GC_malloc"]
-	if !ok {
-		panic("'GC_malloc' not found. Function protoypes for libgc were not emitted")
-	}
-	allocated := b.builder.CreateCall(mallocVal, []llvm.Value{sizeVal}, "")
-	ptrTy := llvm.PointerType(ty, 0 /*address space*/)
-	return b.builder.CreateBitCast(allocated, ptrTy, name)
-}
-
-func (b *blockBuilder) buildMalloc(ty llvm.Type, name string) llvm.Value {
-	size := b.targetData.TypeAllocSize(ty)
-	sizeVal := llvm.ConstInt(b.typeBuilder.sizeT, size, false /*sign extend*/)
-	return b.buildMallocRaw(ty, sizeVal, name)
-}
-
 func (b *blockBuilder) buildArrayMalloc(ty llvm.Type, numElems llvm.Value, name string) llvm.Value {
 	size := b.targetData.TypeAllocSize(ty)
 	tySizeVal := llvm.ConstInt(b.typeBuilder.sizeT, size, false /*sign extend*/)
@@ -83,6 +151,31 @@ func (b *blockBuilder) buildArrayMalloc(ty llvm.Type, numElems llvm.Value, name
 	return b.buildMallocRaw(ty, sizeVal, name)
 }
 
+// Note:
+// Arrays longer than this are allocated on heap even if they don't escape to avoid stack overflow.
+const maxStackArrayLen = 256
+
+// buildAllocation allocates memory for the value of the allocating instruction 'ident'. When the
+// value never escapes from the function, it is allocated on stack instead of heap.
+func (b *blockBuilder) buildAllocation(ty llvm.Type, ident, name string) llvm.Value {
+	if b.escapes.NotEscaping(ident) {
+		return b.buildAlloca(ty, name)
+	}
+	return b.buildMalloc(ty, name)
+}
+
+func (b *blockBuilder) buildArrayAllocation(ty llvm.Type, numElems llvm.Value, ident, name string) llvm.Value {
+	if b.escapes.NotEscaping(ident) {
+		if c := numElems.IsAConstantInt(); !c.IsNil() {
+			if n := c.SExtValue(); 0 <= n && n <= maxStackArrayLen {
+				arr := b.buildAlloca(llvm.ArrayType(ty, int(n)), name+".stack")
+				return b.builder.CreateBitCast(arr, llvm.PointerType(ty, 0 /*address space*/), name)
+			}
+		}
+	}
+	return b.buildArrayMalloc(ty, numElems, name)
+}
+
 func (b *blockBuilder) buildAlloca(t llvm.Type, name string) llvm.Value {
 	saved := b.builder.GetInsertBlock()
 	b.builder.SetInsertPointAtEnd(b.allocaBlock)
@@ -97,6 +190,16 @@ func (b *blockBuilder) buildAlloca(t llvm.Type, name string) llvm.Value {
 	return alloca
 }
 
+// Note:
+// Values in GoCaml are SSA registers. To make them visible from debuggers even at -opt 0, the value
+// of variable is stored to a stack slot and the slot is declared as the variable, as C compilers do.
+// The slots are promoted to registers by optimizer.
+func (b *blockBuilder) buildDebugVar(ident string, val llvm.Value, varInfo llvm.Metadata) {
+	slot := b.buildAlloca(val.Type(), ident+".dbg")
+	b.builder.CreateStore(val, slot)
+	b.debug.declare(b.builder, slot, varInfo)
+}
+
 func (b *blockBuilder) buildEq(ty types.Type, bin *mir.Binary, lhs, rhs llvm.Value) llvm.Value {
 	icmp, fcmp, name := getOpCmpPredicate(bin.Op)
 
@@ -108,11 +211,11 @@ func (b *blockBuilder) buildEq(ty types.Type, bin *mir.Binary, lhs, rhs llvm.Val
 			i = 0
 		}
 		return llvm.ConstInt(b.typeBuilder.boolT, i, false /*sign extend*/)
-	case *types.Bool, *types.Int:
+	case *types.Bool, *types.Int, *types.Char:
 		return b.builder.CreateICmp(icmp, lhs, rhs, name)
 	case *types.Float:
 		return b.builder.CreateFCmp(fcmp, lhs, rhs, name)
-	case *types.String:
+	case *types.String, *types.Bytes:
 		eqlFun, ok := b.globalTable["__str_equal"]
 		if !ok {
 			panic("__str_equal() not found")
@@ -126,8 +229,8 @@ func (b *blockBuilder) buildEq(ty types.Type, bin *mir.Binary, lhs, rhs llvm.Val
 	case *types.Tuple:
 		cmp := llvm.Value{}
 		for i, elemTy := range ty.Elems {
-			l := b.builder.CreateLoad(b.builder.CreateStructGEP(lhs, i, "tpl.left"), "")
-			r := b.builder.CreateLoad(b.builder.CreateStructGEP(rhs, i, "tpl.right"), "")
+			l := b.buildTupleElem(ty, lhs, i, "tpl.left")
+			r := b.buildTupleElem(ty, rhs, i, "tpl.right")
 			elemCmp := b.buildEq(elemTy, bin, l, r)
 			if cmp.C == nil {
 				cmp = elemCmp
@@ -159,6 +262,8 @@ func (b *blockBuilder) buildLess(val *mir.Binary, lhs, rhs llvm.Value) llvm.Valu
 	switch lty.(type) {
 	case *types.Int:
 		return b.builder.CreateICmp(ipred, lhs, rhs, name)
+	case *types.Char:
+		return b.builder.CreateICmp(getUnsignedPredicate(ipred), lhs, rhs, name)
 	case *types.Float:
 		return b.builder.CreateFCmp(fpred, lhs, rhs, name)
 	default:
@@ -205,30 +310,40 @@ func (b *blockBuilder) buildEqOption(ty *types.Option, bin *mir.Binary, lhs, rhs
 
 func (b *blockBuilder) buildIsSome(optVal llvm.Value, tyVal llvm.Type, ty *types.Option) llvm.Value {
 	switch ty.Elem.(type) {
-	case *types.Int, *types.Bool, *types.Float:
+	case *types.Int, *types.Bool, *types.Float, *types.Char:
 		one := llvm.ConstInt(tyVal, 1, false /*signed*/)
 		// Extract flag value
 		flag := b.builder.CreateAnd(optVal, one, "")
 		// flag == 1 means that it contains a value
 		return b.builder.CreateICmp(llvm.IntEQ, flag, one, "issome")
-	case *types.String, *types.Fun, *types.Array:
+	case *types.String, *types.Bytes, *types.Fun, *types.Array:
 		ptr := b.builder.CreateExtractValue(optVal, 0, "")
 		return b.builder.CreateNot(b.builder.CreateIsNull(ptr, ""), "issome")
 	case *types.Tuple:
+		if isUnboxedTuple(ty.Elem) {
+			return b.buildOptionFlag(optVal)
+		}
+		return b.builder.CreateNot(b.builder.CreateIsNull(optVal, ""), "issome")
+	case *types.Buffer:
 		return b.builder.CreateNot(b.builder.CreateIsNull(optVal, ""), "issome")
 	case *types.Option, *types.Unit:
-		flag := b.builder.CreateExtractValue(optVal, 0, "")
-		return b.builder.CreateICmp(
-			llvm.IntEQ,
-			flag,
-			llvm.ConstInt(b.typeBuilder.boolT, 1, false /*signed*/),
-			"issome",
-		)
+		return b.buildOptionFlag(optVal)
 	default:
 		panic("unreachable")
 	}
 }
 
+// buildOptionFlag returns the flag of option value represented as a pair of flag and element.
+func (b *blockBuilder) buildOptionFlag(optVal llvm.Value) llvm.Value {
+	flag := b.builder.CreateExtractValue(optVal, 0, "")
+	return b.builder.CreateICmp(
+		llvm.IntEQ,
+		flag,
+		llvm.ConstInt(b.typeBuilder.boolT, 1, false /*signed*/),
+		"issome",
+	)
+}
+
 func (b *blockBuilder) buildDerefSome(optVal llvm.Value, ty *types.Option) llvm.Value {
 	switch ty.Elem.(type) {
 	case *types.Int:
@@ -250,7 +365,18 @@ func (b *blockBuilder) buildDerefSome(optVal llvm.Value, ty *types.Option) llvm.
 		v := b.builder.CreateLShr(optVal, one, "")
 		// Truncate to the same size bits
 		return b.builder.CreateTrunc(v, b.typeBuilder.boolT, "derefsome")
-	case *types.String, *types.Fun, *types.Array, *types.Tuple:
+	case *types.Char:
+		// shift 1 bit to squash a flag
+		one := llvm.ConstInt(llvm.IntType(9), 1, false /*signed*/)
+		v := b.builder.CreateLShr(optVal, one, "")
+		// Truncate to the same size bits
+		return b.builder.CreateTrunc(v, b.typeBuilder.charT, "derefsome")
+	case *types.String, *types.Bytes, *types.Buffer, *types.Fun, *types.Array:
+		return optVal
+	case *types.Tuple:
+		if isUnboxedTuple(ty.Elem) {
+			return b.builder.CreateExtractValue(optVal, 1, "derefsome")
+		}
 		return optVal
 	case *types.Option, *types.Unit:
 		return b.builder.CreateExtractValue(optVal, 1, "derefsome")
@@ -259,6 +385,207 @@ func (b *blockBuilder) buildDerefSome(optVal llvm.Value, ty *types.Option) llvm.
 	}
 }
 
+func (b *blockBuilder) buildStringConst(str string) llvm.Value {
+	strVal := b.buildAlloca(b.typeBuilder.stringT, "")
+
+	charsVal := b.builder.CreateGlobalStringPtr(str, "")
+	charsPtr := b.builder.CreateStructGEP(strVal, 0, "")
+	b.builder.CreateStore(charsVal, charsPtr)
+
+	sizeVal := llvm.ConstInt(b.typeBuilder.intT, uint64(len(str)), true /*signed*/)
+	sizePtr := b.builder.CreateStructGEP(strVal, 1, "str.size")
+	b.builder.CreateStore(sizeVal, sizePtr)
+
+	return b.builder.CreateLoad(strVal, "str")
+}
+
+// buildArithCheck emits a branch to runtime error when 'failed' is true. Runtime error reports the
+// message with the position of current instruction and never returns.
+func (b *blockBuilder) buildArithCheck(failed llvm.Value, msg string) {
+	parent := b.builder.GetInsertBlock().Parent()
+	errBlock := llvm.AddBasicBlock(parent, "arith.error")
+	okBlock := llvm.AddBasicBlock(parent, "arith.ok")
+	b.builder.CreateCondBr(failed, errBlock, okBlock)
+
+	b.builder.SetInsertPointAtEnd(errBlock)
+	path := "<unknown>"
+	if b.pos.File != nil {
+		path = b.pos.File.Path
+	}
+	b.buildRuntimeCall(
+		"__arith_error",
+		b.buildStringConst(msg),
+		b.buildStringConst(path),
+		llvm.ConstInt(b.typeBuilder.intT, uint64(b.pos.Line), true /*sign extend*/),
+		llvm.ConstInt(b.typeBuilder.intT, uint64(b.pos.Column), true /*sign extend*/),
+	)
+	b.builder.CreateUnreachable()
+
+	b.builder.SetInsertPointAtEnd(okBlock)
+}
+
+// buildCheckedArith builds integer arithmetic with LLVM's overflow intrinsic. When the operation
+// overflows, it causes a runtime error.
+func (b *blockBuilder) buildCheckedArith(op string, lhs, rhs llvm.Value, name string) llvm.Value {
+	ret := b.builder.CreateCall(b.overflowIntrinsic(op), []llvm.Value{lhs, rhs}, name+".ovf")
+	overflow := b.builder.CreateExtractValue(ret, 1, name+".overflow")
+	b.buildArithCheck(overflow, "Integer overflow")
+	return b.builder.CreateExtractValue(ret, 0, name)
+}
+
+// buildDivCheck checks the operands of integer division or modulo. Division by zero is checked
+// when division check is enabled. INT_MIN / -1 is checked when overflow check is enabled. It returns
+// the divisor to be used for the operation.
+func (b *blockBuilder) buildDivCheck(op mir.OperatorKind, lhs, rhs llvm.Value) llvm.Value {
+	intT := b.typeBuilder.intT
+	if b.divCheck {
+		zero := llvm.ConstInt(intT, 0, false /*sign extend*/)
+		isZero := b.builder.CreateICmp(llvm.IntEQ, rhs, zero, "div.iszero")
+		b.buildArithCheck(isZero, "Division by zero")
+	}
+	if !b.ovfCheck {
+		return rhs
+	}
+	minusOne := llvm.ConstAllOnes(intT)
+	if op == mir.MOD {
+		// Note:
+		// INT_MIN mod -1 does not overflow. Its result is 0. But 'srem' instruction causes undefined
+		// behavior (SIGFPE on x86) with the operands. Since x mod -1 is equal to x mod 1 for any x,
+		// -1 is replaced with 1.
+		isMinusOne := b.builder.CreateICmp(llvm.IntEQ, rhs, minusOne, "mod.isminusone")
+		return b.builder.CreateSelect(isMinusOne, llvm.ConstInt(intT, 1, false /*sign extend*/), rhs, "mod.divisor")
+	}
+	min := llvm.ConstInt(intT, uint64(1)<<uint(intT.IntTypeWidth()-1), false /*sign extend*/)
+	isMin := b.builder.CreateICmp(llvm.IntEQ, lhs, min, "div.ismin")
+	isMinusOne := b.builder.CreateICmp(llvm.IntEQ, rhs, minusOne, "div.isminusone")
+	b.buildArithCheck(b.builder.CreateAnd(isMin, isMinusOne, "div.overflow"), "Integer overflow")
+	return rhs
+}
+
+func (b *blockBuilder) buildRuntimeCall(name string, args ...llvm.Value) llvm.Value {
+	funVal, ok := b.globalTable[name]
+	if !ok {
+		panic(name + "() not found")
+	}
+	return b.builder.CreateCall(funVal, args, "")
+}
+
+// buildShowElems renders each element with buildShow() and makes a string array of the results.
+func (b *blockBuilder) buildShowElems(ty *types.Tuple, tplVal llvm.Value) llvm.Value {
+	arr := llvm.Undef(b.typeBuilder.fromMIR(&types.Array{types.StringType}))
+	sizeVal := llvm.ConstInt(b.typeBuilder.intT, uint64(len(ty.Elems)), false /*signed*/)
+	arrPtr := b.buildArrayMalloc(b.typeBuilder.stringT, sizeVal, "show.elems")
+	arr = b.builder.CreateInsertValue(arr, arrPtr, 0, "")
+	arr = b.builder.CreateInsertValue(arr, sizeVal, 1, "")
+
+	for i, elemTy := range ty.Elems {
+		elemVal := b.buildTupleElem(ty, tplVal, i, "")
+		strVal := b.buildShow(elemTy, elemVal)
+		indices := []llvm.Value{llvm.ConstInt(b.typeBuilder.intT, uint64(i), false /*signed*/)}
+		b.builder.CreateStore(strVal, b.builder.CreateInBoundsGEP(arrPtr, indices, ""))
+	}
+
+	return arr
+}
+
+func (b *blockBuilder) buildShowArray(ty *types.Array, arrVal llvm.Value) llvm.Value {
+	sizeVal := b.builder.CreateExtractValue(arrVal, 1, "")
+	elemsPtr := b.builder.CreateExtractValue(arrVal, 0, "")
+
+	strs := llvm.Undef(b.typeBuilder.fromMIR(&types.Array{types.StringType}))
+	strsPtr := b.buildArrayMalloc(b.typeBuilder.stringT, sizeVal, "show.elems")
+	strs = b.builder.CreateInsertValue(strs, strsPtr, 0, "")
+	strs = b.builder.CreateInsertValue(strs, sizeVal, 1, "")
+
+	iterPtr := b.buildAlloca(b.typeBuilder.intT, "show.iter")
+	b.builder.CreateStore(llvm.ConstInt(b.typeBuilder.intT, 0, false), iterPtr)
+
+	parent := b.builder.GetInsertBlock().Parent()
+	condBlock := llvm.AddBasicBlock(parent, "show.arr.cond")
+	loopBlock := llvm.AddBasicBlock(parent, "show.arr.elem")
+	endBlock := llvm.AddBasicBlock(parent, "show.arr.end")
+	b.builder.CreateBr(condBlock)
+	b.builder.SetInsertPointAtEnd(condBlock)
+
+	iterVal := b.builder.CreateLoad(iterPtr, "")
+	compVal := b.builder.CreateICmp(llvm.IntEQ, iterVal, sizeVal, "")
+	b.builder.CreateCondBr(compVal, endBlock, loopBlock)
+
+	b.builder.SetInsertPointAtEnd(loopBlock)
+	elemVal := b.builder.CreateLoad(b.builder.CreateInBoundsGEP(elemsPtr, []llvm.Value{iterVal}, ""), "")
+	// Note: Rendering element may insert new blocks (e.g. nested array)
+	strVal := b.buildShow(ty.Elem, elemVal)
+	b.builder.CreateStore(strVal, b.builder.CreateInBoundsGEP(strsPtr, []llvm.Value{iterVal}, ""))
+	iterVal = b.builder.CreateAdd(iterVal, llvm.ConstInt(b.typeBuilder.intT, 1, false), "show.arr.inc")
+	b.builder.CreateStore(iterVal, iterPtr)
+	b.builder.CreateBr(condBlock)
+
+	endBlock.MoveAfter(b.builder.GetInsertBlock())
+	b.builder.SetInsertPointAtEnd(endBlock)
+
+	return b.buildRuntimeCall("__show_array", strs)
+}
+
+func (b *blockBuilder) buildShowOption(ty *types.Option, optVal llvm.Value) llvm.Value {
+	isSome := b.buildIsSome(optVal, b.typeBuilder.buildOption(ty), ty)
+
+	parent := b.builder.GetInsertBlock().Parent()
+	someBlock := llvm.AddBasicBlock(parent, "show.opt.some")
+	noneBlock := llvm.AddBasicBlock(parent, "show.opt.none")
+	endBlock := llvm.AddBasicBlock(parent, "show.opt.end")
+	b.builder.CreateCondBr(isSome, someBlock, noneBlock)
+
+	b.builder.SetInsertPointAtEnd(someBlock)
+	elemStr := b.buildShow(ty.Elem, b.buildDerefSome(optVal, ty))
+	someVal := b.buildRuntimeCall("__show_some", elemStr)
+	b.builder.CreateBr(endBlock)
+	someLastBlock := b.builder.GetInsertBlock()
+
+	noneBlock.MoveAfter(someLastBlock)
+	b.builder.SetInsertPointAtEnd(noneBlock)
+	noneVal := b.buildStringConst("None")
+	b.builder.CreateBr(endBlock)
+
+	endBlock.MoveAfter(noneBlock)
+	b.builder.SetInsertPointAtEnd(endBlock)
+	phi := b.builder.CreatePHI(b.typeBuilder.stringT, "show.opt")
+	phi.AddIncoming([]llvm.Value{someVal, noneVal}, []llvm.BasicBlock{someLastBlock, noneBlock})
+	return phi
+}
+
+// buildShow builds a string value which represents the given value in OCaml toplevel syntax.
+// The type must be resolved to concrete type by monomorphization.
+func (b *blockBuilder) buildShow(ty types.Type, v llvm.Value) llvm.Value {
+	switch ty := ty.(type) {
+	case *types.Unit:
+		return b.buildStringConst("()")
+	case *types.Bool:
+		return b.buildRuntimeCall("__show_bool", v)
+	case *types.Int:
+		return b.buildRuntimeCall("int_to_str", v)
+	case *types.Float:
+		return b.buildRuntimeCall("__show_float", v)
+	case *types.Char:
+		return b.buildRuntimeCall("__show_char", v)
+	case *types.String:
+		return b.buildRuntimeCall("__show_str", v)
+	case *types.Bytes:
+		return b.buildRuntimeCall("__show_bytes", v)
+	case *types.Buffer:
+		return b.buildStringConst("<abstr>")
+	case *types.Fun:
+		return b.buildStringConst("<fun>")
+	case *types.Tuple:
+		return b.buildRuntimeCall("__show_tuple", b.buildShowElems(ty, v))
+	case *types.Array:
+		return b.buildShowArray(ty, v)
+	case *types.Option:
+		return b.buildShowOption(ty, v)
+	default:
+		panic("FATAL: Cannot show value of type " + ty.String())
+	}
+}
+
 func (b *blockBuilder) buildVal(ident string, val mir.Val) llvm.Value {
 	switch val := val.(type) {
 	case *mir.Unit:
@@ -273,22 +600,18 @@ func (b *blockBuilder) buildVal(ident string, val mir.Val) llvm.Value {
 		return llvm.ConstInt(b.typeBuilder.intT, uint64(val.Const), true /*sign extend*/)
 	case *mir.Float:
 		return llvm.ConstFloat(b.typeBuilder.floatT, val.Const)
+	case *mir.Char:
+		return llvm.ConstInt(b.typeBuilder.charT, uint64(val.Const), false /*sign extend*/)
 	case *mir.String:
-		strVal := b.buildAlloca(b.typeBuilder.stringT, "")
-
-		charsVal := b.builder.CreateGlobalStringPtr(val.Const, "")
-		charsPtr := b.builder.CreateStructGEP(strVal, 0, "")
-		b.builder.CreateStore(charsVal, charsPtr)
-
-		sizeVal := llvm.ConstInt(b.typeBuilder.intT, uint64(len(val.Const)), true /*signed*/)
-		sizePtr := b.builder.CreateStructGEP(strVal, 1, "str.size")
-		b.builder.CreateStore(sizeVal, sizePtr)
-
-		return b.builder.CreateLoad(strVal, "str")
+		return b.buildStringConst(val.Const)
 	case *mir.Unary:
 		child := b.resolve(val.Child)
 		switch val.Op {
 		case mir.NEG:
+			if b.ovfCheck {
+				zero := llvm.ConstInt(b.typeBuilder.intT, 0, false /*sign extend*/)
+				return b.buildCheckedArith("ssub", zero, child, "neg")
+			}
 			return b.builder.CreateNeg(child, "neg")
 		case mir.FNEG:
 			return b.builder.CreateFNeg(child, "fneg")
@@ -302,14 +625,25 @@ func (b *blockBuilder) buildVal(ident string, val mir.Val) llvm.Value {
 		rhs := b.resolve(val.RHS)
 		switch val.Op {
 		case mir.ADD:
+			if b.ovfCheck {
+				return b.buildCheckedArith("sadd", lhs, rhs, "add")
+			}
 			return b.builder.CreateAdd(lhs, rhs, "add")
 		case mir.SUB:
+			if b.ovfCheck {
+				return b.buildCheckedArith("ssub", lhs, rhs, "sub")
+			}
 			return b.builder.CreateSub(lhs, rhs, "sub")
 		case mir.MUL:
+			if b.ovfCheck {
+				return b.buildCheckedArith("smul", lhs, rhs, "mul")
+			}
 			return b.builder.CreateMul(lhs, rhs, "mul")
 		case mir.DIV:
+			rhs = b.buildDivCheck(val.Op, lhs, rhs)
 			return b.builder.CreateSDiv(lhs, rhs, "div")
 		case mir.MOD:
+			rhs = b.buildDivCheck(val.Op, lhs, rhs)
 			return b.builder.CreateSRem(lhs, rhs, "mod")
 		case mir.FADD:
 			return b.builder.CreateFAdd(lhs, rhs, "fadd")
@@ -398,25 +732,53 @@ func (b *blockBuilder) buildVal(ident string, val mir.Val) llvm.Value {
 		}
 
 		for _, a := range val.Args {
-			argVals = append(argVals, b.resolve(a))
+			v := b.resolve(a)
+			if val.Kind == mir.EXTERNAL_CALL {
+				v = b.buildToExternal(v, b.typeOf(a))
+			}
+			argVals = append(argVals, v)
+		}
+
+		// Note:
+		// Unboxed tuple returned from external function must be converted after the call. So the call
+		// cannot be a tail call.
+		retTy := b.typeOf(ident)
+		if val.IsTail && !(val.Kind == mir.EXTERNAL_CALL && isUnboxedTuple(retTy)) {
+			if b.tailRec != nil && b.tailRec.funName == val.Callee && val.Kind != mir.EXTERNAL_CALL {
+				// Skip captures pointer. It is the same as the current function's.
+				params := argVals[len(argVals)-len(val.Args):]
+				return b.buildSelfTailCall(params, b.typeBuilder.fromMIR(b.typeOf(ident)))
+			}
+			return b.buildTailCall(val.Kind, funVal, argVals)
 		}
 
 		// Note:
 		// Call inst cannot have a name when the return type is void.
-		ret := b.builder.CreateCall(funVal, argVals, "")
+		ret := b.buildCall(val.Kind, funVal, argVals)
 		if ret.Type().TypeKind() == llvm.VoidTypeKind {
 			// When returned value is void
 			ret = b.unitVal
 		}
+		if val.Kind == mir.EXTERNAL_CALL {
+			ret = b.buildFromExternal(ret, retTy)
+		}
 		return ret
 	case *mir.Tuple:
+		if ty := b.typeOf(ident); isUnboxedTuple(ty) {
+			tpl := llvm.Undef(b.typeBuilder.fromMIR(ty))
+			for i, e := range val.Elems {
+				tpl = b.builder.CreateInsertValue(tpl, b.resolve(e), i, fmt.Sprintf("%s.%d", ident, i))
+			}
+			return tpl
+		}
+
 		// Note:
-		// Type of tuple is a pointer to struct. To obtain the value for tuple, we need underlying
+		// Type of boxed tuple is a pointer to struct. To obtain the value for tuple, we need underlying
 		// struct type because 'alloca' instruction returns the pointer to allocated memory.
 		ptrTy := b.typeBuilder.fromMIR(b.typeOf(ident))
 		allocTy := ptrTy.ElementType()
 
-		ptr := b.buildMalloc(allocTy, ident)
+		ptr := b.buildAllocation(allocTy, ident, ident)
 		for i, e := range val.Elems {
 			v := b.resolve(e)
 			p := b.builder.CreateStructGEP(ptr, i, fmt.Sprintf("%s.%d", ident, i))
@@ -435,7 +797,7 @@ func (b *blockBuilder) buildVal(ident string, val mir.Val) llvm.Value {
 		arr := llvm.Undef(b.typeBuilder.fromMIR(t))
 
 		sizeVal := b.resolve(val.Size)
-		arrVal := b.buildArrayMalloc(elemTy, sizeVal, "array.ptr")
+		arrVal := b.buildArrayAllocation(elemTy, sizeVal, ident, "array.ptr")
 		arr = b.builder.CreateInsertValue(arr, arrVal, 0, "")
 
 		// Prepare 2nd argument value and iteration variable for the loop
@@ -485,7 +847,7 @@ func (b *blockBuilder) buildVal(ident string, val mir.Val) llvm.Value {
 		}
 
 		elemTy := b.typeBuilder.fromMIR(t.Elem)
-		arrPtr := b.buildArrayMalloc(elemTy, sizeVal, "array.ptr")
+		arrPtr := b.buildArrayAllocation(elemTy, sizeVal, ident, "array.ptr")
 		arr = b.builder.CreateInsertValue(arr, arrPtr, 0, "")
 
 		for i, elem := range val.Elems {
@@ -498,8 +860,7 @@ func (b *blockBuilder) buildVal(ident string, val mir.Val) llvm.Value {
 		return arr
 	case *mir.TplLoad:
 		from := b.resolve(val.From)
-		p := b.builder.CreateStructGEP(from, val.Index, "")
-		return b.builder.CreateLoad(p, "tplload")
+		return b.buildTupleElem(b.typeOf(val.From), from, val.Index, "tplload")
 	case *mir.ArrLoad:
 		fromVal := b.resolve(val.From)
 		idxVal := b.resolve(val.Index)
@@ -517,6 +878,12 @@ func (b *blockBuilder) buildVal(ident string, val mir.Val) llvm.Value {
 	case *mir.ArrLen:
 		fromVal := b.resolve(val.Array)
 		return b.builder.CreateExtractValue(fromVal, 1, "arrsize")
+	case *mir.StrLoad:
+		fromVal := b.resolve(val.From)
+		idxVal := b.resolve(val.Index)
+		charsPtr := b.builder.CreateExtractValue(fromVal, 0, "")
+		charPtr := b.builder.CreateInBoundsGEP(charsPtr, []llvm.Value{idxVal}, "")
+		return b.builder.CreateLoad(charPtr, "strload")
 	case *mir.XRef:
 		ext, ok := b.env.Externals[val.Ident]
 		if !ok {
@@ -529,7 +896,7 @@ func (b *blockBuilder) buildVal(ident string, val mir.Val) llvm.Value {
 			if !ok {
 				panic("Value for external value not found: " + ext.CName)
 			}
-			return b.builder.CreateLoad(x, val.Ident)
+			return b.buildFromExternal(b.builder.CreateLoad(x, val.Ident), ext.Type)
 		}
 
 		// When external function is used as variable, it must be wrapped as closure
@@ -565,7 +932,7 @@ func (b *blockBuilder) buildVal(ident string, val mir.Val) llvm.Value {
 		}
 		b.builder.CreateStore(funPtr, b.builder.CreateStructGEP(closureVal, 0, ""))
 
-		capturesVal := b.buildMalloc(capturesTy, fmt.Sprintf("captures.%s", val.Fun))
+		capturesVal := b.buildAllocation(capturesTy, ident, fmt.Sprintf("captures.%s", val.Fun))
 		for i, v := range val.Vars {
 			ptr := b.builder.CreateStructGEP(capturesVal, i, "")
 			freevar := b.resolve(v)
@@ -588,7 +955,7 @@ func (b *blockBuilder) buildVal(ident string, val mir.Val) llvm.Value {
 		}
 
 		switch ty.Elem.(type) {
-		case *types.Int, *types.Bool:
+		case *types.Int, *types.Bool, *types.Char:
 			tyVal := b.typeBuilder.buildOption(ty)
 			// Extend 1 bit for flag
 			extended := b.builder.CreateZExt(elemVal, tyVal, "")
@@ -603,9 +970,17 @@ func (b *blockBuilder) buildVal(ident string, val mir.Val) llvm.Value {
 			extended := b.builder.CreateZExt(casted, tyVal, "")
 			shifted := b.builder.CreateShl(extended, llvm.ConstInt(tyVal, 1, false /*signed*/), "")
 			return b.builder.CreateOr(shifted, llvm.ConstInt(tyVal, 1, false /*signed*/), "")
-		case *types.String, *types.Fun, *types.Array, *types.Tuple:
+		case *types.String, *types.Bytes, *types.Buffer, *types.Fun, *types.Array:
 			// They use NULL pointer for 'None' value. So nothing to do to make 'Some' value.
 			return elemVal
+		case *types.Tuple:
+			if !isUnboxedTuple(ty.Elem) {
+				return elemVal
+			}
+			v := llvm.Undef(b.typeBuilder.buildOption(ty))
+			v = b.builder.CreateInsertValue(v, llvm.ConstInt(b.typeBuilder.boolT, 1, false), 0, "some.flag")
+			v = b.builder.CreateInsertValue(v, elemVal, 1, "some.elem")
+			return v
 		case *types.Option, *types.Unit:
 			v := llvm.Undef(b.typeBuilder.buildOption(ty))
 			v = b.builder.CreateInsertValue(v, llvm.ConstInt(b.typeBuilder.boolT, 1, false), 0, "some.flag")
@@ -622,14 +997,21 @@ func (b *blockBuilder) buildVal(ident string, val mir.Val) llvm.Value {
 
 		tyVal := b.typeBuilder.buildOption(ty)
 		switch ty.Elem.(type) {
-		case *types.Int, *types.Bool, *types.Float:
+		case *types.Int, *types.Bool, *types.Float, *types.Char:
 			return llvm.ConstInt(tyVal, 0, false)
-		case *types.String, *types.Fun, *types.Array:
+		case *types.String, *types.Bytes, *types.Fun, *types.Array:
 			v := llvm.Undef(tyVal)
 			null := llvm.ConstPointerNull(tyVal.StructElementTypes()[0])
 			v = b.builder.CreateInsertValue(v, null, 0, "none.flag")
 			return v
 		case *types.Tuple:
+			if !isUnboxedTuple(ty.Elem) {
+				return llvm.ConstPointerNull(tyVal)
+			}
+			v := llvm.Undef(tyVal)
+			v = b.builder.CreateInsertValue(v, llvm.ConstInt(b.typeBuilder.boolT, 0, false), 0, "none.flag")
+			return v
+		case *types.Buffer:
 			return llvm.ConstPointerNull(tyVal)
 		case *types.Option, *types.Unit:
 			v := llvm.Undef(b.typeBuilder.buildOption(ty))
@@ -652,6 +1034,8 @@ func (b *blockBuilder) buildVal(ident string, val mir.Val) llvm.Value {
 			panic("Type of DerefSome is not an option type: " + b.typeOf(val.SomeVal).String())
 		}
 		return b.buildDerefSome(optVal, ty)
+	case *mir.Show:
+		return b.buildShow(b.typeOf(val.Child), b.resolve(val.Child))
 	case *mir.NOP:
 		panic("unreachable")
 	default:
@@ -660,11 +1044,21 @@ func (b *blockBuilder) buildVal(ident string, val mir.Val) llvm.Value {
 }
 
 func (b *blockBuilder) buildInsn(insn *mir.Insn) llvm.Value {
+	b.pos = insn.Pos
 	if b.debug != nil {
 		b.debug.setLocation(b.builder, insn.Pos)
 	}
 	v := b.buildVal(insn.Ident, insn.Val)
 	b.registers[insn.Ident] = v
+	if b.debug != nil {
+		// Only variables which appear in source have display names. Temporary registers are ignored.
+		if name, ok := b.env.DisplayNames[insn.Ident]; ok {
+			// Note: Debug location may be changed while building nested blocks of 'if'
+			b.debug.setLocation(b.builder, insn.Pos)
+			info := b.debug.localVarInfo(name, b.typeOf(insn.Ident), insn.Pos.Line)
+			b.buildDebugVar(insn.Ident, v, info)
+		}
+	}
 	return v
 }
 
@@ -1,6 +1,7 @@
 package codegen
 
 import (
+	"github.com/rhysd/gocaml/mangle"
 	"github.com/rhysd/gocaml/types"
 	"github.com/rhysd/locerr"
 	"llvm.org/llvm/bindings/go/llvm"
@@ -40,8 +41,8 @@ func newSizeTable(tb *typeBuilder, data llvm.TargetData) *sizeTable {
 
 func (sizes *sizeTable) calcSize(t types.Type) sizeEntry {
 	ty := sizes.typeBuilder.fromMIR(t)
-	if _, ok := t.(*types.Tuple); ok {
-		// Tuple is managed by GC with pointer. What we want is size of actual allocated type, not a pointer.
+	if _, ok := t.(*types.Tuple); ok && !isUnboxedTuple(t) {
+		// Boxed tuple is managed by GC with pointer. What we want is size of actual allocated type, not a pointer.
 		ty = ty.ElementType()
 	}
 	bits := sizes.data.TypeSizeInBits(ty)
@@ -185,8 +186,12 @@ func (d *debugInfoBuilder) typeInfo(ty types.Type) llvm.Metadata {
 		return d.basicTypeInfo(ty, llvm.DW_ATE_boolean)
 	case *types.Float:
 		return d.basicTypeInfo(ty, llvm.DW_ATE_float)
-	case *types.String:
+	case *types.Char:
+		return d.basicTypeInfo(ty, llvm.DW_ATE_unsigned_char)
+	case *types.String, *types.Bytes:
 		return d.stringInfo
+	case *types.Buffer:
+		return d.voidPtrInfo
 	case *types.Unit:
 		size := d.sizes.sizeOf(ty)
 		return d.builder.CreateStructType(d.compileUnit, llvm.DIStructType{
@@ -222,13 +227,30 @@ func (d *debugInfoBuilder) typeInfo(ty types.Type) llvm.Metadata {
 			AlignInBits: size.alignInBits,
 			Elements:    elems,
 		})
+		if isUnboxedTuple(ty) {
+			return allocated
+		}
 		return d.pointerOf(allocated, name)
 	case *types.Option:
 		switch ty := ty.Elem.(type) {
-		case *types.Int, *types.Bool, *types.Float:
+		case *types.Int, *types.Bool, *types.Float, *types.Char:
 			return d.basicTypeInfo(ty, llvm.DW_ATE_unsigned)
-		case *types.String, *types.Fun, *types.Array, *types.Tuple:
+		case *types.String, *types.Bytes, *types.Buffer, *types.Fun, *types.Array:
 			return d.typeInfo(ty)
+		case *types.Tuple:
+			if !isUnboxedTuple(ty) {
+				return d.typeInfo(ty)
+			}
+			// Option of unboxed tuple is a pair of flag and the tuple value as well as option of unit.
+			opt := &types.Option{ty}
+			size := d.sizes.sizeOf(opt)
+			return d.builder.CreateStructType(d.compileUnit, llvm.DIStructType{
+				Name:        opt.String(),
+				File:        d.file,
+				SizeInBits:  size.allocInBits,
+				AlignInBits: size.alignInBits,
+				Elements:    []llvm.Metadata{d.basicTypeInfo(types.BoolType, llvm.DW_ATE_boolean), d.typeInfo(ty)},
+			})
 		case *types.Option, *types.Unit:
 			size := d.sizes.sizeOf(ty)
 			elems := []llvm.Metadata{
@@ -270,10 +292,14 @@ func (d *debugInfoBuilder) setMainFuncInfo(mainfun llvm.Value, line int) {
 func (d *debugInfoBuilder) setFuncInfo(funptr llvm.Value, ty *types.Fun, line int, isClosure bool) {
 	// Note:
 	// All functions are at toplevel, so any function will be never nested in others.
-	name := funptr.Name()
+	sym := funptr.Name()
+	name := sym
+	if demangled, err := mangle.Demangle(sym); err == nil {
+		name = demangled
+	}
 	meta := d.builder.CreateFunction(d.file, llvm.DIFunction{
 		Name:         name,
-		LinkageName:  name,
+		LinkageName:  sym,
 		Line:         line,
 		ScopeLine:    line,
 		Type:         d.funcTypeInfo(ty, isClosure),
@@ -284,6 +310,35 @@ func (d *debugInfoBuilder) setFuncInfo(funptr llvm.Value, ty *types.Fun, line in
 	d.scope = meta
 }
 
+func (d *debugInfoBuilder) localVarInfo(name string, ty types.Type, line int) llvm.Metadata {
+	return d.builder.CreateAutoVariable(d.scope, llvm.DIAutoVariable{
+		Name:           name,
+		File:           d.file,
+		Line:           line,
+		Type:           d.typeInfo(ty),
+		AlwaysPreserve: true,
+	})
+}
+
+// argNo is 1-based index of the parameter. Note that closure's first parameter is captures.
+func (d *debugInfoBuilder) paramVarInfo(name string, ty types.Type, line, argNo int) llvm.Metadata {
+	return d.builder.CreateParameterVariable(d.scope, llvm.DIParameterVariable{
+		Name:           name,
+		File:           d.file,
+		Line:           line,
+		Type:           d.typeInfo(ty),
+		AlwaysPreserve: true,
+		ArgNo:          argNo,
+	})
+}
+
+// declare associates the variable with the stack slot which holds its value. Current debug location
+// of the builder is set to the 'llvm.dbg.declare' call.
+func (d *debugInfoBuilder) declare(b llvm.Builder, slot llvm.Value, varInfo llvm.Metadata) {
+	call := d.builder.InsertDeclareAtEnd(slot, varInfo, d.builder.CreateExpression(nil), b.GetInsertBlock())
+	b.SetInstDebugLocation(call)
+}
+
 func (d *debugInfoBuilder) setLocation(b llvm.Builder, pos locerr.Pos) {
 	scope := d.scope
 	if scope.C == nil {
@@ -6,6 +6,7 @@ package codegen
 
 import (
 	"fmt"
+	"github.com/rhysd/gocaml/common"
 	"github.com/rhysd/gocaml/mir"
 	"github.com/rhysd/gocaml/types"
 	"github.com/rhysd/locerr"
@@ -48,6 +49,39 @@ type EmitOptions struct {
 	// DebugInfo determines to generate debug information or not. If true, debug information will
 	// be added and you can debug the generated executable with debugger like an LLDB.
 	DebugInfo bool
+	// DivisionCheck determines to check division by zero at runtime. If true, integer division
+	// or modulo by zero causes a runtime error with its source location instead of undefined behavior.
+	DivisionCheck bool
+	// OverflowCheck determines to check integer overflow of +, -, * and / at runtime like '-ftrapv'.
+	OverflowCheck bool
+	// Exports is a list of names of toplevel functions exported to C. Exported functions can be
+	// called from C with the same names. They are useful to compile GoCaml code as a library.
+	Exports []string
+	// PositionIndependent determines to emit position independent code. It is necessary to link
+	// the generated object into a shared library.
+	PositionIndependent bool
+	// LinkTimeOptimization determines to link the runtime module (gocamlrt.bc) into the generated
+	// module before optimizations. Small runtime functions such as str_length or int_to_float can
+	// be inlined into GoCaml code.
+	LinkTimeOptimization bool
+	// CPU is a name of target CPU (e.g. "haswell"). Empty string means a generic CPU of the target.
+	// "native" means the CPU of your machine.
+	CPU string
+	// Features is a comma-separated list of target features to enable or disable (e.g. "+avx2,-sse4.1").
+	Features string
+	// RelocModel determines relocation model of generated code.
+	RelocModel RelocModel
+	// CodeModel determines code model of generated code.
+	CodeModel CodeModel
+	// SizeLevel determines how much optimizations reduce code size. 0 means no size reduction, 1 is
+	// equivalent to -Os and 2 is equivalent to -Oz.
+	SizeLevel int
+	// InlineThreshold is a threshold of inliner. 0 means a threshold decided by optimization level
+	// and size level.
+	InlineThreshold int
+	// Passes is a list of names of LLVM passes. When it is not empty, the passes are run in order
+	// instead of the default optimization pipeline. Please see AvailablePasses() for valid names.
+	Passes []string
 }
 
 // Emitter object to emit LLVM IR, object file, assembly or executable.
@@ -59,6 +93,9 @@ type Emitter struct {
 	Module   llvm.Module
 	Machine  llvm.TargetMachine
 	Disposed bool
+	// Timer measures elapsed time of LLVM passes and code generation when it is not nil.
+	Timer   *common.Timer
+	exports []*exportedFun
 }
 
 // Dispose does finalization for internal module and target machine.
@@ -72,41 +109,88 @@ func (emitter *Emitter) Dispose() {
 	emitter.Disposed = true
 }
 
+func (emitter *Emitter) inlineThreshold() int {
+	if emitter.InlineThreshold > 0 {
+		return emitter.InlineThreshold
+	}
+	// Threshold magic numbers came from computeThresholdFromOptLevels() in llvm/lib/Analysis/InlineCost.cpp
+	switch {
+	case emitter.SizeLevel == 1:
+		return 75 // -Os
+	case emitter.SizeLevel >= 2:
+		return 25 // -Oz
+	case emitter.Optimization == OptimizeAggressive:
+		return 275
+	default:
+		// -O1 is the same inline level as -O2
+		return 225
+	}
+}
+
+// addSizeAttrs adds 'optsize' (and 'minsize' for -Oz) attributes to all functions defined in the
+// module. Some passes and code generation refer them to reduce code size.
+func (emitter *Emitter) addSizeAttrs() {
+	ctx := emitter.Module.Context()
+	attrs := []llvm.Attribute{ctx.CreateEnumAttribute(llvm.AttributeKindID("optsize"), 0)}
+	if emitter.SizeLevel >= 2 {
+		attrs = append(attrs, ctx.CreateEnumAttribute(llvm.AttributeKindID("minsize"), 0))
+	}
+	for fun := emitter.Module.FirstFunction(); fun.C != nil; fun = llvm.NextFunction(fun) {
+		if fun.IsDeclaration() {
+			continue
+		}
+		for _, attr := range attrs {
+			fun.AddFunctionAttr(attr)
+		}
+	}
+}
+
 // RunOptimizationPasses passes optimizations on generated LLVM IR module following specified optimization level.
+// When Passes option is specified, the passes are run instead. When Timer is set, elapsed time of
+// each pass is measured.
 func (emitter *Emitter) RunOptimizationPasses() {
+	if len(emitter.Passes) > 0 {
+		emitter.runPasses(emitter.Passes)
+		return
+	}
+
 	if emitter.Optimization == OptimizeNone {
 		return
 	}
 	level := int(emitter.Optimization)
 
+	if emitter.SizeLevel > 0 {
+		emitter.addSizeAttrs()
+	}
+
 	builder := llvm.NewPassManagerBuilder()
 	defer builder.Dispose()
 	builder.SetOptLevel(level)
-
-	// Threshold magic numbers came from computeThresholdFromOptLevels() in llvm/lib/Analysis/InlineCost.cpp
-	threshold := uint(225) // O2
-	if emitter.Optimization == OptimizeAggressive {
-		// -O1 is the same inline level as -O2
-		threshold = 275
-	}
-	builder.UseInlinerWithThreshold(threshold)
+	builder.SetSizeLevel(emitter.SizeLevel)
+	builder.UseInlinerWithThreshold(uint(emitter.inlineThreshold()))
 
 	funcPasses := llvm.NewFunctionPassManagerForModule(emitter.Module)
 	defer funcPasses.Dispose()
 	builder.PopulateFunc(funcPasses)
-	for fun := emitter.Module.FirstFunction(); fun.C != nil; fun = llvm.NextFunction(fun) {
-		if fun.IsDeclaration() {
-			continue
-		}
-		funcPasses.InitializeFunc()
-		funcPasses.RunFunc(fun)
-		funcPasses.FinalizeFunc()
-	}
-
 	modPasses := llvm.NewPassManager()
 	defer modPasses.Dispose()
 	builder.Populate(modPasses)
-	modPasses.Run(emitter.Module)
+
+	measurePasses(emitter.Timer, func() {
+		emitter.Timer.Measure("llvm: function passes", func() {
+			for fun := emitter.Module.FirstFunction(); fun.C != nil; fun = llvm.NextFunction(fun) {
+				if fun.IsDeclaration() {
+					continue
+				}
+				funcPasses.InitializeFunc()
+				funcPasses.RunFunc(fun)
+				funcPasses.FinalizeFunc()
+			}
+		})
+		emitter.Timer.Measure("llvm: module passes", func() {
+			modPasses.Run(emitter.Module)
+		})
+	})
 }
 
 // EmitLLVMIR returns LLVM IR as string.
@@ -116,7 +200,11 @@ func (emitter *Emitter) EmitLLVMIR() string {
 
 // EmitAsm returns assembly code as string.
 func (emitter *Emitter) EmitAsm() (string, error) {
-	buf, err := emitter.Machine.EmitToMemoryBuffer(emitter.Module, llvm.AssemblyFile)
+	var buf llvm.MemoryBuffer
+	var err error
+	emitter.Timer.Measure("llvm: code generation", func() {
+		buf, err = emitter.Machine.EmitToMemoryBuffer(emitter.Module, llvm.AssemblyFile)
+	})
 	if err != nil {
 		return "", err
 	}
@@ -125,9 +213,21 @@ func (emitter *Emitter) EmitAsm() (string, error) {
 	return asm, nil
 }
 
+// EmitBitcode returns LLVM bitcode of the module as byte sequence.
+func (emitter *Emitter) EmitBitcode() []byte {
+	buf := llvm.WriteBitcodeToMemoryBuffer(emitter.Module)
+	bc := buf.Bytes()
+	buf.Dispose()
+	return bc
+}
+
 // EmitObject returns object file contents as byte sequence.
 func (emitter *Emitter) EmitObject() ([]byte, error) {
-	buf, err := emitter.Machine.EmitToMemoryBuffer(emitter.Module, llvm.ObjectFile)
+	var buf llvm.MemoryBuffer
+	var err error
+	emitter.Timer.Measure("llvm: code generation", func() {
+		buf, err = emitter.Machine.EmitToMemoryBuffer(emitter.Module, llvm.ObjectFile)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -137,34 +237,90 @@ func (emitter *Emitter) EmitObject() ([]byte, error) {
 }
 
 // EmitExecutable creates executable file with specified name. This is the final result of compilation!
-func (emitter *Emitter) EmitExecutable(executable string) (err error) {
-	objfile := fmt.Sprintf("%s.tmp.o", executable)
-	obj, err := emitter.EmitObject()
+// Additional object files and libraries in inputs are linked together.
+func (emitter *Emitter) EmitExecutable(executable string, inputs ...string) (err error) {
+	objfile, err := emitter.emitTempObject(executable)
 	if err != nil {
 		return
 	}
-	if err = ioutil.WriteFile(objfile, obj, 0666); err != nil {
-		return
-	}
 	defer os.Remove(objfile)
 	linker := newDefaultLinker(emitter.LinkerFlags)
-	err = linker.link(executable, []string{objfile})
+	err = linker.link(executable, append([]string{objfile}, inputs...))
 	// Linker link runtime and make an executable
 	return
 }
 
+func (emitter *Emitter) emitTempObject(output string) (string, error) {
+	objfile := fmt.Sprintf("%s.tmp.o", output)
+	obj, err := emitter.EmitObject()
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(objfile, obj, 0666); err != nil {
+		return "", err
+	}
+	return objfile, nil
+}
+
+// EmitStaticLibrary creates a static library file with specified name. The library contains the
+// runtime. So C programs can use it only by linking it and libgc. Exported functions can be called
+// after calling gocaml_init(). Additional object files in objs are also added to the library.
+func (emitter *Emitter) EmitStaticLibrary(lib string, objs ...string) error {
+	objfile, err := emitter.emitTempObject(lib)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(objfile)
+	return archive(lib, append([]string{objfile}, objs...))
+}
+
+// EmitSharedLibrary creates a shared library file with specified name. Code must be emitted with
+// PositionIndependent option. Additional object files and libraries in inputs are linked together.
+func (emitter *Emitter) EmitSharedLibrary(lib string, inputs ...string) error {
+	objfile, err := emitter.emitTempObject(lib)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(objfile)
+	linker := newDefaultLinker(emitter.LinkerFlags)
+	return linker.linkShared(lib, append([]string{objfile}, inputs...))
+}
+
+// LinkExecutable links object files and libraries which were compiled in advance with the runtime
+// into an executable. One of the objects must be compiled from GoCaml source.
+func LinkExecutable(executable string, inputs []string, ldflags string) error {
+	return newDefaultLinker(ldflags).link(executable, inputs)
+}
+
 // NewEmitter creates new emitter object.
 func NewEmitter(prog *mir.Program, env *types.Env, src *locerr.Source, opts EmitOptions) (*Emitter, error) {
+	if err := checkPasses(opts.Passes); err != nil {
+		return nil, err
+	}
+
+	exports, err := findExports(prog, env, opts.Exports)
+	if err != nil {
+		return nil, err
+	}
+
 	builder, err := newModuleBuilder(env, src, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	if err = builder.build(prog); err != nil {
+	if err = builder.build(prog, exports); err != nil {
 		return nil, err
 	}
 	defer builder.dispose()
 
+	if opts.LinkTimeOptimization {
+		if err := linkRuntimeModule(builder.module); err != nil {
+			builder.module.Dispose()
+			builder.machine.Dispose()
+			return nil, err
+		}
+	}
+
 	return &Emitter{
 		opts,
 		prog,
@@ -173,5 +329,7 @@ func NewEmitter(prog *mir.Program, env *types.Env, src *locerr.Source, opts Emit
 		builder.module,
 		builder.machine,
 		false,
+		nil,
+		exports,
 	}, nil
 }
@@ -1,17 +1,34 @@
 package codegen
 
 import (
+	"bytes"
 	"github.com/rhysd/gocaml/closure"
+	"github.com/rhysd/gocaml/common"
 	"github.com/rhysd/gocaml/sema"
 	"github.com/rhysd/gocaml/syntax"
 	"github.com/rhysd/locerr"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
 )
 
 func testCreateEmitter(code string, optimize OptLevel, debug bool) (e *Emitter, err error) {
+	return testCreateEmitterWithOptions(code, EmitOptions{Optimization: optimize, DebugInfo: debug})
+}
+
+func testCreateEmitterWithOptions(code string, opts EmitOptions) (e *Emitter, err error) {
+	e, err = testCreateUnoptimizedEmitter(code, opts)
+	if err != nil {
+		return
+	}
+	e.RunOptimizationPasses()
+	return
+}
+
+// testCreateUnoptimizedEmitter creates an emitter without running optimization passes.
+func testCreateUnoptimizedEmitter(code string, opts EmitOptions) (e *Emitter, err error) {
 	s := locerr.NewDummySource(code)
 	ast, err := syntax.Parse(s)
 	if err != nil {
@@ -22,12 +39,7 @@ func testCreateEmitter(code string, optimize OptLevel, debug bool) (e *Emitter,
 		return
 	}
 	prog := closure.Transform(ir)
-	opts := EmitOptions{optimize, "", "", debug}
 	e, err = NewEmitter(prog, env, s, opts)
-	if err != nil {
-		return
-	}
-	e.RunOptimizationPasses()
 	return
 }
 
@@ -109,7 +121,7 @@ func TestEmitUnoptimizedLLVMIR(t *testing.T) {
 	}
 	defer e.Dispose()
 	ir := e.EmitLLVMIR()
-	if !strings.Contains(ir, `define private i64 @"f$t1"(i64 %"x$t2")`) {
+	if !strings.Contains(ir, `define internal i64 @_GC1f(i64 %"x$t2")`) {
 		t.Fatal("Function 'f' was inlined with OptimizeNone config:", ir)
 	}
 }
@@ -126,6 +138,29 @@ func TestEmitLLVMIRWithDebugInfo(t *testing.T) {
 	}
 }
 
+func TestEmitLLVMIRWithDebugVariables(t *testing.T) {
+	e, err := testCreateEmitter("let a = 10 in let rec f x = let y = x + a in y in println_int (f 42)", OptimizeNone, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Dispose()
+	ir := e.EmitLLVMIR()
+	for _, want := range []string{
+		`!DILocalVariable(name: "a"`,
+		`!DILocalVariable(name: "y"`,
+		// First parameter of closure 'f' is captures
+		`!DILocalVariable(name: "x", arg: 2`,
+		"call void @llvm.dbg.declare",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Fatalf("'%s' is not contained in debug information: %s", want, ir)
+		}
+	}
+	if strings.Contains(ir, `!DILocalVariable(name: "x$t`) {
+		t.Fatalf("Alpha-transformed name is used for variable: %s", ir)
+	}
+}
+
 func TestEmitOptimizedAggressive(t *testing.T) {
 	e, err := testCreateEmitter("let rec f x = x + x in println_int (f 42)", OptimizeAggressive, false)
 	if err != nil {
@@ -133,7 +168,7 @@ func TestEmitOptimizedAggressive(t *testing.T) {
 	}
 	defer e.Dispose()
 	ir := e.EmitLLVMIR()
-	if strings.Contains(ir, `define private i64 @"f$t1"(i64 %"x$t2")`) {
+	if strings.Contains(ir, `define internal i64 @_GC1f(i64 %"x$t2")`) {
 		t.Fatalf("Function 'f' was not inlined with OptimizeAggressive config: %s", ir)
 	}
 }
@@ -141,9 +176,11 @@ func TestEmitOptimizedAggressive(t *testing.T) {
 func TestEmitIRContainingExternalSymbols(t *testing.T) {
 	code := `
 	external f: int -> unit = "c_f";
+	external g: int -> bool -> bool = "c_g";
+	external h: char -> char = "c_h";
 	external x: int = "c_x";
 	external y: int = "c_y";
-	x; y; f (x + y)`
+	x; y; f (x + y); println_bool (g x true); print_char (h 'a')`
 	e, err := testCreateEmitter(code, OptimizeDefault, true)
 	if err != nil {
 		t.Fatal(err)
@@ -151,6 +188,9 @@ func TestEmitIRContainingExternalSymbols(t *testing.T) {
 	defer e.Dispose()
 	ir := e.EmitLLVMIR()
 	expects := []string{
+		"declare zeroext i1 @c_g(i64, i1 zeroext)",
+		"declare zeroext i8 @c_h(i8 zeroext)",
+		"declare void @print_char(i8 zeroext)",
 		"@c_x = external local_unnamed_addr global i64",
 		"@c_y = external local_unnamed_addr global i64",
 		"declare void @c_f(i64)",
@@ -184,3 +224,283 @@ func TestDisposeEmitter(t *testing.T) {
 	// Do not crash when it's called twice
 	e.Dispose()
 }
+
+func TestLinkTimeOptimization(t *testing.T) {
+	code := "println_float (int_to_float (Array.length argv)); println_int (str_length argv.(0))"
+	opts := EmitOptions{Optimization: OptimizeDefault, LinkTimeOptimization: true}
+	e, err := testCreateEmitterWithOptions(code, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Dispose()
+
+	ir := e.EmitLLVMIR()
+	if !regexp.MustCompile(`define .*@int_to_float\(`).MatchString(ir) {
+		t.Fatalf("Runtime was not linked to the module: %s", ir)
+	}
+	if regexp.MustCompile(`call .*@int_to_float\(`).MatchString(ir) {
+		t.Fatalf("Runtime function was not inlined: %s", ir)
+	}
+	// str_length receives a struct by value. clang coerces it into separate parameters following C ABI
+	if regexp.MustCompile(`call .*@str_length`).MatchString(ir) {
+		t.Fatalf("Runtime function receiving a struct was not inlined: %s", ir)
+	}
+	if strings.Contains(ir, "@str_length.adapter") {
+		t.Fatalf("Adapter for runtime function should be removed after inlining: %s", ir)
+	}
+
+	outfile, err := filepath.Abs("__test_lto_a.out")
+	if err != nil {
+		panic(err)
+	}
+	if err := e.EmitExecutable(outfile); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(outfile)
+}
+
+func TestExplicitPasses(t *testing.T) {
+	code := "let rec f x = let y = x + 1 in y * 2 in println_int (f 42)"
+	opts := EmitOptions{Optimization: OptimizeNone, Passes: []string{"inline", "instcombine", "simplifycfg"}}
+	e, err := testCreateUnoptimizedEmitter(code, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Dispose()
+	before := e.EmitLLVMIR()
+	e.Timer = common.NewTimer()
+	e.RunOptimizationPasses()
+	after := e.EmitLLVMIR()
+	if before == after {
+		t.Fatalf("IR was not changed by passes: %s", after)
+	}
+	if strings.Count(after, "call ") >= strings.Count(before, "call ") {
+		t.Errorf("Call of f was not inlined by 'inline' pass: %s", after)
+	}
+	var buf bytes.Buffer
+	e.Timer.Report(&buf)
+	for _, p := range opts.Passes {
+		if !strings.Contains(buf.String(), "llvm: "+p) {
+			t.Errorf("Time of pass '%s' is not reported: %s", p, buf.String())
+		}
+	}
+}
+
+func TestTimeDefaultPasses(t *testing.T) {
+	code := "let rec f x = let y = x + 1 in y * 2 in println_int (f 42)"
+	opts := EmitOptions{Optimization: OptimizeDefault}
+	e, err := testCreateUnoptimizedEmitter(code, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Dispose()
+	e.Timer = common.NewTimer()
+	e.RunOptimizationPasses()
+	var buf bytes.Buffer
+	e.Timer.Report(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		"llvm: function passes",
+		"llvm: module passes",
+		"Pass execution timing report",
+		"Function Integration/Inlining",
+		"Combine redundant instructions",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("'%s' is not contained in time report: %s", want, out)
+		}
+	}
+}
+
+func TestUnknownPass(t *testing.T) {
+	opts := EmitOptions{Optimization: OptimizeNone, Passes: []string{"instcombine", "unknown-pass"}}
+	_, err := testCreateEmitterWithOptions("println_int 42", opts)
+	if err == nil {
+		t.Fatal("Error did not occur")
+	}
+	if !strings.Contains(err.Error(), "Unknown LLVM pass 'unknown-pass'") {
+		t.Fatal("Unexpected error:", err)
+	}
+}
+
+func TestOptimizeSize(t *testing.T) {
+	for _, tc := range []struct {
+		level int
+		attr  string
+	}{
+		{1, "optsize"},
+		{2, "minsize"},
+	} {
+		opts := EmitOptions{Optimization: OptimizeDefault, SizeLevel: tc.level}
+		e, err := testCreateEmitterWithOptions("let rec f x = x + x in println_int (f 42)", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ir := e.EmitLLVMIR()
+		e.Dispose()
+		if !strings.Contains(ir, tc.attr) {
+			t.Errorf("Attribute '%s' is not contained with size level %d: %s", tc.attr, tc.level, ir)
+		}
+	}
+}
+
+func TestInlineThreshold(t *testing.T) {
+	for _, tc := range []struct {
+		opt       OptLevel
+		size      int
+		threshold int
+		want      int
+	}{
+		{OptimizeDefault, 0, 0, 225},
+		{OptimizeLess, 0, 0, 225},
+		{OptimizeAggressive, 0, 0, 275},
+		{OptimizeDefault, 1, 0, 75},
+		{OptimizeDefault, 2, 0, 25},
+		{OptimizeAggressive, 0, 500, 500},
+	} {
+		e := &Emitter{}
+		e.Optimization = tc.opt
+		e.SizeLevel = tc.size
+		e.InlineThreshold = tc.threshold
+		if have := e.inlineThreshold(); have != tc.want {
+			t.Errorf("Wanted threshold %d but got %d (opt=%d, size=%d, threshold=%d)", tc.want, have, tc.opt, tc.size, tc.threshold)
+		}
+	}
+}
+
+func TestExportFunctions(t *testing.T) {
+	code := `
+	let rec add x y = x + y in
+	let rec greet s = println_str ("hello, " ^ s) in
+	let rec len (a: int array) = Array.length a in
+	let rec helper x = x * 2 in
+	println_int (helper (add 1 2))`
+	opts := EmitOptions{Optimization: OptimizeNone, Exports: []string{"greet", "add", "len"}, PositionIndependent: true}
+	e, err := testCreateEmitterWithOptions(code, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Dispose()
+
+	ir := e.EmitLLVMIR()
+	for _, want := range []string{
+		"define i64 @add(i64 %x, i64 %y)",
+		"define void @greet(%gocaml.string %s)",
+		"define i64 @len({ i64*, i64 } %a)",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("Exported function '%s' is not contained in IR: %s", want, ir)
+		}
+	}
+	if strings.Contains(ir, "@helper(") {
+		t.Errorf("Function not exported should not be visible: %s", ir)
+	}
+
+	header := e.EmitHeader("lib.h")
+	for _, want := range []string{
+		"#define      GOCAML_LIB_H_H_INCLUDED",
+		"#include \"gocaml.h\"",
+		"gocaml_int add(gocaml_int, gocaml_int);\ngocaml_int len(gocaml_array);\nvoid greet(gocaml_string);\n",
+	} {
+		if !strings.Contains(header, want) {
+			t.Errorf("'%s' is not contained in header: %s", want, header)
+		}
+	}
+}
+
+func TestExportError(t *testing.T) {
+	for _, tc := range []struct {
+		what     string
+		code     string
+		export   string
+		expected string
+	}{
+		{
+			"not found",
+			"let rec f x = x in f 42",
+			"g",
+			"Function 'g' to export is not found",
+		},
+		{
+			"nested function",
+			"let rec f x = let rec g y = y in g x in f 42",
+			"g",
+			"Function 'g' to export is not found",
+		},
+		{
+			"closure",
+			"let a = 42 in let rec f x = x + a in f 42",
+			"f",
+			"Exported function must not be a closure",
+		},
+		{
+			"defined twice",
+			"let rec f x = x in let rec f x = x + 1 in f 42",
+			"f",
+			"are defined multiple times at toplevel",
+		},
+		{
+			"unsupported type",
+			"let rec f x = (x + 1, x) in f 42",
+			"f",
+			"cannot be passed to C",
+		},
+		{
+			"invalid C identifier",
+			"let rec 関数 x = x in 関数 42",
+			"関数",
+			"is not a valid C identifier",
+		},
+		{
+			"symbol conflict",
+			"let rec print_int x = x in print_int 42",
+			"print_int",
+			"is already defined",
+		},
+	} {
+		t.Run(tc.what, func(t *testing.T) {
+			opts := EmitOptions{Optimization: OptimizeNone, Exports: []string{tc.export}}
+			_, err := testCreateEmitterWithOptions(tc.code, opts)
+			if err == nil {
+				t.Fatal("Error did not occur")
+			}
+			if msg := err.Error(); !strings.Contains(msg, tc.expected) {
+				t.Fatalf("Unexpected error message. '%s' is not contained in '%s'", tc.expected, msg)
+			}
+		})
+	}
+}
+
+func TestEmitHeader(t *testing.T) {
+	code := `
+	external v: int = "c_v";
+	external f: int * float -> (int * string) option = "c_f";
+	external g: (int -> bool) -> unit = "c_g";
+	external big: int * int * int * int * int -> float option = "c_big";
+	println_int v`
+	e, err := testCreateEmitter(code, OptimizeNone, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Dispose()
+
+	header := e.EmitHeader("ext.h")
+	for _, want := range []string{
+		"typedef unsigned __int128 gocaml_option1;",
+		"typedef struct gocaml_tuple1 {\n    gocaml_int _0;\n    gocaml_int _1;\n    gocaml_int _2;\n    gocaml_int _3;\n    gocaml_int _4;\n} gocaml_tuple1;",
+		"typedef struct gocaml_tuple2 {\n    gocaml_int _0;\n    gocaml_string _1;\n} gocaml_tuple2;",
+		"typedef gocaml_tuple2 *gocaml_option2;",
+		"typedef struct gocaml_tuple3 {\n    gocaml_int _0;\n    gocaml_float _1;\n} gocaml_tuple3;",
+		"typedef struct {\n    gocaml_bool (*fun)(void *, gocaml_int);\n    void *env;\n} gocaml_closure1;",
+		"gocaml_option1 c_big(gocaml_tuple1 *);\ngocaml_option2 c_f(gocaml_tuple3 *);\nvoid c_g(gocaml_closure1);\nextern gocaml_int c_v;\n",
+	} {
+		if !strings.Contains(header, want) {
+			t.Errorf("'%s' is not contained in header: %s", want, header)
+		}
+	}
+	for _, builtin := range []string{"println_int", "gocaml_init", "__str_join"} {
+		if strings.Contains(header, builtin+"(") {
+			t.Errorf("Builtin symbol '%s' should not be declared: %s", builtin, header)
+		}
+	}
+}
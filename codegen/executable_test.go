@@ -1,8 +1,10 @@
 package codegen
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/rhysd/gocaml/closure"
+	"github.com/rhysd/gocaml/mir"
 	"github.com/rhysd/gocaml/sema"
 	"github.com/rhysd/gocaml/syntax"
 	"github.com/rhysd/locerr"
@@ -10,6 +12,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"strings"
 	"testing"
 )
@@ -39,38 +43,223 @@ func TestExecutable(t *testing.T) {
 		if expect == "" {
 			panic(fmt.Sprintf("Expected output file '%s' was not found for code '%s'", outputFile, input))
 		}
-		t.Run(base, func(t *testing.T) {
-			defer func() {
-				err := recover()
+		// Note: Runtime checks must not change results of programs which have no arithmetic error
+		for _, checked := range []bool{false, true} {
+			name := base
+			if checked {
+				name += "_checked"
+			}
+			t.Run(name, func(t *testing.T) {
+				defer func() {
+					err := recover()
+					if err != nil {
+						t.Fatal(err)
+					}
+				}()
+
+				opts := EmitOptions{Optimization: OptimizeDefault, DebugInfo: true, DivisionCheck: checked, OverflowCheck: checked}
+				got := runExecutable(t, input, opts)
+				bytes, err := ioutil.ReadFile(expect)
 				if err != nil {
-					t.Fatal(err)
+					panic(err)
+				}
+				want := ""
+				if len(bytes) > 0 {
+					want = string(bytes[:len(bytes)-1]) // Trim EOL (newline at the end of file)
 				}
-			}()
 
-			s, err := locerr.NewSourceFromFile(input)
-			if err != nil {
-				t.Fatal(err)
-			}
+				if got != want {
+					t.Fatalf("Unexpected output from executable:\n\nGot: '%s'\nWant: '%s'", got, want)
+				}
+			})
+		}
+	}
+}
+
+// buildExecutable compiles the source into an executable with the options and returns its path.
+// Caller must remove the executable.
+func buildExecutable(t *testing.T, s *locerr.Source, name string, opts EmitOptions) string {
+	ast, err := syntax.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, ir, err := sema.SemanticsCheck(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog := closure.Transform(ir)
+	mir.MarkTailCalls(prog)
+
+	emitter, err := NewEmitter(prog, env, s, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer emitter.Dispose()
+	emitter.RunOptimizationPasses()
+	outfile, err := filepath.Abs(fmt.Sprintf("test.%s.a.out", name))
+	if err != nil {
+		panic(err)
+	}
+	if err := emitter.EmitExecutable(outfile); err != nil {
+		t.Fatal(err)
+	}
+	return outfile
+}
+
+// runExecutable compiles the source file into an executable with the options, runs it and returns
+// its output.
+func runExecutable(t *testing.T, input string, opts EmitOptions) string {
+	s, err := locerr.NewSourceFromFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outfile := buildExecutable(t, s, filepath.Base(input), opts)
+	defer os.Remove(outfile)
+
+	out, err := exec.Command(outfile).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
 
+// Tail calls must not consume stack even if optimizations are disabled. tail_call.ml contains deep
+// mutual recursion and recursion via closure call.
+func TestTailCallWithoutOptimization(t *testing.T) {
+	want, err := ioutil.ReadFile("testdata/tail_call.out")
+	if err != nil {
+		panic(err)
+	}
+	opts := EmitOptions{Optimization: OptimizeNone, DebugInfo: true}
+	got := runExecutable(t, "testdata/tail_call.ml", opts)
+	if got != strings.TrimSuffix(string(want), "\n") {
+		t.Fatalf("Unexpected output from executable built with -opt 0:\n\nGot: '%s'\nWant: '%s'", got, want)
+	}
+}
+
+func TestBacktrace(t *testing.T) {
+	symbolizer := "addr2line"
+	if runtime.GOOS == "darwin" {
+		symbolizer = "atos"
+	}
+	if _, err := exec.LookPath(symbolizer); err != nil {
+		t.Skip(symbolizer, "is not available:", err)
+	}
+
+	opts := EmitOptions{Optimization: OptimizeNone, DebugInfo: true}
+	s, err := locerr.NewSourceFromFile("testdata/backtrace.ml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outfile := buildExecutable(t, s, "backtrace", opts)
+	defer os.Remove(outfile)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(outfile)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err, stderr.String())
+	}
+
+	// Frames should be demangled and have source locations resolved from debug information
+	out := stderr.String()
+	re := regexp.MustCompile(`#\d+ main at .*backtrace\.ml:\d+`)
+	if !strings.HasPrefix(out, "Backtrace:\n") || !re.MatchString(out) {
+		t.Fatalf("Backtrace should contain a demangled frame with its source location but got '%s'", out)
+	}
+}
+
+// Crash handler must output demangled function names and their source locations even in signal
+// handler.
+func TestCrashBacktrace(t *testing.T) {
+	symbolizer := "addr2line"
+	if runtime.GOOS == "darwin" {
+		symbolizer = "atos"
+	}
+	if _, err := exec.LookPath(symbolizer); err != nil {
+		t.Skip(symbolizer, "is not available:", err)
+	}
+
+	dir, err := ioutil.TempDir("", "gocaml-crash-test")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "crash.ml")
+	code := `external send_signal: int -> int = "raise";
+let rec crash n =
+  let _ = send_signal 11 (* SIGSEGV *) in
+  n + 1
+in
+println_int (crash 1)
+`
+	if err := ioutil.WriteFile(file, []byte(code), 0644); err != nil {
+		panic(err)
+	}
+	s, err := locerr.NewSourceFromFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := EmitOptions{Optimization: OptimizeNone, DebugInfo: true}
+	outfile := buildExecutable(t, s, "crash", opts)
+	defer os.Remove(outfile)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(outfile)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err == nil {
+		t.Fatal("Executable should crash but succeeded:", stderr.String())
+	}
+
+	out := stderr.String()
+	if !strings.HasPrefix(out, "Fatal error: Segmentation fault\nBacktrace:\n") {
+		t.Fatalf("Crash should be reported with its signal but got '%s'", out)
+	}
+	for _, re := range []*regexp.Regexp{
+		regexp.MustCompile(`#\d+ crash at .*crash\.ml:3`),
+		regexp.MustCompile(`#\d+ main at .*crash\.ml:\d+`),
+	} {
+		if !re.MatchString(out) {
+			t.Fatalf("Backtrace on crash should match to '%s' but got '%s'", re.String(), out)
+		}
+	}
+}
+
+func TestArithmeticChecks(t *testing.T) {
+	for _, tc := range []struct {
+		what string
+		code string
+		msg  string
+	}{
+		{"division by zero", "let x = 10 in let y = x - 10 in println_int (x / y)", "Division by zero"},
+		{"modulo by zero", "let x = 10 in let y = x - 10 in println_int (x mod y)", "Division by zero"},
+		{"addition overflow", "let x = 9223372036854775807 in println_int (x + 1)", "Integer overflow"},
+		{"subtraction overflow", "let x = -9223372036854775807 in println_int (x - 2)", "Integer overflow"},
+		{"multiplication overflow", "let x = 4611686018427387904 in println_int (x * 2)", "Integer overflow"},
+		{"negation overflow", "let x = -9223372036854775807 - 1 in println_int (-x)", "Integer overflow"},
+		{"division overflow", "let x = -9223372036854775807 - 1 in println_int (x / (-1))", "Integer overflow"},
+	} {
+		t.Run(tc.what, func(t *testing.T) {
+			s := locerr.NewDummySource(tc.code)
 			ast, err := syntax.Parse(s)
 			if err != nil {
 				t.Fatal(err)
 			}
-
 			env, ir, err := sema.SemanticsCheck(ast)
 			if err != nil {
 				t.Fatal(err)
 			}
 			prog := closure.Transform(ir)
 
-			opts := EmitOptions{OptimizeDefault, "", "", true}
+			opts := EmitOptions{Optimization: OptimizeNone, DivisionCheck: true, OverflowCheck: true}
 			emitter, err := NewEmitter(prog, env, s, opts)
 			if err != nil {
 				t.Fatal(err)
 			}
 			defer emitter.Dispose()
-			emitter.RunOptimizationPasses()
-			outfile, err := filepath.Abs(fmt.Sprintf("test.%s.a.out", base))
+			outfile, err := filepath.Abs(fmt.Sprintf("test.arith.%s.a.out", strings.Replace(tc.what, " ", "_", -1)))
 			if err != nil {
 				panic(err)
 			}
@@ -79,22 +268,250 @@ func TestExecutable(t *testing.T) {
 			}
 			defer os.Remove(outfile)
 
-			bytes, err := exec.Command(outfile).Output()
+			out, err := exec.Command(outfile).CombinedOutput()
+			if err == nil {
+				t.Fatalf("Executable should fail but succeeded: %s", out)
+			}
+			want := fmt.Sprintf("Runtime error: %s at <dummy>:1:", tc.msg)
+			if !strings.Contains(string(out), want) {
+				t.Fatalf("Output should contain '%s' but actually it was '%s'", want, out)
+			}
+		})
+	}
+}
+
+// INT_MIN mod -1 is 0. It is not an overflow.
+func TestModuloMinIntByMinusOne(t *testing.T) {
+	s := locerr.NewDummySource("let x = -9223372036854775807 - 1 in let y = 0 - 1 in println_int (x mod y)")
+	opts := EmitOptions{Optimization: OptimizeNone, DivisionCheck: true, OverflowCheck: true}
+	outfile := buildExecutable(t, s, "mod_min_int", opts)
+	defer os.Remove(outfile)
+
+	out, err := exec.Command(outfile).CombinedOutput()
+	if err != nil {
+		t.Fatal(err, string(out))
+	}
+	if string(out) != "0\n" {
+		t.Fatalf("INT_MIN mod -1 should be 0 but got '%s'", out)
+	}
+}
+
+func TestCallbackFromC(t *testing.T) {
+	csrc := `#include "gocaml.h"
+
+typedef struct {
+    gocaml_int (*fun)(void *, gocaml_int);
+    void *env;
+} int_callback;
+
+typedef struct {
+    gocaml_int _0;
+    gocaml_int _1;
+} pair;
+
+typedef struct {
+    void (*fun)(void *, pair *);
+    void *env;
+} pair_callback;
+
+gocaml_int c_apply_twice(int_callback f, gocaml_int i)
+{
+    return gocaml_closure_call(f, gocaml_closure_call(f, i));
+}
+
+void c_each_pair(pair_callback f, gocaml_int n)
+{
+    for (gocaml_int i = 0; i < n; ++i) {
+        pair p = {i, i * i};
+        gocaml_closure_call(f, &p);
+    }
+}
+
+gocaml_bool c_all(gocaml_closure pred, gocaml_int n)
+{
+    for (gocaml_int i = 0; i < n; ++i) {
+        if (gocaml_closure_call_as(gocaml_bool (*)(void *, gocaml_int), pred, i) != 1) {
+            return 0;
+        }
+    }
+    return 1;
+}
+`
+	code := `
+	external apply_twice: (int -> int) -> int -> int = "c_apply_twice";
+	external each_pair: (int * int -> unit) -> int -> unit = "c_each_pair";
+	external all: (int -> bool) -> int -> bool = "c_all";
+	let offset = 10 in
+	let rec add x = x + offset in
+	println_int (apply_twice add 1);
+	let rec print_pair (p: int * int) = let (a, b) = p in print_int a; print_str " "; println_int b in
+	each_pair print_pair 3;
+	println_bool (all (fun x -> x < 3) 3);
+	println_bool (all (fun x -> x < 3) 4)`
+
+	cfile, err := filepath.Abs("test.callback.c")
+	if err != nil {
+		panic(err)
+	}
+	if err := ioutil.WriteFile(cfile, []byte(csrc), 0666); err != nil {
+		panic(err)
+	}
+	defer os.Remove(cfile)
+	objfile := strings.TrimSuffix(cfile, ".c") + ".o"
+	if out, err := exec.Command("clang", "-std=c99", "-Wall", "-I../runtime", "-c", cfile, "-o", objfile).CombinedOutput(); err != nil {
+		t.Fatalf("Failed to compile C source: %s", out)
+	}
+	defer os.Remove(objfile)
+
+	s := locerr.NewDummySource(code)
+	ast, err := syntax.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, ir, err := sema.SemanticsCheck(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog := closure.Transform(ir)
+	mir.MarkTailCalls(prog)
+
+	opts := EmitOptions{Optimization: OptimizeDefault, LinkerFlags: objfile}
+	emitter, err := NewEmitter(prog, env, s, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer emitter.Dispose()
+	emitter.RunOptimizationPasses()
+	outfile, err := filepath.Abs("test.callback.a.out")
+	if err != nil {
+		panic(err)
+	}
+	if err := emitter.EmitExecutable(outfile); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outfile)
+
+	out, err := exec.Command(outfile).CombinedOutput()
+	if err != nil {
+		t.Fatal(err, string(out))
+	}
+	want := "21\n0 0\n1 1\n2 4\ntrue\nfalse\n"
+	if string(out) != want {
+		t.Fatalf("Unexpected output from executable:\n\nGot: '%s'\nWant: '%s'", out, want)
+	}
+}
+
+func TestLibraryCalledFromC(t *testing.T) {
+	code := `
+	let prefix = "Hello, " in
+	let rec add x y = x + y in
+	let rec greet s = println_str ("Hello, " ^ s) in
+	let rec sum (a: int array) =
+		let rec go i acc = if i >= Array.length a then acc else go (i + 1) (acc + a.(i)) in
+		go 0 0
+	in
+	println_str (prefix ^ "init")`
+	csrc := `#define _POSIX_C_SOURCE 200809L
+#include "lib.h"
+#include <signal.h>
+#include <stdio.h>
+#include <string.h>
+
+static void host_handler(int sig)
+{
+    (void) sig;
+}
+
+int main(void)
+{
+    // Library must not replace signal handlers of the host program
+    struct sigaction sa;
+    memset(&sa, 0, sizeof(sa));
+    sa.sa_handler = host_handler;
+    sigaction(SIGSEGV, &sa, NULL);
+    gocaml_init();
+    sigaction(SIGSEGV, NULL, &sa);
+    if (sa.sa_handler != host_handler) {
+        puts("SIGSEGV handler was replaced");
+        return 1;
+    }
+    gocaml_string s = {(int8_t *) "C", 1};
+    greet(s);
+    gocaml_int elems[] = {1, 2, 3, 4};
+    gocaml_array a = {elems, 4};
+    printf("%lld %lld\n", (long long) add(1, 2), (long long) sum(a));
+    return 0;
+}
+`
+	want := "Hello, init\nHello, C\n3 10\n"
+
+	for _, shared := range []bool{false, true} {
+		name := "static"
+		if shared {
+			name = "shared"
+		}
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "gocaml-lib-test")
+			if err != nil {
+				panic(err)
+			}
+			defer os.RemoveAll(dir)
+
+			opts := EmitOptions{
+				Optimization:        OptimizeDefault,
+				Exports:             []string{"add", "greet", "sum"},
+				PositionIndependent: shared,
+			}
+			e, err := testCreateEmitterWithOptions(code, opts)
 			if err != nil {
 				t.Fatal(err)
 			}
-			got := string(bytes)
-			bytes, err = ioutil.ReadFile(expect)
+			defer e.Dispose()
+
+			lib := filepath.Join(dir, "liblib.a")
+			if shared {
+				lib = filepath.Join(dir, "liblib.so")
+				if runtime.GOOS == "darwin" {
+					lib = filepath.Join(dir, "liblib.dylib")
+				}
+				err = e.EmitSharedLibrary(lib)
+			} else {
+				err = e.EmitStaticLibrary(lib)
+			}
 			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(dir, "lib.h"), []byte(e.EmitHeader("lib.h")), 0666); err != nil {
 				panic(err)
 			}
-			want := ""
-			if len(bytes) > 0 {
-				want = string(bytes[:len(bytes)-1]) // Trim EOL (newline at the end of file)
+
+			cfile := filepath.Join(dir, "main.c")
+			if err := ioutil.WriteFile(cfile, []byte(csrc), 0666); err != nil {
+				panic(err)
+			}
+			rtdir, err := filepath.Abs("../runtime")
+			if err != nil {
+				panic(err)
+			}
+			exe := filepath.Join(dir, "a.out")
+			args := []string{"-std=c99", "-Wall", "-I" + rtdir, cfile, lib, "-o", exe, "-L/usr/local/lib", "-L/usr/lib"}
+			if path := detectLibgcPath(); path != "" {
+				args = append(args, "-L"+path)
+			}
+			if shared {
+				args = append(args, "-Wl,-rpath,"+dir)
+			}
+			args = append(args, "-lgc")
+			if out, err := exec.Command("clang", args...).CombinedOutput(); err != nil {
+				t.Fatalf("Failed to link C program against library: %s", out)
 			}
 
-			if got != want {
-				t.Fatalf("Unexpected output from executable:\n\nGot: '%s'\nWant: '%s'", got, want)
+			out, err := exec.Command(exe).CombinedOutput()
+			if err != nil {
+				t.Fatal(err, string(out))
+			}
+			if string(out) != want {
+				t.Fatalf("Unexpected output from C program:\n\nGot: '%s'\nWant: '%s'", out, want)
 			}
 		})
 	}
@@ -129,8 +546,9 @@ func BenchmarkExecutableCreation(b *testing.B) {
 			b.Fatal(err)
 		}
 		prog := closure.Transform(ir)
+		mir.MarkTailCalls(prog)
 
-		opts := EmitOptions{OptimizeDefault, "", "", true}
+		opts := EmitOptions{Optimization: OptimizeDefault, DebugInfo: true}
 		emitter, err := NewEmitter(prog, env, source, opts)
 		if err != nil {
 			b.Fatal(err)
@@ -187,8 +605,9 @@ func TestExamples(t *testing.T) {
 				t.Fatal(err)
 			}
 			prog := closure.Transform(ir)
+			mir.MarkTailCalls(prog)
 
-			opts := EmitOptions{OptimizeDefault, "", "", true}
+			opts := EmitOptions{Optimization: OptimizeDefault, DebugInfo: true}
 			emitter, err := NewEmitter(prog, env, s, opts)
 			if err != nil {
 				t.Fatal(err)
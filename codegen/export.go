@@ -0,0 +1,189 @@
+package codegen
+
+import (
+	"github.com/rhysd/gocaml/mangle"
+	"github.com/rhysd/gocaml/mir"
+	"github.com/rhysd/gocaml/types"
+	"github.com/rhysd/locerr"
+	"llvm.org/llvm/bindings/go/llvm"
+	"sort"
+)
+
+// exportedFun is a toplevel GoCaml function exported to C. Its C-ABI wrapper is emitted as an
+// external symbol with the same name as the function in source.
+type exportedFun struct {
+	cName string
+	insn  mir.FunInsn
+	ty    *types.Fun
+}
+
+func isCIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c == '_', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case '0' <= c && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func hasTypeVar(t types.Type) bool {
+	switch t := t.(type) {
+	case *types.Var:
+		if t.Ref != nil {
+			return hasTypeVar(t.Ref)
+		}
+		return true
+	case *types.Fun:
+		for _, p := range t.Params {
+			if hasTypeVar(p) {
+				return true
+			}
+		}
+		return hasTypeVar(t.Ret)
+	case *types.Tuple:
+		for _, e := range t.Elems {
+			if hasTypeVar(e) {
+				return true
+			}
+		}
+		return false
+	case *types.Array:
+		return hasTypeVar(t.Elem)
+	case *types.Option:
+		return hasTypeVar(t.Elem)
+	default:
+		return false
+	}
+}
+
+// Note:
+// Only types which can be represented with types in gocaml.h are allowed in signatures of exported
+// functions.
+func isExportableType(t types.Type) bool {
+	switch t.(type) {
+	case *types.Unit, *types.Bool, *types.Int, *types.Float, *types.Char, *types.String, *types.Bytes, *types.Buffer, *types.Array:
+		return true
+	default:
+		return false
+	}
+}
+
+func checkExportedType(name string, fun mir.FunInsn, ty *types.Fun) error {
+	if hasTypeVar(ty) {
+		return locerr.ErrorfAt(fun.Pos, "Cannot export polymorphic function '%s' of type '%s'", name, ty.String())
+	}
+	ts := append([]types.Type{ty.Ret}, ty.Params...)
+	for _, t := range ts {
+		if !isExportableType(t) {
+			return locerr.ErrorfAt(fun.Pos, "Cannot export function '%s' because type '%s' in its signature cannot be passed to C", name, t.String())
+		}
+	}
+	return nil
+}
+
+// findExports looks up toplevel functions for the names to export. A toplevel function is a
+// function not nested in other functions. It returns an error when some function can't be exported.
+func findExports(prog *mir.Program, env *types.Env, names []string) ([]*exportedFun, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	// Mangled symbol name of toplevel function does not depend on internal identifiers
+	bySym := make(map[string]mir.FunInsn, len(prog.Toplevel))
+	for _, f := range prog.Toplevel {
+		if sym, ok := env.MangledNames[f.Name]; ok {
+			bySym[sym] = f
+		}
+	}
+
+	exports := make([]*exportedFun, 0, len(names))
+	seen := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+
+		if !isCIdent(name) {
+			return nil, locerr.Errorf("Cannot export '%s' because it is not a valid C identifier", name)
+		}
+		fun, ok := bySym[mangle.Nested("", name, 0)]
+		if !ok {
+			return nil, locerr.Errorf("Function '%s' to export is not found. Only monomorphic functions defined at toplevel can be exported", name)
+		}
+		if _, ok := bySym[mangle.Nested("", name, 1)]; ok {
+			return nil, locerr.ErrorfAt(fun.Pos, "Cannot export '%s' because functions named '%s' are defined multiple times at toplevel", name, name)
+		}
+		if _, ok := prog.Closures[fun.Name]; ok {
+			return nil, locerr.ErrorfAt(fun.Pos, "Cannot export '%s' because it captures variables. Exported function must not be a closure", name)
+		}
+		found, ok := env.DeclTable[fun.Name]
+		if !ok {
+			panic("FATAL: Type of function to export not found: " + fun.Name)
+		}
+		ty, ok := found.(*types.Fun)
+		if !ok {
+			panic("FATAL: Type of function to export is not a function: " + found.String())
+		}
+		if err := checkExportedType(name, fun, ty); err != nil {
+			return nil, err
+		}
+		exports = append(exports, &exportedFun{name, fun, ty})
+	}
+
+	sort.Slice(exports, func(i, j int) bool {
+		return exports[i].cName < exports[j].cName
+	})
+	return exports, nil
+}
+
+// buildExportWrapper emits a wrapper function of the exported function. It has C ABI (the same
+// ABI as external functions) and is visible from outside of the module.
+func (b *moduleBuilder) buildExportWrapper(export *exportedFun) error {
+	if f := b.module.NamedFunction(export.cName); !f.IsNil() {
+		return locerr.ErrorfAt(export.insn.Pos, "Cannot export '%s' because symbol '%s' is already defined", export.cName, export.cName)
+	}
+
+	funVal, ok := b.funcTable[export.insn.Name]
+	if !ok {
+		panic("Function to export not found: " + export.insn.Name)
+	}
+
+	if b.debug != nil {
+		b.debug.clearLocation(b.builder)
+	}
+
+	tyVal := b.typeBuilder.buildExternalFun(export.ty)
+	val := llvm.AddFunction(b.module, export.cName, tyVal)
+	val.SetLinkage(llvm.ExternalLinkage)
+	val.AddFunctionAttr(b.attributes["nounwind"])
+	val.AddFunctionAttr(b.attributes["ssp"])
+	val.AddFunctionAttr(b.attributes["uwtable"])
+	b.addIntExtAttrs(val, export.ty, 0)
+
+	body := b.context.AddBasicBlock(val, "entry")
+	b.builder.SetInsertPointAtEnd(body)
+	args := make([]llvm.Value, 0, len(export.ty.Params))
+	for i, p := range export.ty.Params {
+		param := val.Param(i)
+		if name, ok := b.env.DisplayNames[export.insn.Val.Params[i]]; ok {
+			param.SetName(name)
+		}
+		args = append(args, b.buildFromExternal(param, p))
+	}
+	ret := b.buildGoCamlCall(funVal, args)
+	if export.ty.Ret == types.UnitType {
+		// External function returns void instead of unit
+		b.builder.CreateRetVoid()
+	} else {
+		b.builder.CreateRet(b.buildToExternal(ret, export.ty.Ret))
+	}
+
+	return nil
+}
@@ -0,0 +1,234 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/rhysd/gocaml/types"
+	"sort"
+	"strings"
+)
+
+// Note:
+// headerBuilder builds C declarations which have the same layouts as LLVM types built by typeBuilder.
+// Primitive types, string, bytes, buffer and arrays are mapped to types defined in gocaml.h. Types
+// for tuples, options and closures are generated as typedefs since their layouts depend on their
+// element types. Each typedef is preceded by a comment describing its GoCaml type.
+//
+//   - bool is 'gocaml_bool' when it is passed to or returned from functions. But it is 'uint8_t'
+//     in tuples and options since i1 occupies 1 byte in memory.
+//   - Unboxed tuples are structs stored inline. Boxed tuples are pointers to structs. Both are
+//     passed to and returned from external functions as pointers.
+//   - Options of int and float are i65 in LLVM. They are represented with 'unsigned __int128' (GCC
+//     and Clang extension). Options of bool and char are i2 and i9. Their lowest bits are flags
+//     and elements are stored in the upper bits.
+//   - Options of pointer-like values (string, bytes, buffer, arrays, closures and boxed tuples) have
+//     the same layouts as their elements. 'None' is represented with NULL pointer at their first fields.
+//   - Other options are structs of a flag and an element.
+//   - Closures are callbacks. They are structs of a function pointer and a pointer to environment.
+//     The function receives the environment as its first argument and has the same ABI as external
+//     functions. Please see the closure ABI described in gocaml.h.
+type headerBuilder struct {
+	typedefs bytes.Buffer
+	names    map[string]string
+	counts   map[string]int
+}
+
+func newHeaderBuilder() *headerBuilder {
+	return &headerBuilder{
+		names:  map[string]string{},
+		counts: map[string]int{},
+	}
+}
+
+func (hb *headerBuilder) newTypedef(kind string, t types.Type, decl string) string {
+	hb.counts[kind]++
+	name := fmt.Sprintf("gocaml_%s%d", kind, hb.counts[kind])
+	fmt.Fprintf(&hb.typedefs, "// %s\ntypedef %s;\n\n", t.String(), fmt.Sprintf(decl, name))
+	hb.names[t.String()] = name
+	return name
+}
+
+func (hb *headerBuilder) tupleName(t *types.Tuple) string {
+	if name, ok := hb.names[t.String()]; ok {
+		return name
+	}
+	fields := make([]string, 0, len(t.Elems))
+	for i, e := range t.Elems {
+		fields = append(fields, fmt.Sprintf("    %s _%d;\n", hb.fieldType(e), i))
+	}
+	return hb.newTypedef("tuple", t, "struct %[1]s {\n"+strings.Join(fields, "")+"} %[1]s")
+}
+
+func (hb *headerBuilder) optionName(t *types.Option) string {
+	if name, ok := hb.names[t.String()]; ok {
+		return name
+	}
+	var decl string
+	switch elem := t.Elem.(type) {
+	case *types.Int, *types.Float:
+		decl = "unsigned __int128 %s"
+	case *types.Bool:
+		decl = "uint8_t %s"
+	case *types.Char:
+		decl = "uint16_t %s"
+	case *types.String, *types.Bytes, *types.Buffer, *types.Fun, *types.Array:
+		decl = hb.fieldType(elem) + " %s"
+	case *types.Tuple:
+		if !isUnboxedTuple(elem) {
+			decl = hb.fieldType(elem) + "%s"
+			break
+		}
+		decl = "struct {\n    uint8_t is_some;\n    " + hb.fieldType(elem) + " elem;\n} %s"
+	case *types.Option, *types.Unit:
+		decl = "struct {\n    uint8_t is_some;\n    " + hb.fieldType(elem) + " elem;\n} %s"
+	default:
+		panic("unreachable: " + t.String())
+	}
+	return hb.newTypedef("option", t, decl)
+}
+
+func (hb *headerBuilder) closureName(t *types.Fun) string {
+	if name, ok := hb.names[t.String()]; ok {
+		return name
+	}
+	params := make([]string, 0, len(t.Params)+1)
+	params = append(params, "void *")
+	for _, p := range t.Params {
+		params = append(params, hb.externalType(p))
+	}
+	ret := "void"
+	if t.Ret != types.UnitType {
+		ret = hb.externalType(t.Ret)
+	}
+	decl := fmt.Sprintf("struct {\n    %s (*fun)(%s);\n    void *env;\n} %%s", ret, strings.Join(params, ", "))
+	return hb.newTypedef("closure", t, decl)
+}
+
+// fieldType returns a C type of the value stored in memory (e.g. in tuples or options).
+func (hb *headerBuilder) fieldType(t types.Type) string {
+	switch t := t.(type) {
+	case *types.Unit:
+		return "gocaml_unit"
+	case *types.Bool:
+		return "uint8_t"
+	case *types.Int:
+		return "gocaml_int"
+	case *types.Float:
+		return "gocaml_float"
+	case *types.Char:
+		return "gocaml_char"
+	case *types.String:
+		return "gocaml_string"
+	case *types.Bytes:
+		return "gocaml_bytes"
+	case *types.Buffer:
+		return "gocaml_buffer"
+	case *types.Array:
+		return "gocaml_array"
+	case *types.Tuple:
+		name := hb.tupleName(t)
+		if isUnboxedTuple(t) {
+			return name
+		}
+		return name + " *"
+	case *types.Option:
+		return hb.optionName(t)
+	case *types.Fun:
+		return hb.closureName(t)
+	default:
+		panic("FATAL: Type cannot be represented in C: " + t.String())
+	}
+}
+
+// externalType returns a C type of the value passed to or returned from external functions.
+func (hb *headerBuilder) externalType(t types.Type) string {
+	if t == types.BoolType {
+		return "gocaml_bool"
+	}
+	if isUnboxedTuple(t) {
+		return hb.tupleName(t.(*types.Tuple)) + " *"
+	}
+	return hb.fieldType(t)
+}
+
+// prototype returns a C prototype of the function which has the same ABI as external functions.
+func (hb *headerBuilder) prototype(name string, ty *types.Fun) string {
+	ret := "void"
+	if ty.Ret != types.UnitType {
+		ret = hb.externalType(ty.Ret)
+	}
+	params := make([]string, 0, len(ty.Params))
+	for _, p := range ty.Params {
+		params = append(params, hb.externalType(p))
+	}
+	return fmt.Sprintf("%s %s(%s);", ret, name, strings.Join(params, ", "))
+}
+
+// declaration returns a C declaration of the external symbol.
+func (hb *headerBuilder) declaration(ext *types.External) string {
+	if fun, ok := ext.Type.(*types.Fun); ok {
+		return hb.prototype(ext.CName, fun)
+	}
+	return fmt.Sprintf("extern %s %s;", hb.externalType(ext.Type), ext.CName)
+}
+
+func headerGuard(name string) string {
+	var buf bytes.Buffer
+	buf.WriteString("GOCAML_")
+	for _, c := range strings.ToUpper(name) {
+		if ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			buf.WriteRune(c)
+		} else {
+			buf.WriteByte('_')
+		}
+	}
+	buf.WriteString("_H_INCLUDED")
+	return buf.String()
+}
+
+// EmitHeader returns a C header which declares external symbols not defined in runtime and exported
+// functions. External symbols must be defined in C. Exported functions can be called from C. Types
+// of tuples, options and closures in the declarations are also defined in the header. Other types
+// are defined in gocaml.h. name is used for include guard.
+func (emitter *Emitter) EmitHeader(name string) string {
+	hb := newHeaderBuilder()
+
+	exts := make([]*types.External, 0, len(emitter.Env.Externals))
+	for _, e := range emitter.Env.Externals {
+		if !types.IsBuiltin(e.CName) {
+			exts = append(exts, e)
+		}
+	}
+	sort.Slice(exts, func(i, j int) bool {
+		return exts[i].CName < exts[j].CName
+	})
+	decls := make([]string, 0, len(exts))
+	for _, e := range exts {
+		decls = append(decls, hb.declaration(e))
+	}
+	protos := make([]string, 0, len(emitter.exports))
+	for _, export := range emitter.exports {
+		protos = append(protos, hb.prototype(export.cName, export.ty))
+	}
+
+	guard := headerGuard(name)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Generated by gocaml from %s. DO NOT EDIT.\n", emitter.Source.Path)
+	fmt.Fprintf(&buf, "#if !defined %s\n#define      %s\n\n", guard, guard)
+	buf.WriteString("#include \"gocaml.h\"\n\n")
+	buf.WriteString("#if defined(__cplusplus)\nextern \"C\" {\n#endif\n\n")
+	buf.Write(hb.typedefs.Bytes())
+	if len(decls) > 0 {
+		buf.WriteString("// External symbols which should be defined in C\n")
+		buf.WriteString(strings.Join(decls, "\n"))
+		buf.WriteString("\n\n")
+	}
+	if len(protos) > 0 {
+		buf.WriteString("// Note: Call gocaml_init() once before calling functions below.\n")
+		buf.WriteString(strings.Join(protos, "\n"))
+		buf.WriteString("\n\n")
+	}
+	buf.WriteString("#if defined(__cplusplus)\n}\n#endif\n\n")
+	fmt.Fprintf(&buf, "#endif    // %s\n", guard)
+	return buf.String()
+}
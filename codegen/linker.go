@@ -3,6 +3,7 @@ package codegen
 import (
 	"github.com/rhysd/locerr"
 	"go/build"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -24,10 +25,15 @@ func gopaths() []string {
 }
 
 func detectRuntimePath() (string, error) {
+	return detectRuntimeFile("gocamlrt.a")
+}
+
+// detectRuntimeFile finds the file built in runtime directory (e.g. gocamlrt.a or gocamlrt.bc).
+func detectRuntimeFile(name string) (string, error) {
 	// XXX:
 	// Need to investigate solid way to get runtime library path
 
-	fromBuildDir, err := filepath.Abs(filepath.Join(filepath.Dir(os.Args[0]), "runtime/gocamlrt.a"))
+	fromBuildDir, err := filepath.Abs(filepath.Join(filepath.Dir(os.Args[0]), "runtime", name))
 	if err != nil {
 		return "", err
 	}
@@ -38,14 +44,14 @@ func detectRuntimePath() (string, error) {
 	candidates := []string{fromBuildDir}
 
 	for _, gopath := range gopaths() {
-		fromGopath := filepath.Join(gopath, "src/github.com/rhysd/gocaml/runtime/gocamlrt.a")
+		fromGopath := filepath.Join(gopath, "src/github.com/rhysd/gocaml/runtime", name)
 		if _, err := os.Stat(fromGopath); err == nil {
 			return fromGopath, nil
 		}
 		candidates = append(candidates, fromGopath)
 	}
 
-	return "", locerr.Errorf("Runtime library (gocamlrt.a) was not found. Candidates: %s", strings.Join(candidates, ", "))
+	return "", locerr.Errorf("Runtime library (%s) was not found. Candidates: %s", name, strings.Join(candidates, ", "))
 }
 
 func detectLibgcPath() string {
@@ -77,6 +83,15 @@ func (lnk *linker) cmdFailed(args []string, msg string) error {
 }
 
 func (lnk *linker) link(executable string, objFiles []string) error {
+	return lnk.run(executable, objFiles, nil)
+}
+
+// linkShared links objects and the runtime into a shared library.
+func (lnk *linker) linkShared(lib string, objFiles []string) error {
+	return lnk.run(lib, objFiles, []string{"-shared"})
+}
+
+func (lnk *linker) run(output string, objFiles []string, flags []string) error {
 	// TODO: Consider Windows environment
 
 	runtimePath, err := detectRuntimePath()
@@ -84,7 +99,8 @@ func (lnk *linker) link(executable string, objFiles []string) error {
 		return err
 	}
 
-	args := append(objFiles, "-o", executable, runtimePath, "-L/usr/local/lib", "-L/usr/lib")
+	args := append(flags, objFiles...)
+	args = append(args, "-o", output, runtimePath, "-L/usr/local/lib", "-L/usr/lib")
 	if path := detectLibgcPath(); path != "" {
 		args = append(args, "-L"+path)
 	}
@@ -99,3 +115,35 @@ func (lnk *linker) link(executable string, objFiles []string) error {
 
 	return nil
 }
+
+// archive creates a static library which contains objects and the runtime. The library is made by
+// adding the objects to a copy of runtime library. Note that 'main' function in the runtime is
+// linked only when the program linking the library does not define it.
+func archive(lib string, objFiles []string) error {
+	runtimePath, err := detectRuntimePath()
+	if err != nil {
+		return err
+	}
+	rt, err := ioutil.ReadFile(runtimePath)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(lib, rt, 0666); err != nil {
+		return err
+	}
+
+	cmd := os.Getenv("GOCAML_AR_CMD")
+	if cmd == "" {
+		cmd = "ar"
+	}
+	args := append([]string{"rs", lib}, objFiles...)
+	if _, err := exec.Command(cmd, args...).Output(); err != nil {
+		msg := err.Error()
+		if exiterr, ok := err.(*exec.ExitError); ok {
+			msg = string(exiterr.Stderr)
+		}
+		return locerr.Errorf("Archiver command failed: %s %s:\n%s", cmd, strings.Join(args, " "), msg)
+	}
+
+	return nil
+}
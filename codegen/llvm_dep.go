@@ -0,0 +1,11 @@
+//go:build !byollvm
+// +build !byollvm
+
+package codegen
+
+// Note:
+// codegen/*.cpp include LLVM C++ headers. As LLVM Go bindings do, flags to compile them are put in
+// llvm_config.go generated from llvm_config.go.in by 'make codegen/llvm_config.go'. When the file
+// is missing, 'run_make' is reported as undefined. Build with '-tags byollvm' to give the flags
+// via $CGO_CPPFLAGS and $CGO_CXXFLAGS instead.
+var _ run_make
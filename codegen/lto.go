@@ -0,0 +1,254 @@
+package codegen
+
+import (
+	"github.com/rhysd/locerr"
+	"llvm.org/llvm/bindings/go/llvm"
+	"strings"
+)
+
+// Note:
+// Link-time optimization (LTO) links the runtime module compiled to LLVM bitcode (gocamlrt.bc) into
+// the module generated from GoCaml code. Since all functions in the runtime are defined in the same
+// module, inliner can inline small runtime functions such as str_length, bit_and or int_to_float
+// into GoCaml functions.
+//
+// The runtime library (gocamlrt.a) is still passed to linker to link main() in gocamlmain.o.
+// gocamlrt.o in the library is not linked since all symbols in it are already defined by the
+// object file generated from the module. Definitions in the runtime remain external so that C
+// objects linked together can call them.
+//
+// clang lowers C types following C ABI. For example, on x86_64 a by-value gocaml_string parameter
+// is coerced into two parameters (i8*, i64) and gocaml_bool is i32. Since GoCaml declares runtime
+// functions with its own types (e.g. %gocaml.string and i1), linking the runtime as-is makes calls
+// go through bitcast of the function and inliner cannot inline them. So declarations whose types
+// differ from the definitions are replaced with small adapters which convert arguments and return
+// value to the types clang chose before linking. The adapters are always inlined.
+
+func archOfTriple(triple string) string {
+	return strings.SplitN(triple, "-", 2)[0]
+}
+
+// linkRuntimeModule loads the runtime bitcode and links it into the module. The runtime bitcode must
+// be built for the same architecture as the module.
+func linkRuntimeModule(module llvm.Module) error {
+	path, err := detectRuntimeFile("gocamlrt.bc")
+	if err != nil {
+		return err
+	}
+
+	rt, err := llvm.ParseBitcodeFile(path)
+	if err != nil {
+		return locerr.Errorf("Cannot load runtime bitcode %s: %s", path, err.Error())
+	}
+
+	if rt.Target() != "" && archOfTriple(rt.Target()) != archOfTriple(module.Target()) {
+		target := rt.Target()
+		rt.Dispose()
+		return locerr.Errorf("Runtime bitcode %s was built for '%s' but target is '%s'. Please rebuild it for the target", path, target, module.Target())
+	}
+
+	// Note:
+	// Vendor and OS parts of target triple may be different between clang and LLVM (e.g.
+	// 'x86_64-pc-linux-gnu' and 'x86_64-unknown-linux-gnu'). Align them to the module to avoid
+	// warnings from linker.
+	rt.SetTarget(module.Target())
+	rt.SetDataLayout(module.DataLayout())
+
+	adaptRuntimeDecls(module, rt)
+
+	// Note: rt is destroyed by linking
+	if err := llvm.LinkModules(module, rt); err != nil {
+		return locerr.Errorf("Cannot link runtime bitcode %s: %s", path, err.Error())
+	}
+	return nil
+}
+
+// adaptRuntimeDecls replaces declarations in the module whose types differ from their definitions in
+// the runtime with adapters. Declarations which cannot be adapted are left as they are.
+func adaptRuntimeDecls(module, rt llvm.Module) {
+	ctx := module.Context()
+	builder := ctx.NewBuilder()
+	defer builder.Dispose()
+	alwaysInline := ctx.CreateEnumAttribute(llvm.AttributeKindID("alwaysinline"), 0)
+
+	for def := rt.FirstFunction(); !def.IsNil(); def = llvm.NextFunction(def) {
+		if def.IsDeclaration() || def.Linkage() != llvm.ExternalLinkage {
+			continue
+		}
+		name := def.Name()
+		decl := module.NamedFunction(name)
+		if decl.IsNil() || !decl.IsDeclaration() {
+			continue
+		}
+		from, to := decl.Type().ElementType(), def.Type().ElementType()
+		if from == to || !canAdaptFunType(from, to) {
+			continue
+		}
+
+		// Note: The declaration itself becomes the adapter so that all its uses call the adapter
+		decl.SetName(name + ".adapter")
+		decl.SetLinkage(llvm.InternalLinkage)
+		decl.AddFunctionAttr(alwaysInline)
+		callee := llvm.AddFunction(module, name, to)
+
+		builder.SetInsertPointAtEnd(ctx.AddBasicBlock(decl, "entry"))
+		leaves := []llvm.Value{}
+		for _, p := range decl.Params() {
+			leaves = flattenValue(builder, p, leaves)
+		}
+		args := make([]llvm.Value, 0, len(to.ParamTypes()))
+		for _, t := range to.ParamTypes() {
+			var arg llvm.Value
+			arg, leaves = buildFromLeaves(builder, t, leaves)
+			args = append(args, arg)
+		}
+		ret := builder.CreateCall(callee, args, "")
+		if from.ReturnType().TypeKind() == llvm.VoidTypeKind {
+			builder.CreateRetVoid()
+			continue
+		}
+		ret, _ = buildFromLeaves(builder, from.ReturnType(), flattenValue(builder, ret, nil))
+		builder.CreateRet(ret)
+	}
+}
+
+// leafTypes returns scalar types contained in the type in order. Aggregates are flattened.
+func leafTypes(t llvm.Type, leaves []llvm.Type) []llvm.Type {
+	switch t.TypeKind() {
+	case llvm.StructTypeKind:
+		for _, e := range t.StructElementTypes() {
+			leaves = leafTypes(e, leaves)
+		}
+	case llvm.ArrayTypeKind:
+		for i := 0; i < t.ArrayLength(); i++ {
+			leaves = leafTypes(t.ElementType(), leaves)
+		}
+	default:
+		leaves = append(leaves, t)
+	}
+	return leaves
+}
+
+func canConvertLeaf(from, to llvm.Type) bool {
+	if from == to {
+		return true
+	}
+	f, t := from.TypeKind(), to.TypeKind()
+	switch {
+	case f == llvm.PointerTypeKind && t == llvm.PointerTypeKind:
+		return true
+	case f == llvm.IntegerTypeKind && t == llvm.IntegerTypeKind:
+		return true
+	case f == llvm.PointerTypeKind && t == llvm.IntegerTypeKind:
+		return true
+	case f == llvm.IntegerTypeKind && t == llvm.PointerTypeKind:
+		return true
+	default:
+		return false
+	}
+}
+
+func canAdaptLeaves(from, to []llvm.Type) bool {
+	if len(from) != len(to) {
+		return false
+	}
+	for i := range from {
+		if !canConvertLeaf(from[i], to[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// canAdaptFunType returns whether arguments and return value of function type 'from' can be
+// converted to ones of function type 'to' element-wise. Parameters passed via memory (e.g. byval
+// or sret) cannot be adapted since numbers of their elements don't match.
+func canAdaptFunType(from, to llvm.Type) bool {
+	if from.IsFunctionVarArg() || to.IsFunctionVarArg() {
+		return false
+	}
+	fromRet, toRet := from.ReturnType(), to.ReturnType()
+	if (fromRet.TypeKind() == llvm.VoidTypeKind) != (toRet.TypeKind() == llvm.VoidTypeKind) {
+		return false
+	}
+	var fromParams, toParams []llvm.Type
+	for _, p := range from.ParamTypes() {
+		fromParams = leafTypes(p, fromParams)
+	}
+	for _, p := range to.ParamTypes() {
+		toParams = leafTypes(p, toParams)
+	}
+	if !canAdaptLeaves(fromParams, toParams) {
+		return false
+	}
+	if fromRet.TypeKind() == llvm.VoidTypeKind {
+		return true
+	}
+	return canAdaptLeaves(leafTypes(toRet, nil), leafTypes(fromRet, nil))
+}
+
+// flattenValue extracts scalar values contained in the value in the same order as leafTypes().
+func flattenValue(builder llvm.Builder, v llvm.Value, leaves []llvm.Value) []llvm.Value {
+	t := v.Type()
+	switch t.TypeKind() {
+	case llvm.StructTypeKind:
+		for i := range t.StructElementTypes() {
+			leaves = flattenValue(builder, builder.CreateExtractValue(v, i, ""), leaves)
+		}
+	case llvm.ArrayTypeKind:
+		for i := 0; i < t.ArrayLength(); i++ {
+			leaves = flattenValue(builder, builder.CreateExtractValue(v, i, ""), leaves)
+		}
+	default:
+		leaves = append(leaves, v)
+	}
+	return leaves
+}
+
+// buildFromLeaves builds a value of the type from leading scalar values and returns it with the
+// rest of values.
+func buildFromLeaves(builder llvm.Builder, t llvm.Type, leaves []llvm.Value) (llvm.Value, []llvm.Value) {
+	switch t.TypeKind() {
+	case llvm.StructTypeKind:
+		agg := llvm.Undef(t)
+		for i, e := range t.StructElementTypes() {
+			var v llvm.Value
+			v, leaves = buildFromLeaves(builder, e, leaves)
+			agg = builder.CreateInsertValue(agg, v, i, "")
+		}
+		return agg, leaves
+	case llvm.ArrayTypeKind:
+		agg := llvm.Undef(t)
+		for i := 0; i < t.ArrayLength(); i++ {
+			var v llvm.Value
+			v, leaves = buildFromLeaves(builder, t.ElementType(), leaves)
+			agg = builder.CreateInsertValue(agg, v, i, "")
+		}
+		return agg, leaves
+	default:
+		return convertLeaf(builder, leaves[0], t), leaves[1:]
+	}
+}
+
+// convertLeaf converts the scalar value to the type. Narrower integers are zero-extended since bool
+// and char are unsigned. Integer is converted to i1 by comparing with zero as C does.
+func convertLeaf(builder llvm.Builder, v llvm.Value, to llvm.Type) llvm.Value {
+	from := v.Type()
+	if from == to {
+		return v
+	}
+	switch f, t := from.TypeKind(), to.TypeKind(); {
+	case f == llvm.PointerTypeKind && t == llvm.PointerTypeKind:
+		return builder.CreateBitCast(v, to, "")
+	case f == llvm.PointerTypeKind:
+		return builder.CreatePtrToInt(v, to, "")
+	case t == llvm.PointerTypeKind:
+		return builder.CreateIntToPtr(v, to, "")
+	case to.IntTypeWidth() == 1:
+		return builder.CreateICmp(llvm.IntNE, v, llvm.ConstNull(from), "")
+	case from.IntTypeWidth() < to.IntTypeWidth():
+		return builder.CreateZExt(v, to, "")
+	default:
+		return builder.CreateTrunc(v, to, "")
+	}
+}
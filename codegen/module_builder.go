@@ -2,6 +2,7 @@ package codegen
 
 import (
 	"fmt"
+	"github.com/rhysd/gocaml/mangle"
 	"github.com/rhysd/gocaml/mir"
 	"github.com/rhysd/gocaml/types"
 	"github.com/rhysd/locerr"
@@ -21,6 +22,10 @@ type moduleBuilder struct {
 	globalTable map[string]llvm.Value
 	funcTable   map[string]llvm.Value
 	closures    mir.Closures
+	escapes     mir.EscapeInfo
+	callbacks   map[string]llvm.Value
+	divCheck    bool
+	ovfCheck    bool
 }
 
 func createAttributeTable(ctx llvm.Context) map[string]llvm.Attribute {
@@ -34,12 +39,14 @@ func createAttributeTable(ctx llvm.Context) map[string]llvm.Attribute {
 		"ssp",
 		"uwtable",
 		"alwaysinline",
+		"zeroext",
 	} {
 		kind := llvm.AttributeKindID(attr)
 		attrs[attr] = ctx.CreateEnumAttribute(kind, 0)
 	}
 
 	// String attributes
+	// Note: "false" for "disable-tail-calls" means that tail calls are allowed in the function.
 	for _, attr := range []struct {
 		kind  string
 		value string
@@ -73,14 +80,25 @@ func newModuleBuilder(env *types.Env, file *locerr.Source, opts EmitOptions) (*m
 		return nil, err
 	}
 
+	reloc, err := opts.RelocModel.toLLVM(opts.PositionIndependent)
+	if err != nil {
+		return nil, err
+	}
+
+	cpu, features, err := resolveCPU(opts.CPU, opts.Features)
+	if err != nil {
+		return nil, err
+	}
+
 	machine := target.CreateTargetMachine(
 		triple,
-		"", // CPU
-		"", // Features
+		cpu,
+		features,
 		optLevel,
-		llvm.RelocDefault,     // static or dynamic-no-pic or default
-		llvm.CodeModelDefault, // small, medium, large, kernel, JIT-default, default
+		reloc,                   // static or dynamic-no-pic or default
+		opts.CodeModel.toLLVM(), // small, medium, large, kernel, JIT-default, default
 	)
+	guaranteeTailCalls(machine)
 
 	targetData := machine.CreateTargetData()
 	dataLayout := targetData.String()
@@ -119,6 +137,10 @@ func newModuleBuilder(env *types.Env, file *locerr.Source, opts EmitOptions) (*m
 		nil,
 		nil,
 		nil,
+		nil,
+		map[string]llvm.Value{},
+		opts.DivisionCheck,
+		opts.OverflowCheck,
 	}, nil
 }
 
@@ -145,8 +167,9 @@ func (b *moduleBuilder) buildExternalClosureWrapper(funName string, ty *types.Fu
 
 	// Build declaration of closure wrapper
 	tyVal := b.typeBuilder.buildExternalClosure(ty)
-	val := llvm.AddFunction(b.module, name, tyVal)
-	val.SetLinkage(llvm.PrivateLinkage)
+	val := llvm.AddFunction(b.module, mangle.ClosureWrapper(funName), tyVal)
+	val.SetLinkage(llvm.InternalLinkage)
+	val.SetFunctionCallConv(gocamlCallConv)
 	val.AddFunctionAttr(b.attributes["alwaysinline"])
 	val.AddFunctionAttr(b.attributes["nounwind"])
 	val.AddFunctionAttr(b.attributes["ssp"])
@@ -166,13 +189,14 @@ func (b *moduleBuilder) buildExternalClosureWrapper(funName string, ty *types.Fu
 	lenArgs := len(ty.Params)
 	args := make([]llvm.Value, 0, lenArgs)
 	for i := 0; i < lenArgs; i++ {
-		args = append(args, val.Param(i+1))
+		args = append(args, b.buildToExternal(val.Param(i+1), ty.Params[i]))
 	}
 	ret := b.builder.CreateCall(extFunVal, args, "")
 	if ty.Ret == types.UnitType {
 		// When the external function returns void
 		ret = llvm.ConstNamedStruct(b.typeBuilder.unitT, []llvm.Value{})
 	}
+	ret = b.buildFromExternal(ret, ty.Ret)
 	b.builder.CreateRet(ret)
 	b.builder.SetInsertPointAtEnd(saved)
 
@@ -189,15 +213,152 @@ func (b *moduleBuilder) buildExternalDecl(ext *types.External) {
 		val := llvm.AddFunction(b.module, ext.CName, tyVal)
 		val.SetLinkage(llvm.ExternalLinkage)
 		val.AddFunctionAttr(b.attributes["disable-tail-calls"])
+		b.addIntExtAttrs(val, ty, 0)
 		b.globalTable[ext.CName] = val
 	default:
-		t := b.typeBuilder.fromMIR(ty)
+		t := b.typeBuilder.fromExternal(ty)
 		v := llvm.AddGlobal(b.module, t, ext.CName)
 		v.SetLinkage(llvm.ExternalLinkage)
 		b.globalTable[ext.CName] = v
 	}
 }
 
+// buildToExternal converts the value to the representation for external functions. Unboxed tuple is
+// copied to heap and passed as a pointer. Closure is converted to a callback. Please see
+// typeBuilder.fromExternal().
+func (b *moduleBuilder) buildToExternal(val llvm.Value, ty types.Type) llvm.Value {
+	if fun, ok := ty.(*types.Fun); ok {
+		return b.buildCallback(val, fun)
+	}
+	if !isUnboxedTuple(ty) {
+		return val
+	}
+	ptr := b.buildMalloc(val.Type(), "tpl.boxed")
+	b.builder.CreateStore(val, ptr)
+	return ptr
+}
+
+// buildFromExternal converts the value passed from external functions to the representation in
+// GoCaml. It is the reverse operation of buildToExternal().
+func (b *moduleBuilder) buildFromExternal(val llvm.Value, ty types.Type) llvm.Value {
+	if !isUnboxedTuple(ty) {
+		return val
+	}
+	return b.builder.CreateLoad(val, "tpl.unboxed")
+}
+
+// buildCallback converts the closure value to a callback for C. The closure is copied to heap and
+// the callback's environment points to it. The callback's function is a trampoline which converts
+// arguments and return value between C and GoCaml and calls the closure.
+func (b *moduleBuilder) buildCallback(closure llvm.Value, ty *types.Fun) llvm.Value {
+	env := b.buildMalloc(closure.Type(), "callback.env")
+	b.builder.CreateStore(closure, env)
+	env = b.builder.CreateBitCast(env, b.typeBuilder.voidPtrT, "")
+	cb := llvm.Undef(b.typeBuilder.buildCallback(ty))
+	cb = b.builder.CreateInsertValue(cb, b.buildCallbackTrampoline(ty), 0, "")
+	return b.builder.CreateInsertValue(cb, env, 1, "callback")
+}
+
+func (b *moduleBuilder) buildCallbackTrampoline(ty *types.Fun) llvm.Value {
+	sym := mangle.Callback(ty)
+	if f, ok := b.callbacks[sym]; ok {
+		return f
+	}
+
+	cbTy := b.typeBuilder.buildCallback(ty)
+	val := llvm.AddFunction(b.module, sym, cbTy.StructElementTypes()[0].ElementType())
+	val.SetLinkage(llvm.InternalLinkage)
+	val.AddFunctionAttr(b.attributes["nounwind"])
+	val.AddFunctionAttr(b.attributes["ssp"])
+	val.AddFunctionAttr(b.attributes["uwtable"])
+	b.addIntExtAttrs(val, ty, 1 /*environment*/)
+	b.callbacks[sym] = val
+
+	// Note: Trampoline may be built while building other function's body
+	saved := b.builder.GetInsertBlock()
+	savedLoc := b.builder.GetCurrentDebugLocation()
+	if b.debug != nil {
+		b.debug.clearLocation(b.builder)
+	}
+	body := b.context.AddBasicBlock(val, "entry")
+	b.builder.SetInsertPointAtEnd(body)
+
+	closureTy := b.typeBuilder.buildClosure(ty)
+	envPtr := b.builder.CreateBitCast(val.Param(0), llvm.PointerType(closureTy, 0 /*address space*/), "")
+	closure := b.builder.CreateLoad(envPtr, "closure")
+	funPtr := b.builder.CreateExtractValue(closure, 0, "funptr")
+	args := make([]llvm.Value, 0, len(ty.Params)+1)
+	args = append(args, b.builder.CreateExtractValue(closure, 1, "capturesptr"))
+	for i, p := range ty.Params {
+		args = append(args, b.buildFromExternal(val.Param(i+1), p))
+	}
+	ret := b.buildGoCamlCall(funPtr, args)
+	if ty.Ret == types.UnitType {
+		// Callback returns void instead of unit as well as external functions
+		b.builder.CreateRetVoid()
+	} else {
+		b.builder.CreateRet(b.buildToExternal(ret, ty.Ret))
+	}
+
+	if !saved.IsNil() {
+		b.builder.SetInsertPointAtEnd(saved)
+	}
+	if b.debug != nil {
+		b.builder.SetCurrentDebugLocation(savedLoc.Line, savedLoc.Col, savedLoc.Scope, savedLoc.InlinedAt)
+	}
+	return val
+}
+
+// Note:
+// bool is i1 and char is i8 in GoCaml, but C ABI expects integers narrower than 'int' to be
+// extended by the caller (for parameters) or the callee (for return values). Functions compiled by
+// clang (e.g. print_char()) assume that the register is already extended. So bool and char
+// parameters and return values of functions called from or calling C are zero-extended. offset is
+// the number of leading parameters which don't appear in the function type.
+func (b *moduleBuilder) addIntExtAttrs(fun llvm.Value, ty *types.Fun, offset int) {
+	if needsZeroExt(ty.Ret) {
+		fun.AddAttributeAtIndex(llvm.AttributeReturnIndex, b.attributes["zeroext"])
+	}
+	for i, p := range ty.Params {
+		if needsZeroExt(p) {
+			// Note: Index 0 is for return value. Parameters' indices start from 1
+			fun.AddAttributeAtIndex(offset+i+1, b.attributes["zeroext"])
+		}
+	}
+}
+
+func needsZeroExt(ty types.Type) bool {
+	return ty == types.BoolType || ty == types.CharType
+}
+
+// overflowIntrinsic returns LLVM's arithmetic with overflow intrinsic function for int type. op is
+// one of "sadd", "ssub" or "smul". The intrinsic returns a pair of the result and overflow flag.
+func (b *moduleBuilder) overflowIntrinsic(op string) llvm.Value {
+	intT := b.typeBuilder.intT
+	name := fmt.Sprintf("llvm.%s.with.overflow.i%d", op, intT.IntTypeWidth())
+	if f := b.module.NamedFunction(name); !f.IsNil() {
+		return f
+	}
+	retT := b.context.StructType([]llvm.Type{intT, b.context.Int1Type()}, false /*packed*/)
+	return llvm.AddFunction(b.module, name, llvm.FunctionType(retT, []llvm.Type{intT, intT}, false /*varargs*/))
+}
+
+// buildGoCamlCall builds a call of function defined in GoCaml with its calling convention.
+func (b *moduleBuilder) buildGoCamlCall(funVal llvm.Value, args []llvm.Value) llvm.Value {
+	call := b.builder.CreateCall(funVal, args, "")
+	call.SetInstructionCallConv(gocamlCallConv)
+	return call
+}
+
+// symbolName returns a mangled symbol name of the function. Please see package mangle for the
+// mangling scheme.
+func (b *moduleBuilder) symbolName(name string) string {
+	if sym, ok := b.env.MangledNames[name]; ok {
+		return sym
+	}
+	return name
+}
+
 func (b *moduleBuilder) buildFuncDecl(insn mir.FunInsn) {
 	name := insn.Name
 	_, isClosure := b.closures[name]
@@ -212,7 +373,7 @@ func (b *moduleBuilder) buildFuncDecl(insn mir.FunInsn) {
 	}
 
 	t := b.typeBuilder.buildFun(ty, !isClosure)
-	v := llvm.AddFunction(b.module, name, t)
+	v := llvm.AddFunction(b.module, b.symbolName(name), t)
 
 	index := 0
 	if isClosure {
@@ -225,8 +386,12 @@ func (b *moduleBuilder) buildFuncDecl(insn mir.FunInsn) {
 		index++
 	}
 
-	// Currently GoCaml does not have modules. So all functions are private.
-	v.SetLinkage(llvm.PrivateLinkage)
+	// Currently GoCaml does not have modules. So all functions are internal.
+	// Note:
+	// Private linkage is not used because private symbols don't remain in symbol table of the
+	// executable. Symbols are necessary to show function names in backtrace.
+	v.SetLinkage(llvm.InternalLinkage)
+	v.SetFunctionCallConv(gocamlCallConv)
 
 	v.AddFunctionAttr(b.attributes["inlinehint"])
 	v.AddFunctionAttr(b.attributes["nounwind"])
@@ -261,12 +426,44 @@ func (b *moduleBuilder) buildFunBody(insn mir.FunInsn) {
 		blockBuilder.registers[p] = funVal.Param(i)
 	}
 
+	if mir.HasSelfTailCall(name, fun) {
+		// Self tail calls are lowered to jumps to loop header. Parameters are replaced with PHI nodes
+		// which receive arguments of the tail calls. This guarantees constant stack usage for
+		// tail recursive functions at any optimization level.
+		header := b.context.AddBasicBlock(funVal, "tailrec")
+		b.builder.CreateBr(header)
+		b.builder.SetInsertPointAtEnd(header)
+		phis := make([]llvm.Value, 0, len(fun.Params))
+		for _, p := range fun.Params {
+			param := blockBuilder.registers[p]
+			phi := b.builder.CreatePHI(param.Type(), p)
+			phi.AddIncoming([]llvm.Value{param}, []llvm.BasicBlock{start})
+			blockBuilder.registers[p] = phi
+			phis = append(phis, phi)
+		}
+		blockBuilder.tailRec = &tailRecLoop{name, header, phis}
+	}
+
 	if b.debug != nil {
 		ty, ok := b.env.DeclTable[name].(*types.Fun)
 		if !ok {
 			panic("Type for function definition not found: " + name)
 		}
 		b.debug.setFuncInfo(funVal, ty, insn.Pos.Line, isClosure)
+		b.debug.setLocation(b.builder, insn.Pos)
+
+		for i, p := range fun.Params {
+			n, ok := b.env.DisplayNames[p]
+			if !ok {
+				continue
+			}
+			argNo := i + 1
+			if isClosure {
+				argNo++
+			}
+			info := b.debug.paramVarInfo(n, ty.Params[i], insn.Pos.Line, argNo)
+			blockBuilder.buildDebugVar(p, blockBuilder.registers[p], info)
+		}
 	}
 
 	// Expose captures of closure
@@ -278,6 +475,12 @@ func (b *moduleBuilder) buildFunBody(insn mir.FunInsn) {
 				ptr := b.builder.CreateStructGEP(closureVal, i, "")
 				exposed := b.builder.CreateLoad(ptr, fmt.Sprintf("%s.capture.%s", name, n))
 				blockBuilder.registers[n] = exposed
+				if b.debug != nil {
+					if d, ok := b.env.DisplayNames[n]; ok {
+						info := b.debug.localVarInfo(d, b.env.DeclTable[n], insn.Pos.Line)
+						blockBuilder.buildDebugVar(n, exposed, info)
+					}
+				}
 			}
 		}
 		if fun.IsRecursive {
@@ -362,7 +565,23 @@ func (b *moduleBuilder) buildLibgcFuncDecls() {
 	b.globalTable["GC_malloc"] = v
 }
 
-func (b *moduleBuilder) build(prog *mir.Program) error {
+func (b *moduleBuilder) buildMallocRaw(ty llvm.Type, sizeVal llvm.Value, name string) llvm.Value {
+	mallocVal, ok := b.globalTable["GC_malloc"]
+	if !ok {
+		panic("'GC_malloc' not found. Function protoypes for libgc were not emitted")
+	}
+	allocated := b.builder.CreateCall(mallocVal, []llvm.Value{sizeVal}, "")
+	ptrTy := llvm.PointerType(ty, 0 /*address space*/)
+	return b.builder.CreateBitCast(allocated, ptrTy, name)
+}
+
+func (b *moduleBuilder) buildMalloc(ty llvm.Type, name string) llvm.Value {
+	size := b.targetData.TypeAllocSize(ty)
+	sizeVal := llvm.ConstInt(b.typeBuilder.sizeT, size, false /*sign extend*/)
+	return b.buildMallocRaw(ty, sizeVal, name)
+}
+
+func (b *moduleBuilder) build(prog *mir.Program, exports []*exportedFun) error {
 	// Note:
 	// Currently global variables are external symbols only.
 	b.globalTable = make(map[string]llvm.Value, len(b.env.Externals)+1 /* 1 = libgc functions */)
@@ -376,6 +595,7 @@ func (b *moduleBuilder) build(prog *mir.Program) error {
 	}
 
 	b.closures = prog.Closures
+	b.escapes = mir.AnalyzeEscape(prog)
 	for _, fun := range prog.Toplevel {
 		b.buildFuncDecl(fun)
 	}
@@ -384,6 +604,12 @@ func (b *moduleBuilder) build(prog *mir.Program) error {
 		b.buildFunBody(fun)
 	}
 
+	for _, export := range exports {
+		if err := b.buildExportWrapper(export); err != nil {
+			return err
+		}
+	}
+
 	b.buildMain(prog.Entry)
 	if b.debug != nil {
 		b.debug.finalize()
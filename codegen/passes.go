@@ -0,0 +1,92 @@
+package codegen
+
+import (
+	"github.com/rhysd/locerr"
+	"llvm.org/llvm/bindings/go/llvm"
+	"sort"
+	"strings"
+)
+
+type passAdder func(pm llvm.PassManager, emitter *Emitter)
+
+// Note:
+// LLVM C API does not provide a way to add passes by their names. Names of passes available in
+// the explicit pass list are mapped to functions of LLVM Go bindings here. The names are the same
+// as ones used by LLVM 'opt' command.
+var passAdders = map[string]passAdder{
+	"adce":                  func(pm llvm.PassManager, _ *Emitter) { pm.AddAggressiveDCEPass() },
+	"argpromotion":          func(pm llvm.PassManager, _ *Emitter) { pm.AddArgumentPromotionPass() },
+	"constmerge":            func(pm llvm.PassManager, _ *Emitter) { pm.AddConstantMergePass() },
+	"constprop":             func(pm llvm.PassManager, _ *Emitter) { pm.AddConstantPropagationPass() },
+	"deadargelim":           func(pm llvm.PassManager, _ *Emitter) { pm.AddDeadArgEliminationPass() },
+	"dse":                   func(pm llvm.PassManager, _ *Emitter) { pm.AddDeadStoreEliminationPass() },
+	"functionattrs":         func(pm llvm.PassManager, _ *Emitter) { pm.AddFunctionAttrsPass() },
+	"globaldce":             func(pm llvm.PassManager, _ *Emitter) { pm.AddGlobalDCEPass() },
+	"globalopt":             func(pm llvm.PassManager, _ *Emitter) { pm.AddGlobalOptimizerPass() },
+	"gvn":                   func(pm llvm.PassManager, _ *Emitter) { pm.AddGVNPass() },
+	"indvars":               func(pm llvm.PassManager, _ *Emitter) { pm.AddIndVarSimplifyPass() },
+	"inline":                addInlinerPass,
+	"instcombine":           func(pm llvm.PassManager, _ *Emitter) { pm.AddInstructionCombiningPass() },
+	"ipconstprop":           func(pm llvm.PassManager, _ *Emitter) { pm.AddIPConstantPropagationPass() },
+	"ipsccp":                func(pm llvm.PassManager, _ *Emitter) { pm.AddIPSCCPPass() },
+	"jump-threading":        func(pm llvm.PassManager, _ *Emitter) { pm.AddJumpThreadingPass() },
+	"licm":                  func(pm llvm.PassManager, _ *Emitter) { pm.AddLICMPass() },
+	"loop-deletion":         func(pm llvm.PassManager, _ *Emitter) { pm.AddLoopDeletionPass() },
+	"loop-rotate":           func(pm llvm.PassManager, _ *Emitter) { pm.AddLoopRotatePass() },
+	"loop-unroll":           func(pm llvm.PassManager, _ *Emitter) { pm.AddLoopUnrollPass() },
+	"loop-unswitch":         func(pm llvm.PassManager, _ *Emitter) { pm.AddLoopUnswitchPass() },
+	"mem2reg":               func(pm llvm.PassManager, _ *Emitter) { pm.AddPromoteMemoryToRegisterPass() },
+	"memcpyopt":             func(pm llvm.PassManager, _ *Emitter) { pm.AddMemCpyOptPass() },
+	"prune-eh":              func(pm llvm.PassManager, _ *Emitter) { pm.AddPruneEHPass() },
+	"reassociate":           func(pm llvm.PassManager, _ *Emitter) { pm.AddReassociatePass() },
+	"reg2mem":               func(pm llvm.PassManager, _ *Emitter) { pm.AddDemoteMemoryToRegisterPass() },
+	"sccp":                  func(pm llvm.PassManager, _ *Emitter) { pm.AddSCCPPass() },
+	"simplifycfg":           func(pm llvm.PassManager, _ *Emitter) { pm.AddCFGSimplificationPass() },
+	"sroa":                  func(pm llvm.PassManager, _ *Emitter) { pm.AddScalarReplAggregatesPass() },
+	"strip-dead-prototypes": func(pm llvm.PassManager, _ *Emitter) { pm.AddStripDeadPrototypesPass() },
+	"tailcallelim":          func(pm llvm.PassManager, _ *Emitter) { pm.AddTailCallEliminationPass() },
+	"verify":                func(pm llvm.PassManager, _ *Emitter) { pm.AddVerifierPass() },
+}
+
+// addInlinerPass adds an inliner with the threshold of emitter. Inliner added by
+// AddFunctionInliningPass() always uses the default threshold. Pass manager builder with -O0 only
+// populates the inliner set to it.
+func addInlinerPass(pm llvm.PassManager, emitter *Emitter) {
+	builder := llvm.NewPassManagerBuilder()
+	defer builder.Dispose()
+	builder.SetOptLevel(0)
+	builder.UseInlinerWithThreshold(uint(emitter.inlineThreshold()))
+	builder.Populate(pm)
+}
+
+// AvailablePasses returns sorted names of LLVM passes which can be specified in Passes option.
+func AvailablePasses() []string {
+	names := make([]string, 0, len(passAdders))
+	for name := range passAdders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func checkPasses(passes []string) error {
+	for _, p := range passes {
+		if _, ok := passAdders[p]; !ok {
+			return locerr.Errorf("Unknown LLVM pass '%s'. Available passes are %s", p, strings.Join(AvailablePasses(), ", "))
+		}
+	}
+	return nil
+}
+
+// runPasses runs the passes in order. Each pass is run with its own pass manager to measure
+// elapsed time per pass.
+func (emitter *Emitter) runPasses(passes []string) {
+	for _, name := range passes {
+		pm := llvm.NewPassManager()
+		passAdders[name](pm, emitter)
+		emitter.Timer.Measure("llvm: "+name, func() {
+			pm.Run(emitter.Module)
+		})
+		pm.Dispose()
+	}
+}
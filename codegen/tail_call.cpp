@@ -0,0 +1,8 @@
+#include "tail_call.h"
+#include "llvm/Target/TargetMachine.h"
+
+// LLVM C API does not provide a way to set target options. The machine is passed as an opaque
+// pointer and unwrapped here.
+void gocamlGuaranteeTailCalls(void *machine) {
+    reinterpret_cast<llvm::TargetMachine *>(machine)->Options.GuaranteedTailCallOpt = true;
+}
@@ -0,0 +1,28 @@
+package codegen
+
+// #include "tail_call.h"
+import "C"
+
+import (
+	"llvm.org/llvm/bindings/go/llvm"
+	"unsafe"
+)
+
+// Note:
+// 'tail' marker of call instruction is only a hint for LLVM. A tail call may consume stack when it
+// is not optimized (e.g. -O0) or when the callee's prototype differs from the caller's. To
+// guarantee that tail calls never consume stack, all functions defined in GoCaml use 'fastcc'
+// calling convention and guaranteed tail call optimization (the same as 'llc -tailcallopt') is
+// enabled in the target machine. With it, every call marked as 'tail' from a 'fastcc' function to a
+// 'fastcc' function is always compiled to a jump. It includes mutual recursions and calls of
+// closures.
+//
+// Functions called from C (e.g. '__gocaml_main', callback trampolines and exported functions) must
+// use C calling convention.
+const gocamlCallConv = llvm.FastCallConv
+
+// guaranteeTailCalls enables guaranteed tail call optimization in the target machine. It is not
+// available via LLVM C API.
+func guaranteeTailCalls(machine llvm.TargetMachine) {
+	C.gocamlGuaranteeTailCalls(unsafe.Pointer(machine.C))
+}
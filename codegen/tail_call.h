@@ -0,0 +1,14 @@
+#if !defined GOCAML_TAIL_CALL_H_INCLUDED
+#define      GOCAML_TAIL_CALL_H_INCLUDED
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+void gocamlGuaranteeTailCalls(void *machine);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif    // GOCAML_TAIL_CALL_H_INCLUDED
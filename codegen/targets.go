@@ -1,7 +1,12 @@
 package codegen
 
 import (
+	"github.com/rhysd/locerr"
 	"llvm.org/llvm/bindings/go/llvm"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
 )
 
 type Target struct {
@@ -16,3 +21,175 @@ func AllTargets() []Target {
 	}
 	return targets
 }
+
+// RelocModel represents relocation model of generated code.
+type RelocModel int
+
+const (
+	// RelocDefault is the default relocation model of the target.
+	RelocDefault RelocModel = iota
+	// RelocStatic is non-relocatable code.
+	RelocStatic
+	// RelocPIC is position independent code.
+	RelocPIC
+	// RelocDynamicNoPIC is code which is not position independent but its external references are
+	// relocatable.
+	RelocDynamicNoPIC
+)
+
+var relocModelNames = []string{"default", "static", "pic", "dynamic-no-pic"}
+
+func (m RelocModel) String() string {
+	return relocModelNames[m]
+}
+
+// ParseRelocModel parses a name of relocation model. Valid names are "default", "static", "pic" and
+// "dynamic-no-pic".
+func ParseRelocModel(name string) (RelocModel, error) {
+	for i, n := range relocModelNames {
+		if n == name {
+			return RelocModel(i), nil
+		}
+	}
+	return RelocDefault, locerr.Errorf("Unknown relocation model '%s'. Valid models are %s", name, strings.Join(relocModelNames, ", "))
+}
+
+// CodeModel represents code model of generated code.
+type CodeModel int
+
+const (
+	// CodeModelDefault is the default code model of the target.
+	CodeModelDefault CodeModel = iota
+	// CodeModelSmall is small code model.
+	CodeModelSmall
+	// CodeModelKernel is kernel code model.
+	CodeModelKernel
+	// CodeModelMedium is medium code model.
+	CodeModelMedium
+	// CodeModelLarge is large code model.
+	CodeModelLarge
+)
+
+var codeModelNames = []string{"default", "small", "kernel", "medium", "large"}
+
+func (m CodeModel) String() string {
+	return codeModelNames[m]
+}
+
+// ParseCodeModel parses a name of code model. Valid names are "default", "small", "kernel", "medium"
+// and "large".
+func ParseCodeModel(name string) (CodeModel, error) {
+	for i, n := range codeModelNames {
+		if n == name {
+			return CodeModel(i), nil
+		}
+	}
+	return CodeModelDefault, locerr.Errorf("Unknown code model '%s'. Valid models are %s", name, strings.Join(codeModelNames, ", "))
+}
+
+func (m RelocModel) toLLVM(positionIndependent bool) (llvm.RelocMode, error) {
+	switch m {
+	case RelocStatic, RelocDynamicNoPIC:
+		if positionIndependent {
+			return llvm.RelocDefault, locerr.Errorf("Relocation model '%s' cannot be used for position independent code", m.String())
+		}
+		if m == RelocStatic {
+			return llvm.RelocStatic, nil
+		}
+		return llvm.RelocDynamicNoPic, nil
+	case RelocPIC:
+		return llvm.RelocPIC, nil
+	default:
+		if positionIndependent {
+			return llvm.RelocPIC, nil
+		}
+		return llvm.RelocDefault, nil
+	}
+}
+
+func (m CodeModel) toLLVM() llvm.CodeModel {
+	switch m {
+	case CodeModelSmall:
+		return llvm.CodeModelSmall
+	case CodeModelKernel:
+		return llvm.CodeModelKernel
+	case CodeModelMedium:
+		return llvm.CodeModelMedium
+	case CodeModelLarge:
+		return llvm.CodeModelLarge
+	default:
+		return llvm.CodeModelDefault
+	}
+}
+
+var (
+	reTargetCPU     = regexp.MustCompile(`"-target-cpu" "([^"]+)"`)
+	reTargetFeature = regexp.MustCompile(`"-target-feature" "([^"]+)"`)
+)
+
+// Note:
+// LLVM C API does not provide a way to get the name and features of host CPU (LLVMGetHostCPUName()
+// is not available in LLVM 5). Instead, ask clang, which is already required for linking, to
+// resolve 'native' CPU. 'clang -###' shows the arguments passed to its frontend which contain
+// '-target-cpu' and '-target-feature'.
+func hostCPU() (string, string, error) {
+	flag := "-mcpu=native"
+	if runtime.GOARCH == "amd64" || runtime.GOARCH == "386" {
+		flag = "-march=native"
+	}
+	args := []string{"-###", flag, "-x", "c", "-c", "-"}
+	out, err := exec.Command("clang", args...).CombinedOutput()
+	if err != nil {
+		return "", "", locerr.Errorf("Cannot detect host CPU with 'clang %s': %s", strings.Join(args, " "), string(out))
+	}
+
+	m := reTargetCPU.FindSubmatch(out)
+	if m == nil {
+		return "", "", locerr.Errorf("Cannot detect host CPU from output of 'clang %s': %s", strings.Join(args, " "), string(out))
+	}
+	cpu := string(m[1])
+
+	features := []string{}
+	for _, m := range reTargetFeature.FindAllSubmatch(out, -1) {
+		features = append(features, string(m[1]))
+	}
+	return cpu, strings.Join(features, ","), nil
+}
+
+// resolveCPU returns CPU name and features passed to target machine. "native" CPU is resolved to
+// the CPU of host machine and its features. Features specified by user are put after host features
+// so that they can override host features.
+func resolveCPU(cpu, features string) (string, string, error) {
+	if cpu != "native" {
+		return cpu, features, nil
+	}
+	host, hostFeatures, err := hostCPU()
+	if err != nil {
+		return "", "", err
+	}
+	if features == "" {
+		return host, hostFeatures, nil
+	}
+	if hostFeatures == "" {
+		return host, features, nil
+	}
+	return host, hostFeatures + "," + features, nil
+}
+
+// PrintCPUsAndFeatures prints available CPUs and features for the target triple to stderr. Empty
+// string means the default target on your machine.
+func PrintCPUsAndFeatures(triple string) error {
+	if triple == "" {
+		triple = llvm.DefaultTargetTriple()
+	}
+	target, err := llvm.GetTargetFromTriple(triple)
+	if err != nil {
+		return err
+	}
+	// Note:
+	// LLVM prints tables of available CPUs and features to stderr when "help" is specified as CPU
+	// name of target machine (like 'llc -mcpu=help').
+	machine := target.CreateTargetMachine(triple, "help", "", llvm.CodeGenLevelDefault, llvm.RelocDefault, llvm.CodeModelDefault)
+	machine.Dispose()
+	return nil
+}
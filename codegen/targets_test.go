@@ -1,6 +1,8 @@
 package codegen
 
 import (
+	"llvm.org/llvm/bindings/go/llvm"
+	"strings"
 	"testing"
 )
 
@@ -10,3 +12,60 @@ func TestAllTargets(t *testing.T) {
 		t.Fatalf("No target was found")
 	}
 }
+
+func TestParseRelocModel(t *testing.T) {
+	for _, m := range []RelocModel{RelocDefault, RelocStatic, RelocPIC, RelocDynamicNoPIC} {
+		parsed, err := ParseRelocModel(m.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed != m {
+			t.Errorf("Wanted %s but got %s", m.String(), parsed.String())
+		}
+	}
+	if _, err := ParseRelocModel("unknown"); err == nil || !strings.Contains(err.Error(), "Unknown relocation model 'unknown'") {
+		t.Fatal("Unexpected error:", err)
+	}
+}
+
+func TestParseCodeModel(t *testing.T) {
+	for _, m := range []CodeModel{CodeModelDefault, CodeModelSmall, CodeModelKernel, CodeModelMedium, CodeModelLarge} {
+		parsed, err := ParseCodeModel(m.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed != m {
+			t.Errorf("Wanted %s but got %s", m.String(), parsed.String())
+		}
+	}
+	if _, err := ParseCodeModel("huge"); err == nil || !strings.Contains(err.Error(), "Unknown code model 'huge'") {
+		t.Fatal("Unexpected error:", err)
+	}
+}
+
+func TestRelocModelForPositionIndependentCode(t *testing.T) {
+	if _, err := RelocStatic.toLLVM(true); err == nil {
+		t.Fatal("Static relocation model should not be allowed for position independent code")
+	}
+	for _, m := range []RelocModel{RelocDefault, RelocPIC} {
+		r, err := m.toLLVM(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r != llvm.RelocPIC {
+			t.Errorf("Relocation model for %s should be PIC", m.String())
+		}
+	}
+}
+
+func TestEmitWithCPUAndFeatures(t *testing.T) {
+	opts := EmitOptions{Optimization: OptimizeDefault, Triple: "x86_64-unknown-linux-gnu", CPU: "haswell", Features: "+avx2", RelocModel: RelocStatic, CodeModel: CodeModelLarge}
+	e, err := testCreateEmitterWithOptions("println_float (sqrt 2.0)", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Dispose()
+	if _, err := e.EmitAsm(); err != nil {
+		t.Fatal(err)
+	}
+}
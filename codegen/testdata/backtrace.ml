@@ -0,0 +1,5 @@
+(* Backtrace is output to stderr. So it does not appear in output *)
+let rec f n =
+  if n = 0 then print_backtrace () else f (n - 1) in
+f 3;
+println_str "done"
@@ -0,0 +1,41 @@
+let b = Bytes.create 5 in
+println_int (Bytes.length b);
+Bytes.set b 0 'h';
+Bytes.set b 1 'i';
+print_char (Bytes.get b 1); print_char '\n';
+let c = Bytes.of_string "hello world" in
+Bytes.blit c 6 b 0 5;
+println_str (Bytes.to_string b);
+Bytes.blit c 0 c 2 5;
+println_str (Bytes.to_string c);
+let s = Bytes.to_string b in
+Bytes.set b 0 'W';
+println_str s;
+println_str (Bytes.to_string b);
+println_bool (b = Bytes.of_string "World");
+println_bool (b <> Bytes.of_string "World");
+print_any (Bytes.of_string "a\n"); print_str "\n";
+print_any (Some (Bytes.create 2)); print_str "\n";
+let o: bytes option = None in
+println_bool (o = None);
+
+let buf = Buffer.create 1 in
+let rec add i =
+  if i < 100 then (
+    Buffer.add_string buf (int_to_str i);
+    Buffer.add_char buf ',';
+    add (i + 1)
+  ) else ()
+in
+add 0;
+println_int (Buffer.length buf);
+println_str (str_sub (Buffer.contents buf) 0 20);
+let contents = Buffer.contents buf in
+Buffer.add_char buf '!';
+println_int (str_length contents);
+println_int (Buffer.length buf);
+let ob = Some buf in
+match ob with
+| Some b -> Buffer.add_string b "foo"; println_int (Buffer.length b)
+| None -> ();
+print_any buf; print_str "\n"
@@ -0,0 +1,27 @@
+let s = "hello" in
+let c = s.[1] in
+print_char c;
+print_char '\n';
+print_char 'a'; print_char '\''; print_char '\065'; print_char '\x42'; print_char '\n';
+println_int (Char.code c);
+print_char (Char.chr (Char.code s.[0] - 32)); print_char '\n';
+println_bool (c = 'e');
+println_bool (c <> 'e');
+println_bool ('a' < 'b');
+println_bool ('\255' > 'a');
+let o = Some s.[4] in
+match o with
+| Some x -> print_char x; print_char '\n'
+| None -> ();
+let n: char option = None in
+println_bool (o = n);
+let cs = Array.make 3 'z' in
+cs.(1) <- 'y';
+print_char cs.(0); print_char cs.(1); print_char '\n';
+let t = ('p', 1) in
+let (p, i) = t in
+print_char p; println_int i;
+Printf.printf "[%c][%-3c]\n" 'q' s.[2];
+print_any 'x'; print_str "\n";
+print_any (Some '\n'); print_str "\n";
+println_str (show [| 'a'; '\'' |])
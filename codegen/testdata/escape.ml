@@ -0,0 +1,45 @@
+(* Allocations which don't escape are placed on stack *)
+let rec sum n =
+  let t = (n, n * 2) in
+  let (a, b) = t in
+  let arr = [| a; b; 3 |] in
+  let zeros = Array.make 4 0 in
+  zeros.(0) <- arr.(0) + arr.(1) + arr.(2);
+  zeros.(0)
+in
+println_int (sum 10);
+
+let rec make_pair x = (x, x + 1) in
+let (p, q) = make_pair 20 in
+println_int (p + q);
+
+let rec count n acc =
+  let pair = (n, acc) in
+  let (i, j) = pair in
+  if i = 0 then j else count (i - 1) (j + i)
+in
+println_int (count 100 0);
+
+let rec adder x =
+  let rec add y = x + y in
+  add
+in
+let add3 = adder 3 in
+println_int (add3 4);
+
+let rec apply_local x =
+  let rec mul y = x * y in
+  mul 10 + mul 20
+in
+println_int (apply_local 2);
+
+let stored = Array.make 3 (0, 0) in
+let rec store i =
+  if i < 3 then (
+    stored.(i) <- (i, i * i);
+    store (i + 1)
+  ) else ()
+in
+store 0;
+let (_, x) = stored.(2) in
+println_int x
@@ -0,0 +1,16 @@
+let rec succ x = x + 1 in
+let rec twice f x = f (f x) in
+
+3 |> succ |> println_int;
+println_int @@ succ @@ 40 + 1;
+10 |> (fun x -> x * 2) |> println_int;
+let f = if true then succ else (fun x -> twice succ x) in
+1 |> f |> println_int;
+
+println_str ("foo" ^ "bar");
+println_str @@ "a" ^ "b" ^ "c";
+let s = "x = " ^ int_to_str (1 |> succ) in
+println_str s;
+println_str ("" ^ "");
+println_bool ("ab" ^ "cd" = "abcd");
+()
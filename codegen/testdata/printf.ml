@@ -0,0 +1,18 @@
+let n = 42 in
+let s = "foo" in
+Printf.printf "%d: %s\n" n s;
+Printf.printf "hello\n";
+Printf.printf "";
+Printf.printf "%i %u %x %X %o\n" (-1) 10 255 255 8;
+Printf.printf "[%5d][%-5d][%05d][%+d]\n" 42 42 42 42;
+Printf.printf "%f %.2f %e %g\n" 3.14 3.14159 314.0 0.5;
+Printf.printf "[%s][%5s][%-5s][%.2s]\n" s s s s;
+Printf.printf "%b %B\n" true false;
+Printf.printf "100%%\n";
+Printf.printf "%s\n" (str_sub "hello" 1 3);
+
+let t = Printf.sprintf "%d + %d = %d" 1 2 (1 + 2) in
+println_str t;
+println_str (Printf.sprintf "%s" s);
+println_int (str_length (Printf.sprintf ""));
+println_str (Printf.sprintf "%.3f" (int_to_float n))
@@ -0,0 +1,23 @@
+print_any 42; print_str "\n";
+print_any (-3); print_str "\n";
+print_any 3.0; print_str "\n";
+print_any 0.25; print_str "\n";
+print_any true; print_str "\n";
+print_any (); print_str "\n";
+print_any "a\"b\n"; print_str "\n";
+print_any (1, "two", 3.5); print_str "\n";
+print_any [| 1; 2; 3 |]; print_str "\n";
+let e: int array = [| |] in
+print_any e; print_str "\n";
+print_any [| [| true |]; [| false; true |] |]; print_str "\n";
+print_any (Some 42); print_str "\n";
+print_any (Some (-1)); print_str "\n";
+print_any (Some (Some "x")); print_str "\n";
+let o: int option = None in
+print_any o; print_str "\n";
+print_any [| Some (1, 2); None |]; print_str "\n";
+print_any (fun x -> x + 1); print_str "\n";
+println_str (show (print_int, [| (); () |]));
+let s = show (Some 3.14) in
+println_int (str_length s);
+println_str s
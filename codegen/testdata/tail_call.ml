@@ -0,0 +1,22 @@
+(* Tail calls must not consume stack *)
+let rec count n acc = if n = 0 then acc else count (n - 1) (acc + 1) in
+println_int (count 10000000 0);
+
+let k = 3 in
+let rec add_k n acc =
+  if n <= 0 then acc else
+  let r = add_k (n - 1) (acc + k) in
+  r
+in
+println_int (add_k 10000000 0);
+
+let rec is_even odd n = if n = 0 then true else odd (n - 1) in
+let rec is_odd n = if n = 0 then false else is_even is_odd (n - 1) in
+println_bool (is_odd 1000001);
+println_bool (is_odd 1000000);
+
+let rec loop n = if n = 0 then println_str "done" else loop (n - 1) in
+loop 10000000;
+
+let rec find_some (f: int -> int option) n = match f n with Some x -> x | None -> find_some f (n + 1) in
+println_int (find_some (fun x -> if x = 5000000 then Some (x * 2) else None) 0)
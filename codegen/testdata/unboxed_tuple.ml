@@ -0,0 +1,56 @@
+(* Small tuples of scalars are passed by value and stored inline *)
+let rec add_vec (v: float * float) (w: float * float) =
+  let (x1, y1) = v in
+  let (x2, y2) = w in
+  (x1 +. x2, y1 +. y2)
+in
+let (x, y) = add_vec (1.0, 2.0) (0.5, 0.25) in
+println_float x;
+println_float y;
+
+let points = Array.make 3 (0.0, 0.0) in
+points.(1) <- (1.5, 2.5);
+points.(2) <- add_vec points.(1) (1.0, 1.0);
+let p2 = points.(2) in
+let (px, py) = p2 in
+println_float px;
+println_float py;
+println_str (show points);
+
+let nested = ((1, 2), (3, 4)) in
+let (_, cd) = nested in
+let (c, d) = cd in
+println_int (c + d);
+println_bool (nested = ((1, 2), (3, 4)));
+println_bool (nested <> ((1, 2), (3, 5)));
+
+let o = Some (1, 'a') in
+let none = if false then o else None in
+(match o with
+ | Some t -> let (i, c) = t in println_int i; println_str (show c)
+ | None -> println_str "unreachable");
+println_bool (none = None);
+println_bool (o = Some (1, 'a'));
+println_str (show o);
+println_str (show [| Some (3.0, true); None |]);
+
+let boxed = (1, "two", (3, 4)) in
+let (_, s, t) = boxed in
+let (_, four) = t in
+println_str s;
+println_int four;
+
+let pair = (10, 20) in
+let rec sum_with x = let (a, b) = pair in a + b + x in
+println_int (sum_with 3);
+
+let (frac, integral) = modf 3.5 in
+println_float frac;
+println_float integral;
+let (m, e) = frexp 8.0 in
+println_float m;
+println_int e;
+
+let f = modf in
+let (frac, _) = f 1.25 in
+println_float frac
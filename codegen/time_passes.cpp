@@ -0,0 +1,22 @@
+#include "time_passes.h"
+#include "llvm/Pass.h"
+#include "llvm/Support/Timer.h"
+#include "llvm/Support/raw_ostream.h"
+#include <cstring>
+#include <string>
+
+// The same as '-time-passes' option of LLVM tools. Pass managers measure each pass while it is
+// enabled.
+void gocamlSetTimePasses(int enabled) {
+    llvm::TimePassesIsEnabled = enabled != 0;
+}
+
+// Returns reports of all timers (including pass execution timing report) and resets them. The
+// returned string must be freed by caller.
+char *gocamlTakeTimePassesReport(void) {
+    std::string report;
+    llvm::raw_string_ostream os(report);
+    llvm::TimerGroup::printAll(os);
+    os.flush();
+    return strdup(report.c_str());
+}
@@ -0,0 +1,30 @@
+package codegen
+
+// #include <stdlib.h>
+// #include "time_passes.h"
+import "C"
+
+import (
+	"github.com/rhysd/gocaml/common"
+	"unsafe"
+)
+
+// Note:
+// Default optimization pipeline is populated by pass manager builder. Since passes in it cannot be
+// run one by one via LLVM C API, LLVM's own pass timers (the same as '-time-passes' of 'opt') are
+// used to measure each pass. Its report is added to the timer.
+
+// measurePasses runs f with LLVM's pass timers enabled and adds LLVM's report to the timer. When the
+// timer is nil, it only runs f.
+func measurePasses(timer *common.Timer, f func()) {
+	if timer == nil {
+		f()
+		return
+	}
+	C.gocamlSetTimePasses(1)
+	f()
+	C.gocamlSetTimePasses(0)
+	report := C.gocamlTakeTimePassesReport()
+	defer C.free(unsafe.Pointer(report))
+	timer.AddReport(C.GoString(report))
+}
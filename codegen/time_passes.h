@@ -0,0 +1,15 @@
+#if !defined GOCAML_TIME_PASSES_H_INCLUDED
+#define      GOCAML_TIME_PASSES_H_INCLUDED
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+void gocamlSetTimePasses(int enabled);
+char *gocamlTakeTimePassesReport(void);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif    // GOCAML_TIME_PASSES_H_INCLUDED
@@ -13,6 +13,7 @@ type typeBuilder struct {
 	intT      llvm.Type
 	floatT    llvm.Type
 	boolT     llvm.Type
+	charT     llvm.Type
 	stringT   llvm.Type
 	voidT     llvm.Type
 	voidPtrT  llvm.Type
@@ -20,6 +21,7 @@ type typeBuilder struct {
 	optIntT   llvm.Type
 	optBoolT  llvm.Type
 	optFloatT llvm.Type
+	optCharT  llvm.Type
 	captures  map[string]llvm.Type
 }
 
@@ -40,6 +42,7 @@ func newTypeBuilder(ctx llvm.Context, intPtrTy llvm.Type, env *types.Env) *typeB
 		integer,
 		ctx.DoubleType(),
 		ctx.Int1Type(),
+		ctx.Int8Type(),
 		str,
 		ctx.VoidType(),
 		llvm.PointerType(ctx.Int8Type(), 0 /*address space*/),
@@ -47,10 +50,48 @@ func newTypeBuilder(ctx llvm.Context, intPtrTy llvm.Type, env *types.Env) *typeB
 		ctx.IntType(65), // 64bit int + 1bit flag
 		ctx.IntType(2),  // 1bit int + 1bit flag
 		ctx.IntType(65), // 64bit float + 1bit flag
+		ctx.IntType(9),  // 8bit char + 1bit flag
 		map[string]llvm.Type{},
 	}
 }
 
+// Note:
+// Small tuples are unboxed. They are passed by value in registers and stored inline in arrays, other
+// tuples and closure captures instead of being allocated on heap. Since tuples are immutable, copying
+// them is not observable. A tuple is unboxed when it consists of at most maxUnboxedTupleFields scalar
+// values (unit, bool, int, float and char), counting fields of nested unboxed tuples. For example,
+// an array of 'float * float' is laid out as an array of inline structs.
+const maxUnboxedTupleFields = 4
+
+// scalarFields returns the number of scalar fields in the type. When the type contains non-scalar
+// value, it returns -1.
+func scalarFields(t types.Type) int {
+	switch t := t.(type) {
+	case *types.Unit, *types.Bool, *types.Int, *types.Float, *types.Char:
+		return 1
+	case *types.Tuple:
+		n := 0
+		for _, e := range t.Elems {
+			c := scalarFields(e)
+			if c < 0 {
+				return -1
+			}
+			n += c
+		}
+		return n
+	default:
+		return -1
+	}
+}
+
+func isUnboxedTuple(t types.Type) bool {
+	if _, ok := t.(*types.Tuple); !ok {
+		return false
+	}
+	n := scalarFields(t)
+	return 0 <= n && n <= maxUnboxedTupleFields
+}
+
 func (b *typeBuilder) buildClosureCaptures(name string, closure []string) llvm.Type {
 	if cached, ok := b.captures[name]; ok {
 		return cached
@@ -70,8 +111,22 @@ func (b *typeBuilder) buildClosureCaptures(name string, closure []string) llvm.T
 	return captures
 }
 
+// fromExternal returns a type of value passed to or returned from external functions. Unboxed tuples
+// are passed as pointers to heap-allocated memory to keep the ABI with C simple. Closures are passed
+// as callbacks.
+func (b *typeBuilder) fromExternal(from types.Type) llvm.Type {
+	if fun, ok := from.(*types.Fun); ok {
+		return b.buildCallback(fun)
+	}
+	t := b.fromMIR(from)
+	if isUnboxedTuple(from) {
+		return llvm.PointerType(t, 0 /*address space*/)
+	}
+	return t
+}
+
 func (b *typeBuilder) buildExternalFun(from *types.Fun) llvm.Type {
-	ret := b.fromMIR(from.Ret)
+	ret := b.fromExternal(from.Ret)
 	if ret == b.unitT {
 		// If return type of external function is unit, use void instead of unit
 		// because external function (usually written in C) does not have unit type.
@@ -80,11 +135,23 @@ func (b *typeBuilder) buildExternalFun(from *types.Fun) llvm.Type {
 	}
 	params := make([]llvm.Type, 0, len(from.Params))
 	for _, p := range from.Params {
-		params = append(params, b.fromMIR(p))
+		params = append(params, b.fromExternal(p))
 	}
 	return llvm.FunctionType(ret, params, false /*varargs*/)
 }
 
+// buildCallback creates a closure type passed to C as a callback. Its function has the same ABI as
+// external functions except that it receives a pointer to its environment as the first argument.
+func (b *typeBuilder) buildCallback(from *types.Fun) llvm.Type {
+	ext := b.buildExternalFun(from)
+	params := append([]llvm.Type{b.voidPtrT}, ext.ParamTypes()...)
+	fun := llvm.FunctionType(ext.ReturnType(), params, false /*varargs*/)
+	return b.context.StructType([]llvm.Type{
+		llvm.PointerType(fun, 0 /*address space*/),
+		b.voidPtrT,
+	}, false /*packed*/)
+}
+
 func (b *typeBuilder) buildExternalClosure(from *types.Fun) llvm.Type {
 	ret := b.fromMIR(from.Ret)
 	params := make([]llvm.Type, 0, len(from.Params)+1)
@@ -129,9 +196,22 @@ func (b *typeBuilder) buildOption(ty *types.Option) llvm.Type {
 		return b.optBoolT
 	case *types.Float:
 		return b.optFloatT
-	case *types.String, *types.Fun, *types.Tuple, *types.Array:
+	case *types.Char:
+		return b.optCharT
+	case *types.String, *types.Bytes, *types.Buffer, *types.Fun, *types.Array:
 		// Represents 'None' value with NULL pointer
 		return b.fromMIR(elem)
+	case *types.Tuple:
+		if !isUnboxedTuple(elem) {
+			// Represents 'None' value with NULL pointer
+			return b.fromMIR(elem)
+		}
+		// Unboxed tuple is not a pointer. So it needs a flag as well as unit.
+		elems := []llvm.Type{
+			b.boolT,
+			b.fromMIR(elem),
+		}
+		return b.context.StructType(elems, false /*packed*/)
 	case *types.Option:
 		elems := []llvm.Type{
 			b.boolT,
@@ -159,8 +239,14 @@ func (b *typeBuilder) fromMIR(from types.Type) llvm.Type {
 		return b.intT
 	case *types.Float:
 		return b.floatT
-	case *types.String:
+	case *types.Char:
+		return b.charT
+	case *types.String, *types.Bytes:
+		// Bytes has the same layout as string. Only difference is that its content is mutable.
 		return b.stringT
+	case *types.Buffer:
+		// Buffer is an opaque pointer to runtime's buffer struct
+		return b.voidPtrT
 	case *types.Fun:
 		// Function type which occurs in normal expression's type is always closure because
 		// function type variable is always closure. Normal function pointer never occurs in value context.
@@ -171,7 +257,11 @@ func (b *typeBuilder) fromMIR(from types.Type) llvm.Type {
 		for _, e := range ty.Elems {
 			elems = append(elems, b.fromMIR(e))
 		}
-		return llvm.PointerType(b.context.StructType(elems, false /*packed*/), 0 /*address space*/)
+		tpl := b.context.StructType(elems, false /*packed*/)
+		if isUnboxedTuple(ty) {
+			return tpl
+		}
+		return llvm.PointerType(tpl, 0 /*address space*/)
 	case *types.Array:
 		return b.context.StructType([]llvm.Type{
 			llvm.PointerType(b.fromMIR(ty.Elem), 0 /*address space*/),
@@ -0,0 +1,94 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+type timerRecord struct {
+	name    string
+	elapsed time.Duration
+}
+
+// Timer measures elapsed time of compiler phases and LLVM passes. Time of phases which have the
+// same name is accumulated. All methods can be called with nil receiver. In the case, they do
+// nothing but running given functions. So callers need not to check whether time is measured.
+type Timer struct {
+	records []*timerRecord
+	indices map[string]int
+	reports []string
+}
+
+func NewTimer() *Timer {
+	return &Timer{[]*timerRecord{}, map[string]int{}, []string{}}
+}
+
+// Add adds elapsed time of the phase.
+func (t *Timer) Add(name string, elapsed time.Duration) {
+	if t == nil {
+		return
+	}
+	if i, ok := t.indices[name]; ok {
+		t.records[i].elapsed += elapsed
+		return
+	}
+	t.indices[name] = len(t.records)
+	t.records = append(t.records, &timerRecord{name, elapsed})
+}
+
+// AddReport adds a detailed report which was already formatted (e.g. LLVM's pass execution timing
+// report). Reports are written after the table of phases.
+func (t *Timer) AddReport(report string) {
+	if t == nil {
+		return
+	}
+	t.reports = append(t.reports, report)
+}
+
+// Measure runs the function and records its elapsed time as the phase.
+func (t *Timer) Measure(name string, f func()) {
+	if t == nil {
+		f()
+		return
+	}
+	start := time.Now()
+	f()
+	t.Add(name, time.Since(start))
+}
+
+// Total returns total elapsed time of all phases.
+func (t *Timer) Total() time.Duration {
+	total := time.Duration(0)
+	if t == nil {
+		return total
+	}
+	for _, r := range t.records {
+		total += r.elapsed
+	}
+	return total
+}
+
+// Report writes a table of elapsed time of each phase in measured order to the writer.
+func (t *Timer) Report(w io.Writer) {
+	if t == nil {
+		return
+	}
+	total := t.Total()
+	fmt.Fprintln(w, "===-------------------------------------------------------------------------===")
+	fmt.Fprintln(w, "                         Compilation time report")
+	fmt.Fprintln(w, "===-------------------------------------------------------------------------===")
+	fmt.Fprintf(w, "  Total Execution Time: %.4f seconds\n\n", total.Seconds())
+	fmt.Fprintln(w, "   ---Wall Time---  --- Name ---")
+	for _, r := range t.records {
+		ratio := 0.0
+		if total > 0 {
+			ratio = float64(r.elapsed) / float64(total) * 100
+		}
+		fmt.Fprintf(w, "   %.4f (%5.1f%%)  %s\n", r.elapsed.Seconds(), ratio, r.name)
+	}
+	fmt.Fprintf(w, "   %.4f (100.0%%)  Total\n", total.Seconds())
+	for _, r := range t.reports {
+		fmt.Fprintf(w, "\n%s", r)
+	}
+}
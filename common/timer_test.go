@@ -0,0 +1,91 @@
+package common
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimerAccumulatesSameName(t *testing.T) {
+	timer := NewTimer()
+	timer.Add("parse", 2*time.Millisecond)
+	timer.Add("infer", 3*time.Millisecond)
+	timer.Add("parse", 5*time.Millisecond)
+
+	if len(timer.records) != 2 {
+		t.Fatalf("Wanted 2 records but got %d", len(timer.records))
+	}
+	if timer.records[0].name != "parse" || timer.records[0].elapsed != 7*time.Millisecond {
+		t.Errorf("Unexpected first record: %s %s", timer.records[0].name, timer.records[0].elapsed)
+	}
+	if timer.Total() != 10*time.Millisecond {
+		t.Errorf("Unexpected total time: %s", timer.Total())
+	}
+}
+
+func TestTimerMeasure(t *testing.T) {
+	timer := NewTimer()
+	called := false
+	timer.Measure("closure", func() { called = true })
+	if !called {
+		t.Fatal("Function was not called")
+	}
+	if len(timer.records) != 1 || timer.records[0].name != "closure" {
+		t.Fatal("Phase was not recorded:", timer.records)
+	}
+}
+
+func TestTimerReport(t *testing.T) {
+	timer := NewTimer()
+	timer.Add("parse", 250*time.Millisecond)
+	timer.Add("llvm: instcombine", 750*time.Millisecond)
+
+	var buf bytes.Buffer
+	timer.Report(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		"Total Execution Time: 1.0000 seconds",
+		"0.2500 ( 25.0%)  parse",
+		"0.7500 ( 75.0%)  llvm: instcombine",
+		"1.0000 (100.0%)  Total",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("'%s' is not contained in report: %s", want, out)
+		}
+	}
+}
+
+func TestTimerAdditionalReports(t *testing.T) {
+	timer := NewTimer()
+	timer.Add("parse", time.Second)
+	timer.AddReport("... Pass execution timing report ...\n")
+
+	var buf bytes.Buffer
+	timer.Report(&buf)
+	out := buf.String()
+	total := strings.Index(out, "Total\n")
+	report := strings.Index(out, "... Pass execution timing report ...")
+	if total < 0 || report < total {
+		t.Fatalf("Additional report should be written after the table: %s", out)
+	}
+}
+
+func TestNilTimer(t *testing.T) {
+	var timer *Timer
+	called := false
+	timer.Measure("parse", func() { called = true })
+	if !called {
+		t.Fatal("Function was not called with nil timer")
+	}
+	timer.Add("parse", time.Second)
+	timer.AddReport("report")
+	if timer.Total() != 0 {
+		t.Fatal("Nil timer should not record anything")
+	}
+	var buf bytes.Buffer
+	timer.Report(&buf)
+	if buf.Len() != 0 {
+		t.Fatal("Nil timer should not report anything:", buf.String())
+	}
+}
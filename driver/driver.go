@@ -7,8 +7,11 @@ import (
 	"github.com/rhysd/gocaml/ast"
 	"github.com/rhysd/gocaml/closure"
 	"github.com/rhysd/gocaml/codegen"
+	"github.com/rhysd/gocaml/common"
+	"github.com/rhysd/gocaml/mangle"
 	"github.com/rhysd/gocaml/mir"
 	"github.com/rhysd/gocaml/mono"
+	"github.com/rhysd/gocaml/opt"
 	"github.com/rhysd/gocaml/sema"
 	"github.com/rhysd/gocaml/syntax"
 	"github.com/rhysd/gocaml/token"
@@ -16,7 +19,11 @@ import (
 	"github.com/rhysd/locerr"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
 )
 
 type OptLevel int
@@ -26,14 +33,210 @@ const (
 	O1
 	O2
 	O3
+	// Os is equivalent to O2 with reducing code size
+	Os
+	// Oz is equivalent to O2 with reducing code size aggressively
+	Oz
 )
 
+// mirInlineThreshold returns the maximum size of functions inlined by MIR inliner at the level.
+// MIR inliner is disabled with O0.
+func (level OptLevel) mirInlineThreshold() int {
+	switch level {
+	case O0:
+		return 0
+	case O1:
+		return 10
+	case O3:
+		return 50
+	case Os:
+		return 10
+	case Oz:
+		return 3
+	default:
+		return 25
+	}
+}
+
+// OutputKind is a kind of final output of compilation.
+type OutputKind int
+
+const (
+	// Executable is an executable file linked with the runtime. This is the default.
+	Executable OutputKind = iota
+	// StaticLibrary is a static library archive which contains the runtime.
+	StaticLibrary
+	// SharedLibrary is a shared library linked with the runtime.
+	SharedLibrary
+)
+
+// FileKind is a kind of file emitted by EmitFile().
+type FileKind int
+
+const (
+	ObjectFile FileKind = iota
+	AssemblyFile
+	LLVMIRFile
+	BitcodeFile
+)
+
+// Ext returns a file extension of the kind of file.
+func (k FileKind) Ext() string {
+	switch k {
+	case ObjectFile:
+		return ".o"
+	case AssemblyFile:
+		return ".s"
+	case LLVMIRFile:
+		return ".ll"
+	case BitcodeFile:
+		return ".bc"
+	default:
+		panic("unreachable")
+	}
+}
+
+// Stages of compilation. Programs can be dumped before or after each stage with DumpBefore and
+// DumpAfter. Note that "tomir" stage includes alpha transform and type inference. So the AST just
+// after parsing is dumped before the stage.
+const (
+	StageToMIR     = "tomir"
+	StageInline    = "inline"
+	StageConstFold = "constfold"
+	StageClosure   = "closure"
+	StageDCE       = "dce"
+	StageMono      = "mono"
+	StageCodegen   = "codegen"
+)
+
+// Stages is a list of all stages in order.
+var Stages = []string{StageToMIR, StageInline, StageConstFold, StageClosure, StageDCE, StageMono, StageCodegen}
+
+// CheckStages returns an error when unknown stage is contained in the list.
+func CheckStages(stages []string) error {
+	for _, s := range stages {
+		if !containsStage(Stages, s) {
+			return locerr.Errorf("Unknown stage '%s'. Valid stages are %s", s, strings.Join(Stages, ", "))
+		}
+	}
+	return nil
+}
+
+func containsStage(stages []string, stage string) bool {
+	for _, s := range stages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
 // Driver instance to compile GoCaml code into other representations.
 type Driver struct {
 	Optimization OptLevel
 	LinkFlags    string
 	TargetTriple string
 	DebugInfo    bool
+	// Check division by zero at runtime
+	DivisionCheck bool
+	// Check integer overflow at runtime
+	OverflowCheck bool
+	// Output is a kind of output file of Compile()
+	Output OutputKind
+	// Exports is a list of names of toplevel functions exported to C
+	Exports []string
+	// OutFile is a path to output file. When it is empty, output file is named after the source.
+	// "-" means stdout. It is only available for EmitFile() since executables and libraries are
+	// written by linker.
+	OutFile string
+	// LinkInputs is a list of object files and libraries linked with the compiled code
+	LinkInputs []string
+	// LinkTimeOptimization links the runtime bitcode into the compiled module before optimizations
+	LinkTimeOptimization bool
+	// CPU is a name of target CPU. "native" means the CPU of host machine
+	CPU string
+	// Features is a comma-separated list of target features (e.g. "+avx2")
+	Features string
+	// RelocModel is a relocation model of generated code
+	RelocModel codegen.RelocModel
+	// CodeModel is a code model of generated code
+	CodeModel codegen.CodeModel
+	// InlineThreshold is a threshold of LLVM inliner. 0 means a threshold decided by Optimization
+	InlineThreshold int
+	// Passes is a list of LLVM passes run instead of the default optimization pipeline
+	Passes []string
+	// Timer measures elapsed time of each compilation phase when it is not nil
+	Timer *common.Timer
+	// DumpBefore is a list of stages. Program is dumped to stderr before each of them
+	DumpBefore []string
+	// DumpAfter is a list of stages. Program is dumped to stderr after each of them
+	DumpAfter []string
+	// DumpDot renders dumped MIR as Graphviz DOT
+	DumpDot bool
+	// VerifyMIR verifies MIR after each stage to find bugs of compiler passes early
+	VerifyMIR bool
+}
+
+// dumpHeader outputs a header of dump and returns true when the program should be dumped at the
+// stage. 'when' must be "Before" or "After".
+func (d *Driver) dumpHeader(when, stage, what string) bool {
+	stages := d.DumpAfter
+	if when == "Before" {
+		stages = d.DumpBefore
+	}
+	if !containsStage(stages, stage) {
+		return false
+	}
+	comment := ""
+	if d.DumpDot && what == "MIR" {
+		comment = "// "
+	}
+	fmt.Fprintf(os.Stderr, "%s*** %s Dump %s %s ***\n", comment, what, when, stage)
+	return true
+}
+
+func (d *Driver) dumpBlock(when, stage string, block *mir.Block, env *types.Env) {
+	if !d.dumpHeader(when, stage, "MIR") {
+		return
+	}
+	if d.DumpDot {
+		block.PrintDot(os.Stderr, env)
+	} else {
+		block.Println(os.Stderr, env)
+	}
+}
+
+func (d *Driver) dumpProgram(when, stage string, prog *mir.Program, env *types.Env) {
+	if !d.dumpHeader(when, stage, "MIR") {
+		return
+	}
+	if d.DumpDot {
+		prog.PrintDot(os.Stderr, env)
+	} else {
+		prog.Println(os.Stderr, env)
+	}
+}
+
+func (d *Driver) verifyBlock(stage string, block *mir.Block, env *types.Env) error {
+	if !d.VerifyMIR {
+		return nil
+	}
+	// Block before closure transform can be verified as a program which has no toplevel function
+	return d.verifyProgram(stage, &mir.Program{Toplevel: mir.NewToplevel(), Closures: mir.Closures{}, Entry: block}, env)
+}
+
+func (d *Driver) verifyProgram(stage string, prog *mir.Program, env *types.Env) error {
+	if !d.VerifyMIR {
+		return nil
+	}
+	verify := mir.Verify
+	if stage == StageMono {
+		verify = mir.VerifyMonomorphic
+	}
+	if err := verify(prog, env); err != nil {
+		return locerr.Notef(err, "MIR is broken after stage '%s'", stage)
+	}
+	return nil
 }
 
 // PrintTokens returns the lexed tokens for a source code.
@@ -65,7 +268,12 @@ func (d *Driver) PrintTokens(src *locerr.Source) {
 
 // Parse parses the source and returns the parsed AST.
 func (d *Driver) Parse(src *locerr.Source) (*ast.AST, error) {
-	return syntax.Parse(src)
+	var parsed *ast.AST
+	var err error
+	d.Timer.Measure("parse", func() {
+		parsed, err = syntax.Parse(src)
+	})
+	return parsed, err
 }
 
 // PrintAST outputs AST structure to stdout.
@@ -108,15 +316,105 @@ func (d *Driver) EmitMIR(src *locerr.Source) (*mir.Program, *types.Env, error) {
 	if err != nil {
 		return nil, nil, err
 	}
-	env, ir, err := sema.SemanticsCheck(parsed)
+	if d.dumpHeader("Before", StageToMIR, "AST") {
+		ast.Fprint(os.Stderr, parsed)
+		fmt.Fprintln(os.Stderr)
+	}
+	env, ir, err := sema.SemanticsCheckWithTimer(parsed, d.Timer)
 	if err != nil {
 		return nil, nil, err
 	}
-	prog := closure.Transform(ir)
-	prog = mono.Monomorphize(prog, env)
+	d.dumpBlock("After", StageToMIR, ir, env)
+	if err := d.verifyBlock(StageToMIR, ir, env); err != nil {
+		return nil, nil, err
+	}
+
+	d.dumpBlock("Before", StageInline, ir, env)
+	d.Timer.Measure("inline", func() {
+		opt.Inline(ir, env, d.Optimization.mirInlineThreshold(), d.OverflowCheck)
+	})
+	d.dumpBlock("After", StageInline, ir, env)
+	if err := d.verifyBlock(StageInline, ir, env); err != nil {
+		return nil, nil, err
+	}
+
+	d.dumpBlock("Before", StageConstFold, ir, env)
+	d.Timer.Measure("constfold", func() {
+		opt.FoldConstants(ir)
+	})
+	d.dumpBlock("After", StageConstFold, ir, env)
+	if err := d.verifyBlock(StageConstFold, ir, env); err != nil {
+		return nil, nil, err
+	}
+
+	var prog *mir.Program
+	d.dumpBlock("Before", StageClosure, ir, env)
+	d.Timer.Measure("closure", func() {
+		prog = closure.Transform(ir)
+	})
+	d.dumpProgram("After", StageClosure, prog, env)
+	if err := d.verifyProgram(StageClosure, prog, env); err != nil {
+		return nil, nil, err
+	}
+
+	d.dumpProgram("Before", StageDCE, prog, env)
+	d.Timer.Measure("dce", func() {
+		opt.EliminateDeadCode(prog, d.exportedFuns(prog, env), d.OverflowCheck)
+	})
+	d.dumpProgram("After", StageDCE, prog, env)
+	if err := d.verifyProgram(StageDCE, prog, env); err != nil {
+		return nil, nil, err
+	}
+
+	d.dumpProgram("Before", StageMono, prog, env)
+	d.Timer.Measure("mono", func() {
+		prog = mono.Monomorphize(prog, env)
+	})
+	d.dumpProgram("After", StageMono, prog, env)
+	if err := d.verifyProgram(StageMono, prog, env); err != nil {
+		return nil, nil, err
+	}
+
+	mir.MarkTailCalls(prog)
 	return prog, env, nil
 }
 
+// exportedFuns returns names of toplevel functions which may be exported to C. They must not be
+// removed as dead code even if they are not called in the program.
+func (d *Driver) exportedFuns(prog *mir.Program, env *types.Env) []string {
+	if len(d.Exports) == 0 {
+		return nil
+	}
+	syms := make(map[string]struct{}, len(d.Exports)*2)
+	for _, name := range d.Exports {
+		// Note:
+		// Functions defined twice with the same name are also kept so that code generator can
+		// report an error for them.
+		syms[mangle.Nested("", name, 0)] = struct{}{}
+		syms[mangle.Nested("", name, 1)] = struct{}{}
+	}
+	roots := []string{}
+	for name := range prog.Toplevel {
+		if sym, ok := env.MangledNames[name]; ok {
+			if _, ok := syms[sym]; ok {
+				roots = append(roots, name)
+			}
+		}
+	}
+	return roots
+}
+
+// PrintEscape outputs results of escape analysis for allocations in the source to stdout. Allocations
+// which don't escape are placed on stack by code generation.
+func (d *Driver) PrintEscape(src *locerr.Source) error {
+	prog, env, err := d.EmitMIR(src)
+	if err != nil {
+		return err
+	}
+	mir.AnalyzeEscape(prog).Println(os.Stdout, env)
+	return nil
+}
+
 func (d *Driver) emitterFromSource(src *locerr.Source) (*codegen.Emitter, error) {
 	prog, env, err := d.EmitMIR(src)
 	if err != nil {
@@ -124,6 +422,7 @@ func (d *Driver) emitterFromSource(src *locerr.Source) (*codegen.Emitter, error)
 	}
 
 	level := codegen.OptimizeDefault
+	size := 0
 	switch d.Optimization {
 	case O0:
 		level = codegen.OptimizeNone
@@ -131,25 +430,85 @@ func (d *Driver) emitterFromSource(src *locerr.Source) (*codegen.Emitter, error)
 		level = codegen.OptimizeLess
 	case O3:
 		level = codegen.OptimizeAggressive
+	case Os:
+		size = 1
+	case Oz:
+		size = 2
+	}
+	opts := codegen.EmitOptions{
+		Optimization:         level,
+		Triple:               d.TargetTriple,
+		LinkerFlags:          d.LinkFlags,
+		DebugInfo:            d.DebugInfo,
+		DivisionCheck:        d.DivisionCheck,
+		OverflowCheck:        d.OverflowCheck,
+		Exports:              d.Exports,
+		PositionIndependent:  d.Output == SharedLibrary,
+		LinkTimeOptimization: d.LinkTimeOptimization,
+		CPU:                  d.CPU,
+		Features:             d.Features,
+		RelocModel:           d.RelocModel,
+		CodeModel:            d.CodeModel,
+		SizeLevel:            size,
+		InlineThreshold:      d.InlineThreshold,
+		Passes:               d.Passes,
 	}
-	opts := codegen.EmitOptions{level, d.TargetTriple, d.LinkFlags, d.DebugInfo}
 
-	return codegen.NewEmitter(prog, env, src, opts)
+	d.dumpProgram("Before", StageCodegen, prog, env)
+	var emitter *codegen.Emitter
+	d.Timer.Measure("codegen", func() {
+		emitter, err = codegen.NewEmitter(prog, env, src, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if d.dumpHeader("After", StageCodegen, "LLVM IR") {
+		fmt.Fprintln(os.Stderr, emitter.EmitLLVMIR())
+	}
+	emitter.Timer = d.Timer
+	return emitter, nil
 }
 
 func (d *Driver) EmitObjFile(src *locerr.Source) error {
+	return d.EmitFile(src, ObjectFile)
+}
+
+// EmitFile compiles the source and writes the result to OutFile. When OutFile is empty, the file is
+// named after the source with the extension of the kind (e.g. 'foo.ml' -> 'foo.s').
+func (d *Driver) EmitFile(src *locerr.Source, kind FileKind) error {
 	emitter, err := d.emitterFromSource(src)
 	if err != nil {
 		return err
 	}
 	defer emitter.Dispose()
 	emitter.RunOptimizationPasses()
-	obj, err := emitter.EmitObject()
+
+	var content []byte
+	switch kind {
+	case ObjectFile:
+		content, err = emitter.EmitObject()
+	case AssemblyFile:
+		var asm string
+		asm, err = emitter.EmitAsm()
+		content = []byte(asm)
+	case LLVMIRFile:
+		content = []byte(emitter.EmitLLVMIR())
+	case BitcodeFile:
+		content = emitter.EmitBitcode()
+	}
 	if err != nil {
 		return err
 	}
-	filename := fmt.Sprintf("%s.o", src.BaseName())
-	return ioutil.WriteFile(filename, obj, 0666)
+
+	path := d.OutFile
+	if path == "" {
+		path = src.BaseName() + kind.Ext()
+	}
+	if path == "-" {
+		_, err := os.Stdout.Write(content)
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0666)
 }
 
 func (d *Driver) EmitLLVMIR(src *locerr.Source) (string, error) {
@@ -174,21 +533,156 @@ func (d *Driver) EmitAsm(src *locerr.Source) (string, error) {
 	return emitter.EmitAsm()
 }
 
+// EmitHeader returns a C header which declares external symbols defined in C and exported
+// functions with C types.
+func (d *Driver) EmitHeader(src *locerr.Source) (string, error) {
+	emitter, err := d.emitterFromSource(src)
+	if err != nil {
+		return "", err
+	}
+	defer emitter.Dispose()
+
+	name := "a.h"
+	if src.Exists {
+		name = filepath.Base(src.BaseName()) + ".h"
+	}
+	return emitter.EmitHeader(name), nil
+}
+
+// checkLinkedOutFile checks OutFile for outputs written by linker. Linker cannot write them to stdout.
+func (d *Driver) checkLinkedOutFile() error {
+	if d.OutFile == "-" {
+		return locerr.Errorf("Executable or library cannot be written to stdout. '-o -' is only available with -c or -S")
+	}
+	return nil
+}
+
 func (d *Driver) Compile(source *locerr.Source) error {
+	if err := d.checkLinkedOutFile(); err != nil {
+		return err
+	}
 	emitter, err := d.emitterFromSource(source)
 	if err != nil {
 		return err
 	}
 	defer emitter.Dispose()
 	emitter.RunOptimizationPasses()
-	var executable string
+
+	if d.Output != Executable {
+		return d.emitLibrary(emitter, source)
+	}
+
+	executable := d.OutFile
+	if executable == "" {
+		if source.Exists {
+			executable = source.BaseName()
+		} else {
+			executable, err = filepath.Abs("a.out")
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return emitter.EmitExecutable(executable, d.LinkInputs...)
+}
+
+// Run compiles the source into an executable in a temporary directory and runs it with the
+// arguments. Standard input and outputs are connected to the program. It returns the exit status
+// of the program. When the program is killed by a signal, it returns 128 + signal number as shells
+// do. The temporary directory is removed after the program exits.
+func (d *Driver) Run(source *locerr.Source, args []string) (int, error) {
+	if d.Output != Executable {
+		return 0, locerr.Errorf("Only an executable can be run")
+	}
+
+	dir, err := ioutil.TempDir("", "gocaml-run-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dir)
+
+	name := "a.out"
 	if source.Exists {
-		executable = source.BaseName()
+		name = source.BaseName()
+	}
+	executable := filepath.Join(dir, name)
+
+	compiler := *d
+	compiler.OutFile = executable
+	if err := compiler.Compile(source); err != nil {
+		return 0, err
+	}
+
+	cmd := exec.Command(executable, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if exit, ok := err.(*exec.ExitError); ok {
+			if status, ok := exit.Sys().(syscall.WaitStatus); ok {
+				if status.Signaled() {
+					return 128 + int(status.Signal()), nil
+				}
+				return status.ExitStatus(), nil
+			}
+		}
+		return 0, err
+	}
+	return 0, nil
+}
+
+// Link links object files and libraries in LinkInputs into an executable without compiling any
+// source. One of the objects must be compiled from GoCaml source (e.g. with EmitObjFile()).
+func (d *Driver) Link() error {
+	if d.Output != Executable {
+		return locerr.Errorf("Library cannot be created only from object files. GoCaml source is necessary")
+	}
+	if err := d.checkLinkedOutFile(); err != nil {
+		return err
+	}
+	executable := d.OutFile
+	if executable == "" {
+		executable = "a.out"
+	}
+	return codegen.LinkExecutable(executable, d.LinkInputs, d.LinkFlags)
+}
+
+// emitLibrary emits a library file and a C header file declaring exported functions. When the
+// source is 'foo.ml', 'libfoo.a' (or 'libfoo.so') and 'foo.h' are generated. When OutFile is
+// specified, the header is put in the same directory as the library.
+func (d *Driver) emitLibrary(emitter *codegen.Emitter, source *locerr.Source) error {
+	base := "a"
+	if source.Exists {
+		base = source.BaseName()
+	}
+	dir, name := filepath.Split(base)
+
+	lib := d.OutFile
+	var err error
+	if d.Output == StaticLibrary {
+		if lib == "" {
+			lib = filepath.Join(dir, "lib"+name+".a")
+		}
+		for _, in := range d.LinkInputs {
+			if filepath.Ext(in) != ".o" {
+				return locerr.Errorf("Only object files can be added to static library but '%s' was given", in)
+			}
+		}
+		err = emitter.EmitStaticLibrary(lib, d.LinkInputs...)
 	} else {
-		executable, err = filepath.Abs("a.out")
-		if err != nil {
-			return err
+		if lib == "" {
+			ext := ".so"
+			if runtime.GOOS == "darwin" {
+				ext = ".dylib"
+			}
+			lib = filepath.Join(dir, "lib"+name+ext)
 		}
+		err = emitter.EmitSharedLibrary(lib, d.LinkInputs...)
 	}
-	return emitter.EmitExecutable(executable)
+	if err != nil {
+		return err
+	}
+
+	header := filepath.Join(filepath.Dir(lib), name+".h")
+	return ioutil.WriteFile(header, []byte(emitter.EmitHeader(filepath.Base(header))), 0666)
 }
@@ -0,0 +1,216 @@
+package driver
+
+import (
+	"fmt"
+	"github.com/rhysd/locerr"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runWithStdout runs the source with Driver.Run() and returns its exit status and output to stdout.
+func runWithStdout(t *testing.T, d Driver, code string, args []string) (int, string) {
+	f, err := ioutil.TempFile("", "gocaml-run-test")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	stdout := os.Stdout
+	os.Stdout = f
+	status, err := d.Run(locerr.NewDummySource(code), args)
+	os.Stdout = stdout
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		panic(err)
+	}
+	return status, string(out)
+}
+
+func TestRun(t *testing.T) {
+	for _, tc := range []struct {
+		what   string
+		code   string
+		args   []string
+		status int
+		output string
+	}{
+		{
+			"arguments and exit status",
+			`
+			external exit: int -> unit = "exit";
+			let rec print_args i =
+				if i < Array.length argv then (println_str argv.(i); print_args (i + 1)) else ()
+			in
+			print_args 1;
+			exit (Array.length argv)`,
+			[]string{"foo", "-o", "--", "bar baz"},
+			5,
+			"foo\n-o\n--\nbar baz\n",
+		},
+		{
+			"successful exit",
+			`println_int 42`,
+			nil,
+			0,
+			"42\n",
+		},
+		{
+			"killed by signal",
+			`
+			external send_signal: int -> int = "raise";
+			let _ = send_signal 9 in
+			println_str "not reached"`,
+			nil,
+			128 + 9,
+			"",
+		},
+	} {
+		t.Run(tc.what, func(t *testing.T) {
+			status, out := runWithStdout(t, Driver{}, tc.code, tc.args)
+			if status != tc.status {
+				t.Errorf("Wanted exit status %d but got %d", tc.status, status)
+			}
+			if out != tc.output {
+				t.Errorf("Unexpected output:\n\nGot: '%s'\nWant: '%s'", out, tc.output)
+			}
+		})
+	}
+}
+
+// Unused arithmetic must not be removed by optimizations when overflow is checked at runtime
+func TestRunUnusedOverflow(t *testing.T) {
+	code := `
+	let rec add x y = x + y in
+	let rec neg x = -x in
+	let max = 9223372036854775807 in
+	let _ = add max 1 in
+	let _ = neg (-max - 1) in
+	println_str "not reached"`
+	d := Driver{Optimization: O2, OverflowCheck: true}
+	status, out := runWithStdout(t, d, code, nil)
+	if status == 0 {
+		t.Fatalf("Program should fail due to integer overflow but succeeded: '%s'", out)
+	}
+	if out != "" {
+		t.Fatalf("Program should stop before output: '%s'", out)
+	}
+}
+
+// Functions specialized or copied by MIR inliner must have their own symbol names and must not
+// change behavior of programs.
+func TestRunWithInliner(t *testing.T) {
+	for _, tc := range []struct {
+		file   string
+		output string
+	}{
+		{"../examples/compose.ml", "1600\n"},
+		{"../codegen/testdata/tail_call.ml", ""},
+		{"../codegen/testdata/pipe_op.ml", ""},
+		{"../codegen/testdata/lambda.ml", ""},
+	} {
+		t.Run(filepath.Base(tc.file), func(t *testing.T) {
+			want := tc.output
+			if want == "" {
+				b, err := ioutil.ReadFile(strings.TrimSuffix(tc.file, ".ml") + ".out")
+				if err != nil {
+					panic(err)
+				}
+				want = strings.TrimSuffix(string(b), "\n") // Trim EOL (newline at the end of file)
+			}
+			src, err := locerr.NewSourceFromFile(tc.file)
+			if err != nil {
+				panic(err)
+			}
+			d := Driver{Optimization: O2}
+
+			prog, env, err := d.EmitMIR(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			syms := map[string]string{}
+			for name := range prog.Toplevel {
+				sym, ok := env.MangledNames[name]
+				if !ok {
+					t.Errorf("Function '%s' has no mangled symbol name", name)
+					continue
+				}
+				if other, ok := syms[sym]; ok {
+					t.Errorf("Functions '%s' and '%s' have the same symbol name '%s'", name, other, sym)
+				}
+				syms[sym] = name
+			}
+
+			status, out := runWithStdout(t, d, string(src.Code), nil)
+			if status != 0 {
+				t.Fatalf("Program exited with status %d: '%s'", status, out)
+			}
+			if out != want {
+				t.Fatalf("Unexpected output with inliner:\n\nGot: '%s'\nWant: '%s'", out, want)
+			}
+		})
+	}
+}
+
+// Passes on MIR must keep MIR well-formed for all sources in codegen tests
+func TestVerifyMIR(t *testing.T) {
+	skipped := map[string]string{
+		"option_eq.ml":           "type checker cannot instantiate generic function with 'None'",
+		"option_values.ml":       "type checker cannot instantiate generic function with 'None'",
+		"zero_length_array.ml":   "type checker cannot instantiate generic function with empty array",
+		"tuple.ml":               "type checker crashes on occur check of generic type variable",
+		"nested_aggregates.ml":   "type checker crashes on occur check of generic type variable",
+		"array.ml":               "polymorphic function values are not monomorphized yet",
+		"arrstore_bug.ml":        "polymorphic closures are not monomorphized yet",
+		"compare_fun.ml":         "polymorphic closures are not monomorphized yet",
+		"function_var.ml":        "polymorphic closures are not monomorphized yet",
+		"issue_15_fixed.ml":      "polymorphic closures are not monomorphized yet",
+		"type_annotation_fun.ml": "polymorphic function values are not monomorphized yet",
+		"underscore.ml":          "polymorphic function values are not monomorphized yet",
+	}
+
+	files, err := filepath.Glob("../codegen/testdata/*.ml")
+	if err != nil {
+		panic(err)
+	}
+	if len(files) == 0 {
+		panic("No test file was found")
+	}
+
+	for _, file := range files {
+		name := filepath.Base(file)
+		for _, level := range []OptLevel{O0, O2} {
+			t.Run(fmt.Sprintf("%s:O%d", name, level), func(t *testing.T) {
+				if reason, ok := skipped[name]; ok {
+					t.Skip(reason)
+				}
+				src, err := locerr.NewSourceFromFile(file)
+				if err != nil {
+					panic(err)
+				}
+				d := Driver{Optimization: level, VerifyMIR: true}
+				if _, _, err := d.EmitMIR(src); err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+	}
+}
+
+func TestRunLibrary(t *testing.T) {
+	d := Driver{Output: StaticLibrary}
+	_, err := d.Run(locerr.NewDummySource("println_int 42"), nil)
+	if err == nil {
+		t.Fatal("Error did not occur")
+	}
+	if msg := err.Error(); !strings.Contains(msg, "Only an executable can be run") {
+		t.Fatal("Unexpected error:", msg)
+	}
+}
@@ -4,9 +4,13 @@ import (
 	"flag"
 	"fmt"
 	"github.com/rhysd/gocaml/codegen"
+	"github.com/rhysd/gocaml/common"
 	"github.com/rhysd/gocaml/driver"
+	"github.com/rhysd/gocaml/mangle"
 	"github.com/rhysd/locerr"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -24,14 +28,58 @@ var (
 	ldflags     = flag.String("ldflags", "", "Flags passed to underlying linker")
 	debug       = flag.Bool("g", false, "Compile with debug information")
 	target      = flag.String("target", "", "Target architecture triple")
-	showTargets = flag.Bool("show-targets", false, "Show all available targets")
+	showTargets = flag.Bool("show-targets", false, "Show all available targets, and CPUs and features for the target (to stderr)")
+	divCheck    = flag.Bool("check-div", false, "Check division by zero at runtime (enabled by default with -opt 0)")
+	trapv       = flag.Bool("trapv", false, "Check integer overflow of +, -, * and / at runtime")
+	printEscape = flag.Bool("print-escape", false, "Show results of escape analysis for allocations to stdout")
+	exports     = flag.String("export", "", "Comma-separated names of toplevel functions exported to C")
+	lib         = flag.Bool("lib", false, "Compile to static library 'libXXX.a' with C header 'XXX.h'")
+	shared      = flag.Bool("shared", false, "Compile to shared library 'libXXX.so' with C header 'XXX.h'")
+	emitHeader  = flag.Bool("emit-header", false, "Emit C header declaring external symbols and exported functions to stdout")
+	outFile     = flag.String("o", "", "Write output to the file. '-' means stdout (only for -c and -S)")
+	compileOnly = flag.Bool("c", false, "Compile to object file without linking (the same as -obj)")
+	emitAsmFile = flag.Bool("S", false, "Compile to assembly file")
+	emitLLVM    = flag.Bool("emit-llvm", false, "Use LLVM representation for -c (bitcode) and -S (LLVM IR)")
+	emitBC      = flag.Bool("emit-bc", false, "Compile to LLVM bitcode file (the same as -c -emit-llvm)")
+	lto         = flag.Bool("flto", false, "Link runtime bitcode 'gocamlrt.bc' before optimizations so that runtime functions can be inlined")
+	cpu         = flag.String("mcpu", "", "Target CPU name (e.g. 'haswell'). 'native' means the CPU of this machine")
+	features    = flag.String("mattr", "", "Comma-separated target features to enable or disable (e.g. '+avx2,-sse4.1')")
+	relocModel  = flag.String("relocation-model", "default", "Relocation model ('default', 'static', 'pic' or 'dynamic-no-pic')")
+	codeModel   = flag.String("code-model", "default", "Code model ('default', 'small', 'kernel', 'medium' or 'large')")
+	optSize     = flag.Bool("Os", false, "Optimize for code size (-opt 2 with reducing code size)")
+	optMinSize  = flag.Bool("Oz", false, "Optimize for code size aggressively (-opt 2 with reducing code size further)")
+	inlineLimit = flag.Int("inline-threshold", 0, "Threshold of LLVM inliner (0 means default of optimization level)")
+	passes      = flag.String("passes", "", "Comma-separated LLVM passes run instead of the default optimization pipeline (e.g. 'inline,instcombine,gvn')")
+	timePasses  = flag.Bool("time-passes", false, "Report elapsed time of each compilation phase and LLVM pass to stderr")
+	dumpBefore  = flag.String("dump-before", "", "Comma-separated stages (tomir, inline, constfold, closure, dce, mono, codegen). Dump program to stderr before them")
+	dumpAfter   = flag.String("dump-after", "", "Comma-separated stages (tomir, inline, constfold, closure, dce, mono, codegen). Dump program to stderr after them")
+	mirDot      = flag.Bool("mir-dot", false, "Render MIR as Graphviz DOT for -mir, -dump-before and -dump-after")
+	verifyMIR   = flag.Bool("verify-mir", false, "Verify MIR after each stage and report broken MIR as an error (for debugging the compiler)")
 )
 
-const usageHeader = `Usage: gocaml [flags] [file]
+const usageHeader = `Usage: gocaml [flags] [files...]
+       gocaml run [flags] file [-- args...]
+       gocaml demangle [symbols...]
 
   Compiler for GoCaml.
   When file is given as argument, compiler will compile it. Otherwise, compiler
-  attempt to read from STDIN as source code to compile.
+  attempt to read from STDIN as source code to compile. Flags can be put after
+  files. Arguments after '--' are treated as files even if they start with '-'.
+
+  Object files and libraries (.o, .a, .so, .dylib) given as arguments are linked
+  together. Multiple sources can be compiled at once only with -c or -S since
+  each source is a whole program.
+
+  'run' subcommand compiles the source into a temporary directory and runs it.
+  Flags must be put before the file. Arguments after the file (and optional
+  '--') are passed to the program and its exit status is propagated. When the
+  program is killed by a signal, the status is 128 + signal number. A shebang
+  line '#!/usr/bin/env -S gocaml run' at the head of source is ignored so that
+  the source can be run as a script.
+
+  'demangle' subcommand demangles symbol names of GoCaml functions given as
+  arguments. When no argument is given, it reads text from STDIN and outputs it
+  replacing mangled symbols with demangled names (e.g. 'nm a.out | gocaml demangle').
 
 Flags:`
 
@@ -41,6 +89,12 @@ func usage() {
 }
 
 func getOptLevel() driver.OptLevel {
+	switch {
+	case *optMinSize:
+		return driver.Oz
+	case *optSize:
+		return driver.Os
+	}
 	switch *opt {
 	case 0:
 		return driver.O0
@@ -58,9 +112,217 @@ func getOptLevel() driver.OptLevel {
 	}
 }
 
+func getDivisionCheck(level driver.OptLevel) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "check-div" {
+			set = true
+		}
+	})
+	if set {
+		return *divCheck
+	}
+	return level == driver.O0
+}
+
+func splitList(list string) []string {
+	names := []string{}
+	for _, n := range strings.Split(list, ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+func getExports() []string {
+	return splitList(*exports)
+}
+
+func getStages(list string) []string {
+	stages := splitList(list)
+	if err := driver.CheckStages(stages); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(4)
+	}
+	return stages
+}
+
+func getTimer() *common.Timer {
+	if !*timePasses {
+		return nil
+	}
+	return common.NewTimer()
+}
+
+func getOutputKind() driver.OutputKind {
+	switch {
+	case *shared:
+		return driver.SharedLibrary
+	case *lib:
+		return driver.StaticLibrary
+	default:
+		return driver.Executable
+	}
+}
+
+func getRelocModel() codegen.RelocModel {
+	m, err := codegen.ParseRelocModel(*relocModel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(4)
+	}
+	return m
+}
+
+func getCodeModel() codegen.CodeModel {
+	m, err := codegen.ParseCodeModel(*codeModel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(4)
+	}
+	return m
+}
+
+// parseArgs parses command line flags and returns positional arguments. Unlike flag.Parse(), flags
+// can be put after positional arguments like 'gocaml foo.ml -o foo'. All arguments after '--' are
+// positional arguments even if they start with '-'.
+func parseArgs(args []string) []string {
+	inputs := []string{}
+	for {
+		flag.CommandLine.Parse(args)
+		rest := flag.Args()
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(inputs, rest...)
+		}
+		if len(rest) == 0 {
+			return inputs
+		}
+		inputs = append(inputs, rest[0])
+		args = rest[1:]
+	}
+}
+
+// splitInputs splits inputs into GoCaml sources and files passed to linker.
+func splitInputs(inputs []string) ([]string, []string) {
+	sources := []string{}
+	linkInputs := []string{}
+	for _, in := range inputs {
+		switch filepath.Ext(in) {
+		case ".o", ".a", ".so", ".dylib":
+			linkInputs = append(linkInputs, in)
+		default:
+			sources = append(sources, in)
+		}
+	}
+	return sources, linkInputs
+}
+
+func getFileKind() (driver.FileKind, bool) {
+	object := *compileOnly || *obj
+	switch {
+	case *emitAsmFile && *emitLLVM:
+		return driver.LLVMIRFile, true
+	case *emitAsmFile:
+		return driver.AssemblyFile, true
+	case (object && *emitLLVM) || *emitBC:
+		return driver.BitcodeFile, true
+	case object:
+		return driver.ObjectFile, true
+	default:
+		return driver.ObjectFile, false
+	}
+}
+
+func openSource(path string) *locerr.Source {
+	var src *locerr.Source
+	var err error
+	if path == "" {
+		src, err = locerr.NewSourceFromStdin()
+	} else {
+		src, err = locerr.NewSourceFromFile(path)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error on opening source: %s\n", err.Error())
+		os.Exit(4)
+	}
+	return src
+}
+
+// run implements 'run' subcommand. Flags must be put before the source file. Arguments after the
+// source file are passed to the program. '--' just after the source file is omitted.
+func run(args []string) {
+	flag.Usage = usage
+	flag.CommandLine.Parse(args)
+	if *help {
+		usage()
+		os.Exit(0)
+	}
+
+	path := ""
+	progArgs := []string{}
+	if rest := flag.Args(); len(rest) > 0 {
+		path = rest[0]
+		progArgs = rest[1:]
+		if len(progArgs) > 0 && progArgs[0] == "--" {
+			progArgs = progArgs[1:]
+		}
+	}
+
+	level := getOptLevel()
+	d := driver.Driver{
+		Optimization:         level,
+		TargetTriple:         *target,
+		LinkFlags:            *ldflags,
+		DebugInfo:            *debug,
+		DivisionCheck:        getDivisionCheck(level),
+		OverflowCheck:        *trapv,
+		LinkTimeOptimization: *lto,
+		CPU:                  *cpu,
+		Features:             *features,
+		RelocModel:           getRelocModel(),
+		CodeModel:            getCodeModel(),
+		InlineThreshold:      *inlineLimit,
+		Passes:               splitList(*passes),
+		Timer:                getTimer(),
+		DumpBefore:           getStages(*dumpBefore),
+		DumpAfter:            getStages(*dumpAfter),
+		DumpDot:              *mirDot,
+		VerifyMIR:            *verifyMIR,
+	}
+
+	status, err := d.Run(openSource(path), progArgs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(4)
+	}
+	d.Timer.Report(os.Stderr)
+	os.Exit(status)
+}
+
+func demangle(args []string) {
+	var in io.Reader = os.Stdin
+	if len(args) > 0 {
+		in = strings.NewReader(strings.Join(args, "\n"))
+	}
+	if err := mangle.Filter(in, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(4)
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "demangle" {
+		demangle(os.Args[2:])
+		os.Exit(0)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "run" {
+		run(os.Args[2:])
+	}
+
 	flag.Usage = usage
-	flag.Parse()
+	inputs := parseArgs(os.Args[1:])
 
 	if *help {
 		usage()
@@ -76,29 +338,82 @@ func main() {
 			pad := strings.Repeat("\t", tabs)
 			fmt.Printf("%s:%s%s\n", t.Name, pad, t.Description)
 		}
+		if err := codegen.PrintCPUsAndFeatures(*target); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(4)
+		}
 		os.Exit(0)
 	}
 
-	var src *locerr.Source
-	var err error
+	sources, linkInputs := splitInputs(inputs)
 
-	if flag.NArg() == 0 {
-		src, err = locerr.NewSourceFromStdin()
-	} else {
-		src, err = locerr.NewSourceFromFile(flag.Arg(0))
+	level := getOptLevel()
+	d := driver.Driver{
+		Optimization:         level,
+		TargetTriple:         *target,
+		LinkFlags:            *ldflags,
+		DebugInfo:            *debug,
+		DivisionCheck:        getDivisionCheck(level),
+		OverflowCheck:        *trapv,
+		Output:               getOutputKind(),
+		Exports:              getExports(),
+		OutFile:              *outFile,
+		LinkInputs:           linkInputs,
+		LinkTimeOptimization: *lto,
+		CPU:                  *cpu,
+		Features:             *features,
+		RelocModel:           getRelocModel(),
+		CodeModel:            getCodeModel(),
+		InlineThreshold:      *inlineLimit,
+		Passes:               splitList(*passes),
+		Timer:                getTimer(),
+		DumpBefore:           getStages(*dumpBefore),
+		DumpAfter:            getStages(*dumpAfter),
+		DumpDot:              *mirDot,
+		VerifyMIR:            *verifyMIR,
 	}
 
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error on opening source: %s\n", err.Error())
+	if kind, ok := getFileKind(); ok {
+		if len(sources) > 1 && *outFile != "" {
+			fmt.Fprintln(os.Stderr, "Cannot specify -o when generating multiple output files")
+			os.Exit(4)
+		}
+		if len(sources) == 0 {
+			sources = append(sources, "") // STDIN
+		}
+		for _, path := range sources {
+			if err := d.EmitFile(openSource(path), kind); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(4)
+			}
+		}
+		d.Timer.Report(os.Stderr)
+		os.Exit(0)
+	}
+
+	if *emitLLVM {
+		fmt.Fprintln(os.Stderr, "-emit-llvm cannot be used when linking. Use it with -c or -S")
 		os.Exit(4)
 	}
 
-	d := driver.Driver{
-		Optimization: getOptLevel(),
-		TargetTriple: *target,
-		LinkFlags:    *ldflags,
-		DebugInfo:    *debug,
+	if len(sources) > 1 {
+		fmt.Fprintln(os.Stderr, "Multiple sources cannot be linked together since each GoCaml source is a whole program. Use -c or -S to compile them separately")
+		os.Exit(4)
+	}
+
+	if len(sources) == 0 && len(linkInputs) > 0 {
+		if err := d.Link(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(4)
+		}
+		os.Exit(0)
+	}
+
+	path := ""
+	if len(sources) == 1 {
+		path = sources[0]
 	}
+	src := openSource(path)
 
 	switch {
 	case *showTokens:
@@ -121,7 +436,23 @@ func main() {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(4)
 		}
-		prog.Println(os.Stdout, env)
+		if *mirDot {
+			prog.PrintDot(os.Stdout, env)
+		} else {
+			prog.Println(os.Stdout, env)
+		}
+	case *printEscape:
+		if err := d.PrintEscape(src); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(4)
+		}
+	case *emitHeader:
+		header, err := d.EmitHeader(src)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(4)
+		}
+		fmt.Print(header)
 	case *llvm:
 		ir, err := d.EmitLLVMIR(src)
 		if err != nil {
@@ -136,15 +467,12 @@ func main() {
 			os.Exit(4)
 		}
 		fmt.Println(asm)
-	case *obj:
-		if err := d.EmitObjFile(src); err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(4)
-		}
 	default:
 		if err := d.Compile(src); err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(4)
 		}
 	}
+
+	d.Timer.Report(os.Stderr)
 }
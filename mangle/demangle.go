@@ -0,0 +1,242 @@
+package mangle
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/rhysd/gocaml/types"
+	"io"
+	"regexp"
+	"strings"
+)
+
+type demangler struct {
+	src string
+	idx int
+}
+
+func (d *demangler) eof() bool {
+	return d.idx >= len(d.src)
+}
+
+func (d *demangler) peek() byte {
+	if d.eof() {
+		return 0
+	}
+	return d.src[d.idx]
+}
+
+func (d *demangler) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("Cannot demangle '%s' at offset %d: %s", d.src, d.idx, fmt.Sprintf(format, args...))
+}
+
+func (d *demangler) number() (int, bool) {
+	start := d.idx
+	n := 0
+	for !d.eof() && '0' <= d.peek() && d.peek() <= '9' {
+		n = n*10 + int(d.peek()-'0')
+		d.idx++
+	}
+	return n, d.idx != start
+}
+
+func (d *demangler) segment() (string, error) {
+	n, ok := d.number()
+	if !ok {
+		return "", d.errorf("Length of name is expected")
+	}
+	if n == 0 || d.idx+n > len(d.src) {
+		return "", d.errorf("Invalid length of name %d", n)
+	}
+	name := d.src[d.idx : d.idx+n]
+	d.idx += n
+
+	if d.peek() != 'D' {
+		return name, nil
+	}
+	d.idx++
+	index, ok := d.number()
+	if !ok || d.peek() != '_' {
+		return "", d.errorf("Invalid discriminator for '%s'", name)
+	}
+	d.idx++
+	return fmt.Sprintf("%s#%d", name, index), nil
+}
+
+func (d *demangler) typeList() ([]types.Type, error) {
+	ts := []types.Type{}
+	for d.peek() != 'E' {
+		t, err := d.typ()
+		if err != nil {
+			return nil, err
+		}
+		ts = append(ts, t)
+	}
+	d.idx++ // Eat 'E'
+	return ts, nil
+}
+
+func (d *demangler) typ() (types.Type, error) {
+	if d.eof() {
+		return nil, d.errorf("Type is expected but reached end of symbol")
+	}
+	c := d.peek()
+	d.idx++
+	switch c {
+	case 'u':
+		return types.UnitType, nil
+	case 'b':
+		return types.BoolType, nil
+	case 'i':
+		return types.IntType, nil
+	case 'f':
+		return types.FloatType, nil
+	case 'c':
+		return types.CharType, nil
+	case 's':
+		return types.StringType, nil
+	case 'y':
+		return types.BytesType, nil
+	case 'r':
+		return types.BufferType, nil
+	case 'V':
+		return types.NewGeneric(), nil
+	case 'A':
+		elem, err := d.typ()
+		if err != nil {
+			return nil, err
+		}
+		return &types.Array{elem}, nil
+	case 'O':
+		elem, err := d.typ()
+		if err != nil {
+			return nil, err
+		}
+		return &types.Option{elem}, nil
+	case 'T':
+		elems, err := d.typeList()
+		if err != nil {
+			return nil, err
+		}
+		if len(elems) < 2 {
+			return nil, d.errorf("Tuple must have at least 2 elements but %d", len(elems))
+		}
+		return &types.Tuple{elems}, nil
+	case 'F':
+		ts, err := d.typeList()
+		if err != nil {
+			return nil, err
+		}
+		if len(ts) < 2 {
+			return nil, d.errorf("Function type must have return type and parameters")
+		}
+		return &types.Fun{ts[0], ts[1:]}, nil
+	default:
+		d.idx--
+		return nil, d.errorf("Unknown type '%c'", c)
+	}
+}
+
+func (d *demangler) symbol() (string, error) {
+	if !strings.HasPrefix(d.src[d.idx:], Prefix) {
+		return "", d.errorf("Symbol must start with '%s'", Prefix)
+	}
+	d.idx += len(Prefix)
+
+	segs := []string{}
+	for !d.eof() && '0' <= d.peek() && d.peek() <= '9' {
+		s, err := d.segment()
+		if err != nil {
+			return "", err
+		}
+		segs = append(segs, s)
+	}
+	if len(segs) == 0 {
+		return "", d.errorf("At least one name is necessary")
+	}
+	name := strings.Join(segs, ".")
+
+	spec := ""
+	if d.peek() == 'S' {
+		d.idx++
+		index, ok := d.number()
+		if !ok || d.peek() != '_' {
+			return "", d.errorf("Invalid specialization for '%s'", name)
+		}
+		d.idx++
+		spec = fmt.Sprintf(" (specialization %d)", index)
+	}
+
+	if d.peek() == 'I' {
+		d.idx++
+		ts, err := d.typeList()
+		if err != nil {
+			return "", err
+		}
+		if len(ts) == 0 {
+			return "", d.errorf("Instantiation must have at least one type")
+		}
+		ss := make([]string, 0, len(ts))
+		for _, t := range ts {
+			ss = append(ss, t.String())
+		}
+		name = fmt.Sprintf("%s<%s>", name, strings.Join(ss, ", "))
+	}
+
+	switch d.peek() {
+	case 'W':
+		d.idx++
+		name += " (closure)"
+	case 'C':
+		d.idx++
+		name += " (callback)"
+	}
+
+	return name + spec, nil
+}
+
+// Demangle converts the mangled symbol name into human readable name. An error is returned when
+// the symbol is not a valid mangled name.
+func Demangle(sym string) (string, error) {
+	d := &demangler{sym, 0}
+	name, err := d.symbol()
+	if err != nil {
+		return "", err
+	}
+	if !d.eof() {
+		return "", d.errorf("Unexpected characters after symbol")
+	}
+	return name, nil
+}
+
+// Note: Names in source may contain unicode letters.
+var reSymbol = regexp.MustCompile(`_GC[0-9A-Za-z_\p{L}]+`)
+
+func demangleWord(word string) string {
+	name, err := Demangle(word)
+	if err != nil {
+		return word
+	}
+	return name
+}
+
+// Filter reads text from r and writes it to w replacing all mangled symbols in the text with
+// demangled names. Texts which are not valid mangled symbols are kept as-is. Each line is written
+// with a newline at the end. Lines can be longer than bufio.Scanner's limit.
+func Filter(r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			line = reSymbol.ReplaceAllStringFunc(strings.TrimSuffix(line, "\n"), demangleWord)
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
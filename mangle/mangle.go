@@ -0,0 +1,164 @@
+// Package mangle provides name mangling for symbols of GoCaml functions and its demangler.
+//
+// Identifiers in MIR are named by alpha transformation and monomorphization (e.g. 'id$t1$int').
+// They depend on counters in compiler so they are unstable between builds and hard to read in
+// tools such as 'nm' or 'perf'. Instead, functions are emitted with symbol names mangled by the
+// following scheme.
+//
+//	symbol         := "_GC" segment+ [specialization] [instantiation] [suffix]
+//	segment        := <length> <name> [discriminator]
+//	discriminator  := "D" <index> "_"
+//	specialization := "S" <index> "_"
+//	instantiation  := "I" type+ "E"
+//	suffix         := "W" | "C"
+//	type           := "u" | "b" | "i" | "f" | "c" | "s" | "y" | "r"
+//	                | "F" type type+ "E"
+//	                | "T" type+ "E"
+//	                | "A" type
+//	                | "O" type
+//	                | "V"
+//
+// segment is a name of function in source. <length> is a byte length of <name>. Nested functions
+// have the names of enclosing functions as preceding segments. Lambda is named 'fun'.
+//
+// discriminator distinguishes functions which have the same name in the same function. The 2nd
+// definition has index 1, the 3rd one has index 2, and so on.
+//
+// specialization distinguishes copies of a function made by inliner (functions specialized for
+// known function arguments and nested functions copied into the caller). The 1st copy has index 1,
+// the 2nd one has index 2, and so on.
+//
+// instantiation is a list of types of instantiated type variables of polymorphic function.
+// Primitive types are unit, bool, int, float, char, string, bytes and buffer in order. "F" is a
+// function type (return type followed by parameter types), "T" is a tuple, "A" is an array, "O" is
+// an option and "V" is a type variable which was not instantiated. Closures duplicated by
+// monomorphization are instantiated with their function types followed by types of their captures.
+//
+// suffix "W" means a closure wrapper of external function. "C" means a trampoline to call a closure
+// from C as a callback. The trampoline is named 'callback' and instantiated with its function type.
+//
+// Examples:
+//
+//	let rec f x = x + 1                  (* f  => _GC1f      *)
+//	let rec f x = let rec g y = y in g x (* g  => _GC1f1g    *)
+//	let rec id x = x in id 42            (* id => _GC2idIiE  *)
+//
+// Demangled name is names joined with '.' (with '#' and index when a discriminator exists)
+// followed by instantiated types in '<' and '>'. For example, '_GC1fD1_1gIiE' is demangled to
+// 'f#1.g<int>'. Specialization is shown at the end like 'f<int> (specialization 1)'.
+package mangle
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/rhysd/gocaml/types"
+	"strconv"
+)
+
+// Prefix is a prefix of all mangled symbols.
+const Prefix = "_GC"
+
+// LambdaName is a name of lambda function in mangled names. Since 'fun' is a keyword, it never
+// conflicts with other function names.
+const LambdaName = "fun"
+
+func segment(name string, index int) string {
+	if index == 0 {
+		return fmt.Sprintf("%d%s", len(name), name)
+	}
+	return fmt.Sprintf("%d%sD%d_", len(name), name, index)
+}
+
+// Nested returns a mangled symbol name of function 'name' defined in the function whose symbol is
+// 'parent'. When the function is at toplevel, parent should be empty. index is the discriminator
+// for functions which have the same name in the same scope.
+func Nested(parent, name string, index int) string {
+	if parent == "" {
+		parent = Prefix
+	}
+	return parent + segment(name, index)
+}
+
+// ClosureWrapper returns a mangled symbol name of closure wrapper for the external function.
+func ClosureWrapper(name string) string {
+	return Prefix + segment(name, 0) + "W"
+}
+
+// Callback returns a mangled symbol name of the trampoline which calls a closure of the function type
+// from C.
+func Callback(ty *types.Fun) string {
+	return Instantiate(Prefix+segment("callback", 0), []types.Type{ty}) + "C"
+}
+
+// Specialize returns a mangled symbol name of the index-th copy of the function. sym must not be
+// instantiated yet.
+func Specialize(sym string, index int) string {
+	return fmt.Sprintf("%sS%d_", sym, index)
+}
+
+// Instantiate returns a mangled symbol name of polymorphic function instantiated with the types.
+func Instantiate(sym string, ts []types.Type) string {
+	var buf bytes.Buffer
+	buf.WriteString(sym)
+	buf.WriteByte('I')
+	for _, t := range ts {
+		writeType(&buf, t)
+	}
+	buf.WriteByte('E')
+	return buf.String()
+}
+
+// Type returns mangled representation of the type.
+func Type(t types.Type) string {
+	var buf bytes.Buffer
+	writeType(&buf, t)
+	return buf.String()
+}
+
+func writeType(buf *bytes.Buffer, t types.Type) {
+	switch t := t.(type) {
+	case *types.Unit:
+		buf.WriteByte('u')
+	case *types.Bool:
+		buf.WriteByte('b')
+	case *types.Int:
+		buf.WriteByte('i')
+	case *types.Float:
+		buf.WriteByte('f')
+	case *types.Char:
+		buf.WriteByte('c')
+	case *types.String:
+		buf.WriteByte('s')
+	case *types.Bytes:
+		buf.WriteByte('y')
+	case *types.Buffer:
+		buf.WriteByte('r')
+	case *types.Fun:
+		buf.WriteByte('F')
+		writeType(buf, t.Ret)
+		for _, p := range t.Params {
+			writeType(buf, p)
+		}
+		buf.WriteByte('E')
+	case *types.Tuple:
+		buf.WriteByte('T')
+		for _, e := range t.Elems {
+			writeType(buf, e)
+		}
+		buf.WriteByte('E')
+	case *types.Array:
+		buf.WriteByte('A')
+		writeType(buf, t.Elem)
+	case *types.Option:
+		buf.WriteByte('O')
+		writeType(buf, t.Elem)
+	case *types.Var:
+		if t.Ref != nil {
+			writeType(buf, t.Ref)
+			return
+		}
+		buf.WriteByte('V')
+	default:
+		panic("FATAL: Unreachable: Cannot mangle unknown type: " + strconv.Quote(t.String()))
+	}
+}
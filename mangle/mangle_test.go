@@ -0,0 +1,180 @@
+package mangle
+
+import (
+	"bytes"
+	"errors"
+	"github.com/rhysd/gocaml/types"
+	"strings"
+	"testing"
+)
+
+func TestMangleAndDemangle(t *testing.T) {
+	f := Nested("", "f", 0)
+	f1 := Nested("", "f", 1)
+	for _, tc := range []struct {
+		what      string
+		mangled   string
+		demangled string
+	}{
+		{"toplevel", f, "f"},
+		{"discriminator", f1, "f#1"},
+		{"nested", Nested(f, "g", 0), "f.g"},
+		{"nested in 2nd definition", Nested(f1, "g", 2), "f#1.g#2"},
+		{"lambda", Nested(f, LambdaName, 0), "f.fun"},
+		{"long name", Nested("", "this_is_long_function_name", 0), "this_is_long_function_name"},
+		{"name with digits", Nested(Nested("", "f1", 0), "g22", 0), "f1.g22"},
+		{"unicode name", Nested("", "関数", 0), "関数"},
+		{"closure wrapper", ClosureWrapper("print_int"), "print_int (closure)"},
+		{
+			"callback",
+			Callback(&types.Fun{types.UnitType, []types.Type{types.IntType, types.StringType}}),
+			"callback<int -> string -> unit> (callback)",
+		},
+		{
+			"primitive types",
+			Instantiate(f, []types.Type{
+				types.UnitType,
+				types.BoolType,
+				types.IntType,
+				types.FloatType,
+				types.CharType,
+				types.StringType,
+				types.BytesType,
+				types.BufferType,
+			}),
+			"f<unit, bool, int, float, char, string, bytes, buffer>",
+		},
+		{
+			"compound types",
+			Instantiate(f, []types.Type{
+				&types.Fun{types.IntType, []types.Type{types.BoolType, &types.Tuple{[]types.Type{types.IntType, types.FloatType}}}},
+				&types.Array{&types.Option{types.StringType}},
+				&types.Option{&types.Fun{types.UnitType, []types.Type{types.IntType}}},
+			}),
+			"f<bool -> (int * float) -> int, string option array, (int -> unit) option>",
+		},
+		{
+			"type variable",
+			Instantiate(f, []types.Type{&types.Array{types.NewGeneric()}}),
+			"f<'a array>",
+		},
+		{"specialization", Specialize(f, 1), "f (specialization 1)"},
+		{"specialization of nested", Specialize(Nested(f1, "g", 0), 12), "f#1.g (specialization 12)"},
+		{
+			"instantiated specialization",
+			Instantiate(Specialize(f, 2), []types.Type{types.IntType}),
+			"f<int> (specialization 2)",
+		},
+		{
+			"linked type variable",
+			Instantiate(f, []types.Type{&types.Var{types.IntType, 0, 0}}),
+			"f<int>",
+		},
+	} {
+		t.Run(tc.what, func(t *testing.T) {
+			if !strings.HasPrefix(tc.mangled, Prefix) {
+				t.Fatalf("Mangled name '%s' does not start with prefix", tc.mangled)
+			}
+			have, err := Demangle(tc.mangled)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if have != tc.demangled {
+				t.Fatalf("Wanted '%s' but had '%s' (mangled: '%s')", tc.demangled, have, tc.mangled)
+			}
+		})
+	}
+}
+
+func TestMangledNames(t *testing.T) {
+	for _, tc := range []struct {
+		have string
+		want string
+	}{
+		{Nested("", "f", 0), "_GC1f"},
+		{Nested(Nested("", "f", 1), "g", 0), "_GC1fD1_1g"},
+		{Instantiate(Nested("", "id", 0), []types.Type{types.IntType}), "_GC2idIiE"},
+		{Type(&types.Fun{types.UnitType, []types.Type{&types.Array{types.IntType}, types.FloatType}}), "FuAifE"},
+		{Type(&types.Tuple{[]types.Type{types.IntType, &types.Option{types.CharType}}}), "TiOcE"},
+		{ClosureWrapper("f"), "_GC1fW"},
+		{Instantiate(Specialize(Nested("", "f", 0), 1), []types.Type{types.IntType}), "_GC1fS1_IiE"},
+	} {
+		if tc.have != tc.want {
+			t.Errorf("Wanted '%s' but had '%s'", tc.want, tc.have)
+		}
+	}
+}
+
+func TestDemangleError(t *testing.T) {
+	for _, sym := range []string{
+		"",
+		"f$t1",
+		"_GC",
+		"_GCf",
+		"_GC0",
+		"_GC3fo",
+		"_GC1fD",
+		"_GC1fD1",
+		"_GC1fI",
+		"_GC1fIE",
+		"_GC1fIxE",
+		"_GC1fITiEE",
+		"_GC1fIFiEE",
+		"_GC1fx",
+		"_GC1fWW",
+		"_GC1fS",
+		"_GC1fS1",
+		"_GC1fSx_",
+		"_GC1fIiES1_",
+	} {
+		if have, err := Demangle(sym); err == nil {
+			t.Errorf("Demangling '%s' should cause an error but had '%s'", sym, have)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	input := `0000000000400500 t _GC1f
+0000000000400510 t _GC1f1gIiE
+call _GC1fW and _GC1fx
+__gocaml_main f$t1`
+	want := `0000000000400500 t f
+0000000000400510 t f.g<int>
+call f (closure) and _GC1fx
+__gocaml_main f$t1
+`
+	var out bytes.Buffer
+	if err := Filter(strings.NewReader(input), &out); err != nil {
+		t.Fatal(err)
+	}
+	if have := out.String(); have != want {
+		t.Fatalf("Wanted:\n%s\nbut had:\n%s", want, have)
+	}
+}
+
+func TestFilterLongLine(t *testing.T) {
+	long := strings.Repeat("x", 100000)
+	input := long + " _GC1f\n_GC1g"
+	want := long + " f\ng\n"
+	var out bytes.Buffer
+	if err := Filter(strings.NewReader(input), &out); err != nil {
+		t.Fatal(err)
+	}
+	if have := out.String(); have != want {
+		t.Fatalf("Long line was not filtered correctly. Output length: %d", len(have))
+	}
+}
+
+type errorReader struct{}
+
+func (r errorReader) Read(p []byte) (int, error) {
+	return 0, errors.New("read error")
+}
+
+func TestFilterReadError(t *testing.T) {
+	var out bytes.Buffer
+	err := Filter(errorReader{}, &out)
+	if err == nil || err.Error() != "read error" {
+		t.Fatalf("Read error should be reported but got %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+package mir
+
+import (
+	"fmt"
+	"github.com/rhysd/gocaml/types"
+	"io"
+	"sort"
+)
+
+// Escape is a result of escape analysis for one allocating instruction. Allocating instructions are
+// 'tuple', 'array', 'arrlit' and 'makecls' (for its captures).
+type Escape struct {
+	Insn *Insn
+	// Escapes is true when the allocated memory may be referred after the function returns.
+	Escapes bool
+	// Reason why the allocation escapes. Empty when it does not escape.
+	Reason string
+}
+
+// EscapeInfo is a mapping from identifiers of allocating instructions to results of escape analysis.
+type EscapeInfo map[string]*Escape
+
+// NotEscaping returns true when the value of the identifier is allocated in function and it never
+// leaves the function. Such allocations can be placed on stack instead of heap.
+func (info EscapeInfo) NotEscaping(ident string) bool {
+	e, ok := info[ident]
+	return ok && !e.Escapes
+}
+
+// Println outputs results of the analysis sorted by their positions for diagnostics.
+func (info EscapeInfo) Println(out io.Writer, env *types.Env) {
+	escapes := make([]*Escape, 0, len(info))
+	for _, e := range info {
+		escapes = append(escapes, e)
+	}
+	sort.Slice(escapes, func(i, j int) bool {
+		l, r := escapes[i].Insn.Pos, escapes[j].Insn.Pos
+		if l.Line != r.Line {
+			return l.Line < r.Line
+		}
+		if l.Column != r.Column {
+			return l.Column < r.Column
+		}
+		return escapes[i].Insn.Ident < escapes[j].Insn.Ident
+	})
+
+	for _, e := range escapes {
+		what := allocKind(e.Insn.Val)
+		if name, ok := env.DisplayNames[e.Insn.Ident]; ok {
+			what = fmt.Sprintf("%s '%s'", what, name)
+		}
+		pos := e.Insn.Pos
+		path := "<unknown>"
+		if pos.File != nil {
+			path = pos.File.Path
+		}
+		if e.Escapes {
+			fmt.Fprintf(out, "%s:%d:%d: %s escapes: %s\n", path, pos.Line, pos.Column, what, e.Reason)
+		} else {
+			fmt.Fprintf(out, "%s:%d:%d: %s does not escape\n", path, pos.Line, pos.Column, what)
+		}
+	}
+}
+
+func allocKind(val Val) string {
+	switch val.(type) {
+	case *Tuple:
+		return "tuple"
+	case *Array, *ArrLit:
+		return "array"
+	case *MakeCls:
+		return "closure"
+	default:
+		panic("unreachable")
+	}
+}
+
+// Escape analysis is intra-procedural and flow-insensitive. It is conservative; an allocated value
+// is considered to escape when it is
+//
+// - returned from the function
+// - passed to some function as an argument
+// - stored in other tuple, array or closure captures
+// - a closure called at tail position (captures are passed to the callee which outlives the frame)
+// - a closure whose function refers itself as a value (it may return itself)
+//
+// Values are tracked through 'ref', 'some', 'derefsome' and 'if' since they don't copy the allocated
+// memory.
+type escapeAnalysis struct {
+	prog   *Program
+	allocs []*Insn
+	// Edges from identifier to identifiers to which its value flows
+	flows map[string][]string
+	// Identifiers whose values escape and their reasons
+	escaping map[string]string
+}
+
+func (ea *escapeAnalysis) flow(from, to string) {
+	ea.flows[from] = append(ea.flows[from], to)
+}
+
+func (ea *escapeAnalysis) escape(ident, reason string) {
+	if _, ok := ea.escaping[ident]; !ok {
+		ea.escaping[ident] = reason
+	}
+}
+
+func (ea *escapeAnalysis) escapeAll(idents []string, reason string) {
+	for _, i := range idents {
+		ea.escape(i, reason)
+	}
+}
+
+func (ea *escapeAnalysis) visitInsn(insn *Insn) {
+	switch val := insn.Val.(type) {
+	case *Tuple:
+		ea.allocs = append(ea.allocs, insn)
+		ea.escapeAll(val.Elems, "stored in tuple")
+	case *ArrLit:
+		ea.allocs = append(ea.allocs, insn)
+		ea.escapeAll(val.Elems, "stored in array")
+	case *Array:
+		ea.allocs = append(ea.allocs, insn)
+		ea.escape(val.Elem, "stored in array")
+	case *MakeCls:
+		ea.allocs = append(ea.allocs, insn)
+		ea.escapeAll(val.Vars, "captured by closure")
+		if f, ok := ea.prog.Toplevel[val.Fun]; ok && refersItself(val.Fun, f.Val.Body) {
+			ea.escape(insn.Ident, "closure refers itself as a value")
+		}
+	case *ArrStore:
+		ea.escape(val.RHS, "stored in array")
+	case *App:
+		ea.escapeAll(val.Args, "passed to function")
+		if val.Kind == CLOSURE_CALL && val.IsTail {
+			ea.escape(val.Callee, "called at tail position")
+		}
+	case *Ref:
+		ea.flow(val.Ident, insn.Ident)
+	case *Some:
+		ea.flow(val.Elem, insn.Ident)
+	case *DerefSome:
+		ea.flow(val.SomeVal, insn.Ident)
+	case *If:
+		ea.visitBlock(val.Then)
+		ea.visitBlock(val.Else)
+		if last := val.Then.Bottom.Prev; last != val.Then.Top {
+			ea.flow(last.Ident, insn.Ident)
+		}
+		if last := val.Else.Bottom.Prev; last != val.Else.Top {
+			ea.flow(last.Ident, insn.Ident)
+		}
+	}
+}
+
+func (ea *escapeAnalysis) visitBlock(block *Block) {
+	for i := block.Top.Next; i.Next != nil; i = i.Next {
+		ea.visitInsn(i)
+	}
+}
+
+// reason returns why the value of the identifier escapes by following the value flows. Empty string
+// means it does not escape.
+func (ea *escapeAnalysis) reason(ident string) string {
+	visited := map[string]struct{}{}
+	stack := []string{ident}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := visited[i]; ok {
+			continue
+		}
+		visited[i] = struct{}{}
+		if r, ok := ea.escaping[i]; ok {
+			return r
+		}
+		stack = append(stack, ea.flows[i]...)
+	}
+	return ""
+}
+
+func (ea *escapeAnalysis) analyzeBlock(block *Block, isFun bool, info EscapeInfo) {
+	ea.allocs = []*Insn{}
+	ea.flows = map[string][]string{}
+	ea.escaping = map[string]string{}
+
+	ea.visitBlock(block)
+	if last := block.Bottom.Prev; isFun && last != block.Top {
+		ea.escape(last.Ident, "returned from function")
+	}
+
+	for _, insn := range ea.allocs {
+		r := ea.reason(insn.Ident)
+		info[insn.Ident] = &Escape{insn, r != "", r}
+	}
+}
+
+// refersItself returns true when the function refers its name other than a callee of application.
+func refersItself(name string, block *Block) bool {
+	for i := block.Top.Next; i.Next != nil; i = i.Next {
+		for _, o := range Operands(i.Val) {
+			if o == name {
+				return true
+			}
+		}
+		if val, ok := i.Val.(*If); ok {
+			if refersItself(name, val.Then) || refersItself(name, val.Else) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AnalyzeEscape analyzes whether each allocation in the program escapes from its function or not.
+// This analysis must be done after MarkTailCalls() because it considers tail calls.
+func AnalyzeEscape(prog *Program) EscapeInfo {
+	info := EscapeInfo{}
+	ea := &escapeAnalysis{prog: prog}
+	for _, f := range prog.Toplevel {
+		ea.analyzeBlock(f.Val.Body, true, info)
+	}
+	ea.analyzeBlock(prog.Entry, false, info)
+	return info
+}
@@ -0,0 +1,162 @@
+package mir
+
+import (
+	"bytes"
+	"github.com/rhysd/gocaml/types"
+	"github.com/rhysd/locerr"
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeEscape(t *testing.T) {
+	// f x =
+	//   t1 = tuple x,x
+	//   a = tplload 0 t1
+	//   t2 = tuple x,x
+	//   t3 = tuple a,a
+	//   $k1 = app g t3
+	//   t4 = tuple x,x
+	//   t5 = tuple t4,x
+	//   $k2 = show t5
+	//   s = some t2
+	//   $k3 = if x
+	//     $k4 = ref t2
+	//   else
+	//     $k5 = derefsome s
+	body := NewBlockFromArray("body", []*Insn{
+		NewInsn("t1", &Tuple{[]string{"x", "x"}}, locerr.Pos{}),
+		NewInsn("a", &TplLoad{"t1", 0}, locerr.Pos{}),
+		NewInsn("t2", &Tuple{[]string{"x", "x"}}, locerr.Pos{}),
+		NewInsn("t3", &Tuple{[]string{"a", "a"}}, locerr.Pos{}),
+		NewInsn("$k1", &App{"g", []string{"t3"}, DIRECT_CALL, false}, locerr.Pos{}),
+		NewInsn("t4", &Tuple{[]string{"x", "x"}}, locerr.Pos{}),
+		NewInsn("t5", &Tuple{[]string{"t4", "x"}}, locerr.Pos{}),
+		NewInsn("$k2", &Show{"t5"}, locerr.Pos{}),
+		NewInsn("s", &Some{"t2"}, locerr.Pos{}),
+		NewInsn("$k3", &If{
+			"x",
+			NewBlockFromArray("then", []*Insn{
+				NewInsn("$k4", &Ref{"t2"}, locerr.Pos{}),
+			}),
+			NewBlockFromArray("else", []*Insn{
+				NewInsn("$k5", &DerefSome{"s"}, locerr.Pos{}),
+			}),
+		}, locerr.Pos{}),
+	})
+
+	// c y = y
+	// d y = $k6 = ref d
+	top := NewToplevel()
+	top.Add("f", &Fun{[]string{"x"}, body, false}, locerr.Pos{})
+	top.Add("c", &Fun{[]string{"y"}, NewBlockFromArray("body", []*Insn{
+		NewInsn("$k6", &Ref{"y"}, locerr.Pos{}),
+	}), false}, locerr.Pos{})
+	top.Add("d", &Fun{[]string{"y"}, NewBlockFromArray("body", []*Insn{
+		NewInsn("$k7", &Ref{"d"}, locerr.Pos{}),
+	}), true}, locerr.Pos{})
+
+	// program:
+	//   y = int 42
+	//   c = makecls (y) c
+	//   $k8 = appcls c y
+	//   d = makecls (y) d
+	//   $k9 = appcls d y
+	//   arr = arrlit y
+	//   $k10 = arrstore arr y y
+	//   t6 = tuple y,y
+	entry := NewBlockFromArray("program", []*Insn{
+		NewInsn("y", &Int{42}, locerr.Pos{}),
+		NewInsn("c", &MakeCls{[]string{"y"}, "c"}, locerr.Pos{}),
+		NewInsn("$k8", &App{"c", []string{"y"}, CLOSURE_CALL, false}, locerr.Pos{}),
+		NewInsn("d", &MakeCls{[]string{"y"}, "d"}, locerr.Pos{}),
+		NewInsn("$k9", &App{"d", []string{"y"}, CLOSURE_CALL, false}, locerr.Pos{}),
+		NewInsn("arr", &ArrLit{[]string{"y"}}, locerr.Pos{}),
+		NewInsn("$k10", &ArrStore{"arr", "y", "y"}, locerr.Pos{}),
+		NewInsn("t6", &Tuple{[]string{"y", "y"}}, locerr.Pos{}),
+	})
+
+	prog := &Program{top, Closures{"c": []string{"y"}, "d": []string{"y"}}, entry}
+	info := AnalyzeEscape(prog)
+
+	for _, tc := range []struct {
+		ident  string
+		reason string
+	}{
+		{"t1", ""},
+		{"t2", "returned from function"},
+		{"t3", "passed to function"},
+		{"t4", "stored in tuple"},
+		{"t5", ""},
+		{"c", ""},
+		{"d", "closure refers itself as a value"},
+		{"arr", ""},
+		{"t6", ""},
+	} {
+		e, ok := info[tc.ident]
+		if !ok {
+			t.Errorf("Allocation '%s' was not analyzed", tc.ident)
+			continue
+		}
+		if e.Escapes != (tc.reason != "") {
+			t.Errorf("Escape of '%s' is unexpected. Wanted reason '%s' but had '%s'", tc.ident, tc.reason, e.Reason)
+			continue
+		}
+		if e.Reason != tc.reason {
+			t.Errorf("Wanted reason '%s' for '%s' but had '%s'", tc.reason, tc.ident, e.Reason)
+		}
+		if info.NotEscaping(tc.ident) != (tc.reason == "") {
+			t.Errorf("NotEscaping() returned unexpected value for '%s'", tc.ident)
+		}
+	}
+
+	if info.NotEscaping("a") {
+		t.Error("Not allocating instruction should not be reported as not escaping")
+	}
+}
+
+func TestEscapeAtTailCall(t *testing.T) {
+	// f x =
+	//   c = makecls (x) c
+	//   $k1 = appcls c x  (tail)
+	body := NewBlockFromArray("body", []*Insn{
+		NewInsn("c", &MakeCls{[]string{"x"}, "c"}, locerr.Pos{}),
+		NewInsn("$k1", &App{"c", []string{"x"}, CLOSURE_CALL, false}, locerr.Pos{}),
+	})
+	top := NewToplevel()
+	top.Add("f", &Fun{[]string{"x"}, body, false}, locerr.Pos{})
+	top.Add("c", &Fun{[]string{"y"}, NewBlockFromArray("body", []*Insn{
+		NewInsn("$k2", &Ref{"y"}, locerr.Pos{}),
+	}), false}, locerr.Pos{})
+	prog := &Program{top, Closures{"c": []string{"x"}}, NewBlockFromArray("program", []*Insn{
+		NewInsn("$k3", UnitVal, locerr.Pos{}),
+	})}
+
+	MarkTailCalls(prog)
+	info := AnalyzeEscape(prog)
+	if info.NotEscaping("c") {
+		t.Fatal("Closure called at tail position should escape")
+	}
+	if r := info["c"].Reason; r != "called at tail position" {
+		t.Fatal("Unexpected reason:", r)
+	}
+}
+
+func TestEscapeInfoPrintln(t *testing.T) {
+	src := locerr.NewDummySource("")
+	entry := NewBlockFromArray("program", []*Insn{
+		NewInsn("x", &Int{42}, locerr.Pos{0, 1, 1, src}),
+		NewInsn("p$t1", &Tuple{[]string{"x", "x"}}, locerr.Pos{0, 2, 5, src}),
+		NewInsn("$k1", &ArrLit{[]string{"p$t1"}}, locerr.Pos{0, 3, 1, src}),
+	})
+	prog := &Program{NewToplevel(), Closures{}, entry}
+	env := types.NewEnv()
+	env.DisplayNames["p$t1"] = "p"
+
+	var buf bytes.Buffer
+	AnalyzeEscape(prog).Println(&buf, env)
+	have := strings.TrimSpace(buf.String())
+	want := "<dummy>:2:5: tuple 'p' escapes: stored in array\n<dummy>:3:1: array does not escape"
+	if have != want {
+		t.Fatalf("Wanted:\n%s\nbut had:\n%s", want, have)
+	}
+}
@@ -1,9 +1,11 @@
 package mir
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/rhysd/gocaml/types"
 	"io"
+	"strings"
 )
 
 type printer struct {
@@ -51,3 +53,122 @@ func (b *Block) Println(out io.Writer, types *types.Env) {
 	}
 	p.printlnBlock(b)
 }
+
+// Note:
+// dotPrinter renders MIR as a Graphviz DOT graph. Instructions in a block are put in one node until
+// 'if' instruction appears. 'if' node has edges to the first nodes of 'then' and 'else' blocks and
+// the last nodes of both blocks have edges to the node of following instructions. Function bodies
+// (toplevel functions, or nested functions before closure transform) are rendered as clusters.
+// Nested function bodies are connected to the node defining them with dashed edges.
+type dotPrinter struct {
+	types    *types.Env
+	out      io.Writer
+	nodes    int
+	clusters int
+}
+
+func dotEscape(s string) string {
+	r := strings.NewReplacer("\\", "\\\\", "\"", "\\\"")
+	return r.Replace(s)
+}
+
+func (p *dotPrinter) insnLine(insn *Insn) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s = ", insn.Ident)
+	insn.Val.Print(&buf)
+	t, ok := p.types.DeclTable[insn.Ident]
+	if !ok {
+		panic("FATAL: Type of identifier not found: " + insn.Ident)
+	}
+	fmt.Fprintf(&buf, " ; type=%s", t.String())
+	return buf.String()
+}
+
+func (p *dotPrinter) node(lines []string) string {
+	id := fmt.Sprintf("n%d", p.nodes)
+	p.nodes++
+	escaped := make([]string, 0, len(lines))
+	for _, l := range lines {
+		escaped = append(escaped, dotEscape(l))
+	}
+	fmt.Fprintf(p.out, "%s [label=\"%s\\l\"];\n", id, strings.Join(escaped, "\\l"))
+	return id
+}
+
+func (p *dotPrinter) edge(from, to, attrs string) {
+	if attrs == "" {
+		fmt.Fprintf(p.out, "%s -> %s;\n", from, to)
+		return
+	}
+	fmt.Fprintf(p.out, "%s -> %s [%s];\n", from, to, attrs)
+}
+
+// block renders the block and returns IDs of its first node and its last node.
+func (p *dotPrinter) block(b *Block) (string, string) {
+	first := ""
+	prevs := []string{}
+	lines := []string{"BEGIN: " + b.Name}
+
+	flush := func() string {
+		id := p.node(lines)
+		if first == "" {
+			first = id
+		}
+		for _, prev := range prevs {
+			p.edge(prev, id, "")
+		}
+		prevs = []string{id}
+		lines = []string{}
+		return id
+	}
+
+	for i := b.Top.Next; i.Next != nil; i = i.Next {
+		lines = append(lines, p.insnLine(i))
+		switch v := i.Val.(type) {
+		case *If:
+			cond := flush()
+			thenFirst, thenLast := p.block(v.Then)
+			elseFirst, elseLast := p.block(v.Else)
+			p.edge(cond, thenFirst, "label=\"then\"")
+			p.edge(cond, elseFirst, "label=\"else\"")
+			prevs = []string{thenLast, elseLast}
+		case *Fun:
+			def := flush()
+			body := p.cluster(i.Ident, v)
+			p.edge(def, body, "style=dashed")
+		}
+	}
+
+	lines = append(lines, "END: "+b.Name)
+	last := flush()
+	return first, last
+}
+
+// cluster renders the function body as a cluster and returns ID of its first node.
+func (p *dotPrinter) cluster(name string, fun *Fun) string {
+	fmt.Fprintf(p.out, "subgraph cluster_%d {\n", p.clusters)
+	p.clusters++
+	var buf bytes.Buffer
+	fun.Print(&buf)
+	fmt.Fprintf(p.out, "label=\"%s = %s\";\n", dotEscape(name), dotEscape(buf.String()))
+	first, _ := p.block(fun.Body)
+	fmt.Fprintln(p.out, "}")
+	return first
+}
+
+func (p *dotPrinter) begin(name string) {
+	fmt.Fprintf(p.out, "digraph \"%s\" {\n", dotEscape(name))
+	fmt.Fprintln(p.out, "node [shape=box, fontname=\"monospace\"];")
+}
+
+func (p *dotPrinter) end() {
+	fmt.Fprintln(p.out, "}")
+}
+
+// PrintDot outputs the block as a Graphviz DOT graph.
+func (b *Block) PrintDot(out io.Writer, env *types.Env) {
+	p := &dotPrinter{env, out, 0, 0}
+	p.begin(b.Name)
+	p.block(b)
+	p.end()
+}
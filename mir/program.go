@@ -5,6 +5,7 @@ import (
 	"github.com/rhysd/gocaml/types"
 	"github.com/rhysd/locerr"
 	"io"
+	"sort"
 	"strings"
 )
 
@@ -61,3 +62,22 @@ func (prog *Program) Println(out io.Writer, env *types.Env) {
 	prog.PrintToplevels(out, env)
 	prog.Entry.Println(out, env)
 }
+
+// PrintDot outputs the program as a Graphviz DOT graph. Each toplevel function is rendered as a
+// cluster in order of its name. The entry block is rendered at last.
+func (prog *Program) PrintDot(out io.Writer, env *types.Env) {
+	p := &dotPrinter{env, out, 0, 0}
+	p.begin("program")
+
+	names := make([]string, 0, len(prog.Toplevel))
+	for n := range prog.Toplevel {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		p.cluster(n, prog.Toplevel[n].Val)
+	}
+
+	p.block(prog.Entry)
+	p.end()
+}
@@ -33,3 +33,84 @@ func TestDump(t *testing.T) {
 		t.Fatalf("Entry section not found")
 	}
 }
+
+func TestPrintDot(t *testing.T) {
+	top := NewToplevel()
+	top.Add("f", &Fun{
+		[]string{"x"},
+		NewBlockFromArray("body (f)", []*Insn{
+			NewInsn("$k1", &If{
+				"x",
+				NewBlockFromArray("then", []*Insn{NewInsn("$k2", &Int{1}, locerr.Pos{})}),
+				NewBlockFromArray("else", []*Insn{NewInsn("$k3", &Int{2}, locerr.Pos{})}),
+			}, locerr.Pos{}),
+		}),
+		false,
+	}, locerr.Pos{})
+	prog := &Program{
+		top,
+		map[string][]string{},
+		NewBlockFromArray("program", []*Insn{
+			NewInsn("$k4", &String{"a\"b"}, locerr.Pos{}),
+		}),
+	}
+
+	env := types.NewEnv()
+	env.DeclTable["f"] = &types.Fun{types.IntType, []types.Type{types.BoolType}}
+	env.DeclTable["$k1"] = types.IntType
+	env.DeclTable["$k2"] = types.IntType
+	env.DeclTable["$k3"] = types.IntType
+	env.DeclTable["$k4"] = types.StringType
+
+	var buf bytes.Buffer
+	prog.PrintDot(&buf, env)
+	out := buf.String()
+	for _, want := range []string{
+		"digraph \"program\" {",
+		"subgraph cluster_0 {",
+		"label=\"f = fun x\";",
+		"n0 [label=\"BEGIN: body (f)\\l$k1 = if x ; type=int\\l\"];",
+		"n1 [label=\"BEGIN: then\\l$k2 = int 1 ; type=int\\lEND: then\\l\"];",
+		"n0 -> n1 [label=\"then\"];",
+		"n0 -> n2 [label=\"else\"];",
+		"n1 -> n3;",
+		"n2 -> n3;",
+		"n4 [label=\"BEGIN: program\\l$k4 = string \\\"a\\\\\\\"b\\\" ; type=string\\lEND: program\\l\"];",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("'%s' is not contained in output: %s", want, out)
+		}
+	}
+}
+
+func TestPrintDotNestedFunction(t *testing.T) {
+	block := NewBlockFromArray("program", []*Insn{
+		NewInsn("g", &Fun{
+			[]string{"y"},
+			NewBlockFromArray("body (g)", []*Insn{NewInsn("$k1", &Ref{"y"}, locerr.Pos{})}),
+			true,
+		}, locerr.Pos{}),
+		NewInsn("$k2", &Int{42}, locerr.Pos{}),
+	})
+
+	env := types.NewEnv()
+	env.DeclTable["g"] = &types.Fun{types.IntType, []types.Type{types.IntType}}
+	env.DeclTable["$k1"] = types.IntType
+	env.DeclTable["$k2"] = types.IntType
+
+	var buf bytes.Buffer
+	block.PrintDot(&buf, env)
+	out := buf.String()
+	for _, want := range []string{
+		"n0 [label=\"BEGIN: program\\lg = recfun y ; type=int -> int\\l\"];",
+		"subgraph cluster_0 {",
+		"label=\"g = recfun y\";",
+		"n0 -> n1 [style=dashed];",
+		"n2 [label=\"$k2 = int 42 ; type=int\\lEND: program\\l\"];",
+		"n0 -> n2;",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("'%s' is not contained in output: %s", want, out)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package mir
+
+// MarkTailCalls sets IsTail flag to each 'app' instruction at tail position of functions in the
+// program. Code generator guarantees that marked calls don't consume stack. Self tail calls are
+// lowered to loops and other tail calls are emitted as tail calls followed by return.
+// Note that applications in entry block are never marked because it is not a function.
+func MarkTailCalls(prog *Program) {
+	for _, f := range prog.Toplevel {
+		markTailInBlock(f.Val.Body)
+	}
+}
+
+func markTailInBlock(block *Block) {
+	last := block.Bottom.Prev
+	if last == block.Top {
+		return
+	}
+	markTailInsn(last)
+}
+
+func markTailInsn(insn *Insn) {
+	switch val := insn.Val.(type) {
+	case *App:
+		val.IsTail = true
+	case *If:
+		markTailInBlock(val.Then)
+		markTailInBlock(val.Else)
+	case *Ref:
+		// Note:
+		// `let x = f a in x` is K-normalized into `x = app f a; $k = ref x`. In the case, the
+		// application is also at tail position.
+		if prev := insn.Prev; prev != nil && prev.Ident == val.Ident {
+			markTailInsn(prev)
+		}
+	}
+}
+
+// HasSelfTailCall returns whether the function body contains tail application to the function
+// itself. 'name' is a name of the function.
+func HasSelfTailCall(name string, fun *Fun) bool {
+	return hasSelfTailCallInBlock(name, fun.Body)
+}
+
+func hasSelfTailCallInBlock(name string, block *Block) bool {
+	for i := block.Top.Next; i.Next != nil; i = i.Next {
+		switch val := i.Val.(type) {
+		case *App:
+			if val.IsTail && val.Kind != EXTERNAL_CALL && val.Callee == name {
+				return true
+			}
+		case *If:
+			if hasSelfTailCallInBlock(name, val.Then) || hasSelfTailCallInBlock(name, val.Else) {
+				return true
+			}
+		}
+	}
+	return false
+}
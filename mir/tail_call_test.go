@@ -0,0 +1,87 @@
+package mir
+
+import (
+	"bytes"
+	"github.com/rhysd/locerr"
+	"testing"
+)
+
+func TestMarkTailCalls(t *testing.T) {
+	// f x =
+	//   $k1 = app g x
+	//   $k2 = if x
+	//     $k3 = app f x
+	//   else
+	//     $k4 = appx h x
+	//     $k5 = ref $k4
+	notTail := &App{"g", []string{"x"}, DIRECT_CALL, false}
+	self := &App{"f", []string{"x"}, DIRECT_CALL, false}
+	ext := &App{"h", []string{"x"}, EXTERNAL_CALL, false}
+	body := NewBlockFromArray("body", []*Insn{
+		NewInsn("$k1", notTail, locerr.Pos{}),
+		NewInsn("$k2", &If{
+			"x",
+			NewBlockFromArray("then", []*Insn{
+				NewInsn("$k3", self, locerr.Pos{}),
+			}),
+			NewBlockFromArray("else", []*Insn{
+				NewInsn("$k4", ext, locerr.Pos{}),
+				NewInsn("$k5", &Ref{"$k4"}, locerr.Pos{}),
+			}),
+		}, locerr.Pos{}),
+	})
+	fun := &Fun{[]string{"x"}, body, true}
+
+	// Entry block is not a function body
+	entryApp := &App{"f", []string{"$k6"}, DIRECT_CALL, false}
+	entry := NewBlockFromArray("program", []*Insn{
+		NewInsn("$k6", &Int{42}, locerr.Pos{}),
+		NewInsn("$k7", entryApp, locerr.Pos{}),
+	})
+
+	top := NewToplevel()
+	top.Add("f", fun, locerr.Pos{})
+	prog := &Program{top, Closures{}, entry}
+
+	if HasSelfTailCall("f", fun) {
+		t.Fatal("Self tail call was found before marking tail calls")
+	}
+
+	MarkTailCalls(prog)
+
+	if notTail.IsTail {
+		t.Error("App at non-tail position was marked")
+	}
+	if !self.IsTail {
+		t.Error("App in then clause at tail position was not marked")
+	}
+	if !ext.IsTail {
+		t.Error("App referred at tail position was not marked")
+	}
+	if entryApp.IsTail {
+		t.Error("App in entry block was marked")
+	}
+	if !HasSelfTailCall("f", fun) {
+		t.Error("Self tail call was not found")
+	}
+	if HasSelfTailCall("g", fun) {
+		t.Error("Self tail call to other function was found")
+	}
+}
+
+func TestTailAppPrint(t *testing.T) {
+	for _, tc := range []struct {
+		val      *App
+		expected string
+	}{
+		{&App{"f", []string{"a", "b"}, DIRECT_CALL, true}, "tailapp f a,b"},
+		{&App{"f", []string{"a"}, CLOSURE_CALL, true}, "tailappcls f a"},
+		{&App{"f", []string{"a"}, EXTERNAL_CALL, false}, "appx f a"},
+	} {
+		var buf bytes.Buffer
+		tc.val.Print(&buf)
+		if buf.String() != tc.expected {
+			t.Errorf("Expected '%s' but actually '%s'", tc.expected, buf.String())
+		}
+	}
+}
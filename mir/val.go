@@ -87,6 +87,9 @@ type (
 	Float struct {
 		Const float64
 	}
+	Char struct {
+		Const byte
+	}
 	String struct {
 		Const string
 	}
@@ -115,6 +118,7 @@ type (
 		Callee string
 		Args   []string
 		Kind   AppKind
+		IsTail bool // Set by MarkTailCalls() when the application is at tail position of function
 	}
 	Tuple struct {
 		Elems []string
@@ -138,6 +142,9 @@ type (
 	ArrLen struct {
 		Array string
 	}
+	StrLoad struct {
+		From, Index string
+	}
 	Some struct {
 		Elem string
 	}
@@ -152,6 +159,10 @@ type (
 	XRef struct {
 		Ident string
 	}
+	// Expanded to a string representation of the child value following its type at codegen
+	Show struct {
+		Child string
+	}
 	NOP struct {
 	}
 	// Introduced at closure-transform.
@@ -179,6 +190,9 @@ func (v *Int) Print(out io.Writer) {
 func (v *Float) Print(out io.Writer) {
 	fmt.Fprintf(out, "float %f", v.Const)
 }
+func (v *Char) Print(out io.Writer) {
+	fmt.Fprintf(out, "char %s", strconv.QuoteRuneToASCII(rune(v.Const)))
+}
 func (v *String) Print(out io.Writer) {
 	fmt.Fprintf(out, "string %s", strconv.Quote(v.Const))
 }
@@ -202,7 +216,11 @@ func (v *Fun) Print(out io.Writer) {
 	fmt.Fprintf(out, "%sfun %s", rec, strings.Join(v.Params, ","))
 }
 func (v *App) Print(out io.Writer) {
-	fmt.Fprintf(out, "app%s %s %s", appTable[v.Kind], v.Callee, strings.Join(v.Args, ","))
+	tail := ""
+	if v.IsTail {
+		tail = "tail"
+	}
+	fmt.Fprintf(out, "%sapp%s %s %s", tail, appTable[v.Kind], v.Callee, strings.Join(v.Args, ","))
 }
 func (v *Tuple) Print(out io.Writer) {
 	fmt.Fprintf(out, "tuple %s", strings.Join(v.Elems, ","))
@@ -225,9 +243,15 @@ func (v *ArrStore) Print(out io.Writer) {
 func (v *ArrLen) Print(out io.Writer) {
 	fmt.Fprintf(out, "arrlen %s", v.Array)
 }
+func (v *StrLoad) Print(out io.Writer) {
+	fmt.Fprintf(out, "strload %s %s", v.Index, v.From)
+}
 func (v *XRef) Print(out io.Writer) {
 	fmt.Fprintf(out, "xref %s", v.Ident)
 }
+func (v *Show) Print(out io.Writer) {
+	fmt.Fprintf(out, "show %s", v.Child)
+}
 func (v *NOP) Print(out io.Writer) {
 	fmt.Fprint(out, "nop")
 }
@@ -246,3 +270,49 @@ func (v *IsSome) Print(out io.Writer) {
 func (v *DerefSome) Print(out io.Writer) {
 	fmt.Fprintf(out, "derefsome %s", v.SomeVal)
 }
+
+// Operands returns identifiers which the value directly uses as operands. Callee of application and
+// function of closure are not included because they are not used as values. Identifiers used in
+// nested blocks of 'if' and 'fun' are not included either.
+func Operands(val Val) []string {
+	switch val := val.(type) {
+	case *Unary:
+		return []string{val.Child}
+	case *Binary:
+		return []string{val.LHS, val.RHS}
+	case *Ref:
+		return []string{val.Ident}
+	case *If:
+		return []string{val.Cond}
+	case *App:
+		return val.Args
+	case *Tuple:
+		return val.Elems
+	case *TplLoad:
+		return []string{val.From}
+	case *Array:
+		return []string{val.Size, val.Elem}
+	case *ArrLit:
+		return val.Elems
+	case *ArrLoad:
+		return []string{val.From, val.Index}
+	case *ArrStore:
+		return []string{val.To, val.Index, val.RHS}
+	case *ArrLen:
+		return []string{val.Array}
+	case *StrLoad:
+		return []string{val.From, val.Index}
+	case *Some:
+		return []string{val.Elem}
+	case *IsSome:
+		return []string{val.OptVal}
+	case *DerefSome:
+		return []string{val.SomeVal}
+	case *Show:
+		return []string{val.Child}
+	case *MakeCls:
+		return val.Vars
+	default:
+		return nil
+	}
+}
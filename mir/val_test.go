@@ -0,0 +1,31 @@
+package mir
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOperands(t *testing.T) {
+	cases := []struct {
+		what     string
+		val      Val
+		expected []string
+	}{
+		{"constant", &Int{42}, nil},
+		{"binary", &Binary{ADD, "a", "b"}, []string{"a", "b"}},
+		{"if", &If{"c", NewEmptyBlock("then"), NewEmptyBlock("else")}, []string{"c"}},
+		{"fun", &Fun{[]string{"x"}, NewEmptyBlock("body"), false}, nil},
+		{"app", &App{"f", []string{"a", "b"}, DIRECT_CALL, false}, []string{"a", "b"}},
+		{"store", &ArrStore{"arr", "i", "v"}, []string{"arr", "i", "v"}},
+		{"xref", &XRef{"x"}, nil},
+		{"closure", &MakeCls{[]string{"a"}, "f"}, []string{"a"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.what, func(t *testing.T) {
+			if actual := Operands(tc.val); !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatalf("Wanted %v but got %v", tc.expected, actual)
+			}
+		})
+	}
+}
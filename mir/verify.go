@@ -0,0 +1,338 @@
+package mir
+
+import (
+	"github.com/rhysd/gocaml/common"
+	"github.com/rhysd/gocaml/types"
+	"github.com/rhysd/locerr"
+	"sort"
+)
+
+// Verify checks the program is well-formed. It is intended to find bugs of transformations on MIR
+// as early as possible instead of crashing in code generation. It checks that
+//
+//   - every identifier is defined before it is used
+//   - every instruction has its type in type environment
+//   - types of arguments and result of each 'app' instruction match to its callee's type
+//
+// Program before closure transform can be verified by wrapping its block with a Program which has
+// no toplevel function. Nested functions are verified in the block. Type variables are treated as
+// any type because they are not instantiated until monomorphization.
+func Verify(prog *Program, env *types.Env) error {
+	v := &verifier{env: env, prog: prog, scope: map[string]int{}}
+	return v.verify()
+}
+
+// VerifyMonomorphic checks the program in the same way as Verify. In addition, it checks that no
+// polymorphic function remains in the program. It should be used after monomorphization.
+// Only type variables which monomorphization should assign (generic type variables recorded in
+// instantiations of polymorphic functions) are reported. Other type variables may remain in the
+// program. For example, 'f None None' calls an instance of 'f' typed as 'a option -> 'a option -> bool
+// because the element type of 'None' is never determined.
+func VerifyMonomorphic(prog *Program, env *types.Env) error {
+	assigned := map[types.VarID]struct{}{}
+	for _, insts := range env.PolyTypes {
+		for _, inst := range insts {
+			for _, m := range inst.Mapping {
+				assigned[m.ID] = struct{}{}
+			}
+		}
+	}
+	v := &verifier{env: env, prog: prog, scope: map[string]int{}, monomorphic: true, assigned: assigned}
+	return v.verify()
+}
+
+type verifier struct {
+	env         *types.Env
+	prog        *Program
+	scope       map[string]int // Number of definitions of each identifier in current scope
+	defined     []string
+	monomorphic bool
+	assigned    map[types.VarID]struct{} // Type variables which should be assigned by monomorphization
+}
+
+func (v *verifier) verify() error {
+	names := make([]string, 0, len(v.prog.Toplevel))
+	for n := range v.prog.Toplevel {
+		names = append(names, n)
+		v.scope[n]++
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		f := v.prog.Toplevel[n]
+		if err := v.verifyTypeOf(n, f.Pos); err != nil {
+			return err
+		}
+		if err := v.verifyFun(f.Val, v.prog.Closures[n]); err != nil {
+			return locerr.Notef(err, "In toplevel function '%s'", v.displayName(n))
+		}
+	}
+
+	if err := v.verifyBlock(v.prog.Entry); err != nil {
+		return locerr.Note(err, "In entry block")
+	}
+	return nil
+}
+
+func (v *verifier) define(ident string) {
+	v.scope[ident]++
+	v.defined = append(v.defined, ident)
+}
+
+// restore undefines identifiers defined after the mark.
+func (v *verifier) restore(mark int) {
+	for _, ident := range v.defined[mark:] {
+		// Note:
+		// Closure made by 'makecls' is bound to the same name as its toplevel function. So the
+		// identifier may be still defined after leaving the block.
+		v.scope[ident]--
+		if v.scope[ident] == 0 {
+			delete(v.scope, ident)
+		}
+	}
+	v.defined = v.defined[:mark]
+}
+
+func (v *verifier) displayName(ident string) string {
+	if n, ok := v.env.DisplayNames[ident]; ok {
+		return n
+	}
+	return ident
+}
+
+func (v *verifier) errorf(pos locerr.Pos, format string, args ...interface{}) *locerr.Error {
+	if pos.File == nil {
+		return locerr.Errorf("MIR verification: "+format, args...)
+	}
+	return locerr.ErrorfAt(pos, "MIR verification: "+format, args...)
+}
+
+func (v *verifier) verifyFun(fun *Fun, captures []string) error {
+	mark := len(v.defined)
+	defer v.restore(mark)
+	for _, c := range captures {
+		v.define(c)
+	}
+	for _, p := range fun.Params {
+		v.define(p)
+	}
+	return v.verifyBlock(fun.Body)
+}
+
+func (v *verifier) verifyBlock(block *Block) error {
+	mark := len(v.defined)
+	defer v.restore(mark)
+	for i := block.Top.Next; i.Next != nil; i = i.Next {
+		if err := v.verifyInsn(i); err != nil {
+			return err
+		}
+		v.define(i.Ident)
+	}
+	return nil
+}
+
+func (v *verifier) verifyTypeOf(ident string, pos locerr.Pos) error {
+	t, ok := v.env.DeclTable[ident]
+	if !ok {
+		return v.errorf(pos, "Type of '%s' is not found", ident)
+	}
+	if !v.monomorphic {
+		return nil
+	}
+	if _, ok := derefVar(t).(*types.Fun); ok && v.hasAssignedVar(t) {
+		return v.errorf(pos, "Polymorphic function '%s' of type '%s' remains after monomorphization", v.displayName(ident), t.String())
+	}
+	return nil
+}
+
+func (v *verifier) verifyUse(ident string, pos locerr.Pos) error {
+	if v.scope[ident] == 0 {
+		return v.errorf(pos, "Identifier '%s' is used but not defined", ident)
+	}
+	return nil
+}
+
+func (v *verifier) verifyInsn(insn *Insn) error {
+	if err := v.verifyTypeOf(insn.Ident, insn.Pos); err != nil {
+		return err
+	}
+
+	switch val := insn.Val.(type) {
+	case *If:
+		if err := v.verifyUse(val.Cond, insn.Pos); err != nil {
+			return err
+		}
+		if err := v.verifyBlock(val.Then); err != nil {
+			return err
+		}
+		return v.verifyBlock(val.Else)
+	case *Fun:
+		// Function can refer itself in its body
+		v.define(insn.Ident)
+		if err := v.verifyFun(val, nil); err != nil {
+			return locerr.Notef(err, "In function '%s'", v.displayName(insn.Ident))
+		}
+		return nil
+	case *XRef:
+		if _, ok := v.env.Externals[val.Ident]; !ok {
+			return v.errorf(insn.Pos, "External symbol '%s' is not found", val.Ident)
+		}
+		return nil
+	case *MakeCls:
+		if _, ok := v.prog.Toplevel[val.Fun]; !ok {
+			return v.errorf(insn.Pos, "Function '%s' of closure is not found in toplevel", val.Fun)
+		}
+		for _, c := range val.Vars {
+			if err := v.verifyUse(c, insn.Pos); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *App:
+		return v.verifyApp(insn, val)
+	}
+
+	for _, ident := range Operands(insn.Val) {
+		if err := v.verifyUse(ident, insn.Pos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *verifier) verifyApp(insn *Insn, app *App) error {
+	var callee types.Type
+	if app.Kind == EXTERNAL_CALL {
+		ext, ok := v.env.Externals[app.Callee]
+		if !ok {
+			return v.errorf(insn.Pos, "External function '%s' is not found", app.Callee)
+		}
+		callee = ext.Type
+	} else {
+		if err := v.verifyUse(app.Callee, insn.Pos); err != nil {
+			return err
+		}
+		if inst, ok := v.env.RefInsts[insn.Ident]; ok {
+			// Generic function is instantiated at the application
+			callee = inst.To
+		} else if t, ok := v.env.DeclTable[app.Callee]; ok {
+			callee = t
+		} else {
+			return v.errorf(insn.Pos, "Type of callee '%s' is not found", app.Callee)
+		}
+	}
+
+	fun, ok := derefVar(callee).(*types.Fun)
+	if !ok {
+		return v.errorf(insn.Pos, "Callee '%s' is not a function but '%s'", app.Callee, callee.String())
+	}
+	if v.monomorphic && v.hasAssignedVar(fun) {
+		return v.errorf(insn.Pos, "Callee '%s' is still polymorphic after monomorphization: '%s'", v.displayName(app.Callee), fun.String())
+	}
+	if len(fun.Params) != len(app.Args) {
+		return v.errorf(insn.Pos, "Number of arguments mismatch on calling '%s': expected %d but actually %d", v.displayName(app.Callee), len(fun.Params), len(app.Args))
+	}
+
+	for i, a := range app.Args {
+		if err := v.verifyUse(a, insn.Pos); err != nil {
+			return err
+		}
+		t, ok := v.env.DeclTable[a]
+		if !ok {
+			return v.errorf(insn.Pos, "Type of argument '%s' is not found", a)
+		}
+		if !typesMatch(fun.Params[i], t) {
+			return v.errorf(insn.Pos, "Type mismatch at %s argument of '%s': expected '%s' but actually '%s'", common.Ordinal(i+1), v.displayName(app.Callee), fun.Params[i].String(), t.String())
+		}
+	}
+
+	if ret := v.env.DeclTable[insn.Ident]; !typesMatch(fun.Ret, ret) {
+		return v.errorf(insn.Pos, "Type mismatch at result of calling '%s': expected '%s' but actually '%s'", v.displayName(app.Callee), fun.Ret.String(), ret.String())
+	}
+	return nil
+}
+
+// hasAssignedVar returns whether the type contains some type variable which should have been
+// assigned by monomorphization.
+func (v *verifier) hasAssignedVar(t types.Type) bool {
+	switch t := derefVar(t).(type) {
+	case *types.Fun:
+		if v.hasAssignedVar(t.Ret) {
+			return true
+		}
+		for _, p := range t.Params {
+			if v.hasAssignedVar(p) {
+				return true
+			}
+		}
+	case *types.Tuple:
+		for _, e := range t.Elems {
+			if v.hasAssignedVar(e) {
+				return true
+			}
+		}
+	case *types.Array:
+		return v.hasAssignedVar(t.Elem)
+	case *types.Option:
+		return v.hasAssignedVar(t.Elem)
+	case *types.Var:
+		_, ok := v.assigned[t.ID]
+		return ok
+	}
+	return false
+}
+
+func derefVar(t types.Type) types.Type {
+	for {
+		v, ok := t.(*types.Var)
+		if !ok || v.Ref == nil {
+			return t
+		}
+		t = v.Ref
+	}
+}
+
+// typesMatch returns whether two types are the same. Unlike types.Equals, unresolved type variable
+// matches to any type because generic types are not instantiated before monomorphization.
+func typesMatch(l, r types.Type) bool {
+	l, r = derefVar(l), derefVar(r)
+	if _, ok := l.(*types.Var); ok {
+		return true
+	}
+	if _, ok := r.(*types.Var); ok {
+		return true
+	}
+
+	switch l := l.(type) {
+	case *types.Tuple:
+		r, ok := r.(*types.Tuple)
+		if !ok || len(l.Elems) != len(r.Elems) {
+			return false
+		}
+		for i, e := range l.Elems {
+			if !typesMatch(e, r.Elems[i]) {
+				return false
+			}
+		}
+		return true
+	case *types.Array:
+		r, ok := r.(*types.Array)
+		return ok && typesMatch(l.Elem, r.Elem)
+	case *types.Option:
+		r, ok := r.(*types.Option)
+		return ok && typesMatch(l.Elem, r.Elem)
+	case *types.Fun:
+		r, ok := r.(*types.Fun)
+		if !ok || len(l.Params) != len(r.Params) || !typesMatch(l.Ret, r.Ret) {
+			return false
+		}
+		for i, p := range l.Params {
+			if !typesMatch(p, r.Params[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return l == r
+	}
+}
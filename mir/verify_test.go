@@ -0,0 +1,369 @@
+package mir
+
+import (
+	"github.com/rhysd/gocaml/types"
+	"github.com/rhysd/locerr"
+	"strings"
+	"testing"
+)
+
+func verifyTestEnv() *types.Env {
+	env := types.NewEnv()
+	for n, t := range map[string]types.Type{
+		"a":  types.IntType,
+		"b":  types.FloatType,
+		"c":  types.BoolType,
+		"f":  &types.Fun{types.IntType, []types.Type{types.IntType}},
+		"x":  types.IntType,
+		"k":  types.IntType,
+		"t1": types.IntType,
+		"e1": types.IntType,
+		"r":  types.IntType,
+		"u":  types.UnitType,
+		"p":  &types.Fun{types.UnitType, []types.Type{types.IntType}},
+	} {
+		env.DeclTable[n] = t
+	}
+	return env
+}
+
+func verifyTestProgram(entry []*Insn, funs map[string]*Fun, closures Closures) *Program {
+	top := NewToplevel()
+	for n, f := range funs {
+		top.Add(n, f, locerr.Pos{})
+	}
+	return &Program{top, closures, NewBlockFromArray("program", entry)}
+}
+
+func TestVerifyValidProgram(t *testing.T) {
+	env := verifyTestEnv()
+
+	// f x = k = x + a  (a is captured)
+	// program:
+	//   a = int 1
+	//   f = makecls (a) f
+	//   c = bool true
+	//   r = if c
+	//     t1 = app f a
+	//   else
+	//     e1 = ref a
+	//   u = appx print_int r
+	prog := verifyTestProgram([]*Insn{
+		NewInsn("a", &Int{1}, locerr.Pos{}),
+		NewInsn("f", &MakeCls{[]string{"a"}, "f"}, locerr.Pos{}),
+		NewInsn("c", &Bool{true}, locerr.Pos{}),
+		NewInsn("r", &If{
+			"c",
+			NewBlockFromArray("then", []*Insn{
+				NewInsn("t1", &App{"f", []string{"a"}, CLOSURE_CALL, false}, locerr.Pos{}),
+			}),
+			NewBlockFromArray("else", []*Insn{
+				NewInsn("e1", &Ref{"a"}, locerr.Pos{}),
+			}),
+		}, locerr.Pos{}),
+		NewInsn("u", &App{"print_int", []string{"r"}, EXTERNAL_CALL, false}, locerr.Pos{}),
+	}, map[string]*Fun{
+		"f": &Fun{[]string{"x"}, NewBlockFromArray("body", []*Insn{
+			NewInsn("k", &Binary{ADD, "x", "a"}, locerr.Pos{}),
+		}), false},
+	}, Closures{"f": []string{"a"}})
+
+	if err := Verify(prog, env); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyMonomorphic(prog, env); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyNestedFunction(t *testing.T) {
+	env := verifyTestEnv()
+
+	// Block before closure transform
+	//   a = int 1
+	//   f = fun x
+	//     k = app f x
+	//   r = app f a
+	block := NewBlockFromArray("program", []*Insn{
+		NewInsn("a", &Int{1}, locerr.Pos{}),
+		NewInsn("f", &Fun{[]string{"x"}, NewBlockFromArray("body", []*Insn{
+			NewInsn("k", &App{"f", []string{"x"}, DIRECT_CALL, false}, locerr.Pos{}),
+		}), false}, locerr.Pos{}),
+		NewInsn("r", &App{"f", []string{"a"}, DIRECT_CALL, false}, locerr.Pos{}),
+	})
+	if err := Verify(&Program{NewToplevel(), Closures{}, block}, env); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// instantiate records an instantiation of the generic function type as sema does.
+func instantiate(env *types.Env, fun types.Type, generic *types.Var, to types.Type) {
+	if env.PolyTypes == nil {
+		env.PolyTypes = map[types.Type][]*types.Instantiation{}
+	}
+	env.PolyTypes[fun] = append(env.PolyTypes[fun], &types.Instantiation{
+		From:    fun,
+		To:      &types.Fun{to, []types.Type{to}},
+		Mapping: []*types.VarMapping{{generic.ID, to}},
+	})
+}
+
+func TestVerifyError(t *testing.T) {
+	generic := types.NewGeneric()
+	idT := &types.Fun{generic, []types.Type{generic}}
+
+	cases := []struct {
+		what     string
+		entry    []*Insn
+		funs     map[string]*Fun
+		closures Closures
+		decls    map[string]types.Type
+		mono     bool
+		expected string
+	}{
+		{
+			what: "undefined identifier",
+			entry: []*Insn{
+				NewInsn("k", &Binary{ADD, "a", "a"}, locerr.Pos{}),
+			},
+			expected: "Identifier 'a' is used but not defined",
+		},
+		{
+			what: "use before definition",
+			entry: []*Insn{
+				NewInsn("r", &Ref{"a"}, locerr.Pos{}),
+				NewInsn("a", &Int{1}, locerr.Pos{}),
+			},
+			expected: "Identifier 'a' is used but not defined",
+		},
+		{
+			what: "identifier defined in other block",
+			entry: []*Insn{
+				NewInsn("c", &Bool{true}, locerr.Pos{}),
+				NewInsn("r", &If{
+					"c",
+					NewBlockFromArray("then", []*Insn{
+						NewInsn("t1", &Int{1}, locerr.Pos{}),
+					}),
+					NewBlockFromArray("else", []*Insn{
+						NewInsn("e1", &Int{2}, locerr.Pos{}),
+					}),
+				}, locerr.Pos{}),
+				NewInsn("k", &Ref{"t1"}, locerr.Pos{}),
+			},
+			expected: "Identifier 't1' is used but not defined",
+		},
+		{
+			what: "parameter of other function",
+			entry: []*Insn{
+				NewInsn("a", &Int{1}, locerr.Pos{}),
+			},
+			funs: map[string]*Fun{
+				"f": &Fun{[]string{"a"}, NewBlockFromArray("body", []*Insn{
+					NewInsn("k", &Ref{"a"}, locerr.Pos{}),
+				}), false},
+				"p": &Fun{[]string{"t1"}, NewBlockFromArray("body", []*Insn{
+					NewInsn("u", &Unary{NEG, "a"}, locerr.Pos{}),
+				}), false},
+			},
+			expected: "Identifier 'a' is used but not defined",
+		},
+		{
+			what: "capture not in closures",
+			entry: []*Insn{
+				NewInsn("a", &Int{1}, locerr.Pos{}),
+				NewInsn("f", &MakeCls{[]string{"a"}, "f"}, locerr.Pos{}),
+			},
+			funs: map[string]*Fun{
+				"f": &Fun{[]string{"x"}, NewBlockFromArray("body", []*Insn{
+					NewInsn("k", &Binary{ADD, "x", "a"}, locerr.Pos{}),
+				}), false},
+			},
+			expected: "Identifier 'a' is used but not defined",
+		},
+		{
+			what: "closure of unknown function",
+			entry: []*Insn{
+				NewInsn("a", &Int{1}, locerr.Pos{}),
+				NewInsn("f", &MakeCls{[]string{"a"}, "f"}, locerr.Pos{}),
+			},
+			expected: "Function 'f' of closure is not found in toplevel",
+		},
+		{
+			what: "unknown external symbol",
+			entry: []*Insn{
+				NewInsn("k", &XRef{"unknown_symbol"}, locerr.Pos{}),
+			},
+			expected: "External symbol 'unknown_symbol' is not found",
+		},
+		{
+			what: "type not found",
+			entry: []*Insn{
+				NewInsn("unknown", &Int{1}, locerr.Pos{}),
+			},
+			expected: "Type of 'unknown' is not found",
+		},
+		{
+			what: "callee is not a function",
+			entry: []*Insn{
+				NewInsn("a", &Int{1}, locerr.Pos{}),
+				NewInsn("k", &App{"a", []string{"a"}, CLOSURE_CALL, false}, locerr.Pos{}),
+			},
+			expected: "Callee 'a' is not a function but 'int'",
+		},
+		{
+			what: "number of arguments mismatch",
+			entry: []*Insn{
+				NewInsn("a", &Int{1}, locerr.Pos{}),
+				NewInsn("r", &App{"f", []string{"a", "a"}, DIRECT_CALL, false}, locerr.Pos{}),
+			},
+			funs: map[string]*Fun{
+				"f": &Fun{[]string{"x"}, NewBlockFromArray("body", []*Insn{
+					NewInsn("k", &Ref{"x"}, locerr.Pos{}),
+				}), false},
+			},
+			expected: "Number of arguments mismatch on calling 'f': expected 1 but actually 2",
+		},
+		{
+			what: "argument type mismatch",
+			entry: []*Insn{
+				NewInsn("b", &Float{1.0}, locerr.Pos{}),
+				NewInsn("r", &App{"f", []string{"b"}, DIRECT_CALL, false}, locerr.Pos{}),
+			},
+			funs: map[string]*Fun{
+				"f": &Fun{[]string{"x"}, NewBlockFromArray("body", []*Insn{
+					NewInsn("k", &Ref{"x"}, locerr.Pos{}),
+				}), false},
+			},
+			expected: "Type mismatch at 1st argument of 'f': expected 'int' but actually 'float'",
+		},
+		{
+			what: "external function argument type mismatch",
+			entry: []*Insn{
+				NewInsn("b", &Float{1.0}, locerr.Pos{}),
+				NewInsn("u", &App{"print_int", []string{"b"}, EXTERNAL_CALL, false}, locerr.Pos{}),
+			},
+			expected: "Type mismatch at 1st argument of 'print_int': expected 'int' but actually 'float'",
+		},
+		{
+			what: "result type mismatch",
+			entry: []*Insn{
+				NewInsn("a", &Int{1}, locerr.Pos{}),
+				NewInsn("u", &App{"f", []string{"a"}, DIRECT_CALL, false}, locerr.Pos{}),
+			},
+			funs: map[string]*Fun{
+				"f": &Fun{[]string{"x"}, NewBlockFromArray("body", []*Insn{
+					NewInsn("k", &Ref{"x"}, locerr.Pos{}),
+				}), false},
+			},
+			expected: "Type mismatch at result of calling 'f': expected 'int' but actually 'unit'",
+		},
+		{
+			what: "polymorphic function after monomorphization",
+			entry: []*Insn{
+				NewInsn("a", &Int{1}, locerr.Pos{}),
+			},
+			funs: map[string]*Fun{
+				"id": &Fun{[]string{"g"}, NewBlockFromArray("body", []*Insn{
+					NewInsn("k", &Ref{"g"}, locerr.Pos{}),
+				}), false},
+			},
+			decls: map[string]types.Type{
+				"id": idT,
+				"g":  generic,
+			},
+			mono:     true,
+			expected: "Polymorphic function 'id' of type ''a -> 'a' remains after monomorphization",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.what, func(t *testing.T) {
+			env := verifyTestEnv()
+			for n, ty := range tc.decls {
+				env.DeclTable[n] = ty
+			}
+			closures := tc.closures
+			if closures == nil {
+				closures = Closures{}
+			}
+			prog := verifyTestProgram(tc.entry, tc.funs, closures)
+
+			verify := Verify
+			if tc.mono {
+				instantiate(env, idT, generic, types.IntType)
+				if err := Verify(prog, env); err != nil {
+					t.Fatalf("Polymorphic function should be allowed before monomorphization: %s", err)
+				}
+				verify = VerifyMonomorphic
+			}
+
+			err := verify(prog, env)
+			if err == nil {
+				t.Fatalf("Expected error '%s' but no error occurred", tc.expected)
+			}
+			if !strings.Contains(err.Error(), tc.expected) {
+				t.Fatalf("Expected error '%s' but actually got '%s'", tc.expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestVerifyGenericArgument(t *testing.T) {
+	env := verifyTestEnv()
+	generic := types.NewGeneric()
+	idT := &types.Fun{generic, []types.Type{generic}}
+	env.DeclTable["id"] = idT
+	env.DeclTable["g"] = generic
+	instantiate(env, idT, generic, types.IntType)
+	instantiate(env, idT, generic, types.FloatType)
+
+	// Applications of generic function are not type-checked strictly before monomorphization
+	prog := verifyTestProgram([]*Insn{
+		NewInsn("a", &Int{1}, locerr.Pos{}),
+		NewInsn("r", &App{"id", []string{"a"}, DIRECT_CALL, false}, locerr.Pos{}),
+		NewInsn("b", &Float{1.0}, locerr.Pos{}),
+		NewInsn("k", &App{"id", []string{"b"}, DIRECT_CALL, false}, locerr.Pos{}),
+	}, map[string]*Fun{
+		"id": &Fun{[]string{"g"}, NewBlockFromArray("body", []*Insn{
+			NewInsn("x", &Ref{"g"}, locerr.Pos{}),
+		}), false},
+	}, Closures{})
+
+	if err := Verify(prog, env); err != nil {
+		t.Fatal(err)
+	}
+	err := VerifyMonomorphic(prog, env)
+	if err == nil || !strings.Contains(err.Error(), "remains after monomorphization") {
+		t.Fatalf("Generic function should be reported after monomorphization: %v", err)
+	}
+}
+
+func TestVerifyUnassignedTypeVar(t *testing.T) {
+	env := verifyTestEnv()
+	generic := types.NewGeneric()
+	unassigned := types.NewGeneric()
+	env.DeclTable["id"] = &types.Fun{generic, []types.Type{generic}}
+	env.DeclTable["g"] = generic
+	// Instance of 'id' for 'None'. Element type of the option is never determined.
+	instT := &types.Option{unassigned}
+	instantiate(env, env.DeclTable["id"], generic, instT)
+	env.DeclTable["id$1"] = &types.Fun{instT, []types.Type{instT}}
+	env.DeclTable["g$1"] = instT
+	env.DeclTable["n"] = instT
+	env.DeclTable["o"] = instT
+	env.DeclTable["x"] = instT
+
+	prog := verifyTestProgram([]*Insn{
+		NewInsn("n", &None{}, locerr.Pos{}),
+		NewInsn("o", &App{"id$1", []string{"n"}, DIRECT_CALL, false}, locerr.Pos{}),
+	}, map[string]*Fun{
+		"id$1": &Fun{[]string{"g$1"}, NewBlockFromArray("body", []*Insn{
+			NewInsn("x", &Ref{"g$1"}, locerr.Pos{}),
+		}), false},
+	}, Closures{})
+
+	if err := VerifyMonomorphic(prog, env); err != nil {
+		t.Fatalf("Type variable not assigned by monomorphization should be allowed: %s", err)
+	}
+}
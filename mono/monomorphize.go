@@ -3,6 +3,7 @@ package mono
 
 import (
 	"fmt"
+	"github.com/rhysd/gocaml/mangle"
 	"github.com/rhysd/gocaml/mir"
 	"github.com/rhysd/gocaml/types"
 	"strings"
@@ -44,8 +45,10 @@ import (
 //
 // Monomorphization is performed in following order:
 // 1. Monomorphize the expression of entry point of program
-// 2. Monomorphize non-closure functions. Each function is duplicated for each instantiations. For
-//    non-polymorphic functions, they don't need to be duplicated.
+// 2. Monomorphize non-closure functions. For non-polymorphic functions, they don't need to be duplicated.
+//    Polymorphic function is duplicated when a call or a reference to its instantiation is found in
+//    monomorphic code (including other duplicated functions) because instantiations may depend on type
+//    variables of enclosing polymorphic function.
 // 3. Closure functions are monomorphized at makecls instruction because captures may contain polymorphic
 //    type values.
 //
@@ -118,6 +121,10 @@ import (
 // don't break alpha transformation. We introduce another ID counter to solve this.
 // All created instructions due to monomorphization will have a new name with counter. If the counter
 // value is `42`, the instruction monomorphized from `foo$t3` will be named as `foo$t3$42`.
+//
+// Identifiers above are only for MIR. Symbol names of monomorphized functions are mangled with
+// their instantiated types (e.g. `_GC2idIiE`) and recorded in env.MangledNames. Please see package
+// mangle for more details.
 
 type typeVarAssignment map[types.VarID]types.Type
 
@@ -203,10 +210,34 @@ type codeDup struct {
 
 func (dup *codeDup) mangleFun(name string, inst *types.Instantiation) string {
 	ss := append(make([]string, 0, len(inst.Mapping)+1), name)
+	ts := make([]types.Type, 0, len(inst.Mapping))
 	for _, m := range inst.Mapping {
-		ss = append(ss, mangleType(dup.typeVarAssign.applyTo(m.Type)))
+		t := dup.typeVarAssign.applyTo(m.Type)
+		ss = append(ss, mangleType(t))
+		ts = append(ts, t)
+	}
+	mangled := strings.Join(ss, "$")
+	if sym, ok := dup.env.MangledNames[name]; ok {
+		dup.env.MangledNames[mangled] = mangle.Instantiate(sym, ts)
+	}
+	return mangled
+}
+
+// mangleClosure records a symbol name of the duplicated closure. Closures duplicated from the same
+// closure in different instances of the enclosing function may have the same types of captures or
+// the same instantiation. So the symbol name is distinguished by both its instantiated function type
+// and types of its captures.
+func (dup *codeDup) mangleClosure(orig, ident string, captures []string) {
+	sym, ok := dup.env.MangledNames[orig]
+	if !ok {
+		return
+	}
+	ts := make([]types.Type, 0, len(captures)+1)
+	ts = append(ts, dup.env.DeclTable[ident])
+	for _, c := range captures {
+		ts = append(ts, dup.env.DeclTable[c])
 	}
-	return strings.Join(ss, "$")
+	dup.env.MangledNames[ident] = mangle.Instantiate(sym, ts)
 }
 
 // Make new ID from existing ID to identify duplicated instructions from original ones.
@@ -214,6 +245,12 @@ func (dup *codeDup) mangleFun(name string, inst *types.Instantiation) string {
 func (dup *codeDup) newIdent(from string) string {
 	ident := fmt.Sprintf("%s$%d", from, dup.genID())
 	dup.replacedIdents[from] = ident
+	if name, ok := dup.env.DisplayNames[from]; ok {
+		dup.env.DisplayNames[ident] = name
+	}
+	if sym, ok := dup.env.MangledNames[from]; ok {
+		dup.env.MangledNames[ident] = sym
+	}
 	return ident
 }
 
@@ -232,16 +269,53 @@ func (dup *codeDup) resolveIdent(ident string) string {
 func (dup *codeDup) resolveRef(orig string, insnIdent string) string {
 	resolved := dup.resolveIdent(orig)
 
+	// Instantiations at variable references were recorded with identifiers before being replaced.
+	// So we need to get them with original identifiers.
 	inst, ok := dup.env.RefInsts[insnIdent]
 	if !ok {
 		return resolved
 	}
 
-	// Instantiations at variable references were recorded with identifiers before being replaced.
-	// So we need to get them with original identifiers.
-	resolved = dup.mangleFun(resolved, inst)
+	fun, ok := dup.toplevel[resolved]
+	if !ok {
+		// Polymorphic variable which is not a function remains as-is (see the comment of
+		// typeVarAssignment.assignToVar).
+		return resolved
+	}
+	if _, isClosure := dup.closures[resolved]; isClosure {
+		// TODO: Polymorphic closures are monomorphized at 'makecls' instruction. Function pointer
+		// table for its instantiations is not implemented yet.
+		return resolved
+	}
+
+	return dup.instantiate(fun, inst)
+}
+
+// instantiate returns the name of the instance of the polymorphic function for the instantiation.
+// Types in the instantiation may refer type variables of enclosing function. So they are resolved
+// with current type variable assignment. The instance is created when it does not exist yet.
+func (dup *codeDup) instantiate(fun mir.FunInsn, inst *types.Instantiation) string {
+	name := dup.mangleFun(fun.Name, inst)
+	if _, ok := dup.toProg.Toplevel[name]; ok {
+		return name
+	}
+
+	mapping := make([]*types.VarMapping, 0, len(inst.Mapping))
+	for _, m := range inst.Mapping {
+		mapping = append(mapping, &types.VarMapping{m.ID, dup.typeVarAssign.applyTo(m.Type)})
+	}
+	monoInst := &types.Instantiation{inst.From, dup.typeVarAssign.applyTo(inst.To), mapping}
 
-	return resolved
+	// Note: The body of the instance may refer identifiers and type variables of enclosing function
+	// which were already replaced. So they are inherited.
+	child := dup.newCodeDup()
+	for from, to := range dup.replacedIdents {
+		child.replacedIdents[from] = to
+	}
+	for id, t := range dup.typeVarAssign {
+		child.typeVarAssign[id] = t
+	}
+	return child.dupFun(fun, monoInst).Name
 }
 
 func (dup *codeDup) resolveIdents(is []string) []string {
@@ -270,7 +344,7 @@ func (dup *codeDup) dupInsn(from *mir.Insn) *mir.Insn {
 	}
 
 	switch val := from.Val.(type) {
-	case *mir.Unit, *mir.Bool, *mir.Int, *mir.Float, *mir.String, *mir.None, *mir.XRef:
+	case *mir.Unit, *mir.Bool, *mir.Int, *mir.Float, *mir.Char, *mir.String, *mir.None, *mir.XRef:
 		// Don't need to duplicate instruction because they don't refer any idents
 		to.Val = val
 	case *mir.Unary:
@@ -304,6 +378,7 @@ func (dup *codeDup) dupInsn(from *mir.Insn) *mir.Insn {
 			Kind:   val.Kind,
 			Callee: callee,
 			Args:   dup.resolveIdents(val.Args),
+			IsTail: val.IsTail,
 		}
 	case *mir.Tuple:
 		to.Val = &mir.Tuple{dup.resolveIdents(val.Elems)}
@@ -326,12 +401,18 @@ func (dup *codeDup) dupInsn(from *mir.Insn) *mir.Insn {
 		}
 	case *mir.ArrLen:
 		to.Val = &mir.ArrLen{dup.resolveIdent(val.Array)}
+	case *mir.StrLoad:
+		to.Val = &mir.StrLoad{dup.resolveIdent(val.From), dup.resolveIdent(val.Index)}
 	case *mir.Some:
 		to.Val = &mir.Some{dup.resolveIdent(val.Elem)}
 	case *mir.IsSome:
 		to.Val = &mir.IsSome{dup.resolveIdent(val.OptVal)}
 	case *mir.DerefSome:
 		to.Val = &mir.DerefSome{dup.resolveIdent(val.SomeVal)}
+	case *mir.Show:
+		// Note:
+		// Type of the child is resolved to concrete type here. Codegen expands 'show' following it.
+		to.Val = &mir.Show{dup.resolveIdent(val.Child)}
 	case *mir.MakeCls:
 		fun := dup.dupClosure(val.Fun, val.Vars)
 		caps, _ := dup.toProg.Closures[fun.Name]
@@ -378,16 +459,19 @@ func (dup *codeDup) dupFun(fun mir.FunInsn, inst *types.Instantiation) mir.FunIn
 	t := dup.typeVarAssign.applyTo(dup.env.DeclTable[fun.Name])
 	dup.env.DeclTable[funName] = t
 
-	val.Body = dup.dupBlock(fun.Val.Body)
-
 	insn := mir.FunInsn{
 		Name: funName,
 		Val:  val,
 		Pos:  fun.Pos,
 	}
 
+	// Note: Register the instance before duplicating its body because the body may refer the instance
+	// recursively. Recursive reference in its own body is not instantiated by type checker.
 	dup.funInsts[fun.Name] = append(dup.funInsts[fun.Name], funInst{inst, insn})
 	dup.toProg.Toplevel[funName] = insn
+	dup.replacedIdents[fun.Name] = funName
+
+	val.Body = dup.dupBlock(fun.Val.Body)
 	return insn
 }
 
@@ -447,9 +531,11 @@ func (dup *codeDup) dupClosure(name string, captures []string) mir.FunInsn {
 			val.Params = append(val.Params, p)
 		}
 
-		dup.env.DeclTable[ident] = fty
+		dup.env.DeclTable[ident] = dup.typeVarAssign.applyTo(fty)
 		val.Body = dup.dupBlock(val.Body)
 
+		dup.mangleClosure(name, ident, monoCaps)
+
 		insn.Val = val
 		dup.toProg.Toplevel[ident] = insn
 		dup.toProg.Closures[ident] = monoCaps
@@ -457,6 +543,8 @@ func (dup *codeDup) dupClosure(name string, captures []string) mir.FunInsn {
 	}
 
 	// Some capture is polymorphic and closure function is also polymorphic.
+	// Note: dupFun() instantiates the type of the function. It is looked up with the new identifier.
+	dup.env.DeclTable[ident] = fty
 	mappings := make(map[string][]*types.VarMapping, len(insts))
 	for _, inst := range insts {
 		mapping := make([]*types.VarMapping, 0, len(inst.Mapping))
@@ -471,6 +559,7 @@ func (dup *codeDup) dupClosure(name string, captures []string) mir.FunInsn {
 			mapping,
 		}
 		monoFun := dup.dupFun(insn, monoInst)
+		dup.mangleClosure(name, monoFun.Name, monoCaps)
 		dup.toProg.Closures[monoFun.Name] = monoCaps
 		mappings[monoFun.Name] = mapping
 	}
@@ -521,7 +610,13 @@ func (mono *monomorphizer) visitInsn(from *mir.Insn) {
 	case *mir.MakeCls:
 		mono.newCodeDup().dupClosure(val.Fun, val.Vars)
 	case *mir.App:
-		panic("TODO:: Consider callee is generic and monomorphized")
+		// Known function called from monomorphic code may be polymorphic. Callee is replaced with its
+		// instantiation.
+		if val.Kind == mir.DIRECT_CALL {
+			val.Callee = mono.newCodeDup().resolveRef(val.Callee, from.Ident)
+		}
+	case *mir.Ref:
+		val.Ident = mono.newCodeDup().resolveRef(val.Ident, from.Ident)
 	case *mir.If:
 		mono.visitBlock(val.Then)
 		mono.visitBlock(val.Else)
@@ -537,21 +632,18 @@ func (mono *monomorphizer) visitBlock(from *mir.Block) {
 
 func (mono *monomorphizer) visitFun(fun mir.FunInsn) {
 	ty, _ := mono.env.DeclTable[fun.Name]
-	insts, isPoly := mono.env.PolyTypes[ty]
-
-	// When monomorphic functioin, simply visit functioin
-	if !isPoly {
-		// Note: Don't need to check the function was already visited because this function
-		// is called once per each function.
-		mono.visitBlock(fun.Val.Body)
-		mono.toProg.Toplevel[fun.Name] = fun
+	if _, isPoly := mono.env.PolyTypes[ty]; isPoly {
+		// Polymorphic function is duplicated when its instantiation is referred (see codeDup.resolveRef).
+		// Instantiations recorded by type checker may contain type variables of enclosing polymorphic
+		// function. So they cannot be duplicated here.
 		return
 	}
 
-	// When polymorphic function, monomorphize it with each instantiation
-	for _, inst := range insts {
-		mono.newCodeDup().dupFun(fun, inst)
-	}
+	// When monomorphic functioin, simply visit functioin
+	// Note: Don't need to check the function was already visited because this function
+	// is called once per each function.
+	mono.visitBlock(fun.Val.Body)
+	mono.toProg.Toplevel[fun.Name] = fun
 }
 
 func Monomorphize(prog *mir.Program, env *types.Env) *mir.Program {
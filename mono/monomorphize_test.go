@@ -0,0 +1,125 @@
+package mono
+
+import (
+	"github.com/rhysd/gocaml/closure"
+	"github.com/rhysd/gocaml/mir"
+	"github.com/rhysd/gocaml/sema"
+	"github.com/rhysd/gocaml/syntax"
+	"github.com/rhysd/locerr"
+	"reflect"
+	"testing"
+)
+
+func TestMangledNamesOfInstantiatedClosures(t *testing.T) {
+	for _, tc := range []struct {
+		what string
+		code string
+	}{
+		{
+			"monomorphic closure in instantiations of enclosing function",
+			`let rec f x = let rec g b = if b then x else x in g true in let a = f 1 in let b = f 3.14 in ()`,
+		},
+		{
+			"polymorphic closure in instantiations of enclosing function",
+			`let rec f x = let rec g y = (x, y) in g 1 in let (a, b) = f 1 in let (c, d) = f 3.14 in println_int (a + b + d)`,
+		},
+	} {
+		t.Run(tc.what, func(t *testing.T) {
+			s := locerr.NewDummySource(tc.code)
+			ast, err := syntax.Parse(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			env, ir, err := sema.SemanticsCheck(ast)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prog := Monomorphize(closure.Transform(ir), env)
+
+			seen := map[string]string{}
+			for name := range prog.Toplevel {
+				sym, ok := env.MangledNames[name]
+				if !ok {
+					t.Fatalf("Mangled name of '%s' was not found", name)
+				}
+				if other, ok := seen[sym]; ok {
+					t.Fatalf("Functions '%s' and '%s' have the same symbol '%s'", other, name, sym)
+				}
+				seen[sym] = name
+			}
+		})
+	}
+}
+
+func TestResolveInstantiations(t *testing.T) {
+	for _, tc := range []struct {
+		what    string
+		code    string
+		callees []string // Types of callees of direct calls in entry block
+		refs    []string // Types of references in entry block
+	}{
+		{
+			"calls of polymorphic function",
+			`let rec id x = x in let a = id 42 in let b = id true in ()`,
+			[]string{"int -> int", "bool -> bool"},
+			[]string{},
+		},
+		{
+			"recursive polymorphic function",
+			`let rec len a = if Array.length a = 0 then 0 else len a in println_int (len (Array.make 1 true))`,
+			[]string{"bool array -> int"},
+			[]string{},
+		},
+		{
+			"polymorphic function called in instance of enclosing function",
+			`let rec f x = let rec id y = y in id x in let a = f 1 in let b = f 3.14 in ()`,
+			[]string{"int -> int", "float -> float"},
+			[]string{},
+		},
+		{
+			"polymorphic value which is not a function",
+			`let o = None in let a = (o = Some 1) in let b = (o = Some true) in ()`,
+			[]string{},
+			[]string{"int option", "bool option"},
+		},
+	} {
+		t.Run(tc.what, func(t *testing.T) {
+			s := locerr.NewDummySource(tc.code)
+			ast, err := syntax.Parse(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			env, ir, err := sema.SemanticsCheck(ast)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prog := Monomorphize(closure.Transform(ir), env)
+
+			if err := mir.VerifyMonomorphic(prog, env); err != nil {
+				t.Fatal(err)
+			}
+
+			callees, refs := []string{}, []string{}
+			for i := prog.Entry.Top.Next; i.Next != nil; i = i.Next {
+				switch val := i.Val.(type) {
+				case *mir.App:
+					if val.Kind != mir.DIRECT_CALL {
+						continue
+					}
+					if _, ok := prog.Toplevel[val.Callee]; !ok {
+						t.Fatalf("Callee '%s' is not an instance in program", val.Callee)
+					}
+					callees = append(callees, env.DeclTable[val.Callee].String())
+				case *mir.Ref:
+					refs = append(refs, env.DeclTable[i.Ident].String())
+				}
+			}
+			if !reflect.DeepEqual(callees, tc.callees) {
+				t.Errorf("Wanted callees typed %v but got %v", tc.callees, callees)
+			}
+			if !reflect.DeepEqual(refs, tc.refs) {
+				t.Errorf("Wanted references typed %v but got %v", tc.refs, refs)
+			}
+		})
+	}
+}
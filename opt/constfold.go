@@ -0,0 +1,283 @@
+// Package opt provides optimization passes on MIR.
+//
+// Passes in this package are run on MIR block before closure transform. They rely on the
+// property that all identifiers in MIR are unique after alpha transform, so a definition of
+// an identifier can be looked up without considering scopes.
+package opt
+
+import (
+	"github.com/rhysd/gocaml/mir"
+	"math"
+)
+
+// FoldConstants folds constant values in the block and propagates them to their uses.
+//
+//   - Unary and binary operations whose operands are constants
+//   - Conditional branches whose condition is a constant
+//   - 'tplload' from a tuple literal
+//   - 'arrlen' of an array literal
+//
+// Integer operations which would overflow or divide by zero are not folded so that runtime
+// checks (-trapv, -check-div) are still reported.
+func FoldConstants(b *mir.Block) {
+	f := &folder{map[string]mir.Val{}}
+	f.block(b)
+}
+
+type folder struct {
+	defs map[string]mir.Val
+}
+
+func (f *folder) block(b *mir.Block) {
+	for i := b.Top.Next; i.Next != nil; i = i.Next {
+		f.insn(i)
+	}
+}
+
+// def returns the value which defines the identifier, following 'ref' chains.
+func (f *folder) def(ident string) mir.Val {
+	v, ok := f.defs[ident]
+	for ok {
+		r, isRef := v.(*mir.Ref)
+		if !isRef {
+			break
+		}
+		var found mir.Val
+		if found, ok = f.defs[r.Ident]; ok {
+			v = found
+		}
+	}
+	return v
+}
+
+func (f *folder) insn(i *mir.Insn) {
+	switch val := i.Val.(type) {
+	case *mir.Ref:
+		// Note:
+		// Strings are not propagated because each string constant is emitted as a global
+		// value at codegen.
+		switch c := f.def(val.Ident).(type) {
+		case *mir.Int, *mir.Float, *mir.Bool, *mir.Char, *mir.Unit:
+			i.Val = c
+		}
+	case *mir.Unary:
+		if v := f.unary(val); v != nil {
+			i.Val = v
+		}
+	case *mir.Binary:
+		if v := f.binary(val); v != nil {
+			i.Val = v
+		}
+	case *mir.If:
+		c, ok := f.def(val.Cond).(*mir.Bool)
+		if !ok {
+			f.block(val.Then)
+			f.block(val.Else)
+			break
+		}
+		taken := val.Else
+		if c.Const {
+			taken = val.Then
+		}
+		f.block(taken)
+		begin, end := taken.WholeRange()
+		last := end.Prev
+		if begin == end {
+			panic("FATAL: Branch of 'if' must contain at least one instruction")
+		}
+		// Move instructions in the taken branch before the 'if' instruction and bind its
+		// result to the identifier of the 'if'.
+		begin.Prev = i.Prev
+		i.Prev.Next = begin
+		last.Next = i
+		i.Prev = last
+		i.Val = &mir.Ref{last.Ident}
+		f.insn(i)
+		return
+	case *mir.Fun:
+		f.block(val.Body)
+	case *mir.TplLoad:
+		if t, ok := f.def(val.From).(*mir.Tuple); ok {
+			i.Val = &mir.Ref{t.Elems[val.Index]}
+			f.insn(i)
+			return
+		}
+	case *mir.ArrLen:
+		if a, ok := f.def(val.Array).(*mir.ArrLit); ok {
+			i.Val = &mir.Int{int64(len(a.Elems))}
+		}
+	}
+	f.defs[i.Ident] = i.Val
+}
+
+func (f *folder) unary(val *mir.Unary) mir.Val {
+	switch c := f.def(val.Child).(type) {
+	case *mir.Bool:
+		if val.Op == mir.NOT {
+			return &mir.Bool{!c.Const}
+		}
+	case *mir.Int:
+		if val.Op == mir.NEG && c.Const != math.MinInt64 {
+			return &mir.Int{-c.Const}
+		}
+	case *mir.Float:
+		if val.Op == mir.FNEG {
+			return &mir.Float{-c.Const}
+		}
+	}
+	return nil
+}
+
+func (f *folder) binary(val *mir.Binary) mir.Val {
+	lhs, rhs := f.def(val.LHS), f.def(val.RHS)
+
+	switch val.Op {
+	case mir.AND, mir.OR:
+		return f.logical(val, lhs, rhs)
+	}
+
+	switch l := lhs.(type) {
+	case *mir.Int:
+		if r, ok := rhs.(*mir.Int); ok {
+			return foldInt(val.Op, l.Const, r.Const)
+		}
+	case *mir.Float:
+		if r, ok := rhs.(*mir.Float); ok {
+			return foldFloat(val.Op, l.Const, r.Const)
+		}
+	case *mir.Char:
+		if r, ok := rhs.(*mir.Char); ok {
+			return foldCompare(val.Op, int(l.Const)-int(r.Const))
+		}
+	case *mir.Bool:
+		if r, ok := rhs.(*mir.Bool); ok {
+			switch val.Op {
+			case mir.EQ:
+				return &mir.Bool{l.Const == r.Const}
+			case mir.NEQ:
+				return &mir.Bool{l.Const != r.Const}
+			}
+		}
+	case *mir.String:
+		if r, ok := rhs.(*mir.String); ok {
+			switch val.Op {
+			case mir.EQ:
+				return &mir.Bool{l.Const == r.Const}
+			case mir.NEQ:
+				return &mir.Bool{l.Const != r.Const}
+			}
+		}
+	}
+	return nil
+}
+
+// logical folds '&&' and '||'. Both operands are already evaluated in MIR, so the operation
+// can be folded when only one of them is known.
+func (f *folder) logical(val *mir.Binary, lhs, rhs mir.Val) mir.Val {
+	fold := func(known *mir.Bool, other string) mir.Val {
+		if val.Op == mir.AND && !known.Const || val.Op == mir.OR && known.Const {
+			return &mir.Bool{known.Const}
+		}
+		if c, ok := f.def(other).(*mir.Bool); ok {
+			return &mir.Bool{c.Const}
+		}
+		return &mir.Ref{other}
+	}
+	if l, ok := lhs.(*mir.Bool); ok {
+		return fold(l, val.RHS)
+	}
+	if r, ok := rhs.(*mir.Bool); ok {
+		return fold(r, val.LHS)
+	}
+	return nil
+}
+
+func foldInt(op mir.OperatorKind, l, r int64) mir.Val {
+	switch op {
+	case mir.ADD:
+		v := l + r
+		if (v > l) != (r > 0) {
+			return nil // Overflow
+		}
+		return &mir.Int{v}
+	case mir.SUB:
+		v := l - r
+		if (v < l) != (r > 0) {
+			return nil // Overflow
+		}
+		return &mir.Int{v}
+	case mir.MUL:
+		if l == 0 || r == 0 {
+			return &mir.Int{0}
+		}
+		v := l * r
+		if v/r != l || (l == -1 && r == math.MinInt64) || (r == -1 && l == math.MinInt64) {
+			return nil // Overflow
+		}
+		return &mir.Int{v}
+	case mir.DIV, mir.MOD:
+		if r == 0 || (l == math.MinInt64 && r == -1) {
+			return nil
+		}
+		if op == mir.DIV {
+			return &mir.Int{l / r}
+		}
+		return &mir.Int{l % r}
+	}
+
+	d := 0
+	if l < r {
+		d = -1
+	} else if l > r {
+		d = 1
+	}
+	return foldCompare(op, d)
+}
+
+func foldFloat(op mir.OperatorKind, l, r float64) mir.Val {
+	switch op {
+	case mir.FADD:
+		return &mir.Float{l + r}
+	case mir.FSUB:
+		return &mir.Float{l - r}
+	case mir.FMUL:
+		return &mir.Float{l * r}
+	case mir.FDIV:
+		return &mir.Float{l / r}
+	case mir.LT:
+		return &mir.Bool{l < r}
+	case mir.LTE:
+		return &mir.Bool{l <= r}
+	case mir.EQ:
+		return &mir.Bool{l == r}
+	case mir.NEQ:
+		// Note:
+		// Code generator emits ordered comparison for '<>'. So it is false when either operand is NaN.
+		return &mir.Bool{l < r || l > r}
+	case mir.GT:
+		return &mir.Bool{l > r}
+	case mir.GTE:
+		return &mir.Bool{l >= r}
+	}
+	return nil
+}
+
+// foldCompare folds comparison operators. d is negative, zero or positive when lhs is less
+// than, equal to or greater than rhs.
+func foldCompare(op mir.OperatorKind, d int) mir.Val {
+	switch op {
+	case mir.LT:
+		return &mir.Bool{d < 0}
+	case mir.LTE:
+		return &mir.Bool{d <= 0}
+	case mir.EQ:
+		return &mir.Bool{d == 0}
+	case mir.NEQ:
+		return &mir.Bool{d != 0}
+	case mir.GT:
+		return &mir.Bool{d > 0}
+	case mir.GTE:
+		return &mir.Bool{d >= 0}
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package opt
+
+import (
+	"github.com/rhysd/gocaml/mir"
+	"github.com/rhysd/locerr"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func insn(ident string, val mir.Val) *mir.Insn {
+	return &mir.Insn{ident, val, nil, nil, locerr.Pos{}}
+}
+
+func valOf(b *mir.Block, ident string) mir.Val {
+	begin, end := b.WholeRange()
+	for i := begin; i != end; i = i.Next {
+		if i.Ident == ident {
+			return i.Val
+		}
+	}
+	return nil
+}
+
+func identsOf(b *mir.Block) []string {
+	idents := []string{}
+	begin, end := b.WholeRange()
+	for i := begin; i != end; i = i.Next {
+		idents = append(idents, i.Ident)
+	}
+	return idents
+}
+
+func TestFoldBinary(t *testing.T) {
+	cases := []struct {
+		what     string
+		lhs, rhs mir.Val
+		op       mir.OperatorKind
+		expected mir.Val
+	}{
+		{"add", &mir.Int{1}, &mir.Int{2}, mir.ADD, &mir.Int{3}},
+		{"sub", &mir.Int{1}, &mir.Int{2}, mir.SUB, &mir.Int{-1}},
+		{"mul", &mir.Int{-3}, &mir.Int{4}, mir.MUL, &mir.Int{-12}},
+		{"div", &mir.Int{7}, &mir.Int{2}, mir.DIV, &mir.Int{3}},
+		{"mod", &mir.Int{-7}, &mir.Int{2}, mir.MOD, &mir.Int{-1}},
+		{"add overflow", &mir.Int{math.MaxInt64}, &mir.Int{1}, mir.ADD, nil},
+		{"sub overflow", &mir.Int{math.MinInt64}, &mir.Int{1}, mir.SUB, nil},
+		{"mul overflow", &mir.Int{math.MaxInt64}, &mir.Int{2}, mir.MUL, nil},
+		{"mul overflow min", &mir.Int{math.MinInt64}, &mir.Int{-1}, mir.MUL, nil},
+		{"div by zero", &mir.Int{1}, &mir.Int{0}, mir.DIV, nil},
+		{"mod by zero", &mir.Int{1}, &mir.Int{0}, mir.MOD, nil},
+		{"div overflow", &mir.Int{math.MinInt64}, &mir.Int{-1}, mir.DIV, nil},
+		{"fadd", &mir.Float{1.5}, &mir.Float{2.0}, mir.FADD, &mir.Float{3.5}},
+		{"fsub", &mir.Float{1.5}, &mir.Float{2.0}, mir.FSUB, &mir.Float{-0.5}},
+		{"fmul", &mir.Float{1.5}, &mir.Float{2.0}, mir.FMUL, &mir.Float{3.0}},
+		{"fdiv", &mir.Float{1.5}, &mir.Float{2.0}, mir.FDIV, &mir.Float{0.75}},
+		{"int less", &mir.Int{1}, &mir.Int{2}, mir.LT, &mir.Bool{true}},
+		{"int less equal", &mir.Int{2}, &mir.Int{2}, mir.LTE, &mir.Bool{true}},
+		{"int greater", &mir.Int{1}, &mir.Int{2}, mir.GT, &mir.Bool{false}},
+		{"int greater equal", &mir.Int{1}, &mir.Int{2}, mir.GTE, &mir.Bool{false}},
+		{"int equal", &mir.Int{1}, &mir.Int{1}, mir.EQ, &mir.Bool{true}},
+		{"int not equal", &mir.Int{1}, &mir.Int{1}, mir.NEQ, &mir.Bool{false}},
+		{"float less", &mir.Float{1.0}, &mir.Float{2.0}, mir.LT, &mir.Bool{true}},
+		{"float equal", &mir.Float{1.0}, &mir.Float{2.0}, mir.EQ, &mir.Bool{false}},
+		{"float not equal", &mir.Float{1.0}, &mir.Float{2.0}, mir.NEQ, &mir.Bool{true}},
+		{"NaN not equal", &mir.Float{math.NaN()}, &mir.Float{math.NaN()}, mir.NEQ, &mir.Bool{false}},
+		{"char is unsigned", &mir.Char{0x80}, &mir.Char{'a'}, mir.GT, &mir.Bool{true}},
+		{"bool equal", &mir.Bool{true}, &mir.Bool{false}, mir.EQ, &mir.Bool{false}},
+		{"string equal", &mir.String{"foo"}, &mir.String{"foo"}, mir.EQ, &mir.Bool{true}},
+		{"string not equal", &mir.String{"foo"}, &mir.String{"bar"}, mir.NEQ, &mir.Bool{true}},
+		{"and", &mir.Bool{true}, &mir.Bool{false}, mir.AND, &mir.Bool{false}},
+		{"or", &mir.Bool{true}, &mir.Bool{false}, mir.OR, &mir.Bool{true}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.what, func(t *testing.T) {
+			b := mir.NewBlockFromArray("root", []*mir.Insn{
+				insn("a", tc.lhs),
+				insn("b", tc.rhs),
+				insn("c", &mir.Binary{tc.op, "a", "b"}),
+			})
+			FoldConstants(b)
+			actual := valOf(b, "c")
+			if tc.expected == nil {
+				if _, ok := actual.(*mir.Binary); !ok {
+					t.Fatalf("Operation should not be folded but got %#v", actual)
+				}
+				return
+			}
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatalf("Expected %#v but actually %#v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestFoldUnary(t *testing.T) {
+	cases := []struct {
+		what     string
+		child    mir.Val
+		op       mir.OperatorKind
+		expected mir.Val
+	}{
+		{"not", &mir.Bool{true}, mir.NOT, &mir.Bool{false}},
+		{"neg", &mir.Int{3}, mir.NEG, &mir.Int{-3}},
+		{"neg overflow", &mir.Int{math.MinInt64}, mir.NEG, nil},
+		{"fneg", &mir.Float{3.0}, mir.FNEG, &mir.Float{-3.0}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.what, func(t *testing.T) {
+			b := mir.NewBlockFromArray("root", []*mir.Insn{
+				insn("a", tc.child),
+				insn("b", &mir.Unary{tc.op, "a"}),
+			})
+			FoldConstants(b)
+			actual := valOf(b, "b")
+			if tc.expected == nil {
+				if _, ok := actual.(*mir.Unary); !ok {
+					t.Fatalf("Operation should not be folded but got %#v", actual)
+				}
+				return
+			}
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatalf("Expected %#v but actually %#v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestPropagateConstants(t *testing.T) {
+	// let x = 1 + 2 in let y = x in y * 4
+	b := mir.NewBlockFromArray("root", []*mir.Insn{
+		insn("a", &mir.Int{1}),
+		insn("b", &mir.Int{2}),
+		insn("x", &mir.Binary{mir.ADD, "a", "b"}),
+		insn("y", &mir.Ref{"x"}),
+		insn("c", &mir.Int{4}),
+		insn("d", &mir.Binary{mir.MUL, "y", "c"}),
+	})
+	FoldConstants(b)
+	if v, ok := valOf(b, "y").(*mir.Int); !ok || v.Const != 3 {
+		t.Errorf("'ref' to constant should be folded: %#v", valOf(b, "y"))
+	}
+	if v, ok := valOf(b, "d").(*mir.Int); !ok || v.Const != 12 {
+		t.Errorf("Constant should be propagated through 'ref': %#v", valOf(b, "d"))
+	}
+}
+
+func TestFoldLogicalWithUnknownOperand(t *testing.T) {
+	b := mir.NewBlockFromArray("root", []*mir.Insn{
+		insn("t", &mir.Bool{true}),
+		insn("f", &mir.Bool{false}),
+		insn("x", &mir.App{"g", []string{}, mir.DIRECT_CALL, false}),
+		insn("a", &mir.Binary{mir.AND, "t", "x"}),
+		insn("b", &mir.Binary{mir.AND, "x", "f"}),
+		insn("c", &mir.Binary{mir.OR, "x", "t"}),
+		insn("d", &mir.Binary{mir.OR, "f", "x"}),
+	})
+	FoldConstants(b)
+	if v, ok := valOf(b, "a").(*mir.Ref); !ok || v.Ident != "x" {
+		t.Errorf("true && x should be x: %#v", valOf(b, "a"))
+	}
+	if v, ok := valOf(b, "b").(*mir.Bool); !ok || v.Const {
+		t.Errorf("x && false should be false: %#v", valOf(b, "b"))
+	}
+	if v, ok := valOf(b, "c").(*mir.Bool); !ok || !v.Const {
+		t.Errorf("x || true should be true: %#v", valOf(b, "c"))
+	}
+	if v, ok := valOf(b, "d").(*mir.Ref); !ok || v.Ident != "x" {
+		t.Errorf("false || x should be x: %#v", valOf(b, "d"))
+	}
+}
+
+func TestFoldKnownIf(t *testing.T) {
+	for _, cond := range []bool{true, false} {
+		then := mir.NewBlockFromArray("then", []*mir.Insn{
+			insn("t1", &mir.Int{1}),
+			insn("t2", &mir.Int{2}),
+			insn("t3", &mir.Binary{mir.ADD, "t1", "t2"}),
+		})
+		els := mir.NewBlockFromArray("else", []*mir.Insn{
+			insn("e1", &mir.App{"g", []string{}, mir.DIRECT_CALL, false}),
+		})
+		b := mir.NewBlockFromArray("root", []*mir.Insn{
+			insn("a", &mir.Int{1}),
+			insn("b", &mir.Int{2}),
+			insn("c", &mir.Binary{mir.LT, "a", "b"}),
+			insn("d", &mir.Unary{mir.NOT, "c"}),
+			insn("e", &mir.If{"c", then, els}),
+		})
+		if !cond {
+			valOf(b, "e").(*mir.If).Cond = "d"
+		}
+
+		FoldConstants(b)
+
+		var expected []string
+		if cond {
+			expected = []string{"a", "b", "c", "d", "t1", "t2", "t3", "e"}
+		} else {
+			expected = []string{"a", "b", "c", "d", "e1", "e"}
+		}
+		if actual := identsOf(b); !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("Expected instructions %v but actually %v (cond: %v)", expected, actual, cond)
+		}
+
+		for i := b.Top.Next; i.Next != nil; i = i.Next {
+			if i.Next.Prev != i {
+				t.Fatalf("Prev does not point previous node properly at %s", i.Next.Ident)
+			}
+		}
+
+		e := valOf(b, "e")
+		if cond {
+			if v, ok := e.(*mir.Int); !ok || v.Const != 3 {
+				t.Errorf("Result of 'if' should be folded to constant but %#v", e)
+			}
+		} else {
+			if v, ok := e.(*mir.Ref); !ok || v.Ident != "e1" {
+				t.Errorf("Result of 'if' should refer the last instruction of else branch but %#v", e)
+			}
+		}
+	}
+}
+
+func TestFoldInsideBranchesAndFunctions(t *testing.T) {
+	then := mir.NewBlockFromArray("then", []*mir.Insn{
+		insn("t1", &mir.Int{1}),
+		insn("t2", &mir.Binary{mir.ADD, "t1", "t1"}),
+	})
+	els := mir.NewBlockFromArray("else", []*mir.Insn{
+		insn("e1", &mir.Unary{mir.NEG, "t0"}),
+	})
+	body := mir.NewBlockFromArray("body", []*mir.Insn{
+		insn("t0", &mir.Int{3}),
+		insn("r", &mir.If{"p", then, els}),
+	})
+	b := mir.NewBlockFromArray("root", []*mir.Insn{
+		insn("f", &mir.Fun{[]string{"p"}, body, false}),
+	})
+
+	FoldConstants(b)
+
+	if v, ok := valOf(then, "t2").(*mir.Int); !ok || v.Const != 2 {
+		t.Errorf("Then branch should be folded: %#v", valOf(then, "t2"))
+	}
+	if v, ok := valOf(els, "e1").(*mir.Int); !ok || v.Const != -3 {
+		t.Errorf("Else branch should be folded: %#v", valOf(els, "e1"))
+	}
+	if _, ok := valOf(body, "r").(*mir.If); !ok {
+		t.Errorf("'if' with unknown condition should remain: %#v", valOf(body, "r"))
+	}
+}
+
+func TestFoldTupleLoad(t *testing.T) {
+	b := mir.NewBlockFromArray("root", []*mir.Insn{
+		insn("a", &mir.Int{1}),
+		insn("b", &mir.App{"g", []string{}, mir.DIRECT_CALL, false}),
+		insn("t", &mir.Tuple{[]string{"a", "b"}}),
+		insn("u", &mir.Ref{"t"}),
+		insn("x", &mir.TplLoad{"u", 0}),
+		insn("y", &mir.TplLoad{"u", 1}),
+		insn("z", &mir.TplLoad{"p", 1}),
+	})
+	FoldConstants(b)
+	if v, ok := valOf(b, "x").(*mir.Int); !ok || v.Const != 1 {
+		t.Errorf("Constant element of tuple should be propagated: %#v", valOf(b, "x"))
+	}
+	if v, ok := valOf(b, "y").(*mir.Ref); !ok || v.Ident != "b" {
+		t.Errorf("Non-constant element of tuple should be referred: %#v", valOf(b, "y"))
+	}
+	if _, ok := valOf(b, "z").(*mir.TplLoad); !ok {
+		t.Errorf("Load from unknown tuple should remain: %#v", valOf(b, "z"))
+	}
+}
+
+func TestFoldArrayLength(t *testing.T) {
+	b := mir.NewBlockFromArray("root", []*mir.Insn{
+		insn("a", &mir.Int{1}),
+		insn("arr", &mir.ArrLit{[]string{"a", "a", "a"}}),
+		insn("len", &mir.ArrLen{"arr"}),
+		insn("n", &mir.Array{"a", "a"}),
+		insn("len2", &mir.ArrLen{"n"}),
+	})
+	FoldConstants(b)
+	if v, ok := valOf(b, "len").(*mir.Int); !ok || v.Const != 3 {
+		t.Errorf("Length of array literal should be folded: %#v", valOf(b, "len"))
+	}
+	if _, ok := valOf(b, "len2").(*mir.ArrLen); !ok {
+		t.Errorf("Length of array created by Array.make should remain: %#v", valOf(b, "len2"))
+	}
+}
@@ -0,0 +1,209 @@
+package opt
+
+import (
+	"github.com/rhysd/gocaml/mir"
+)
+
+// EliminateDeadCode removes instructions whose results are never used and which have no side
+// effect. And it removes toplevel functions which are no longer reachable from entry block of
+// the program. Functions in roots are always kept even if they are not reachable (e.g. functions
+// exported to C). The program must be closure-transformed.
+//
+// Applications (including external functions) and array stores are considered to have side
+// effects. Integer division is also considered to have side effect because it may raise an error
+// for division by zero at runtime. When overflowCheck is true, integer +, -, * and negation are
+// also considered to have side effects because they may raise an error for overflow at runtime
+// (-trapv). Note that the last instruction of each block is never removed because it is the value
+// of the block.
+func EliminateDeadCode(prog *mir.Program, roots []string, overflowCheck bool) {
+	for {
+		removed := pruneFunctions(prog, roots)
+
+		uses := map[string]int{}
+		countUsesInBlock(prog.Entry, uses, 1)
+		for _, f := range prog.Toplevel {
+			countUsesInBlock(f.Val.Body, uses, 1)
+		}
+
+		if removeUnusedInBlock(prog.Entry, uses, overflowCheck) {
+			removed = true
+		}
+		for _, f := range prog.Toplevel {
+			if removeUnusedInBlock(f.Val.Body, uses, overflowCheck) {
+				removed = true
+			}
+		}
+
+		if !removed {
+			return
+		}
+	}
+}
+
+// removeUnusedInsns removes unused instructions in the block which has no side effect. Unlike
+// EliminateDeadCode, it can be used for the block before closure transform.
+func removeUnusedInsns(block *mir.Block, overflowCheck bool) {
+	for {
+		uses := map[string]int{}
+		countUsesInBlock(block, uses, 1)
+		if !removeUnusedInBlock(block, uses, overflowCheck) {
+			return
+		}
+	}
+}
+
+// pruneFunctions removes toplevel functions which are not reachable from entry block nor roots.
+// It returns true when some function was removed.
+func pruneFunctions(prog *mir.Program, roots []string) bool {
+	reachable := make(map[string]struct{}, len(prog.Toplevel))
+	worklist := []*mir.Block{prog.Entry}
+	visit := func(name string) {
+		f, ok := prog.Toplevel[name]
+		if !ok {
+			return
+		}
+		if _, ok := reachable[name]; ok {
+			return
+		}
+		reachable[name] = struct{}{}
+		worklist = append(worklist, f.Val.Body)
+	}
+
+	for _, r := range roots {
+		visit(r)
+	}
+	for len(worklist) > 0 {
+		b := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+		eachUseInBlock(b, visit)
+	}
+
+	if len(reachable) == len(prog.Toplevel) {
+		return false
+	}
+	for name := range prog.Toplevel {
+		if _, ok := reachable[name]; !ok {
+			delete(prog.Toplevel, name)
+			delete(prog.Closures, name)
+		}
+	}
+	return true
+}
+
+// removeUnusedInBlock removes unused instructions in the block. Instructions are visited in
+// reverse order so that operands of removed instruction can be removed in the same visit.
+func removeUnusedInBlock(block *mir.Block, uses map[string]int, overflowCheck bool) bool {
+	removed := false
+	last := block.Bottom.Prev
+	for i := last; i != block.Top; {
+		prev := i.Prev
+		// Note:
+		// Function definitions before closure transform are not removed here. Unused functions
+		// are removed by pruneFunctions considering roots.
+		_, isFun := i.Val.(*mir.Fun)
+		if i != last && !isFun && uses[i.Ident] == 0 && !hasSideEffect(i.Val, overflowCheck) {
+			countUses(i.Val, uses, -1)
+			i.RemoveFromList()
+			removed = true
+		} else {
+			switch v := i.Val.(type) {
+			case *mir.If:
+				if removeUnusedInBlock(v.Then, uses, overflowCheck) {
+					removed = true
+				}
+				if removeUnusedInBlock(v.Else, uses, overflowCheck) {
+					removed = true
+				}
+			case *mir.Fun:
+				if removeUnusedInBlock(v.Body, uses, overflowCheck) {
+					removed = true
+				}
+			}
+		}
+		i = prev
+	}
+	return removed
+}
+
+func hasSideEffect(val mir.Val, overflowCheck bool) bool {
+	switch val := val.(type) {
+	case *mir.App, *mir.ArrStore:
+		return true
+	case *mir.Binary:
+		switch val.Op {
+		case mir.DIV, mir.MOD:
+			return true
+		case mir.ADD, mir.SUB, mir.MUL:
+			return overflowCheck
+		}
+	case *mir.Unary:
+		return val.Op == mir.NEG && overflowCheck
+	case *mir.If:
+		return blockHasSideEffect(val.Then, overflowCheck) || blockHasSideEffect(val.Else, overflowCheck)
+	}
+	return false
+}
+
+func blockHasSideEffect(block *mir.Block, overflowCheck bool) bool {
+	for i := block.Top.Next; i.Next != nil; i = i.Next {
+		if hasSideEffect(i.Val, overflowCheck) {
+			return true
+		}
+	}
+	return false
+}
+
+// countUses adds delta to use count of each identifier used in the value.
+func countUses(val mir.Val, uses map[string]int, delta int) {
+	switch val := val.(type) {
+	case *mir.If:
+		uses[val.Cond] += delta
+		countUsesInBlock(val.Then, uses, delta)
+		countUsesInBlock(val.Else, uses, delta)
+	case *mir.MakeCls:
+		// Note:
+		// Function of closure is a label, not a use of the closure value. Closure value is
+		// bound to the same name as the function, so counting it would prevent the unused
+		// closure from being removed.
+		for _, v := range val.Vars {
+			uses[v] += delta
+		}
+	default:
+		eachUse(val, func(ident string) {
+			uses[ident] += delta
+		})
+	}
+}
+
+func countUsesInBlock(block *mir.Block, uses map[string]int, delta int) {
+	for i := block.Top.Next; i.Next != nil; i = i.Next {
+		countUses(i.Val, uses, delta)
+	}
+}
+
+func eachUseInBlock(block *mir.Block, f func(string)) {
+	for i := block.Top.Next; i.Next != nil; i = i.Next {
+		eachUse(i.Val, f)
+	}
+}
+
+// eachUse calls f with each identifier used in the value. Identifiers in nested blocks of 'if'
+// and 'fun' are also visited.
+func eachUse(val mir.Val, f func(string)) {
+	for _, o := range mir.Operands(val) {
+		f(o)
+	}
+	switch val := val.(type) {
+	case *mir.If:
+		eachUseInBlock(val.Then, f)
+		eachUseInBlock(val.Else, f)
+	case *mir.Fun:
+		eachUseInBlock(val.Body, f)
+	case *mir.App:
+		if val.Kind != mir.EXTERNAL_CALL {
+			f(val.Callee)
+		}
+	case *mir.MakeCls:
+		f(val.Fun)
+	}
+}
@@ -0,0 +1,167 @@
+package opt
+
+import (
+	"github.com/rhysd/gocaml/mir"
+	"github.com/rhysd/locerr"
+	"reflect"
+	"testing"
+)
+
+func program(entry *mir.Block, funs ...*mir.Insn) *mir.Program {
+	top := mir.NewToplevel()
+	for _, f := range funs {
+		top.Add(f.Ident, f.Val.(*mir.Fun), locerr.Pos{})
+	}
+	return &mir.Program{top, mir.Closures{}, entry}
+}
+
+func TestRemoveUnusedInsns(t *testing.T) {
+	b := mir.NewBlockFromArray("program", []*mir.Insn{
+		insn("a", &mir.Int{1}),
+		insn("b", &mir.Int{2}),
+		insn("c", &mir.Binary{mir.ADD, "a", "b"}),
+		insn("d", &mir.Tuple{[]string{"c", "a"}}),
+		insn("e", &mir.Int{0}),
+		insn("f", &mir.Binary{mir.DIV, "a", "e"}),
+		insn("g", &mir.XRef{"print_int"}),
+		insn("h", &mir.App{"print_int", []string{"b"}, mir.EXTERNAL_CALL, false}),
+		insn("i", &mir.ArrLit{[]string{"a"}}),
+		insn("j", &mir.ArrStore{"i", "e", "b"}),
+		insn("k", &mir.Unit{}),
+	})
+	EliminateDeadCode(program(b), nil, false)
+
+	expected := []string{"a", "b", "e", "f", "h", "i", "j", "k"}
+	if actual := identsOf(b); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected instructions %v but actually %v", expected, actual)
+	}
+}
+
+func TestKeepArithmeticWithOverflowCheck(t *testing.T) {
+	newBlock := func() *mir.Block {
+		return mir.NewBlockFromArray("program", []*mir.Insn{
+			insn("a", &mir.Int{1}),
+			insn("b", &mir.Binary{mir.ADD, "a", "a"}),
+			insn("c", &mir.Binary{mir.SUB, "a", "a"}),
+			insn("d", &mir.Binary{mir.MUL, "a", "a"}),
+			insn("e", &mir.Unary{mir.NEG, "a"}),
+			insn("f", &mir.Binary{mir.LT, "a", "a"}),
+			insn("g", &mir.Float{1.0}),
+			insn("h", &mir.Binary{mir.FADD, "g", "g"}),
+			insn("i", &mir.Unary{mir.FNEG, "g"}),
+			insn("j", &mir.Unit{}),
+		})
+	}
+	expected := []string{"a", "b", "c", "d", "e", "j"}
+
+	b := newBlock()
+	EliminateDeadCode(program(b), nil, true)
+	if actual := identsOf(b); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected instructions %v but actually %v", expected, actual)
+	}
+
+	b = newBlock()
+	removeUnusedInsns(b, true)
+	if actual := identsOf(b); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected instructions %v but actually %v with removeUnusedInsns", expected, actual)
+	}
+
+	b = newBlock()
+	EliminateDeadCode(program(b), nil, false)
+	if actual := identsOf(b); !reflect.DeepEqual(actual, []string{"j"}) {
+		t.Errorf("Unused arithmetic should be removed without overflow check: %v", actual)
+	}
+}
+
+func TestKeepLastInsnOfBlock(t *testing.T) {
+	then := mir.NewBlockFromArray("then", []*mir.Insn{
+		insn("t1", &mir.Int{1}),
+		insn("t2", &mir.Int{2}),
+	})
+	els := mir.NewBlockFromArray("else", []*mir.Insn{
+		insn("e1", &mir.Int{3}),
+	})
+	b := mir.NewBlockFromArray("program", []*mir.Insn{
+		insn("a", &mir.Bool{true}),
+		insn("b", &mir.If{"a", then, els}),
+		insn("c", &mir.Ref{"b"}),
+	})
+	EliminateDeadCode(program(b), nil, false)
+
+	if actual := identsOf(then); !reflect.DeepEqual(actual, []string{"t2"}) {
+		t.Errorf("Unused instruction in then branch should be removed: %v", actual)
+	}
+	if actual := identsOf(els); !reflect.DeepEqual(actual, []string{"e1"}) {
+		t.Errorf("Last instruction in else branch should be kept: %v", actual)
+	}
+	if actual := identsOf(b); !reflect.DeepEqual(actual, []string{"a", "b", "c"}) {
+		t.Errorf("Used instructions should be kept: %v", actual)
+	}
+}
+
+func TestRemoveUnusedIf(t *testing.T) {
+	pure := mir.NewBlockFromArray("then", []*mir.Insn{
+		insn("t1", &mir.Int{1}),
+	})
+	impure := mir.NewBlockFromArray("else", []*mir.Insn{
+		insn("e1", &mir.App{"print_int", []string{"t0"}, mir.EXTERNAL_CALL, false}),
+	})
+	b := mir.NewBlockFromArray("program", []*mir.Insn{
+		insn("t0", &mir.Int{0}),
+		insn("a", &mir.Bool{true}),
+		insn("b", &mir.If{"a", pure, mir.NewBlockFromArray("else", []*mir.Insn{insn("e0", &mir.Int{2})})}),
+		insn("c", &mir.If{"a", pure, impure}),
+		insn("d", &mir.Unit{}),
+	})
+	EliminateDeadCode(program(b), nil, false)
+
+	expected := []string{"t0", "a", "c", "d"}
+	if actual := identsOf(b); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected instructions %v but actually %v", expected, actual)
+	}
+}
+
+func TestPruneUnreachableFunctions(t *testing.T) {
+	// f is called from entry, g is called from f, h is unused and k is only referred by unused closure
+	f := insn("f", &mir.Fun{[]string{"x"}, mir.NewBlockFromArray("body (f)", []*mir.Insn{
+		insn("f1", &mir.App{"g", []string{"x"}, mir.DIRECT_CALL, false}),
+	}), false})
+	g := insn("g", &mir.Fun{[]string{"y"}, mir.NewBlockFromArray("body (g)", []*mir.Insn{
+		insn("g1", &mir.Ref{"y"}),
+	}), false})
+	h := insn("h", &mir.Fun{[]string{"z"}, mir.NewBlockFromArray("body (h)", []*mir.Insn{
+		insn("h1", &mir.App{"h", []string{"z"}, mir.DIRECT_CALL, false}),
+	}), true})
+	k := insn("k", &mir.Fun{[]string{"w"}, mir.NewBlockFromArray("body (k)", []*mir.Insn{
+		insn("k1", &mir.Binary{mir.ADD, "w", "a"}),
+	}), false})
+	e := insn("e", &mir.Fun{[]string{"v"}, mir.NewBlockFromArray("body (e)", []*mir.Insn{
+		insn("e1", &mir.Ref{"v"}),
+	}), false})
+	b := mir.NewBlockFromArray("program", []*mir.Insn{
+		insn("a", &mir.Int{1}),
+		insn("k", &mir.MakeCls{[]string{"a"}, "k"}),
+		insn("b", &mir.App{"f", []string{"a"}, mir.DIRECT_CALL, false}),
+	})
+	prog := program(b, f, g, h, k, e)
+	prog.Closures["k"] = []string{"a"}
+
+	EliminateDeadCode(prog, []string{"e"}, false)
+
+	for _, n := range []string{"f", "g", "e"} {
+		if _, ok := prog.Toplevel[n]; !ok {
+			t.Errorf("Function '%s' should not be removed", n)
+		}
+	}
+	for _, n := range []string{"h", "k"} {
+		if _, ok := prog.Toplevel[n]; ok {
+			t.Errorf("Function '%s' should be removed", n)
+		}
+	}
+	if len(prog.Closures) != 0 {
+		t.Errorf("Closure of removed function should be removed: %v", prog.Closures)
+	}
+	if actual := identsOf(b); !reflect.DeepEqual(actual, []string{"a", "b"}) {
+		t.Errorf("Unused closure should be removed: %v", actual)
+	}
+}
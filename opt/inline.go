@@ -0,0 +1,510 @@
+package opt
+
+import (
+	"fmt"
+	"github.com/rhysd/gocaml/mangle"
+	"github.com/rhysd/gocaml/mir"
+	"github.com/rhysd/gocaml/types"
+)
+
+// Inlining can reveal new applications of known functions (e.g. a lambda passed to an inlined
+// higher-order function). They are also inlined up to this depth in order to keep code size.
+const maxInlineDepth = 8
+
+// Inline inlines applications of small known functions in the block. And it specializes known
+// higher-order functions for applications which pass known functions as arguments. In the
+// specialized function, the function parameter is replaced with the known function, so the
+// calls of the parameter are no longer closure calls and can be inlined.
+//
+// threshold is the maximum number of instructions in a function body to be inlined. Functions
+// whose size is up to twice of threshold are specialized. When threshold is 0, this pass does
+// nothing. overflowCheck must be true when integer overflow is checked at runtime so that unused
+// arithmetic which may raise an error is not removed.
+//
+// This pass must be run before closure transform because it relies on nested function
+// definitions. Polymorphic functions are inlined with types instantiated at the application.
+// But functions referring other polymorphic values in their bodies are not inlined because
+// instantiations of the values are resolved at monomorphization.
+func Inline(b *mir.Block, env *types.Env, threshold int, overflowCheck bool) {
+	if threshold <= 0 {
+		return
+	}
+	inl := &inliner{
+		env,
+		threshold,
+		map[string]*mir.Insn{},
+		map[string]mir.Val{},
+		0,
+		0,
+		map[string]string{},
+		map[string]int{},
+	}
+	inl.block(b)
+
+	// Remove references to known functions which are no longer used after specialization.
+	// Otherwise closure transform considers them as closures.
+	removeUnusedInsns(b, overflowCheck)
+}
+
+type inliner struct {
+	env       *types.Env
+	threshold int
+	funs      map[string]*mir.Insn
+	defs      map[string]mir.Val
+	id        uint
+	depth     int
+	// Mapping from identifier of copied function to symbol name of the original function
+	origSyms map[string]string
+	// Number of copies of each original function's symbol name
+	numCopies map[string]int
+}
+
+func (inl *inliner) block(b *mir.Block) {
+	for i := b.Top.Next; i.Next != nil; i = i.Next {
+		inl.insn(i)
+	}
+}
+
+func (inl *inliner) insn(i *mir.Insn) {
+	switch val := i.Val.(type) {
+	case *mir.Fun:
+		// Inline applications in the body before the function itself is inlined
+		inl.block(val.Body)
+		inl.funs[i.Ident] = i
+	case *mir.If:
+		inl.block(val.Then)
+		inl.block(val.Else)
+	case *mir.App:
+		if val.Kind == mir.DIRECT_CALL && inl.depth < maxInlineDepth {
+			inl.app(i, val)
+		}
+	}
+	inl.defs[i.Ident] = i.Val
+}
+
+// resolveFun returns the definition of known function which the identifier refers. ref is an
+// identifier of the instruction which refers the function directly. It is used for looking up
+// instantiation of the function.
+func (inl *inliner) resolveFun(ident, ref string) (*mir.Insn, string) {
+	for {
+		if f, ok := inl.funs[ident]; ok {
+			return f, ref
+		}
+		r, ok := inl.defs[ident].(*mir.Ref)
+		if !ok {
+			return nil, ""
+		}
+		ident, ref = r.Ident, ident
+	}
+}
+
+// assignment returns assignments to generic type variables instantiated at the reference.
+func (inl *inliner) assignment(ref string) typeVarAssign {
+	inst, ok := inl.env.RefInsts[ref]
+	if !ok {
+		return nil
+	}
+	assign := make(typeVarAssign, len(inst.Mapping))
+	for _, m := range inst.Mapping {
+		assign[m.ID] = m.Type
+	}
+	return assign
+}
+
+func (inl *inliner) app(i *mir.Insn, app *mir.App) {
+	callee, ref := inl.resolveFun(app.Callee, i.Ident)
+	if callee == nil {
+		return
+	}
+	fun := callee.Val.(*mir.Fun)
+	assign := inl.assignment(ref)
+	if len(fun.Params) != len(app.Args) || !inl.canCopy(callee, assign) {
+		return
+	}
+
+	size := sizeOfBlock(fun.Body)
+	if !isRecursive(callee) && size <= inl.threshold {
+		inl.inlineApp(i, fun, app.Args, assign)
+		return
+	}
+	if size <= inl.threshold*2 {
+		inl.specialize(i, app, callee, assign)
+	}
+}
+
+// inlineApp replaces 'r = app f args' with instructions in body of f. The instruction itself
+// remains as 'r = ref x' where x is the result of the inlined body.
+func (inl *inliner) inlineApp(i *mir.Insn, fun *mir.Fun, args []string, assign typeVarAssign) {
+	c := inl.newCopier(assign)
+	for idx, p := range fun.Params {
+		c.renamed[p] = args[idx]
+	}
+	body := c.block(fun.Body)
+
+	inl.depth++
+	inl.block(body)
+	inl.depth--
+
+	begin, end := body.WholeRange()
+	last := end.Prev
+	begin.Prev = i.Prev
+	i.Prev.Next = begin
+	last.Next = i
+	i.Prev = last
+	i.Val = &mir.Ref{last.Ident}
+	delete(inl.env.RefInsts, i.Ident)
+}
+
+// specialize creates a copy of the callee function where parameters receiving known functions
+// are replaced with the functions. The application is replaced with an application of the copy.
+// Recursive applications passing the same parameters are also replaced in the copy.
+func (inl *inliner) specialize(i *mir.Insn, app *mir.App, callee *mir.Insn, assign typeVarAssign) {
+	fun := callee.Val.(*mir.Fun)
+
+	c := inl.newCopier(assign)
+	params := []string{}
+	args := []string{}
+	known := map[int]struct{}{}
+	for idx, a := range app.Args {
+		f, ref := inl.resolveFun(a, a)
+		if f == nil || f == callee || !inl.isMonomorphicRef(f.Ident, ref) {
+			params = append(params, fun.Params[idx])
+			args = append(args, a)
+			continue
+		}
+		known[idx] = struct{}{}
+		c.renamed[fun.Params[idx]] = f.Ident
+	}
+	if len(known) == 0 {
+		return
+	}
+
+	name := c.newIdent(callee.Ident)
+	delete(c.renamed, callee.Ident)
+	c.specialized = &specialization{callee.Ident, name, fun.Params, known}
+	for idx, p := range params {
+		params[idx] = c.newIdent(p)
+	}
+	body := c.block(fun.Body)
+	body.Name = fmt.Sprintf("body (%s)", name)
+
+	if len(assign) > 0 {
+		// Remaining references to the polymorphic function in the copy would need instantiation
+		refers := false
+		eachUseInBlock(body, func(ident string) {
+			refers = refers || ident == callee.Ident
+		})
+		if refers {
+			return
+		}
+	}
+
+	ty := assign.apply(inl.env.DeclTable[callee.Ident]).(*types.Fun)
+	paramTys := make([]types.Type, 0, len(params))
+	for idx, t := range ty.Params {
+		if _, ok := known[idx]; !ok {
+			paramTys = append(paramTys, t)
+		}
+	}
+	inl.env.DeclTable[name] = &types.Fun{ty.Ret, paramTys}
+
+	spec := &mir.Fun{params, body, false}
+	insn := mir.NewInsn(name, spec, callee.Pos)
+	insn.Prev = i.Prev
+	insn.Next = i
+	i.Prev.Next = insn
+	i.Prev = insn
+
+	inl.depth++
+	inl.insn(insn)
+	i.Val = &mir.App{name, args, mir.DIRECT_CALL, app.IsTail}
+	delete(inl.env.RefInsts, i.Ident)
+	inl.insn(i)
+	inl.depth--
+}
+
+// isMonomorphicRef returns whether the reference to the symbol is monomorphic.
+func (inl *inliner) isMonomorphicRef(ident, ref string) bool {
+	if _, ok := inl.env.RefInsts[ref]; ok {
+		return false
+	}
+	return !types.HasTypeVar(inl.env.DeclTable[ident])
+}
+
+// canCopy returns whether the function can be copied with the type variable assignment. All
+// symbols in the copied function must have monomorphic types.
+func (inl *inliner) canCopy(f *mir.Insn, assign typeVarAssign) bool {
+	if !inl.isMonomorphicDecl(f.Ident, assign) {
+		return false
+	}
+	fun := f.Val.(*mir.Fun)
+	for _, p := range fun.Params {
+		if !inl.isMonomorphicDecl(p, assign) {
+			return false
+		}
+	}
+	return inl.canCopyBlock(fun.Body, assign)
+}
+
+func (inl *inliner) canCopyBlock(b *mir.Block, assign typeVarAssign) bool {
+	for i := b.Top.Next; i.Next != nil; i = i.Next {
+		if _, ok := inl.env.RefInsts[i.Ident]; ok || !inl.isMonomorphicDecl(i.Ident, assign) {
+			return false
+		}
+		switch val := i.Val.(type) {
+		case *mir.If:
+			if !inl.canCopyBlock(val.Then, assign) || !inl.canCopyBlock(val.Else, assign) {
+				return false
+			}
+		case *mir.Fun:
+			if !inl.canCopy(i, assign) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (inl *inliner) isMonomorphicDecl(ident string, assign typeVarAssign) bool {
+	t, ok := inl.env.DeclTable[ident]
+	return ok && !types.HasTypeVar(assign.apply(t))
+}
+
+// newIdent makes a new unique identifier from the existing one. Information of the identifier
+// in type environment is inherited. Note that mangled name is not inherited as-is because symbol
+// name of copied function must be unique. A specialization of the original function's symbol is
+// given instead.
+func (inl *inliner) newIdent(from string, assign typeVarAssign) string {
+	inl.id++
+	ident := fmt.Sprintf("%s$i%d", from, inl.id)
+	if t, ok := inl.env.DeclTable[from]; ok {
+		inl.env.DeclTable[ident] = assign.apply(t)
+	}
+	if n, ok := inl.env.DisplayNames[from]; ok {
+		inl.env.DisplayNames[ident] = n
+	}
+	if sym, ok := inl.env.MangledNames[from]; ok {
+		if orig, ok := inl.origSyms[from]; ok {
+			// Copy of copied function is also numbered as a copy of the original function
+			sym = orig
+		}
+		inl.numCopies[sym]++
+		inl.origSyms[ident] = sym
+		inl.env.MangledNames[ident] = mangle.Specialize(sym, inl.numCopies[sym])
+	}
+	return ident
+}
+
+func (inl *inliner) newCopier(assign typeVarAssign) *copier {
+	return &copier{inl, assign, map[string]string{}, map[string]mir.Val{}, nil}
+}
+
+type specialization struct {
+	from, to string
+	params   []string
+	known    map[int]struct{}
+}
+
+// copier copies instructions with renaming all symbols defined in them.
+type copier struct {
+	*inliner
+	assign      typeVarAssign
+	renamed     map[string]string
+	defs        map[string]mir.Val
+	specialized *specialization
+}
+
+func (c *copier) newIdent(from string) string {
+	to := c.inliner.newIdent(from, c.assign)
+	c.renamed[from] = to
+	return to
+}
+
+func (c *copier) ident(from string) string {
+	if to, ok := c.renamed[from]; ok {
+		return to
+	}
+	return from
+}
+
+func (c *copier) idents(from []string) []string {
+	to := make([]string, 0, len(from))
+	for _, i := range from {
+		to = append(to, c.ident(i))
+	}
+	return to
+}
+
+// resolve returns an identifier which the identifier in the original code refers.
+func (c *copier) resolve(ident string) string {
+	for {
+		r, ok := c.defs[ident].(*mir.Ref)
+		if !ok {
+			return ident
+		}
+		ident = r.Ident
+	}
+}
+
+func (c *copier) block(from *mir.Block) *mir.Block {
+	insns := []*mir.Insn{}
+	for i := from.Top.Next; i.Next != nil; i = i.Next {
+		ident := c.newIdent(i.Ident)
+		insns = append(insns, mir.NewInsn(ident, c.val(i.Val), i.Pos))
+		c.defs[i.Ident] = i.Val
+	}
+	return mir.NewBlockFromArray(from.Name, insns)
+}
+
+func (c *copier) val(from mir.Val) mir.Val {
+	switch val := from.(type) {
+	case *mir.Unary:
+		return &mir.Unary{val.Op, c.ident(val.Child)}
+	case *mir.Binary:
+		return &mir.Binary{val.Op, c.ident(val.LHS), c.ident(val.RHS)}
+	case *mir.Ref:
+		return &mir.Ref{c.ident(val.Ident)}
+	case *mir.If:
+		return &mir.If{c.ident(val.Cond), c.block(val.Then), c.block(val.Else)}
+	case *mir.Fun:
+		params := make([]string, 0, len(val.Params))
+		for _, p := range val.Params {
+			params = append(params, c.newIdent(p))
+		}
+		return &mir.Fun{params, c.block(val.Body), val.IsRecursive}
+	case *mir.App:
+		if app := c.specializedApp(val); app != nil {
+			return app
+		}
+		callee := val.Callee
+		if val.Kind != mir.EXTERNAL_CALL {
+			callee = c.ident(callee)
+		}
+		return &mir.App{callee, c.idents(val.Args), val.Kind, false}
+	case *mir.Tuple:
+		return &mir.Tuple{c.idents(val.Elems)}
+	case *mir.TplLoad:
+		return &mir.TplLoad{c.ident(val.From), val.Index}
+	case *mir.Array:
+		return &mir.Array{c.ident(val.Size), c.ident(val.Elem)}
+	case *mir.ArrLit:
+		return &mir.ArrLit{c.idents(val.Elems)}
+	case *mir.ArrLoad:
+		return &mir.ArrLoad{c.ident(val.From), c.ident(val.Index)}
+	case *mir.ArrStore:
+		return &mir.ArrStore{c.ident(val.To), c.ident(val.Index), c.ident(val.RHS)}
+	case *mir.ArrLen:
+		return &mir.ArrLen{c.ident(val.Array)}
+	case *mir.StrLoad:
+		return &mir.StrLoad{c.ident(val.From), c.ident(val.Index)}
+	case *mir.Some:
+		return &mir.Some{c.ident(val.Elem)}
+	case *mir.IsSome:
+		return &mir.IsSome{c.ident(val.OptVal)}
+	case *mir.DerefSome:
+		return &mir.DerefSome{c.ident(val.SomeVal)}
+	case *mir.Show:
+		return &mir.Show{c.ident(val.Child)}
+	case *mir.Unit:
+		return mir.UnitVal
+	case *mir.Bool:
+		return &mir.Bool{val.Const}
+	case *mir.Int:
+		return &mir.Int{val.Const}
+	case *mir.Float:
+		return &mir.Float{val.Const}
+	case *mir.Char:
+		return &mir.Char{val.Const}
+	case *mir.String:
+		return &mir.String{val.Const}
+	case *mir.None:
+		return mir.NoneVal
+	case *mir.XRef:
+		return &mir.XRef{val.Ident}
+	case *mir.MakeCls:
+		panic("FATAL: Closure must not appear before closure transform")
+	default:
+		panic(fmt.Sprintf("FATAL: Unknown value to copy: %v", val))
+	}
+}
+
+// specializedApp returns recursive application of the specialized function when the
+// application passes the same parameters as known functions.
+func (c *copier) specializedApp(app *mir.App) *mir.App {
+	s := c.specialized
+	if s == nil || app.Kind != mir.DIRECT_CALL || c.resolve(app.Callee) != s.from {
+		return nil
+	}
+	args := make([]string, 0, len(app.Args))
+	for idx, a := range app.Args {
+		if _, ok := s.known[idx]; !ok {
+			args = append(args, c.ident(a))
+			continue
+		}
+		if c.resolve(a) != s.params[idx] {
+			return nil
+		}
+	}
+	return &mir.App{s.to, args, mir.DIRECT_CALL, false}
+}
+
+// isRecursive returns whether the function refers itself in its body. Note that IsRecursive flag
+// of mir.Fun is not available until closure transform.
+func isRecursive(f *mir.Insn) bool {
+	found := false
+	eachUseInBlock(f.Val.(*mir.Fun).Body, func(ident string) {
+		found = found || ident == f.Ident
+	})
+	return found
+}
+
+func sizeOfBlock(b *mir.Block) int {
+	size := 0
+	for i := b.Top.Next; i.Next != nil; i = i.Next {
+		size++
+		switch val := i.Val.(type) {
+		case *mir.If:
+			size += sizeOfBlock(val.Then) + sizeOfBlock(val.Else)
+		case *mir.Fun:
+			size += sizeOfBlock(val.Body)
+		}
+	}
+	return size
+}
+
+// typeVarAssign is a mapping from generic type variables to types instantiated at a reference.
+type typeVarAssign map[types.VarID]types.Type
+
+// apply returns the type where type variables are replaced with assigned types. The type is not
+// modified.
+func (assign typeVarAssign) apply(t types.Type) types.Type {
+	if len(assign) == 0 {
+		return t
+	}
+	switch t := t.(type) {
+	case *types.Fun:
+		params := make([]types.Type, 0, len(t.Params))
+		for _, p := range t.Params {
+			params = append(params, assign.apply(p))
+		}
+		return &types.Fun{assign.apply(t.Ret), params}
+	case *types.Tuple:
+		elems := make([]types.Type, 0, len(t.Elems))
+		for _, e := range t.Elems {
+			elems = append(elems, assign.apply(e))
+		}
+		return &types.Tuple{elems}
+	case *types.Array:
+		return &types.Array{assign.apply(t.Elem)}
+	case *types.Option:
+		return &types.Option{assign.apply(t.Elem)}
+	case *types.Var:
+		if t.Ref != nil {
+			return assign.apply(t.Ref)
+		}
+		if a, ok := assign[t.ID]; ok {
+			return assign.apply(a)
+		}
+	}
+	return t
+}
@@ -0,0 +1,175 @@
+package opt
+
+import (
+	"bytes"
+	"github.com/rhysd/gocaml/closure"
+	"github.com/rhysd/gocaml/sema"
+	"github.com/rhysd/gocaml/syntax"
+	"github.com/rhysd/locerr"
+	"strings"
+	"testing"
+)
+
+func TestInline(t *testing.T) {
+	cases := []struct {
+		what      string
+		code      string
+		threshold int
+		contains  []string
+		excludes  []string
+	}{
+		{
+			what:      "small function",
+			code:      "let rec add x y = x + y in print_int (add 1 2)",
+			threshold: 10,
+			contains:  []string{"$k3$i3 = binary + $k1$i1 $k2$i2 ; type=int"},
+			excludes:  []string{"app add$t1"},
+		},
+		{
+			what:      "polymorphic function",
+			code:      "let rec id x = x in print_int (id 42)",
+			threshold: 10,
+			contains:  []string{"$k1$i1 = ref $k3 ; type=int"},
+			excludes:  []string{"app id$t1"},
+		},
+		{
+			what:      "recursive function",
+			code:      "let rec f n = if n = 0 then 0 else f (n - 1) in print_int (f 3)",
+			threshold: 100,
+			contains:  []string{"app f$t1 $k"},
+		},
+		{
+			what:      "large function",
+			code:      "let rec f x = x + x + x + x + x in print_int (f 1)",
+			threshold: 3,
+			contains:  []string{"app f$t1 $k"},
+		},
+		{
+			what:      "threshold zero",
+			code:      "let rec add x y = x + y in print_int (add 1 2)",
+			threshold: 0,
+			contains:  []string{"app add$t1 $k"},
+		},
+		{
+			what:      "lambda passed to inlined function",
+			code:      "let rec apply f x = f x in print_int (apply (fun y -> y * 2) 3)",
+			threshold: 10,
+			contains:  []string{"binary * "},
+			excludes:  []string{"app apply$t1", "app lambda"},
+		},
+		{
+			what:      "known function passed to recursive function",
+			code:      "let rec iter f n = if n = 0 then () else (f n; iter f (n - 1)) in iter (fun i -> print_int i) 10",
+			threshold: 25,
+			contains: []string{
+				"iter$t1$i1 = fun n$t3$i2 ; type=int -> unit",
+				"app iter$t1$i1 $k10$i13 ; type=unit",
+				"app iter$t1$i1 $k17 ; type=unit",
+			},
+			excludes: []string{"app iter$t1 $k17", "ref lambda"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.what, func(t *testing.T) {
+			s := locerr.NewDummySource(tc.code)
+			ast, err := syntax.Parse(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			env, ir, err := sema.SemanticsCheck(ast)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			Inline(ir, env, tc.threshold, false)
+
+			var buf bytes.Buffer
+			ir.Println(&buf, env)
+			out := buf.String()
+			for _, expected := range tc.contains {
+				if !strings.Contains(out, expected) {
+					t.Errorf("Expected '%s' to be contained in '%s'", expected, out)
+				}
+			}
+			for _, unexpected := range tc.excludes {
+				if strings.Contains(out, unexpected) {
+					t.Errorf("Expected '%s' not to be contained in '%s'", unexpected, out)
+				}
+			}
+		})
+	}
+}
+
+func TestSpecializedFunctionIsNotClosure(t *testing.T) {
+	code := `
+	let rec twice x = x *. 2.0 in
+	let rec map f a i = if i < Array.length a then (a.(i) <- f a.(i); map f a (i + 1)) else () in
+	let a = Array.make 3 1.0 in
+	map twice a 0
+	`
+	s := locerr.NewDummySource(code)
+	ast, err := syntax.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, ir, err := sema.SemanticsCheck(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Inline(ir, env, 25, false)
+	prog := closure.Transform(ir)
+
+	if len(prog.Closures) != 0 {
+		t.Fatalf("Specialized function should not capture known function: %v", prog.Closures)
+	}
+
+	var buf bytes.Buffer
+	prog.Entry.Println(&buf, env)
+	out := buf.String()
+	if strings.Contains(out, "appcls") {
+		t.Fatalf("Specialized function should be called directly: %s", out)
+	}
+}
+
+func TestMangledNamesOfCopiedFunctions(t *testing.T) {
+	code := `
+	let rec twice x = x *. 2.0 in
+	let rec half x = x /. 2.0 in
+	let rec map f a i = if i < Array.length a then (a.(i) <- f a.(i); map f a (i + 1)) else () in
+	let a = Array.make 3 1.0 in
+	map twice a 0;
+	map half a 0
+	`
+	s := locerr.NewDummySource(code)
+	ast, err := syntax.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, ir, err := sema.SemanticsCheck(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Inline(ir, env, 25, false)
+	prog := closure.Transform(ir)
+
+	syms := map[string]string{}
+	for name := range prog.Toplevel {
+		sym, ok := env.MangledNames[name]
+		if !ok {
+			t.Errorf("Function '%s' has no mangled symbol name", name)
+			continue
+		}
+		if other, ok := syms[sym]; ok {
+			t.Errorf("Functions '%s' and '%s' have the same symbol name '%s'", name, other, sym)
+		}
+		syms[sym] = name
+	}
+	for _, want := range []string{"_GC3mapS1_", "_GC3mapS2_"} {
+		if _, ok := syms[want]; !ok {
+			t.Errorf("Specialized function '%s' was not found in %v", want, syms)
+		}
+	}
+}
@@ -6,6 +6,7 @@
 typedef int64_t gocaml_int;
 typedef int gocaml_bool;
 typedef double gocaml_float;
+typedef uint8_t gocaml_char;
 
 typedef struct {
     void *buf;
@@ -17,6 +18,54 @@ typedef struct {
     gocaml_int size;
 } gocaml_string;
 
+// Mutable byte sequence. It has the same layout as gocaml_string.
+typedef struct {
+    uint8_t *buf; // Null-terminated for convenience. But it may contain '\0' in its content
+    gocaml_int size;
+} gocaml_bytes;
+
+// Opaque pointer to string buffer
+typedef struct gocaml_buffer_impl *gocaml_buffer;
+
 typedef struct {} gocaml_unit;
 
+// Closure ABI
+//
+// A GoCaml function passed to an external function is converted to a callback. It is a struct of
+// a function pointer and a pointer to its environment. The function must be called with the
+// environment as the first argument followed by the arguments. Arguments and return value are
+// passed in the same way as external functions (e.g. unit return value is void, tuples are passed
+// as pointers). So a callback can be passed to C libraries which take 'void (*cb)(void *env, ...)'
+// and 'void *env' as cb.fun and cb.env.
+//
+// gocaml_closure is a type-erased callback. Typed callback structs for each function type (e.g.
+// 'struct { gocaml_int (*fun)(void *, gocaml_int); void *env; }' for 'int -> int') can be generated
+// by 'gocaml -emit-header'. They have the same layout as gocaml_closure.
+//
+// The environment is allocated by GC. When C code keeps a callback after the external function
+// returns, it must be stored in memory scanned by GC (e.g. global variables or memory allocated by
+// GC_malloc()). Otherwise, it may be collected.
+typedef struct {
+    void (*fun)(void);
+    void *env;
+} gocaml_closure;
+
+// Calls a typed callback with arguments. e.g. gocaml_closure_call(cb, 42)
+#define gocaml_closure_call(closure, ...) ((closure).fun((closure).env, __VA_ARGS__))
+
+// Calls a type-erased callback (gocaml_closure) as a function pointer type.
+// e.g. gocaml_closure_call_as(gocaml_int (*)(void *, gocaml_int), cb, 42)
+#define gocaml_closure_call_as(fun_type, closure, ...) (((fun_type) (closure).fun)((closure).env, __VA_ARGS__))
+
+// Initializes runtime and evaluates toplevel expression of GoCaml program compiled as a library
+// (with -lib or -shared). It must be called once before calling any exported GoCaml function.
+// Unlike an executable, it does not install any signal handler so that handlers of the host program
+// (e.g. crash reporters or language runtimes) are kept as-is.
+void gocaml_init(void);
+
+// Installs a handler which outputs backtrace on crash (SIGSEGV, SIGBUS, SIGFPE and SIGABRT) and
+// sets up an alternative signal stack for the calling thread. Executables compiled by gocaml call it
+// at startup. A library may call it after gocaml_init() to opt in.
+void gocaml_install_crash_handler(void);
+
 #endif    // GOCAML_H_INCLUDED
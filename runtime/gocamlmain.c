@@ -0,0 +1,15 @@
+// Note:
+// main() is separated from gocamlrt.c so that it is linked only when the program is an executable.
+// When GoCaml code is compiled to a library, the C program linking it has its own main().
+
+extern int __gocaml_main();
+extern void __gocaml_init_runtime(int const argc, char const* const argv_[]);
+extern void gocaml_install_crash_handler(void);
+
+int main(int const argc, char const* const argv_[]) {
+    __gocaml_init_runtime(argc, argv_);
+    // Note: GC_init() in __gocaml_init_runtime() may temporarily install its own signal handlers.
+    // Install ours after that. Library does not install it (see gocaml.h).
+    gocaml_install_crash_handler();
+    return __gocaml_main();
+}
@@ -1,12 +1,31 @@
+// Note: Required for POSIX and platform specific APIs (sigaction, readlink, dl_iterate_phdr, ...)
+#define _GNU_SOURCE
+
 #include <stdio.h>
+#include <stdarg.h>
 #include <inttypes.h>
 #include <stdlib.h>
 #include <string.h>
 #include <time.h>
 #include <math.h>
+#include <signal.h>
 #include <gc.h>
 #include "gocaml.h"
 
+#if defined(__linux__) || defined(__APPLE__)
+# define GOCAML_BACKTRACE_ENABLED
+# include <errno.h>
+# include <execinfo.h>
+# include <fcntl.h>
+# include <unistd.h>
+# include <sys/wait.h>
+# if defined(__APPLE__)
+#  include <mach-o/dyld.h>
+# else
+#  include <link.h>
+# endif
+#endif
+
 #define SNPRINTF_MAX 128
 #define LINE_MAX 1024
 #define BUF_CHUNK 1024
@@ -54,8 +73,590 @@ typedef struct {
     gocaml_float snd;
 } if_pair_t;
 
-int main(int const argc, char const* const argv_[]) {
+#define BACKTRACE_MAX 128
+#define BACKTRACE_LINE_MAX 1024
+
+// Demangler for symbol names of GoCaml functions. Please see package mangle in compiler for the
+// mangling scheme. e.g. '_GC1fD1_1gIiE' -> 'f#1.g<int>'
+typedef struct {
+    char const* src;
+    char *buf;
+    size_t size;
+    size_t len;
+    int muted;
+    char next_var;
+} demangler;
+
+static void dm_write(demangler *const d, char const* const s, size_t const len)
+{
+    if (d->muted) {
+        return;
+    }
+    for (size_t i = 0; i < len && d->len + 1 < d->size; ++i) {
+        d->buf[d->len++] = s[i];
+    }
+    d->buf[d->len] = '\0';
+}
+
+static void dm_puts(demangler *const d, char const* const s)
+{
+    dm_write(d, s, strlen(s));
+}
+
+static int dm_number(demangler *const d, size_t *const n)
+{
+    char const* const start = d->src;
+    *n = 0;
+    while ('0' <= *d->src && *d->src <= '9') {
+        *n = *n * 10 + (size_t) (*d->src - '0');
+        d->src++;
+    }
+    return d->src != start;
+}
+
+static int dm_type(demangler *const d, int const nested);
+
+// Parses types until 'E' and writes them with separator. When sep is NULL, nothing is written.
+static int dm_type_list(demangler *const d, char const* const sep, int const nested)
+{
+    int count = 0;
+    while (*d->src != 'E') {
+        if (count > 0 && sep != NULL) {
+            dm_puts(d, sep);
+        }
+        if (!dm_type(d, nested)) {
+            return -1;
+        }
+        count++;
+    }
+    d->src++; // Eat 'E'
+    return count;
+}
+
+static int dm_type(demangler *const d, int const nested)
+{
+    char const c = *d->src++;
+    switch (c) {
+        case 'u': dm_puts(d, "unit"); return 1;
+        case 'b': dm_puts(d, "bool"); return 1;
+        case 'i': dm_puts(d, "int"); return 1;
+        case 'f': dm_puts(d, "float"); return 1;
+        case 'c': dm_puts(d, "char"); return 1;
+        case 's': dm_puts(d, "string"); return 1;
+        case 'y': dm_puts(d, "bytes"); return 1;
+        case 'r': dm_puts(d, "buffer"); return 1;
+        case 'V': {
+            char const var[3] = {'\'', d->next_var, '\0'};
+            dm_puts(d, var);
+            if (!d->muted && d->next_var < 'z') {
+                d->next_var++;
+            }
+            return 1;
+        }
+        case 'A':
+        case 'O':
+            if (!dm_type(d, 1)) {
+                return 0;
+            }
+            dm_puts(d, c == 'A' ? " array" : " option");
+            return 1;
+        case 'T':
+            if (nested) {
+                dm_puts(d, "(");
+            }
+            if (dm_type_list(d, " * ", 1) < 2) {
+                return 0;
+            }
+            if (nested) {
+                dm_puts(d, ")");
+            }
+            return 1;
+        case 'F': {
+            // Note: Return type is encoded first, but it is written last
+            char const* const ret = d->src;
+            int const muted = d->muted;
+            d->muted = 1;
+            if (!dm_type(d, 1)) {
+                return 0;
+            }
+            d->muted = muted;
+
+            if (nested) {
+                dm_puts(d, "(");
+            }
+            if (dm_type_list(d, " -> ", 1) < 1) {
+                return 0;
+            }
+            char const* const end = d->src;
+            dm_puts(d, " -> ");
+            d->src = ret;
+            dm_type(d, 1);
+            d->src = end;
+            if (nested) {
+                dm_puts(d, ")");
+            }
+            return 1;
+        }
+        default:
+            return 0;
+    }
+}
+
+static int dm_symbol(demangler *const d)
+{
+    if (strncmp(d->src, "_GC", 3) != 0) {
+        return 0;
+    }
+    d->src += 3;
+
+    int segs = 0;
+    size_t len;
+    while (dm_number(d, &len)) {
+        if (len == 0 || strlen(d->src) < len) {
+            return 0;
+        }
+        if (segs > 0) {
+            dm_puts(d, ".");
+        }
+        dm_write(d, d->src, len);
+        d->src += len;
+        segs++;
+
+        if (*d->src == 'D') {
+            d->src++;
+            char const* const index = d->src;
+            if (!dm_number(d, &len) || *d->src != '_') {
+                return 0;
+            }
+            dm_puts(d, "#");
+            dm_write(d, index, (size_t) (d->src - index));
+            d->src++;
+        }
+    }
+    if (segs == 0) {
+        return 0;
+    }
+
+    if (*d->src == 'I') {
+        d->src++;
+        dm_puts(d, "<");
+        if (dm_type_list(d, ", ", 0) < 1) {
+            return 0;
+        }
+        dm_puts(d, ">");
+    }
+
+    if (*d->src == 'W') {
+        d->src++;
+        dm_puts(d, " (closure)");
+    }
+
+    return *d->src == '\0';
+}
+
+// Converts symbol name of GoCaml function into its name in source. e.g. '_GC1f1g' -> 'f.g'
+// Note: This function is async-signal-safe because it is also called from crash handler.
+static void demangle_symbol(char const* const sym, char *const buf, size_t const size)
+{
+    demangler d = {sym, buf, size, 0, 0, 'a'};
+    buf[0] = '\0';
+    if (strcmp(sym, "__gocaml_main") == 0) {
+        dm_puts(&d, "main");
+        return;
+    }
+
+    if (dm_symbol(&d)) {
+        return;
+    }
+
+    // Not a mangled name. Use the symbol as-is
+    d.len = 0;
+    d.muted = 0;
+    buf[0] = '\0';
+    dm_puts(&d, sym);
+}
+
+#if defined(GOCAML_BACKTRACE_ENABLED)
+
+// Note:
+// Backtrace is also output from signal handler on crash. So everything in this section must be
+// async-signal-safe. stdio functions, popen() and heap allocation are not available. Paths needed
+// to spawn a symbolizer are resolved in advance by init_symbolizer(). At output, the symbolizer is
+// spawned with fork() and execve() and its output is read with read() into static buffer.
+
+extern char **environ;
+
+static char symbolizer_path[BACKTRACE_LINE_MAX]; // Empty when symbolizer command is not found
+static char exe_path[BACKTRACE_LINE_MAX];
+static char *symbolizer_argv[BACKTRACE_MAX + 8];
+static char addr_args[BACKTRACE_MAX][24];
+static char symbolizer_out[BACKTRACE_MAX * BACKTRACE_LINE_MAX];
+
+static void write_stderr(char const* const s)
+{
+    ssize_t const written = write(STDERR_FILENO, s, strlen(s));
+    (void) written;
+}
+
+// Formats unsigned integer in decimal or hexadecimal (with '0x' prefix) into buffer of 24 bytes
+static char *format_uint(uintptr_t n, int const hex, char *const buf)
+{
+    char *p = buf + 23;
+    *p = '\0';
+    do {
+        *--p = "0123456789abcdef"[n % (hex ? 16 : 10)];
+        n /= hex ? 16 : 10;
+    } while (n != 0);
+    if (hex) {
+        *--p = 'x';
+        *--p = '0';
+    }
+    return p;
+}
+
+// Finds the command in $PATH. It writes the found path to buf or empty string when not found.
+static void find_command(char const* const cmd, char *const buf, size_t const size)
+{
+    buf[0] = '\0';
+    char const* dir = getenv("PATH");
+    if (dir == NULL) {
+        return;
+    }
+    while (*dir != '\0') {
+        size_t const len = strcspn(dir, ":");
+        if (len > 0 && len + strlen(cmd) + 2 <= size) {
+            memcpy(buf, dir, len);
+            buf[len] = '/';
+            strcpy(buf + len + 1, cmd);
+            if (access(buf, X_OK) == 0) {
+                return;
+            }
+        }
+        dir += len;
+        if (*dir == ':') {
+            dir++;
+        }
+    }
+    buf[0] = '\0';
+}
+
+static void print_frame(int const idx, void *const addr, char const* const sym, char const* const loc)
+{
+    char num[24];
+    write_stderr("  #");
+    write_stderr(format_uint((uintptr_t) idx, 0, num));
+    write_stderr(" ");
+    if (sym == NULL || sym[0] == '\0' || sym[0] == '?') {
+        write_stderr(format_uint((uintptr_t) addr, 1, num));
+        write_stderr("\n");
+        return;
+    }
+    char name[BACKTRACE_LINE_MAX];
+    demangle_symbol(sym, name, sizeof(name));
+    write_stderr(name);
+    if (loc != NULL && loc[0] != '\0' && loc[0] != '?') {
+        write_stderr(" at ");
+        write_stderr(loc);
+    }
+    write_stderr("\n");
+}
+
+// Reads a line from output of symbolizer and advances the cursor. Newline is not included.
+static int read_line(char const** const cursor, char *const line)
+{
+    if (**cursor == '\0') {
+        return 0;
+    }
+    size_t const len = strcspn(*cursor, "\n");
+    size_t const copied = len < BACKTRACE_LINE_MAX - 1 ? len : BACKTRACE_LINE_MAX - 1;
+    memcpy(line, *cursor, copied);
+    line[copied] = '\0';
+    *cursor += len;
+    if (**cursor == '\n') {
+        (*cursor)++;
+    }
+    return 1;
+}
+
+# if defined(__APPLE__)
+
+static char load_addr_buf[24];
+static char *load_addr_arg;
+
+static void init_symbolizer(void)
+{
+    uint32_t size = sizeof(exe_path);
+    if (_NSGetExecutablePath(exe_path, &size) != 0) {
+        return;
+    }
+    load_addr_arg = format_uint((uintptr_t) _dyld_get_image_header(0), 1, load_addr_buf);
+    find_command("atos", symbolizer_path, sizeof(symbolizer_path));
+}
+
+// Arguments for atos command. Each line of its output is like 'sym (in exe) (file:line)'
+static void build_symbolizer_argv(void *const* const addrs, int const size)
+{
+    int argc = 0;
+    symbolizer_argv[argc++] = "atos";
+    symbolizer_argv[argc++] = "-o";
+    symbolizer_argv[argc++] = exe_path;
+    symbolizer_argv[argc++] = "-l";
+    symbolizer_argv[argc++] = load_addr_arg;
+    for (int i = 0; i < size; ++i) {
+        // Note: Return address points the next instruction of the call
+        symbolizer_argv[argc++] = format_uint((uintptr_t) addrs[i] - 1, 1, addr_args[i]);
+    }
+    symbolizer_argv[argc] = NULL;
+}
+
+static int read_frame(char const** const cursor, char *const sym, char *const loc)
+{
+    char line[BACKTRACE_LINE_MAX];
+    if (!read_line(cursor, line)) {
+        return 0;
+    }
+    size_t const len = strcspn(line, " ");
+    memcpy(sym, line, len);
+    sym[len] = '\0';
+    loc[0] = '\0';
+    char *const open = strrchr(line, '(');
+    char *const close = strrchr(line, ')');
+    if (open != NULL && close != NULL && open < close && strchr(open, ':') != NULL) {
+        *close = '\0';
+        strcpy(loc, open + 1);
+    }
+    return 1;
+}
+
+# else
+
+static uintptr_t load_bias;
+
+static int get_load_bias(struct dl_phdr_info *const info, size_t const size, void *const data)
+{
+    (void) size;
+    // Note: The first entry is always the main executable
+    *(uintptr_t *) data = (uintptr_t) info->dlpi_addr;
+    return 1;
+}
+
+static void init_symbolizer(void)
+{
+    ssize_t const len = readlink("/proc/self/exe", exe_path, sizeof(exe_path) - 1);
+    if (len < 0) {
+        return;
+    }
+    exe_path[len] = '\0';
+    dl_iterate_phdr(get_load_bias, &load_bias);
+    find_command("addr2line", symbolizer_path, sizeof(symbolizer_path));
+}
+
+// Arguments for addr2line command. It outputs a function name and 'file:line' in two lines for each
+// address.
+static void build_symbolizer_argv(void *const* const addrs, int const size)
+{
+    int argc = 0;
+    symbolizer_argv[argc++] = "addr2line";
+    symbolizer_argv[argc++] = "-f";
+    symbolizer_argv[argc++] = "-e";
+    symbolizer_argv[argc++] = exe_path;
+    for (int i = 0; i < size; ++i) {
+        // Note: Return address points the next instruction of the call
+        symbolizer_argv[argc++] = format_uint((uintptr_t) addrs[i] - load_bias - 1, 1, addr_args[i]);
+    }
+    symbolizer_argv[argc] = NULL;
+}
+
+static int read_frame(char const** const cursor, char *const sym, char *const loc)
+{
+    if (!read_line(cursor, sym) || !read_line(cursor, loc)) {
+        return 0;
+    }
+    // Note: Remove trailing ' (discriminator N)'
+    loc[strcspn(loc, " ")] = '\0';
+    // Note: addr2line outputs '??:0' or 'file:?' when the location is unknown
+    if (strstr(loc, ":0") != NULL || strstr(loc, ":?") != NULL) {
+        loc[0] = '\0';
+    }
+    return 1;
+}
+
+# endif
+
+// Runs symbolizer command for the addresses and reads its output into symbolizer_out. It returns
+// 0 when the command could not be executed.
+static int run_symbolizer(void *const* const addrs, int const size)
+{
+    if (symbolizer_path[0] == '\0' || exe_path[0] == '\0') {
+        return 0;
+    }
+    build_symbolizer_argv(addrs, size);
+
+    int fds[2];
+    if (pipe(fds) != 0) {
+        return 0;
+    }
+    pid_t const pid = fork();
+    if (pid < 0) {
+        close(fds[0]);
+        close(fds[1]);
+        return 0;
+    }
+    if (pid == 0) {
+        close(fds[0]);
+        dup2(fds[1], STDOUT_FILENO);
+        int const null = open("/dev/null", O_WRONLY);
+        if (null >= 0) {
+            dup2(null, STDERR_FILENO);
+        }
+        execve(symbolizer_path, symbolizer_argv, environ);
+        _exit(127);
+    }
+    close(fds[1]);
+
+    size_t len = 0;
+    while (len + 1 < sizeof(symbolizer_out)) {
+        ssize_t const n = read(fds[0], symbolizer_out + len, sizeof(symbolizer_out) - len - 1);
+        if (n < 0 && errno == EINTR) {
+            continue;
+        }
+        if (n <= 0) {
+            break;
+        }
+        len += (size_t) n;
+    }
+    symbolizer_out[len] = '\0';
+    // Note: Close the pipe before waiting so that the command does not block on writing the rest
+    close(fds[0]);
+    int status;
+    while (waitpid(pid, &status, 0) < 0 && errno == EINTR) {}
+
+    // Note: When execve() failed in child process, nothing is output
+    return len > 0;
+}
+
+// Prints stack frames obtained by backtrace() to stderr. Frames are printed until the entry point of
+// GoCaml program. It is async-signal-safe so it can be called from crash handler.
+// Note: Callers call backtrace() by themselves and skip their own frames. Frames cannot be skipped
+// here reliably because the call to this function may be a tail call.
+static void dump_backtrace(void *const* const addrs, int const size)
+{
+    if (size <= 0) {
+        return;
+    }
+
+    write_stderr("Backtrace:\n");
+    int printed = 0;
+    if (run_symbolizer(addrs, size)) {
+        char const* cursor = symbolizer_out;
+        char sym[BACKTRACE_LINE_MAX];
+        char loc[BACKTRACE_LINE_MAX];
+        while (printed < size && read_frame(&cursor, sym, loc)) {
+            print_frame(printed, addrs[printed], sym, loc);
+            printed++;
+            if (strcmp(sym, "__gocaml_main") == 0) {
+                break;
+            }
+        }
+    }
+
+    if (printed == 0) {
+        backtrace_symbols_fd(addrs, size, STDERR_FILENO);
+    }
+}
+
+static void crash_handler(int const sig)
+{
+    char const* msg = "Aborted";
+    switch (sig) {
+        case SIGSEGV: msg = "Segmentation fault"; break;
+        case SIGBUS:  msg = "Bus error"; break;
+        case SIGFPE:  msg = "Arithmetic exception"; break;
+    }
+    write_stderr("Fatal error: ");
+    write_stderr(msg);
+    write_stderr("\n");
+
+    void *addrs[BACKTRACE_MAX];
+    int const size = backtrace(addrs, BACKTRACE_MAX);
+    // Skip crash_handler() and signal trampoline
+    dump_backtrace(addrs + 2, size - 2);
+
+    // Note: The handler was reset to default by SA_RESETHAND. Raise the signal again to exit with it.
+    raise(sig);
+}
+
+#endif // GOCAML_BACKTRACE_ENABLED
+
+// Installs a signal handler which outputs backtrace on crash (SIGSEGV, SIGBUS, SIGFPE and SIGABRT).
+// It is called from main() of executable (see gocamlmain.c). Library does not install it unless
+// the host program calls this function because it would replace the host's own handlers.
+void gocaml_install_crash_handler(void)
+{
+#if defined(GOCAML_BACKTRACE_ENABLED)
+    // Note:
+    // Signal handler runs on an alternative stack because SIGSEGV may be caused by stack overflow.
+    static char alt_stack[1 << 16];
+    stack_t ss;
+    ss.ss_sp = alt_stack;
+    ss.ss_size = sizeof(alt_stack);
+    ss.ss_flags = 0;
+    sigaltstack(&ss, NULL);
+
+    // Note:
+    // backtrace() may allocate memory at the first call to load libgcc. Call it here in advance
+    // because heap may be broken on crash.
+    void *dummy[1];
+    backtrace(dummy, 1);
+
+    struct sigaction sa;
+    memset(&sa, 0, sizeof(sa));
+    sa.sa_handler = crash_handler;
+    sa.sa_flags = SA_ONSTACK | SA_RESETHAND;
+    sigemptyset(&sa.sa_mask);
+    sigaction(SIGSEGV, &sa, NULL);
+    sigaction(SIGBUS, &sa, NULL);
+    sigaction(SIGFPE, &sa, NULL);
+    sigaction(SIGABRT, &sa, NULL);
+#endif
+}
+
+void print_backtrace(gocaml_unit _)
+{
+    (void) _;
+#if defined(GOCAML_BACKTRACE_ENABLED)
+    // Note: Backtrace is written to stderr directly. Flush buffered output in advance.
+    fflush(stderr);
+    void *addrs[BACKTRACE_MAX];
+    int const size = backtrace(addrs, BACKTRACE_MAX);
+    // Skip print_backtrace()
+    dump_backtrace(addrs + 1, size - 1);
+#else
+    fputs("Backtrace is not supported on this platform\n", stderr);
+#endif
+}
+
+// Called from code checking arithmetic errors such as division by zero. It never returns.
+void __arith_error(gocaml_string const msg, gocaml_string const file, gocaml_int const line, gocaml_int const column)
+{
+    fprintf(stderr, "Runtime error: %.*s at %.*s:%" PRId64 ":%" PRId64 "\n", (int) msg.size, (char *) msg.chars, (int) file.size, (char *) file.chars, line, column);
+#if defined(GOCAML_BACKTRACE_ENABLED)
+    fflush(stderr);
+    void *addrs[BACKTRACE_MAX];
+    int const size = backtrace(addrs, BACKTRACE_MAX);
+    // Skip __arith_error()
+    dump_backtrace(addrs + 1, size - 1);
+#endif
+    exit(1);
+}
+
+// Initializes runtime. This is called from main() of executable (see gocamlmain.c) or gocaml_init()
+// of library.
+void __gocaml_init_runtime(int const argc, char const* const argv_[]) {
     GC_init();
+#if defined(GOCAML_BACKTRACE_ENABLED)
+    init_symbolizer();
+#endif
     gocaml_string *ptr = (gocaml_string *) GC_malloc(argc * sizeof(gocaml_string *));
     for (int i = 0; i < argc; ++i) {
         gocaml_string s;
@@ -65,7 +666,19 @@ int main(int const argc, char const* const argv_[]) {
     }
     argv.buf = ptr;
     argv.size = (int64_t) argc;
-    return __gocaml_main();
+}
+
+// Entry point of library compiled with -lib or -shared. It initializes runtime and evaluates the
+// toplevel expression of the program. Calling it more than once has no effect.
+void gocaml_init(void)
+{
+    static int initialized = 0;
+    if (initialized) {
+        return;
+    }
+    initialized = 1;
+    __gocaml_init_runtime(0, NULL);
+    __gocaml_main();
 }
 
 void print_int(gocaml_int const i)
@@ -78,6 +691,11 @@ void print_bool(gocaml_bool const i)
     printf("%s", i ? "true" : "false");
 }
 
+void print_char(gocaml_char const c)
+{
+    putchar(c);
+}
+
 void print_float(gocaml_float const d)
 {
     printf("%lg", d);
@@ -129,7 +747,8 @@ gocaml_bool __str_equal(gocaml_string const l, gocaml_string const r)
     if (l.size != r.size) {
         return (gocaml_bool) 0;
     }
-    int const cmp = strncmp((char *)l.chars, (char *)r.chars, (size_t) l.size);
+    // Note: Bytes are also compared with this function. They may contain '\0' in their content.
+    int const cmp = memcmp(l.chars, r.chars, (size_t) l.size);
     return (gocaml_bool) cmp == 0;
 }
 
@@ -144,10 +763,270 @@ gocaml_string str_concat(gocaml_string const l, gocaml_string const r)
 
     gocaml_string ret;
     ret.chars = (int8_t *) new_ptr;
-    ret.size = (gocaml_int) new_size;
+    ret.size = (gocaml_int) (new_size - 1);
+    return ret;
+}
+
+// string array for joining strings
+typedef struct {
+    gocaml_string *buf;
+    gocaml_int size;
+} str_array_t;
+
+// Joins all strings in the array into one newly allocated string. This is used for building a result
+// of Printf.printf and Printf.sprintf.
+gocaml_string __str_join(str_array_t const strs)
+{
+    size_t size = 0;
+    for (gocaml_int i = 0; i < strs.size; ++i) {
+        size += (size_t) strs.buf[i].size;
+    }
+
+    char *const buf = (char *) GC_malloc_atomic(size + 1);
+    char *p = buf;
+    for (gocaml_int i = 0; i < strs.size; ++i) {
+        memcpy(p, strs.buf[i].chars, (size_t) strs.buf[i].size);
+        p += strs.buf[i].size;
+    }
+    buf[size] = '\0';
+
+    gocaml_string ret;
+    ret.chars = (int8_t *) buf;
+    ret.size = (gocaml_int) size;
+    return ret;
+}
+
+// Makes a string from a format and arguments passed to snprintf(). The result is allocated by GC.
+static gocaml_string format_to_str(char const* const fmt, ...)
+{
+    va_list args;
+    va_start(args, fmt);
+    int const len = vsnprintf(NULL, 0, fmt, args);
+    va_end(args);
+
+    char *const buf = (char *) GC_malloc_atomic(len + 1);
+    va_start(args, fmt);
+    vsnprintf(buf, len + 1, fmt, args);
+    va_end(args);
+
+    gocaml_string ret;
+    ret.chars = (int8_t *) buf;
+    ret.size = (gocaml_int) len;
+    return ret;
+}
+
+// Size of C's format buffer for the conversion specification. The suffix replacing its conversion
+// character is at most 3 characters (e.g. "lld" or ".*s") followed by '\0'.
+#define C_SPEC_SIZE(spec) ((size_t) (spec).size + 4)
+
+// Copies a conversion specification (e.g. "%-5d") into C's format buffer replacing its conversion
+// character with the given suffix. The buffer must have C_SPEC_SIZE(spec) bytes. When precision_out
+// is not NULL, precision in the specification is removed and set to it (-1 if not specified).
+static void make_c_spec(char *const dst, gocaml_string const spec, char const* const suffix, int *const precision_out)
+{
+    int const len = (int) spec.size - 1; // Omit conversion character
+    int j = 0;
+    if (precision_out != NULL) {
+        *precision_out = -1;
+    }
+    for (int i = 0; i < len; ++i) {
+        char const c = (char) spec.chars[i];
+        if (c == '.' && precision_out != NULL) {
+            int p = 0;
+            while (i + 1 < len && '0' <= spec.chars[i + 1] && spec.chars[i + 1] <= '9') {
+                p = p * 10 + (spec.chars[++i] - '0');
+            }
+            *precision_out = p;
+            continue;
+        }
+        dst[j++] = c;
+    }
+    strcpy(dst + j, suffix);
+}
+
+gocaml_string __format_int(gocaml_string const spec, gocaml_int const i)
+{
+    char fmt[C_SPEC_SIZE(spec)];
+    char const suffix[] = {'l', 'l', (char) spec.chars[spec.size - 1], '\0'};
+    make_c_spec(fmt, spec, suffix, NULL);
+    return format_to_str(fmt, (long long) i);
+}
+
+gocaml_string __format_float(gocaml_string const spec, gocaml_float const f)
+{
+    char fmt[C_SPEC_SIZE(spec)];
+    char const suffix[] = {(char) spec.chars[spec.size - 1], '\0'};
+    make_c_spec(fmt, spec, suffix, NULL);
+    return format_to_str(fmt, f);
+}
+
+static gocaml_string format_chars(gocaml_string const spec, char const* const chars, int size)
+{
+    char fmt[C_SPEC_SIZE(spec)];
+    int precision;
+    make_c_spec(fmt, spec, ".*s", &precision);
+    if (0 <= precision && precision < size) {
+        size = precision;
+    }
+    return format_to_str(fmt, size, chars);
+}
+
+gocaml_string __format_str(gocaml_string const spec, gocaml_string const s)
+{
+    return format_chars(spec, (char const*) s.chars, (int) s.size);
+}
+
+gocaml_string __format_char(gocaml_string const spec, gocaml_char const c)
+{
+    char const s[] = {(char) c, '\0'};
+    return format_chars(spec, s, 1);
+}
+
+gocaml_string __format_bool(gocaml_string const spec, gocaml_bool const b)
+{
+    char const* const s = b ? "true" : "false";
+    return format_chars(spec, s, (int) strlen(s));
+}
+
+// Below functions are used for rendering values in OCaml toplevel syntax by 'show' and 'print_any'.
+// Codegen expands 'show' into calls of them following the type of the value.
+
+gocaml_string __show_bool(gocaml_bool const b)
+{
+    char const* const s = b ? "true" : "false";
+    gocaml_string ret;
+    ret.chars = (int8_t *) s;
+    ret.size = (gocaml_int) strlen(s);
+    return ret;
+}
+
+gocaml_string __show_float(gocaml_float const f)
+{
+    if (isnan(f)) {
+        return format_to_str("nan");
+    }
+    if (isinf(f)) {
+        return format_to_str(f > 0 ? "infinity" : "neg_infinity");
+    }
+
+    gocaml_string ret = format_to_str("%.12g", f);
+    if (strpbrk((char *) ret.chars, ".e") == NULL) {
+        // Note: OCaml shows float value 3.0 as '3.'
+        return format_to_str("%s.", (char *) ret.chars);
+    }
     return ret;
 }
 
+// Escapes a character in OCaml syntax and returns the number of written bytes. The buffer must have 4
+// bytes at least. 'quote' is a quote character of the literal which needs to be escaped.
+static int escape_char(char *const buf, unsigned char const c, char const quote)
+{
+    char *p = buf;
+    switch (c) {
+        case '\\': *p++ = '\\'; *p++ = '\\'; break;
+        case '\n': *p++ = '\\'; *p++ = 'n'; break;
+        case '\t': *p++ = '\\'; *p++ = 't'; break;
+        case '\r': *p++ = '\\'; *p++ = 'r'; break;
+        case '\b': *p++ = '\\'; *p++ = 'b'; break;
+        default:
+            if (c == (unsigned char) quote) {
+                *p++ = '\\';
+                *p++ = quote;
+            } else if (c < ' ' || c > '~') {
+                p += sprintf(p, "\\%03u", (unsigned) c);
+            } else {
+                *p++ = (char) c;
+            }
+            break;
+    }
+    return (int) (p - buf);
+}
+
+gocaml_string __show_char(gocaml_char const c)
+{
+    char buf[5];
+    int const len = escape_char(buf, c, '\'');
+    buf[len] = '\0';
+    return format_to_str("'%s'", buf);
+}
+
+gocaml_string __show_str(gocaml_string const s)
+{
+    // Note: Each character is escaped to 4 characters at most (e.g. '\255')
+    char *const buf = (char *) GC_malloc_atomic((size_t) s.size * 4 + 3);
+    char *p = buf;
+    *p++ = '"';
+    for (gocaml_int i = 0; i < s.size; ++i) {
+        p += escape_char(p, (unsigned char) s.chars[i], '"');
+    }
+    *p++ = '"';
+    *p = '\0';
+
+    gocaml_string ret;
+    ret.chars = (int8_t *) buf;
+    ret.size = (gocaml_int) (p - buf);
+    return ret;
+}
+
+static gocaml_string join_with(str_array_t const strs, char const* const open, char const* const sep, char const* const close)
+{
+    size_t const open_len = strlen(open);
+    size_t const sep_len = strlen(sep);
+    size_t const close_len = strlen(close);
+
+    size_t size = open_len + close_len;
+    for (gocaml_int i = 0; i < strs.size; ++i) {
+        size += (size_t) strs.buf[i].size;
+        if (i > 0) {
+            size += sep_len;
+        }
+    }
+
+    char *const buf = (char *) GC_malloc_atomic(size + 1);
+    char *p = buf;
+    memcpy(p, open, open_len);
+    p += open_len;
+    for (gocaml_int i = 0; i < strs.size; ++i) {
+        if (i > 0) {
+            memcpy(p, sep, sep_len);
+            p += sep_len;
+        }
+        memcpy(p, strs.buf[i].chars, (size_t) strs.buf[i].size);
+        p += strs.buf[i].size;
+    }
+    memcpy(p, close, close_len);
+    buf[size] = '\0';
+
+    gocaml_string ret;
+    ret.chars = (int8_t *) buf;
+    ret.size = (gocaml_int) size;
+    return ret;
+}
+
+gocaml_string __show_tuple(str_array_t const elems)
+{
+    return join_with(elems, "(", ", ", ")");
+}
+
+gocaml_string __show_array(str_array_t const elems)
+{
+    return join_with(elems, "[|", "; ", "|]");
+}
+
+gocaml_string __show_some(gocaml_string const elem)
+{
+    // Note:
+    // Negative numbers, nested 'Some' values and bytes values need parens since they are not atomic.
+    // Tuples are already enclosed with parens.
+    int const needs_paren = elem.size > 0 && (
+        elem.chars[0] == '-' ||
+        (elem.size > 5 && strncmp((char *) elem.chars, "Some ", 5) == 0) ||
+        (elem.size > 16 && strncmp((char *) elem.chars, "Bytes.of_string ", 16) == 0)
+    );
+    char const* const fmt = needs_paren ? "Some (%.*s)" : "Some %.*s";
+    return format_to_str(fmt, (int) elem.size, (char *) elem.chars);
+}
+
 // Slice [start,last) like Go's str[start:last]
 gocaml_string str_sub(gocaml_string const s, gocaml_int const start, gocaml_int const last)
 {
@@ -262,6 +1141,20 @@ gocaml_int to_char_code(gocaml_string const s)
     return (int64_t) s.chars[0];
 }
 
+gocaml_int char_code(gocaml_char const c)
+{
+    return (gocaml_int) c;
+}
+
+gocaml_char char_chr(gocaml_int const i)
+{
+    if (i < 0 || 255 < i) {
+        fprintf(stderr, "Char.chr: Character code %" PRId64 " is out of range 0-255\n", i);
+        exit(1);
+    }
+    return (gocaml_char) i;
+}
+
 gocaml_string from_char_code(gocaml_int const i)
 {
     char *const ptr = GC_malloc(2);
@@ -273,6 +1166,148 @@ gocaml_string from_char_code(gocaml_int const i)
     return ret;
 }
 
+static void check_bytes_range(char const* const func, gocaml_bytes const b, gocaml_int const offset, gocaml_int const len)
+{
+    if (offset < 0 || len < 0 || b.size - len < offset) {
+        fprintf(stderr, "%s: Index out of bounds (offset: %" PRId64 ", length: %" PRId64 ", size: %" PRId64 ")\n", func, offset, len, b.size);
+        exit(1);
+    }
+}
+
+static gocaml_bytes alloc_bytes(char const* const func, gocaml_int const size)
+{
+    if (size < 0) {
+        fprintf(stderr, "%s: Negative size %" PRId64 "\n", func, size);
+        exit(1);
+    }
+    gocaml_bytes ret;
+    ret.buf = (uint8_t *) GC_malloc_atomic((size_t) size + 1);
+    ret.buf[size] = '\0';
+    ret.size = size;
+    return ret;
+}
+
+gocaml_bytes bytes_create(gocaml_int const size)
+{
+    gocaml_bytes const ret = alloc_bytes("Bytes.create", size);
+    memset(ret.buf, 0, (size_t) size);
+    return ret;
+}
+
+gocaml_int bytes_length(gocaml_bytes const b)
+{
+    return b.size;
+}
+
+gocaml_char bytes_get(gocaml_bytes const b, gocaml_int const idx)
+{
+    check_bytes_range("Bytes.get", b, idx, 1);
+    return (gocaml_char) b.buf[idx];
+}
+
+void bytes_set(gocaml_bytes const b, gocaml_int const idx, gocaml_char const c)
+{
+    check_bytes_range("Bytes.set", b, idx, 1);
+    b.buf[idx] = (uint8_t) c;
+}
+
+void bytes_blit(gocaml_bytes const src, gocaml_int const src_off, gocaml_bytes const dst, gocaml_int const dst_off, gocaml_int const len)
+{
+    check_bytes_range("Bytes.blit", src, src_off, len);
+    check_bytes_range("Bytes.blit", dst, dst_off, len);
+    // Note: Source and destination may overlap when they are the same bytes
+    memmove(dst.buf + dst_off, src.buf + src_off, (size_t) len);
+}
+
+gocaml_bytes bytes_of_string(gocaml_string const s)
+{
+    gocaml_bytes const ret = alloc_bytes("Bytes.of_string", s.size);
+    memcpy(ret.buf, s.chars, (size_t) s.size);
+    return ret;
+}
+
+gocaml_string bytes_to_string(gocaml_bytes const b)
+{
+    // Note: Copy the content because bytes may be modified after converting to string
+    char *const buf = (char *) GC_malloc_atomic((size_t) b.size + 1);
+    memcpy(buf, b.buf, (size_t) b.size);
+    buf[b.size] = '\0';
+
+    gocaml_string ret;
+    ret.chars = (int8_t *) buf;
+    ret.size = b.size;
+    return ret;
+}
+
+gocaml_string __show_bytes(gocaml_bytes const b)
+{
+    gocaml_string s;
+    s.chars = (int8_t *) b.buf;
+    s.size = b.size;
+    return str_concat(format_to_str("Bytes.of_string "), __show_str(s));
+}
+
+struct gocaml_buffer_impl {
+    char *buf;
+    gocaml_int size;
+    gocaml_int capacity;
+};
+
+gocaml_buffer buffer_create(gocaml_int const capacity)
+{
+    gocaml_buffer const b = (gocaml_buffer) GC_malloc(sizeof(struct gocaml_buffer_impl));
+    b->capacity = capacity < 1 ? 1 : capacity;
+    b->buf = (char *) GC_malloc_atomic((size_t) b->capacity);
+    b->size = 0;
+    return b;
+}
+
+gocaml_int buffer_length(gocaml_buffer const b)
+{
+    return b->size;
+}
+
+// Ensures the buffer has enough space for appending 'len' bytes. Capacity grows twice so that
+// appending is amortized O(1).
+static void buffer_reserve(gocaml_buffer const b, gocaml_int const len)
+{
+    gocaml_int const required = b->size + len;
+    if (required <= b->capacity) {
+        return;
+    }
+    gocaml_int capacity = b->capacity;
+    while (capacity < required) {
+        capacity *= 2;
+    }
+    b->buf = (char *) GC_realloc(b->buf, (size_t) capacity);
+    b->capacity = capacity;
+}
+
+void buffer_add_char(gocaml_buffer const b, gocaml_char const c)
+{
+    buffer_reserve(b, 1);
+    b->buf[b->size++] = (char) c;
+}
+
+void buffer_add_string(gocaml_buffer const b, gocaml_string const s)
+{
+    buffer_reserve(b, s.size);
+    memcpy(b->buf + b->size, s.chars, (size_t) s.size);
+    b->size += s.size;
+}
+
+gocaml_string buffer_contents(gocaml_buffer const b)
+{
+    char *const buf = (char *) GC_malloc_atomic((size_t) b->size + 1);
+    memcpy(buf, b->buf, (size_t) b->size);
+    buf[b->size] = '\0';
+
+    gocaml_string ret;
+    ret.chars = (int8_t *) buf;
+    ret.size = b->size;
+    return ret;
+}
+
 void do_garbage_collection(gocaml_unit _)
 {
     (void) _;
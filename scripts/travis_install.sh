@@ -8,6 +8,7 @@ if [[ "$TRAVIS_OS_NAME" == "osx" ]]; then
     brew update
     brew info llvm
     brew install bdw-gc llvm
+    export LLVM_CONFIG="$(ls -1 /usr/local/Cellar/llvm/*/bin/llvm-config | tail -1)"
 else
     go get golang.org/x/tools/cmd/cover
     go get github.com/haya14busa/goverage
@@ -3,8 +3,10 @@ package sema
 import (
 	"fmt"
 	"github.com/rhysd/gocaml/ast"
+	"github.com/rhysd/gocaml/mangle"
 	"github.com/rhysd/gocaml/types"
 	"github.com/rhysd/locerr"
+	"strings"
 )
 
 // Alpha transform.
@@ -31,7 +33,7 @@ func duplicateSymbol(symbols []*ast.Symbol) *ast.Symbol {
 
 func isBuiltinTypeCtor(name string) bool {
 	switch name {
-	case "_", "array", "option", "unit", "int", "bool", "float", "string":
+	case "_", "array", "option", "unit", "int", "bool", "float", "char", "string", "bytes", "buffer":
 		return true
 	default:
 		return false
@@ -45,15 +47,28 @@ type transformer struct {
 	tyId      uint
 	err       error
 	externals map[string]struct{}
+	// Mappings from transformed names to display names
+	displayNames map[string]string
+	// Mappings from transformed function names to mangled symbol names
+	mangledNames map[string]string
+	// Symbol names of functions which enclose currently visited node
+	enclosingFuncs []string
+	// Number of functions defined with the same name in the same function. Keys are pairs of
+	// the enclosing function's symbol and the name.
+	funcCounts map[[2]string]int
 }
 
 func newTransformer() *transformer {
 	return &transformer{
-		current:   newScope(nil),
-		typeScope: newScope(nil),
-		varId:     0,
-		tyId:      0,
-		externals: nil,
+		current:        newScope(nil),
+		typeScope:      newScope(nil),
+		varId:          0,
+		tyId:           0,
+		externals:      nil,
+		displayNames:   nil,
+		mangledNames:   nil,
+		enclosingFuncs: []string{},
+		funcCounts:     map[[2]string]int{},
 	}
 }
 
@@ -77,6 +92,35 @@ func (t *transformer) register(s *ast.Symbol) {
 	}
 	s.Name = t.newVarID(s.DisplayName)
 	t.current.mapSymbol(s.DisplayName, s)
+	t.displayNames[s.Name] = s.DisplayName
+}
+
+// enterFunc gives a mangled symbol name to the function and enters its scope.
+func (t *transformer) enterFunc(s *ast.Symbol) {
+	name := s.DisplayName
+	if strings.HasPrefix(name, "lambda.") {
+		// Lambda is named with its position by parser. Use the fixed name for it since the
+		// position is easily changed by editing unrelated code.
+		name = mangle.LambdaName
+	}
+
+	parent := ""
+	if len(t.enclosingFuncs) > 0 {
+		parent = t.enclosingFuncs[len(t.enclosingFuncs)-1]
+	}
+	key := [2]string{parent, name}
+	index := t.funcCounts[key]
+	t.funcCounts[key] = index + 1
+
+	sym := mangle.Nested(parent, name, index)
+	if !s.IsIgnored() {
+		t.mangledNames[s.Name] = sym
+	}
+	t.enclosingFuncs = append(t.enclosingFuncs, sym)
+}
+
+func (t *transformer) leaveFunc() {
+	t.enclosingFuncs = t.enclosingFuncs[:len(t.enclosingFuncs)-1]
 }
 
 func (t *transformer) nest() {
@@ -87,8 +131,40 @@ func (t *transformer) pop() {
 	t.current = t.current.parent
 }
 
+// visitIntrinsicCallee returns true when the callee refers an intrinsic function. Intrinsic can be
+// shadowed by variables and external symbols.
+func (t *transformer) visitIntrinsicCallee(callee ast.Expr) bool {
+	ref, ok := callee.(*ast.VarRef)
+	if !ok {
+		return false
+	}
+	name := ref.Symbol.DisplayName
+	if _, ok := types.Intrinsics[name]; !ok {
+		return false
+	}
+	if _, ok := t.current.resolve(name); ok {
+		return false
+	}
+	_, ok = t.externals[name]
+	return !ok
+}
+
 func (t *transformer) VisitTopdown(node ast.Expr) ast.Visitor {
 	switch n := node.(type) {
+	case *ast.Apply:
+		if !t.visitIntrinsicCallee(n.Callee) {
+			return t
+		}
+		for _, a := range n.Args {
+			ast.Visit(t, a)
+		}
+		return nil
+	case *ast.Pipe:
+		if !t.visitIntrinsicCallee(n.Callee) {
+			return t
+		}
+		ast.Visit(t, n.Arg)
+		return nil
 	case *ast.Let:
 		// At first, transform value bound to the variable
 		ast.Visit(t, n.Bound)
@@ -107,6 +183,7 @@ func (t *transformer) VisitTopdown(node ast.Expr) ast.Visitor {
 		}
 		t.nest()
 		t.register(n.Func.Symbol)
+		t.enterFunc(n.Func.Symbol)
 		t.nest()
 		for _, p := range n.Func.Params {
 			if p.Type != nil {
@@ -118,6 +195,7 @@ func (t *transformer) VisitTopdown(node ast.Expr) ast.Visitor {
 			ast.Visit(t, n.Func.RetType)
 		}
 		ast.Visit(t, n.Func.Body)
+		t.leaveFunc()
 		t.pop() // Pop parameters scope
 		ast.Visit(t, n.Body)
 		t.pop() // Pop function scope
@@ -158,9 +236,15 @@ func (t *transformer) VisitTopdown(node ast.Expr) ast.Visitor {
 			return nil
 		}
 		// Check external it's an external symbol
-		if _, ok := t.externals[n.Symbol.Name]; !ok {
-			t.err = locerr.ErrorfIn(n.Pos(), n.End(), "Undefined variable '%s'", n.Symbol.DisplayName)
+		if _, ok := t.externals[n.Symbol.Name]; ok {
+			return nil
+		}
+		if _, ok := types.Intrinsics[n.Symbol.Name]; ok {
+			// Note: Intrinsic applied directly was already handled at visiting *ast.Apply or *ast.Pipe.
+			t.err = locerr.ErrorfIn(n.Pos(), n.End(), "Intrinsic function '%s' cannot be used as a value. It must be applied directly", n.Symbol.DisplayName)
+			return nil
 		}
+		t.err = locerr.ErrorfIn(n.Pos(), n.End(), "Undefined variable '%s'", n.Symbol.DisplayName)
 		return nil
 	case *ast.CtorType:
 		if isBuiltinTypeCtor(n.Ctor.DisplayName) {
@@ -224,6 +308,8 @@ func AlphaTransform(tree *ast.AST, env *types.Env) error {
 		cnames[e.C] = struct{}{}
 	}
 	v.externals = exts
+	v.displayNames = env.DisplayNames
+	v.mangledNames = env.MangledNames
 
 	ast.Visit(v, tree.Root)
 	return v.err
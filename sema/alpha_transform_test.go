@@ -2,9 +2,11 @@ package sema
 
 import (
 	"github.com/rhysd/gocaml/ast"
+	"github.com/rhysd/gocaml/syntax"
 	"github.com/rhysd/gocaml/token"
 	"github.com/rhysd/gocaml/types"
 	"github.com/rhysd/locerr"
+	"sort"
 	"strings"
 	"testing"
 )
@@ -36,6 +38,76 @@ func TestFlatScope(t *testing.T) {
 	}
 }
 
+func TestDisplayNames(t *testing.T) {
+	tok := &token.Token{
+		Start: locerr.Pos{},
+		End:   locerr.Pos{},
+	}
+	sym := ast.NewSymbol("test")
+	ignored := ast.IgnoredSymbol()
+	root := &ast.Let{
+		tok,
+		sym,
+		&ast.Int{nil, 42},
+		&ast.Let{
+			tok,
+			ignored,
+			&ast.Int{nil, 42},
+			&ast.VarRef{tok, ast.NewSymbol("test")},
+			nil,
+		},
+		nil,
+	}
+	env := types.NewEnv()
+	if err := AlphaTransform(&ast.AST{Root: root}, env); err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := env.DisplayNames[sym.Name]; !ok || n != "test" {
+		t.Fatalf("Display name for '%s' is unexpected: '%s' (found: %v)", sym.Name, n, ok)
+	}
+	if _, ok := env.DisplayNames[ignored.Name]; ok {
+		t.Fatalf("Ignored symbol should not have display name: %s", ignored.Name)
+	}
+}
+
+func TestMangledNames(t *testing.T) {
+	code := `
+	let rec f x =
+		let rec g y = y in
+		let rec g y = (fun z -> z) y in
+		g x
+	in
+	let rec f x = let rec g y = y in g x in
+	let h = fun x -> x in
+	f (h 42)
+	`
+	parsed, err := syntax.Parse(locerr.NewDummySource(code))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := types.NewEnv()
+	if err := AlphaTransform(parsed, env); err != nil {
+		t.Fatal(err)
+	}
+	have := make([]string, 0, len(env.MangledNames))
+	for _, sym := range env.MangledNames {
+		have = append(have, sym)
+	}
+	sort.Strings(have)
+	want := []string{
+		"_GC1f",
+		"_GC1f1g",
+		"_GC1f1gD1_",
+		"_GC1f1gD1_3fun",
+		"_GC1fD1_",
+		"_GC1fD1_1g",
+		"_GC3fun",
+	}
+	if strings.Join(have, " ") != strings.Join(want, " ") {
+		t.Fatalf("Wanted mangled names %v but had %v", want, have)
+	}
+}
+
 func TestNested(t *testing.T) {
 	tok := &token.Token{
 		Start: locerr.Pos{},
@@ -627,3 +699,28 @@ func TestUndefinedSymbol(t *testing.T) {
 		t.Fatal("Unexpected error message:", have, ", wanted:", want)
 	}
 }
+
+func TestIntrinsicAsValue(t *testing.T) {
+	pos := locerr.Pos{}
+	tok := &token.Token{
+		Start: pos,
+		End:   pos,
+		File:  locerr.NewDummySource(""),
+	}
+	ref := &ast.VarRef{
+		tok,
+		ast.NewSymbol("show"),
+	}
+
+	err := AlphaTransform(&ast.AST{Root: ref}, types.NewEnv())
+	if err == nil {
+		t.Fatal("Error should have been caused")
+	}
+
+	want := "Intrinsic function 'show' cannot be used as a value"
+	have := err.Error()
+
+	if !strings.Contains(have, want) {
+		t.Fatal("Unexpected error message:", have, ", wanted:", want)
+	}
+}
@@ -176,7 +176,7 @@ func (d *typeVarDereferencer) checkLess(op string, lhs ast.Expr) string {
 	// This type constraint may be useful for type inference. But current HM type inference algorithm cannot
 	// handle a union type. In this context, the operand should be `int | float`
 	switch operand.(type) {
-	case *Unit, *Bool, *String, *Fun, *Tuple, *Array, *Option:
+	case *Unit, *Bool, *String, *Bytes, *Buffer, *Fun, *Tuple, *Array, *Option:
 		return fmt.Sprintf("'%s' can't be compared with operator '%s'", operand.String(), op)
 	default:
 		return ""
@@ -191,8 +191,11 @@ func (d *typeVarDereferencer) checkEq(op string, lhs ast.Expr) string {
 	// Note:
 	// This type constraint may be useful for type inference. But current HM type inference algorithm cannot
 	// handle a union type. In this context, the operand should be `() | bool | int | float | fun<R, TS...> | tuple<Args...>`
-	if a, ok := operand.(*Array); ok {
-		return fmt.Sprintf("Array type '%s' can't be compared with operator '%s'", a.String(), op)
+	switch operand := operand.(type) {
+	case *Array:
+		return fmt.Sprintf("Array type '%s' can't be compared with operator '%s'", operand.String(), op)
+	case *Buffer:
+		return fmt.Sprintf("Buffer type '%s' can't be compared with operator '%s'", operand.String(), op)
 	}
 	return ""
 }
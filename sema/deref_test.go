@@ -95,6 +95,16 @@ func TestMiscCheckError(t *testing.T) {
 			code:     "let a = Array.make  3 3 in a = a",
 			expected: "'int array' can't be compared with operator '='",
 		},
+		{
+			what:     "bytes is invalid for operator '<'",
+			code:     "let b = Bytes.create 3 in b < b",
+			expected: "'bytes' can't be compared with operator '<'",
+		},
+		{
+			what:     "buffer is invalid for operator '='",
+			code:     "let b = Buffer.create 3 in b = b",
+			expected: "'buffer' can't be compared with operator '='",
+		},
 	}
 
 	for _, tc := range cases {
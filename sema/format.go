@@ -0,0 +1,118 @@
+package sema
+
+import (
+	"fmt"
+	"github.com/rhysd/gocaml/types"
+	"strings"
+)
+
+// Width and precision of conversion specification are limited to this number of digits. Larger
+// values would overflow C's int at runtime.
+const maxFormatDigits = 4
+
+// formatSpec is a piece of format string of 'Printf.printf' and 'Printf.sprintf'. When conv is 0,
+// the piece is a plain text. Otherwise, text is a conversion specification like '%-5d'.
+type formatSpec struct {
+	text string
+	conv byte
+}
+
+func (spec formatSpec) isText() bool {
+	return spec.conv == 0
+}
+
+// argType returns the type of argument which the conversion specification requires.
+func (spec formatSpec) argType() types.Type {
+	switch spec.conv {
+	case 'd', 'i', 'u', 'x', 'X', 'o':
+		return types.IntType
+	case 'f', 'F', 'e', 'E', 'g', 'G':
+		return types.FloatType
+	case 's':
+		return types.StringType
+	case 'c':
+		return types.CharType
+	case 'b', 'B':
+		return types.BoolType
+	default:
+		panic("FATAL: Unknown conversion in format string: " + spec.text)
+	}
+}
+
+// parseFormat parses format string of 'Printf.printf' and 'Printf.sprintf' into plain texts and
+// conversion specifications. Syntax of conversion specification is a subset of OCaml's Printf,
+// '%[flags][width][.precision]type' where flags are '-', '0', '+', ' ' and '#', and type is one of 'd', 'i', 'u', 'x', 'X', 'o',
+// 'f', 'F', 'e', 'E', 'g', 'G', 's', 'c', 'b' and 'B'. '%%' means '%' character.
+func parseFormat(format string) ([]formatSpec, error) {
+	specs := []formatSpec{}
+	text := []byte{}
+	flush := func() {
+		if len(text) > 0 {
+			specs = append(specs, formatSpec{string(text), 0})
+			text = []byte{}
+		}
+	}
+
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			text = append(text, c)
+			continue
+		}
+
+		start := i
+		i++
+		if i < len(format) && format[i] == '%' {
+			text = append(text, '%')
+			continue
+		}
+
+		for i < len(format) && strings.IndexByte("-0+ #", format[i]) >= 0 {
+			i++
+		}
+		digits := func() int {
+			from := i
+			for i < len(format) && '0' <= format[i] && format[i] <= '9' {
+				i++
+			}
+			return i - from
+		}
+		width := digits()
+		precision := 0
+		if i < len(format) && format[i] == '.' {
+			i++
+			precision = digits()
+		}
+
+		if i >= len(format) {
+			return nil, fmt.Errorf("Conversion specification '%s' is not terminated at end of format string", format[start:])
+		}
+
+		conv := format[i]
+		spec := format[start : i+1]
+		if width > maxFormatDigits || precision > maxFormatDigits {
+			return nil, fmt.Errorf("Width or precision of specification '%s' in format string must not have more than %d digits", spec, maxFormatDigits)
+		}
+		switch conv {
+		case 'd', 'i', 'u', 'x', 'X', 'o', 'f', 'F', 'e', 'E', 'g', 'G', 's', 'c', 'b', 'B':
+			flush()
+			specs = append(specs, formatSpec{spec, conv})
+		default:
+			return nil, fmt.Errorf("Unknown conversion '%c' in specification '%s' of format string", conv, spec)
+		}
+	}
+
+	flush()
+	return specs, nil
+}
+
+// numFormatArgs returns the number of arguments required by the format.
+func numFormatArgs(specs []formatSpec) int {
+	n := 0
+	for _, s := range specs {
+		if !s.isText() {
+			n++
+		}
+	}
+	return n
+}
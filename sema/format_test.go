@@ -0,0 +1,130 @@
+package sema
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFormatOK(t *testing.T) {
+	cases := []struct {
+		what     string
+		format   string
+		expected []formatSpec
+	}{
+		{
+			"empty",
+			"",
+			[]formatSpec{},
+		},
+		{
+			"text only",
+			"hello, world\n",
+			[]formatSpec{{"hello, world\n", 0}},
+		},
+		{
+			"escaped percent",
+			"100%% sure",
+			[]formatSpec{{"100% sure", 0}},
+		},
+		{
+			"conversions",
+			"%d: %s\n",
+			[]formatSpec{{"%d", 'd'}, {": ", 0}, {"%s", 's'}, {"\n", 0}},
+		},
+		{
+			"all types",
+			"%d%i%u%x%X%o%f%F%e%E%g%G%s%c%b%B",
+			[]formatSpec{
+				{"%d", 'd'}, {"%i", 'i'}, {"%u", 'u'}, {"%x", 'x'}, {"%X", 'X'}, {"%o", 'o'},
+				{"%f", 'f'}, {"%F", 'F'}, {"%e", 'e'}, {"%E", 'E'}, {"%g", 'g'}, {"%G", 'G'},
+				{"%s", 's'}, {"%c", 'c'}, {"%b", 'b'}, {"%B", 'B'},
+			},
+		},
+		{
+			"flags, width and precision",
+			"[%-5d][%05.2f][%+ #x][%.3s]",
+			[]formatSpec{
+				{"[", 0}, {"%-5d", 'd'}, {"][", 0}, {"%05.2f", 'f'}, {"][", 0},
+				{"%+ #x", 'x'}, {"][", 0}, {"%.3s", 's'}, {"]", 0},
+			},
+		},
+		{
+			"max width and precision",
+			"%-9999.9999f",
+			[]formatSpec{{"%-9999.9999f", 'f'}},
+		},
+		{
+			"unicode text",
+			"あ%dい",
+			[]formatSpec{{"あ", 0}, {"%d", 'd'}, {"い", 0}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.what, func(t *testing.T) {
+			specs, err := parseFormat(tc.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(specs) != len(tc.expected) {
+				t.Fatalf("Expected %d pieces but got %d: %v", len(tc.expected), len(specs), specs)
+			}
+			for i, s := range specs {
+				if s != tc.expected[i] {
+					t.Errorf("Expected %v at %d but got %v", tc.expected[i], i, s)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFormatError(t *testing.T) {
+	cases := []struct {
+		what     string
+		format   string
+		expected string
+	}{
+		{
+			"unknown conversion",
+			"%y",
+			"Unknown conversion 'y'",
+		},
+		{
+			"unterminated at end",
+			"foo %5",
+			"'%5' is not terminated",
+		},
+		{
+			"single percent",
+			"%",
+			"'%' is not terminated",
+		},
+		{
+			"length modifier",
+			"%ld",
+			"Unknown conversion 'l'",
+		},
+		{
+			"too large width",
+			"%10000d",
+			"specification '%10000d' in format string must not have more than 4 digits",
+		},
+		{
+			"too large precision",
+			"%-5.00001f",
+			"specification '%-5.00001f' in format string must not have more than 4 digits",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.what, func(t *testing.T) {
+			_, err := parseFormat(tc.format)
+			if err == nil {
+				t.Fatal("Error did not occur")
+			}
+			if !strings.Contains(err.Error(), tc.expected) {
+				t.Fatalf("Expected '%s' to be contained in error message '%s'", tc.expected, err.Error())
+			}
+		})
+	}
+}
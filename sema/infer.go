@@ -103,6 +103,36 @@ func (inf *Inferer) inferLogicalOp(op string, left, right ast.Expr, level int) (
 	return BoolType, nil
 }
 
+func (inf *Inferer) inferApply(node, calleeNode ast.Expr, argNodes []ast.Expr, level int) (Type, error) {
+	args := make([]Type, len(argNodes))
+	for i, a := range argNodes {
+		t, err := inf.infer(a, level)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = t
+	}
+
+	// Return type of callee is unknown in this point.
+	// So make a new type variable and allocate it as return type.
+	ret := NewVar(nil, level)
+	fun := &Fun{
+		Ret:    ret,
+		Params: args,
+	}
+
+	callee, err := inf.infer(calleeNode, level)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := Unify(callee, fun); err != nil {
+		return nil, err.In(node.Pos(), node.End()).NoteAt(node.Pos(), "Type of called function")
+	}
+
+	return ret, nil
+}
+
 func (inf *Inferer) inferNode(e ast.Expr, level int) (Type, error) {
 	switch n := e.(type) {
 	case *ast.Unit:
@@ -113,6 +143,8 @@ func (inf *Inferer) inferNode(e ast.Expr, level int) (Type, error) {
 		return FloatType, nil
 	case *ast.String:
 		return StringType, nil
+	case *ast.Char:
+		return CharType, nil
 	case *ast.Bool:
 		return BoolType, nil
 	case *ast.Not:
@@ -164,6 +196,8 @@ func (inf *Inferer) inferNode(e ast.Expr, level int) (Type, error) {
 		return inf.inferLogicalOp("&&", n.Left, n.Right, level)
 	case *ast.Or:
 		return inf.inferLogicalOp("||", n.Left, n.Right, level)
+	case *ast.Concat:
+		return inf.inferArithmeticBinOp("^", n.Left, n.Right, StringType, level)
 	case *ast.If:
 		if err := inf.checkNodeType("condition of 'if' expression", n.Cond, BoolType, level); err != nil {
 			return nil, err
@@ -216,6 +250,12 @@ func (inf *Inferer) inferNode(e ast.Expr, level int) (Type, error) {
 		if e, ok := inf.Env.Externals[n.Symbol.Name]; ok {
 			return e.Type, nil
 		}
+		if t, ok := Intrinsics[n.Symbol.Name]; ok {
+			// Note:
+			// Instantiation of intrinsic is not recorded because it's not a declaration and is never
+			// monomorphized. It is expanded following its argument type after monomorphization.
+			return instantiate(t, level).To, nil
+		}
 		panic("FATAL: Unknown symbol must be checked in alpha transform: " + n.Symbol.Name)
 	case *ast.LetRec:
 		// Note:
@@ -280,33 +320,9 @@ func (inf *Inferer) inferNode(e ast.Expr, level int) (Type, error) {
 
 		return inf.infer(n.Body, level)
 	case *ast.Apply:
-		args := make([]Type, len(n.Args))
-		for i, a := range n.Args {
-			t, err := inf.infer(a, level)
-			if err != nil {
-				return nil, err
-			}
-			args[i] = t
-		}
-
-		// Return type of callee is unknown in this point.
-		// So make a new type variable and allocate it as return type.
-		ret := NewVar(nil, level)
-		fun := &Fun{
-			Ret:    ret,
-			Params: args,
-		}
-
-		callee, err := inf.infer(n.Callee, level)
-		if err != nil {
-			return nil, err
-		}
-
-		if err := Unify(callee, fun); err != nil {
-			return nil, err.In(n.Pos(), n.End()).NoteAt(n.Pos(), "Type of called function")
-		}
-
-		return ret, nil
+		return inf.inferApply(n, n.Callee, n.Args, level)
+	case *ast.Pipe:
+		return inf.inferApply(n, n.Callee, []ast.Expr{n.Arg}, level)
 	case *ast.Tuple:
 		elems := make([]Type, len(n.Elems))
 		for i, e := range n.Elems {
@@ -404,6 +420,14 @@ func (inf *Inferer) inferNode(e ast.Expr, level int) (Type, error) {
 
 		// Assign to array does not have a value, so return unit type
 		return UnitType, nil
+	case *ast.StringGet:
+		if err := inf.checkNodeType("string value in index access", n.String, StringType, level); err != nil {
+			return nil, err
+		}
+		if err := inf.checkNodeType("index access to string", n.Index, IntType, level); err != nil {
+			return nil, err
+		}
+		return CharType, nil
 	case *ast.ArrayLit:
 		if len(n.Elems) == 0 {
 			// Array is empty. Cannot infer type of elements.
@@ -451,6 +475,30 @@ func (inf *Inferer) inferNode(e ast.Expr, level int) (Type, error) {
 			return nil, err.In(n.Pos(), n.End()).NoteAt(n.Pos(), "Mismatch of types between 'Some' arm and 'None' arm in 'match' expression")
 		}
 		return some, nil
+	case *ast.Printf:
+		specs, err := parseFormat(n.Format.Value)
+		if err != nil {
+			return nil, locerr.ErrorfIn(n.Format.Pos(), n.Format.End(), "Invalid format string: %s", err.Error())
+		}
+		if expected := numFormatArgs(specs); expected != len(n.Args) {
+			return nil, locerr.ErrorfIn(n.Pos(), n.End(), "Format string %q requires %d argument(s) but %d argument(s) given", n.Format.Value, expected, len(n.Args))
+		}
+		inf.inferred[n.Format] = StringType
+		i := 0
+		for _, spec := range specs {
+			if spec.isText() {
+				continue
+			}
+			what := fmt.Sprintf("%s argument for '%s' in format string", common.Ordinal(i+1), spec.text)
+			if err := inf.checkNodeType(what, n.Args[i], spec.argType(), level); err != nil {
+				return nil, err
+			}
+			i++
+		}
+		if n.Sprintf {
+			return StringType, nil
+		}
+		return UnitType, nil
 	case *ast.Typed:
 		child, err := inf.infer(n.Child, level)
 		if err != nil {
@@ -481,6 +529,67 @@ func (inf *Inferer) infer(e ast.Expr, level int) (Type, error) {
 	return t, nil
 }
 
+func containsFun(t Type) bool {
+	switch t := t.(type) {
+	case *Fun:
+		return true
+	case *Tuple:
+		for _, e := range t.Elems {
+			if containsFun(e) {
+				return true
+			}
+		}
+		return false
+	case *Array:
+		return containsFun(t.Elem)
+	case *Option:
+		return containsFun(t.Elem)
+	case *Var:
+		if t.Ref != nil {
+			return containsFun(t.Ref)
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// Note:
+// Closures passed to external functions directly are converted to callbacks which C code can call
+// safely. Other functions in types of external symbols are not permitted since they would be passed
+// with GoCaml's internal ABI. checkExternalType returns the reason why the type is invalid, or empty
+// string when it is valid.
+func checkExternalType(t Type) string {
+	fun, ok := t.(*Fun)
+	if !ok {
+		if containsFun(t) {
+			return "external value must not contain functions"
+		}
+		return ""
+	}
+	if containsFun(fun.Ret) {
+		return "external function must not return functions"
+	}
+	for _, p := range fun.Params {
+		cb, ok := p.(*Fun)
+		if !ok {
+			if containsFun(p) {
+				return fmt.Sprintf("function in parameter type '%s' cannot be passed to C. Only a parameter of function type can receive a function as a callback", p.String())
+			}
+			continue
+		}
+		if containsFun(cb.Ret) {
+			return fmt.Sprintf("callback '%s' must not return functions", cb.String())
+		}
+		for _, cp := range cb.Params {
+			if containsFun(cp) {
+				return fmt.Sprintf("callback '%s' must not take functions", cb.String())
+			}
+		}
+	}
+	return ""
+}
+
 // Infer infers types in given AST and returns error when detecting type errors
 func (inf *Inferer) Infer(parsed *ast.AST) error {
 	var err error
@@ -501,6 +610,9 @@ func (inf *Inferer) Infer(parsed *ast.AST) error {
 			err = locerr.NoteAt(ext.Pos(), err, "'_' is not permitted in type of external symbol")
 			return err
 		}
+		if msg := checkExternalType(t); msg != "" {
+			return locerr.ErrorfIn(ext.Type.Pos(), ext.Type.End(), "Invalid type '%s' at 'external' declaration '%s': %s", t.String(), ext.Ident.Name, msg)
+		}
 		inf.Env.Externals[ext.Ident.Name] = &External{t, ext.C}
 	}
 	inf.conv.acceptsAnyType = true
@@ -115,6 +115,106 @@ func TestUnificationFailure(t *testing.T) {
 			code:     "false || 42",
 			expected: "Type mismatch between 'bool' and 'int'",
 		},
+		{
+			what:     "^ must have string operands",
+			code:     "\"foo\" ^ 42",
+			expected: "Type mismatch between 'string' and 'int'",
+		},
+		{
+			what:     "|> with non-function value",
+			code:     "42 |> 42",
+			expected: "Type mismatch between 'int' and 'int -> ",
+		},
+		{
+			what:     "|> with mismatched argument",
+			code:     "true |> print_int",
+			expected: "Type mismatch between 'int' and 'bool'",
+		},
+		{
+			what:     "@@ with mismatched argument",
+			code:     "print_int @@ 3.14",
+			expected: "Type mismatch between 'int' and 'float'",
+		},
+		{
+			what:     "index of string must be int",
+			code:     "\"foo\".[true]; ()",
+			expected: "Type mismatch between 'int' and 'bool'",
+		},
+		{
+			what:     "indexing string returns char",
+			code:     "\"foo\".[0] + 1",
+			expected: "Type mismatch between 'int' and 'char'",
+		},
+		{
+			what:     "indexing non-string value",
+			code:     "[| 'a' |].[0]; ()",
+			expected: "Type mismatch between 'string' and 'char array'",
+		},
+		{
+			what:     "char is not int",
+			code:     "Char.code 42; ()",
+			expected: "Type mismatch between 'char' and 'int'",
+		},
+		{
+			what:     "bytes is not string",
+			code:     "str_length (Bytes.create 3); ()",
+			expected: "Type mismatch between 'string' and 'bytes'",
+		},
+		{
+			what:     "Bytes.get returns char",
+			code:     "Bytes.get (Bytes.create 3) 0 + 1",
+			expected: "Type mismatch between 'int' and 'char'",
+		},
+		{
+			what:     "Buffer.add_char requires char",
+			code:     "Buffer.add_char (Buffer.create 1) \"a\"",
+			expected: "Type mismatch between 'char' and 'string'",
+		},
+		{
+			what:     "show returns string",
+			code:     "(show 42) + 1",
+			expected: "Type mismatch between 'int' and 'string'",
+		},
+		{
+			what:     "show with too many arguments",
+			code:     "show 1 2; ()",
+			expected: "Type of called function",
+		},
+		{
+			what:     "print_any with unknown argument type",
+			code:     "print_any None",
+			expected: "Cannot infer type of expression",
+		},
+		{
+			what:     "Printf.printf with mismatched argument",
+			code:     "Printf.printf \"%d\" 3.14",
+			expected: "Type mismatch between 'int' and 'float'",
+		},
+		{
+			what:     "Printf.sprintf with mismatched argument",
+			code:     "Printf.sprintf \"%s %f\" \"foo\" true",
+			expected: "Type mismatch between 'float' and 'bool'",
+		},
+		{
+			what:     "Printf.sprintf returns string",
+			code:     "(Printf.sprintf \"%d\" 42) + 1",
+			expected: "Type mismatch between 'int' and 'string'",
+		},
+		{
+			what:     "Printf.printf with too few arguments",
+			code:     "Printf.printf \"%d %d\" 42",
+			expected: "requires 2 argument(s) but 1 argument(s) given",
+		},
+		{
+			what:     "Printf.printf with too many arguments",
+			code:     "Printf.printf \"%d\" 1 2",
+			expected: "requires 1 argument(s) but 2 argument(s) given",
+		},
+		{
+			what:     "invalid format string",
+			code:     "Printf.printf \"%y\" 1",
+			expected: "Invalid format string: Unknown conversion 'y'",
+		},
 		{
 			what:     "&& is evaluated as bool",
 			code:     "(true && false) + 3",
@@ -479,3 +579,74 @@ func TestExternalDeclError(t *testing.T) {
 		t.Fatal("Unexpected error message:", msg)
 	}
 }
+
+func TestExternalFunctionTypeError(t *testing.T) {
+	for _, tc := range []struct {
+		what string
+		code string
+		want string
+	}{
+		{
+			what: "function value",
+			code: `external f: (int -> int) array = "c_f"; ()`,
+			want: "external value must not contain functions",
+		},
+		{
+			what: "returning function",
+			code: `external f: int -> (int -> int) = "c_f"; ()`,
+			want: "external function must not return functions",
+		},
+		{
+			what: "function in tuple parameter",
+			code: `external f: (int * (int -> int)) -> unit = "c_f"; ()`,
+			want: "function in parameter type 'int * (int -> int)' cannot be passed to C",
+		},
+		{
+			what: "callback taking function",
+			code: `external f: ((int -> int) -> int) -> unit = "c_f"; ()`,
+			want: "callback '(int -> int) -> int' must not take functions",
+		},
+		{
+			what: "callback returning function",
+			code: `external f: (int -> (int -> int) option) -> unit = "c_f"; ()`,
+			want: "callback 'int -> (int -> int) option' must not return functions",
+		},
+	} {
+		t.Run(tc.what, func(t *testing.T) {
+			s := locerr.NewDummySource(tc.code)
+			tree, err := syntax.Parse(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			env := types.NewEnv()
+			if err := AlphaTransform(tree, env); err != nil {
+				t.Fatal(err)
+			}
+			err = NewInferer(env).Infer(tree)
+			if err == nil {
+				t.Fatal("Error should have occurred")
+			}
+			if msg := err.Error(); !strings.Contains(msg, tc.want) {
+				t.Fatal("Unexpected error message:", msg)
+			}
+		})
+	}
+}
+
+func TestExternalCallback(t *testing.T) {
+	s := locerr.NewDummySource(`external f: (int -> bool) -> (int * float -> unit) -> unit = "c_f"; ()`)
+	tree, err := syntax.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := types.NewEnv()
+	if err := AlphaTransform(tree, env); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewInferer(env).Infer(tree); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := env.Externals["f"]; !ok {
+		t.Fatal("External function taking callbacks was not registered:", env.Externals)
+	}
+}
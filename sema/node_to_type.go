@@ -12,12 +12,15 @@ type nodeTypeConv struct {
 }
 
 func newNodeTypeConv(decls []*ast.TypeDecl) (*nodeTypeConv, error) {
-	conv := &nodeTypeConv{make(map[string]Type, len(decls)+5 /*primitives*/), true}
+	conv := &nodeTypeConv{make(map[string]Type, len(decls)+8 /*primitives*/), true}
 	conv.aliases["unit"] = UnitType
 	conv.aliases["int"] = IntType
 	conv.aliases["bool"] = BoolType
 	conv.aliases["float"] = FloatType
+	conv.aliases["char"] = CharType
 	conv.aliases["string"] = StringType
+	conv.aliases["bytes"] = BytesType
+	conv.aliases["buffer"] = BufferType
 
 	for _, decl := range decls {
 		t, err := conv.nodeToType(decl.Type, -1)
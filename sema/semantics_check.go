@@ -6,6 +6,7 @@ package sema
 
 import (
 	"github.com/rhysd/gocaml/ast"
+	"github.com/rhysd/gocaml/common"
 	"github.com/rhysd/gocaml/mir"
 	"github.com/rhysd/gocaml/types"
 	"github.com/rhysd/locerr"
@@ -31,21 +32,37 @@ func Analyze(parsed *ast.AST) (*types.Env, InferredTypes, error) {
 // SemanticsCheck applies type inference, checks semantics of types and finally converts AST into MIR
 // with inferred type information.
 func SemanticsCheck(parsed *ast.AST) (*types.Env, *mir.Block, error) {
+	return SemanticsCheckWithTimer(parsed, nil)
+}
+
+// SemanticsCheckWithTimer is the same as SemanticsCheck, but it measures elapsed time of each phase
+// (alpha transform, type inference and conversion to MIR) with the timer.
+func SemanticsCheckWithTimer(parsed *ast.AST, timer *common.Timer) (*types.Env, *mir.Block, error) {
 	env := types.NewEnv()
+	var err error
 
 	// First, resolve all symbols by alpha transform
-	if err := AlphaTransform(parsed, env); err != nil {
+	timer.Measure("alpha", func() {
+		err = AlphaTransform(parsed, env)
+	})
+	if err != nil {
 		return nil, nil, locerr.NoteAt(parsed.Root.Pos(), err, "Alpha transform failed")
 	}
 
 	// Second, run unification on all nodes and dereference type variables
 	inferer := NewInferer(env)
-	if err := inferer.Infer(parsed); err != nil {
+	timer.Measure("infer", func() {
+		err = inferer.Infer(parsed)
+	})
+	if err != nil {
 		return nil, nil, locerr.NoteAt(parsed.Root.Pos(), err, "Type inference failed")
 	}
 
 	// Third, convert AST into MIR
-	block := ToMIR(parsed.Root, env, inferer.inferred, inferer.insts)
+	var block *mir.Block
+	timer.Measure("tomir", func() {
+		block = ToMIR(parsed.Root, env, inferer.inferred, inferer.insts)
+	})
 
 	return env, block, nil
 }
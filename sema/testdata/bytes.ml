@@ -0,0 +1,17 @@
+let b = Bytes.create 4 in
+let c: bytes = Bytes.of_string "hello" in
+Bytes.set b 0 'h';
+Bytes.blit c 1 b 1 3;
+print_char (Bytes.get b 1);
+println_int (Bytes.length b);
+println_str (Bytes.to_string b);
+println_bool (b = c);
+let buf: buffer = Buffer.create 8 in
+Buffer.add_string buf "foo";
+Buffer.add_char buf (Bytes.get c 0);
+println_int (Buffer.length buf);
+println_str (Buffer.contents buf);
+let o = Some buf in
+match o with
+| Some b -> Buffer.add_char b '!'
+| None -> ()
@@ -0,0 +1,14 @@
+let s = "hello" in
+let c = s.[0] in
+let d: char = '\n' in
+let o = Some c in
+print_char c;
+print_char d;
+println_bool (c = 'h');
+println_bool (c < 'z' && 'a' <= c);
+println_int (Char.code c + 1);
+print_char (Char.chr (Char.code c - 32));
+match o with
+| Some c -> print_char c
+| None -> ();
+Printf.printf "%c%c\n" s.[1] 'x'
@@ -0,0 +1,8 @@
+let s = show (1, 3.14, "foo") in
+print_str s;
+print_any [| Some true; None |];
+(fun x -> x + 1) |> print_any;
+let rec f x = show x in
+print_str (f 42);
+let show = 42 in
+print_int show
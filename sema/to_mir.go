@@ -157,11 +157,11 @@ func (e *emitter) emitLetTupleInsn(node *ast.LetTuple) *mir.Insn {
 	return body
 }
 
-func (e *emitter) emitAppInsn(node *ast.Apply) *mir.Insn {
+func (e *emitter) emitAppInsn(node, callee ast.Expr, argNodes []ast.Expr) *mir.Insn {
 	var prev *mir.Insn
 	var inst *types.Instantiation
 	var ident string
-	if ref, ok := node.Callee.(*ast.VarRef); ok {
+	if ref, ok := callee.(*ast.VarRef); ok {
 		// Note:
 		// When calling a variable directly, it may be direct call of a known function.
 		// Known function is optimized in closure transform. So we set name of variable
@@ -176,27 +176,141 @@ func (e *emitter) emitAppInsn(node *ast.Apply) *mir.Insn {
 		} else if _, ok := e.env.Externals[ref.Symbol.Name]; ok {
 			prev = e.insn(&mir.XRef{ref.Symbol.Name}, nil, ref)
 			ident = prev.Ident
+		} else if _, ok := types.Intrinsics[ref.Symbol.Name]; ok {
+			return e.emitIntrinsicInsn(node, ref.Symbol.Name, argNodes)
 		} else {
 			panic("FATAL: Unknown identifier: " + ref.Symbol.Name)
 		}
 	} else {
-		prev = e.emitInsn(node.Callee)
+		prev = e.emitInsn(callee)
 		ident = prev.Ident
 	}
-	args := make([]string, 0, len(node.Args))
-	for _, a := range node.Args {
+	args := make([]string, 0, len(argNodes))
+	for _, a := range argNodes {
 		arg := e.emitInsn(a)
 		arg.Append(prev)
 		args = append(args, arg.Ident)
 		prev = arg
 	}
-	insn := e.insn(&mir.App{ident, args, mir.DIRECT_CALL}, prev, node)
+	insn := e.insn(&mir.App{ident, args, mir.DIRECT_CALL, false}, prev, node)
 	if inst != nil {
 		e.env.RefInsts[insn.Ident] = inst
 	}
 	return insn
 }
 
+func (e *emitter) emitIntrinsicInsn(node ast.Expr, name string, argNodes []ast.Expr) *mir.Insn {
+	if len(argNodes) != 1 {
+		panic("FATAL: Intrinsic function must take exactly one argument: " + name)
+	}
+	arg := e.emitInsn(argNodes[0])
+	switch name {
+	case "show":
+		return e.insn(&mir.Show{arg.Ident}, arg, node)
+	case "print_any":
+		// Note: 'print_any x' is the same as 'print_str (show x)'
+		str := e.typedInsn(&mir.Show{arg.Ident}, types.StringType, arg, node)
+		return e.insn(&mir.App{"print_str", []string{str.Ident}, mir.EXTERNAL_CALL, false}, str, node)
+	default:
+		panic("FATAL: Unknown intrinsic function: " + name)
+	}
+}
+
+func (e *emitter) typedInsn(val mir.Val, ty types.Type, prev *mir.Insn, node ast.Expr) *mir.Insn {
+	id := e.genID()
+	e.env.DeclTable[id] = ty
+	return mir.Concat(mir.NewInsn(id, val, node.Pos()), prev)
+}
+
+func (e *emitter) emitPrintfInsn(node *ast.Printf) *mir.Insn {
+	// Note:
+	// 'Printf.printf' and 'Printf.sprintf' are expanded to calls of runtime functions at compile time
+	// since format string is always a literal. Each conversion specification is converted to a string
+	// by '__format_*' runtime function and all pieces are joined into one GC-allocated buffer by
+	// '__str_join'.
+	//
+	//   Printf.printf "%d: %s\n" n s
+	//
+	//   $k1 = ref n
+	//   $k2 = ref s
+	//   $k3 = string "%d"
+	//   $k4 = appx __format_int$builtin $k3,$k1
+	//   $k5 = string ": "
+	//   $k6 = string "\n"
+	//   $k7 = arrlit $k4,$k5,$k2,$k6
+	//   $k8 = appx __str_join$builtin $k7
+	//   $k9 = appx print_str $k8
+	specs, err := parseFormat(node.Format.Value)
+	if err != nil {
+		panic("FATAL: Format string was not checked at type inference: " + err.Error())
+	}
+
+	var prev *mir.Insn
+	args := make([]string, 0, len(node.Args))
+	for _, a := range node.Args {
+		arg := e.emitInsn(a)
+		arg.Append(prev)
+		args = append(args, arg.Ident)
+		prev = arg
+	}
+
+	pieces := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		if spec.isText() {
+			prev = e.typedInsn(&mir.String{spec.text}, types.StringType, prev, node)
+			pieces = append(pieces, prev.Ident)
+			continue
+		}
+
+		arg := args[0]
+		args = args[1:]
+		if spec.text == "%s" {
+			// String argument can be a piece as-is
+			pieces = append(pieces, arg)
+			continue
+		}
+
+		var callee string
+		switch spec.argType().(type) {
+		case *types.Int:
+			callee = "__format_int$builtin"
+		case *types.Float:
+			callee = "__format_float$builtin"
+		case *types.String:
+			callee = "__format_str$builtin"
+		case *types.Char:
+			callee = "__format_char$builtin"
+		case *types.Bool:
+			callee = "__format_bool$builtin"
+		}
+		prev = e.typedInsn(&mir.String{spec.text}, types.StringType, prev, node)
+		app := &mir.App{callee, []string{prev.Ident, arg}, mir.EXTERNAL_CALL, false}
+		prev = e.typedInsn(app, types.StringType, prev, node)
+		pieces = append(pieces, prev.Ident)
+	}
+
+	var str *mir.Insn
+	switch len(pieces) {
+	case 0:
+		str = e.typedInsn(&mir.String{""}, types.StringType, prev, node)
+	case 1:
+		if prev != nil && prev.Ident == pieces[0] {
+			str = prev
+		} else {
+			str = e.typedInsn(&mir.Ref{pieces[0]}, types.StringType, prev, node)
+		}
+	default:
+		arr := e.typedInsn(&mir.ArrLit{pieces}, &types.Array{types.StringType}, prev, node)
+		str = e.typedInsn(&mir.App{"__str_join$builtin", []string{arr.Ident}, mir.EXTERNAL_CALL, false}, types.StringType, arr, node)
+	}
+
+	if node.Sprintf {
+		// Result of 'Printf.sprintf' is the formatted string
+		return str
+	}
+	return e.insn(&mir.App{"print_str", []string{str.Ident}, mir.EXTERNAL_CALL, false}, str, node)
+}
+
 func (e *emitter) emitInsn(node ast.Expr) *mir.Insn {
 	switch n := node.(type) {
 	case *ast.Unit:
@@ -209,6 +323,8 @@ func (e *emitter) emitInsn(node ast.Expr) *mir.Insn {
 		return e.insn(&mir.Float{n.Value}, nil, node)
 	case *ast.String:
 		return e.insn(&mir.String{n.Value}, nil, node)
+	case *ast.Char:
+		return e.insn(&mir.Char{n.Value}, nil, node)
 	case *ast.Not:
 		i := e.emitInsn(n.Child)
 		return e.insn(&mir.Unary{mir.NOT, i.Ident}, i, node)
@@ -248,6 +364,11 @@ func (e *emitter) emitInsn(node ast.Expr) *mir.Insn {
 		return e.emitBinaryInsn(mir.AND, n.Left, n.Right, node)
 	case *ast.Or:
 		return e.emitBinaryInsn(mir.OR, n.Left, n.Right, node)
+	case *ast.Concat:
+		l := e.emitInsn(n.Left)
+		r := e.emitInsn(n.Right)
+		r.Append(l)
+		return e.insn(&mir.App{"str_concat", []string{l.Ident, r.Ident}, mir.EXTERNAL_CALL, false}, r, node)
 	case *ast.Eq:
 		return e.emitBinaryInsn(mir.EQ, n.Left, n.Right, node)
 	case *ast.NotEq:
@@ -279,7 +400,14 @@ func (e *emitter) emitInsn(node ast.Expr) *mir.Insn {
 	case *ast.LetRec:
 		return e.emitFunInsn(n)
 	case *ast.Apply:
-		return e.emitAppInsn(n)
+		return e.emitAppInsn(n, n.Callee, n.Args)
+	case *ast.Printf:
+		return e.emitPrintfInsn(n)
+	case *ast.Pipe:
+		// Note:
+		// 'x |> f' is the same as 'f x'. Emitting it as a usual application makes a call to a known
+		// function a direct call. No closure is allocated for it.
+		return e.emitAppInsn(n, n.Callee, []ast.Expr{n.Arg})
 	case *ast.Tuple:
 		var prev *mir.Insn
 		len := len(n.Elems)
@@ -323,6 +451,11 @@ func (e *emitter) emitInsn(node ast.Expr) *mir.Insn {
 		rhs := e.emitInsn(n.Assignee)
 		rhs.Append(index)
 		return e.insn(&mir.ArrStore{array.Ident, index.Ident, rhs.Ident}, rhs, node)
+	case *ast.StringGet:
+		str := e.emitInsn(n.String)
+		index := e.emitInsn(n.Index)
+		index.Append(str)
+		return e.insn(&mir.StrLoad{str.Ident, index.Ident}, index, node)
 	case *ast.ArraySize:
 		array := e.emitInsn(n.Target)
 		return e.insn(&mir.ArrLen{array.Ident}, array, node)
@@ -172,6 +172,117 @@ func TestEmitInsn(t *testing.T) {
 				"app $k4 $k5 ; type=int",
 			},
 		},
+		{
+			"pipeline operator",
+			"let rec f a = a + 1 in 3 |> f",
+			[]string{
+				"fun a$t2 ; type=int -> int",
+				"BEGIN: body (f$t1)",
+				"ref a$t2 ; type=int",
+				"int 1 ; type=int",
+				"binary + $k1 $k2 ; type=int",
+				"END: body (f$t1)",
+				"int 3 ; type=int",
+				"app f$t1 $k4 ; type=int",
+			},
+		},
+		{
+			"application operator",
+			"let rec f a = a + 1 in f @@ 3",
+			[]string{
+				"fun a$t2 ; type=int -> int",
+				"BEGIN: body (f$t1)",
+				"ref a$t2 ; type=int",
+				"int 1 ; type=int",
+				"binary + $k1 $k2 ; type=int",
+				"END: body (f$t1)",
+				"int 3 ; type=int",
+				"app f$t1 $k4 ; type=int",
+			},
+		},
+		{
+			"string concat operator",
+			`"foo" ^ "bar"`,
+			[]string{
+				`string "foo" ; type=string`,
+				`string "bar" ; type=string`,
+				"appx str_concat $k1,$k2 ; type=string",
+			},
+		},
+		{
+			"printf",
+			`Printf.printf "%d: %s\n" 42 "foo"`,
+			[]string{
+				"int 42 ; type=int",
+				`string "foo" ; type=string`,
+				`string "%d" ; type=string`,
+				"appx __format_int$builtin $k3,$k1 ; type=string",
+				`string ": " ; type=string`,
+				`string "\n" ; type=string`,
+				"arrlit $k4,$k5,$k2,$k6 ; type=string array",
+				"appx __str_join$builtin $k7 ; type=string",
+				"appx print_str $k8 ; type=unit",
+			},
+		},
+		{
+			"sprintf",
+			`Printf.sprintf "%s" "foo"`,
+			[]string{
+				`string "foo" ; type=string`,
+			},
+		},
+		{
+			"printf with only text",
+			`Printf.printf "hello"`,
+			[]string{
+				`string "hello" ; type=string`,
+				"appx print_str $k1 ; type=unit",
+			},
+		},
+		{
+			"char literal",
+			"'a'",
+			[]string{
+				"char 'a' ; type=char",
+			},
+		},
+		{
+			"string indexing",
+			`"foo".[1]`,
+			[]string{
+				`string "foo" ; type=string`,
+				"int 1 ; type=int",
+				"strload $k2 $k1 ; type=char",
+			},
+		},
+		{
+			"show intrinsic",
+			"show (1, true)",
+			[]string{
+				"int 1 ; type=int",
+				"bool true ; type=bool",
+				"tuple $k1,$k2 ; type=int * bool",
+				"show $k3 ; type=string",
+			},
+		},
+		{
+			"print_any intrinsic",
+			"print_any [| 3.14 |]",
+			[]string{
+				"float 3.140000 ; type=float",
+				"arrlit $k1 ; type=float array",
+				"show $k2 ; type=string",
+				"appx print_str $k3 ; type=unit",
+			},
+		},
+		{
+			"show intrinsic with pipeline operator",
+			`"foo" |> show`,
+			[]string{
+				`string "foo" ; type=string`,
+				"show $k1 ; type=string",
+			},
+		},
 		{
 			"tuple literal",
 			"(1, 2, 3)",
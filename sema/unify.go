@@ -104,8 +104,8 @@ func assignVar(v *Var, t Type) *locerr.Error {
 
 func Unify(left, right Type) *locerr.Error {
 	switch l := left.(type) {
-	case *Unit, *Bool, *Int, *Float, *String:
-		// Types for Unit, Bool, Int, Float and String are singleton instance.
+	case *Unit, *Bool, *Int, *Float, *Char, *String, *Bytes, *Buffer:
+		// Types for primitive types are singleton instance.
 		// So comparing directly is OK.
 		if l == right {
 			return nil
@@ -78,6 +78,12 @@ import (
 %token<token> LBRACKET
 %token<token> RBRACKET
 %token<token> EXTERNAL
+%token<token> BAR_GREATER
+%token<token> AT_AT
+%token<token> CARET
+%token<token> PRINTF
+%token<token> SPRINTF
+%token<token> CHAR_LITERAL
 
 %nonassoc IN
 %right prec_let
@@ -93,7 +99,8 @@ import (
 %left COMMA
 %left BAR_BAR
 %left AND_AND
-%left EQUAL LESS_GREATER LESS GREATER LESS_EQUAL GREATER_EQUAL
+%left EQUAL LESS_GREATER LESS GREATER LESS_EQUAL GREATER_EQUAL BAR_GREATER
+%right AT_AT CARET
 %left PLUS MINUS PLUS_DOT MINUS_DOT
 %left STAR SLASH STAR_DOT SLASH_DOT PERCENT
 %right prec_unary_minus
@@ -202,6 +209,12 @@ exp:
 		{ $$ = &ast.And{$1, $3} }
 	| exp BAR_BAR exp
 		{ $$ = &ast.Or{$1, $3} }
+	| exp BAR_GREATER exp
+		{ $$ = &ast.Pipe{$1, $3} }
+	| exp AT_AT exp
+		{ $$ = &ast.Apply{$1, []ast.Expr{$3}} }
+	| exp CARET exp
+		{ $$ = &ast.Concat{$1, $3} }
 	| IF seq_exp THEN seq_exp ELSE exp
 		%prec prec_if
 		{ $$ = &ast.If{$1, $2, $4, $6} }
@@ -252,6 +265,18 @@ exp:
 		{ $$ = &ast.ArraySize{$1, $2} }
 	| SOME simple_exp
 		{ $$ = &ast.Some{$1, $2} }
+	| PRINTF simple_exp args
+		%prec prec_app
+		{ $$ = printf($1, $2, $3, false, yylex) }
+	| PRINTF simple_exp
+		%prec prec_app
+		{ $$ = printf($1, $2, nil, false, yylex) }
+	| SPRINTF simple_exp args
+		%prec prec_app
+		{ $$ = printf($1, $2, $3, true, yylex) }
+	| SPRINTF simple_exp
+		%prec prec_app
+		{ $$ = printf($1, $2, nil, true, yylex) }
 	| FUN params simple_type_annotation MINUS_GREATER seq_exp
 		%prec prec_fun
 		{
@@ -331,6 +356,8 @@ simple_exp:
 				$$ = &ast.Float{$1, f}
 			}
 		}
+	| CHAR_LITERAL
+		{ $$ = charLit($1, yylex) }
 	| STRING_LITERAL
 		{
 			from := $1.Value()
@@ -355,6 +382,8 @@ simple_exp:
 		{ $$ = &ast.VarRef{$1, ast.NewSymbol($1.Value())} }
 	| simple_exp DOT LPAREN exp RPAREN
 		{ $$ = &ast.ArrayGet{$1, $4} }
+	| simple_exp DOT LBRACKET exp RBRACKET
+		{ $$ = &ast.StringGet{$1, $4} }
 
 match_arm_start:
 	WITH BAR | WITH
@@ -457,4 +486,43 @@ func sym(tok *token.Token) *ast.Symbol {
 	}
 }
 
+func printf(tok *token.Token, format ast.Expr, args []ast.Expr, sprintf bool, yylex yyLexer) ast.Expr {
+	lit, ok := format.(*ast.String)
+	if !ok {
+		yylex.Error(fmt.Sprintf("Format string of '%s' must be a string literal", tok.Value()))
+		// Note:
+		// Parsing continues after the error. Return a placeholder node with empty format instead
+		// of nil so that nil node is never contained in the tree.
+		lit = &ast.String{tok, ""}
+	}
+	return &ast.Printf{tok, lit, args, sprintf}
+}
+
+func charLit(tok *token.Token, yylex yyLexer) ast.Expr {
+	lit := tok.Value()
+	body := lit[1 : len(lit)-1]
+
+	// Note: '\ddd' is a decimal escape in OCaml. But strconv.UnquoteChar() parses it as octal.
+	if len(body) == 4 && body[0] == '\\' {
+		if n, err := strconv.Atoi(body[1:]); err == nil {
+			if n > 255 {
+				yylex.Error(fmt.Sprintf("Character code in character literal %s must be in range 0-255", lit))
+				return nil
+			}
+			return &ast.Char{tok, byte(n)}
+		}
+	}
+
+	r, _, tail, err := strconv.UnquoteChar(body, '\'')
+	if err != nil || tail != "" {
+		yylex.Error(fmt.Sprintf("Parse error at character literal %s", lit))
+		return nil
+	}
+	if r > 255 {
+		yylex.Error(fmt.Sprintf("Character literal %s must be a single byte character", lit))
+		return nil
+	}
+	return &ast.Char{tok, byte(r)}
+}
+
 // vim: noet
@@ -52,11 +52,27 @@ func NewLexer(src *locerr.Source) *Lexer {
 func (l *Lexer) Lex() {
 	// Set top to peek current rune
 	l.forward()
+	l.skipShebang()
 	for l.state != nil {
 		l.state = l.state(l)
 	}
 }
 
+// skipShebang skips a shebang line such as '#!/usr/bin/env -S gocaml run' at the head of source. It
+// makes a source file executable as a script.
+func (l *Lexer) skipShebang() {
+	if !bytes.HasPrefix(l.src.Code, []byte("#!")) {
+		return
+	}
+	for !l.eof && l.top != '\n' {
+		l.eat()
+	}
+	if !l.eof {
+		l.eat() // Eat '\n'
+	}
+	l.start = l.current
+}
+
 func (l *Lexer) emit(kind token.Kind) {
 	l.Tokens <- token.Token{
 		kind,
@@ -280,6 +296,9 @@ func lexBar(l *Lexer) stateFn {
 	case ']':
 		l.eat()
 		l.emit(token.BAR_RBRACKET)
+	case '>':
+		l.eat()
+		l.emit(token.BAR_GREATER)
 	default:
 		l.emit(token.BAR)
 	}
@@ -301,6 +320,19 @@ func lexLogicalAnd(l *Lexer) stateFn {
 	return lex
 }
 
+func lexAtAt(l *Lexer) stateFn {
+	l.eat() // Eat first '@'
+
+	if l.top != '@' {
+		l.expected("application operator @@", l.top)
+		return nil
+	}
+	l.eat()
+	l.emit(token.AT_AT)
+
+	return lex
+}
+
 func lexLess(l *Lexer) stateFn {
 	l.eat()
 	switch l.top {
@@ -380,9 +412,9 @@ func isDigit(r rune) bool {
 	return '0' <= r && r <= '9'
 }
 
-func lexArrayCreate(l *Lexer) stateFn {
+func lexModuleMember(l *Lexer) stateFn {
 	if l.top != '.' {
-		l.expected("'.' for 'Array.make'", l.top)
+		l.expected("'.' for accessing module member (e.g. 'Array.make')", l.top)
 		return nil
 	}
 	l.eat()
@@ -395,7 +427,7 @@ func lexArrayCreate(l *Lexer) stateFn {
 
 	switch ident {
 	// Note:
-	// Ate 'Array' and '.' but no token was emitted. So 'Array.' remains as
+	// Ate module name (e.g. 'Array') and '.' but no token was emitted. So 'Array.' remains as
 	// current token string.
 	case "Array.make":
 		l.emit(token.ARRAY_MAKE)
@@ -403,8 +435,20 @@ func lexArrayCreate(l *Lexer) stateFn {
 	case "Array.length":
 		l.emit(token.ARRAY_LENGTH)
 		return lex
+	case "Printf.printf":
+		l.emit(token.PRINTF)
+		return lex
+	case "Printf.sprintf":
+		l.emit(token.SPRINTF)
+		return lex
+	case "Char.code", "Char.chr",
+		"Bytes.create", "Bytes.length", "Bytes.get", "Bytes.set", "Bytes.blit", "Bytes.of_string", "Bytes.to_string",
+		"Buffer.create", "Buffer.length", "Buffer.add_char", "Buffer.add_string", "Buffer.contents":
+		// Note: They are usual built-in functions. So they are treated as identifiers.
+		l.emit(token.IDENT)
+		return lex
 	default:
-		l.emitIllegal(fmt.Sprintf("Expected 'make' or 'length' for Array.make but got '%s'", ident))
+		l.emitIllegal(fmt.Sprintf("Unknown module member '%s'", ident))
 		return nil
 	}
 }
@@ -414,8 +458,9 @@ func lexIdent(l *Lexer) stateFn {
 		return nil
 	}
 	i := string(l.src.Code[l.start.Offset:l.current.Offset])
-	if i == "Array" {
-		return lexArrayCreate
+	switch i {
+	case "Array", "Printf", "Char", "Bytes", "Buffer":
+		return lexModuleMember
 	}
 	l.emitIdent(i)
 	return lex
@@ -440,6 +485,32 @@ func lexStringLiteral(l *Lexer) stateFn {
 	return nil
 }
 
+// e.g. 'a', '\n', '\x41'
+func lexCharLiteral(l *Lexer) stateFn {
+	l.eat() // Eat first '\''
+	switch l.top {
+	case '\\':
+		// Skip escape ('\' and following chars). The escape sequence is checked by parser.
+		l.eat()
+		l.eat()
+		for isLetter(l.top) || isDigit(l.top) {
+			l.eat()
+		}
+	case '\'':
+		l.emitIllegal("Empty character literal")
+		return nil
+	default:
+		l.eat()
+	}
+	if l.top != '\'' {
+		l.expected("closing ' of character literal", l.top)
+		return nil
+	}
+	l.eat()
+	l.emit(token.CHAR_LITERAL)
+	return lex
+}
+
 func lexLbracket(l *Lexer) stateFn {
 	l.eat() // Eat '['
 	if l.top == '|' {
@@ -494,8 +565,15 @@ func lex(l *Lexer) stateFn {
 			return lexBar
 		case '&':
 			return lexLogicalAnd
+		case '@':
+			return lexAtAt
+		case '^':
+			l.eat()
+			l.emit(token.CARET)
 		case '"':
 			return lexStringLiteral
+		case '\'':
+			return lexCharLiteral
 		case ':':
 			l.eat()
 			l.emit(token.COLON)
@@ -111,3 +111,22 @@ func TestLexingIllegal(t *testing.T) {
 		})
 	}
 }
+
+func TestLexingShebang(t *testing.T) {
+	s := locerr.NewDummySource("#!/usr/bin/env gocaml run\nprintln_int 42")
+	l := NewLexer(s)
+	go l.Lex()
+	tok := <-l.Tokens
+	if tok.Kind != token.IDENT || tok.String() == "" {
+		t.Fatal("Unexpected first token:", tok.String())
+	}
+	if tok.Start.Line != 2 || tok.Start.Column != 1 {
+		t.Fatal("Shebang line was not skipped correctly:", tok.Start.String())
+	}
+	for tok.Kind != token.EOF {
+		if tok.Kind == token.ILLEGAL {
+			t.Fatal(tok.String())
+		}
+		tok = <-l.Tokens
+	}
+}
@@ -2,6 +2,7 @@ package syntax
 
 import (
 	"fmt"
+	"github.com/rhysd/gocaml/ast"
 	"github.com/rhysd/gocaml/token"
 	"github.com/rhysd/locerr"
 	"io/ioutil"
@@ -55,6 +56,11 @@ func TestErrorHeuristic(t *testing.T) {
 			codes: []string{"[]", "[1; 2]", "[true; false;]"},
 			msg:   "List literal is not implemented yet.",
 		},
+		{
+			what:  "non-literal format string",
+			codes: []string{"let f = \"%d\" in Printf.printf f 42", "print_str (Printf.sprintf (\"%s\" ^ \"\") \"a\")"},
+			msg:   "must be a string literal",
+		},
 		{
 			what:  "multiple types in paren",
 			codes: []string{"let t: (int, bool) = 42 in ()"},
@@ -79,6 +85,21 @@ func TestErrorHeuristic(t *testing.T) {
 	}
 }
 
+func TestPrintfPlaceholderOnError(t *testing.T) {
+	src := locerr.NewDummySource("Printf.printf 42")
+	tok := &token.Token{token.PRINTF, locerr.Pos{0, 1, 1, src}, locerr.Pos{13, 1, 14, src}, src}
+	arg := &token.Token{token.INT, locerr.Pos{14, 1, 15, src}, locerr.Pos{16, 1, 17, src}, src}
+	l := &pseudoLexer{}
+	e := printf(tok, &ast.Int{arg, 42}, nil, false, l)
+	if l.err == nil {
+		t.Fatal("Non-literal format string must raise an error")
+	}
+	p, ok := e.(*ast.Printf)
+	if !ok || p.Format == nil {
+		t.Fatalf("Placeholder node should be returned on error but got %v", e)
+	}
+}
+
 func TestParseInvalid(t *testing.T) {
 	src := locerr.NewDummySource("")
 	tokens := []token.Token{
@@ -169,6 +190,39 @@ func TestInvalidStringLiteral(t *testing.T) {
 	}
 }
 
+func TestInvalidCharLiteral(t *testing.T) {
+	for _, lit := range []string{"'\\256'", "'\\q'", "'あ'"} {
+		t.Run(lit, func(t *testing.T) {
+			src := locerr.NewDummySource(lit)
+			size := len(lit)
+			tokens := []token.Token{
+				token.Token{
+					Kind:  token.CHAR_LITERAL,
+					Start: locerr.Pos{0, 1, 1, src},
+					End:   locerr.Pos{size, 1, size, src},
+					File:  src,
+				},
+				token.Token{
+					Kind:  token.EOF,
+					Start: locerr.Pos{size, 1, size, src},
+					End:   locerr.Pos{size, 1, size, src},
+					File:  src,
+				},
+			}
+			c := make(chan token.Token)
+			go func() {
+				for _, t := range tokens {
+					c <- t
+				}
+			}()
+			r, err := ParseTokens(c)
+			if err == nil {
+				t.Fatalf("Invalid char literal must raise an error but got %v", r)
+			}
+		})
+	}
+}
+
 func TestTooLargeFloatLiteral(t *testing.T) {
 	src := locerr.NewDummySource("1.7976931348623159e308")
 	tokens := []token.Token{
@@ -0,0 +1,14 @@
+let b = Bytes.create 3 in
+Bytes.set b 0 'a';
+print_char (Bytes.get b 0);
+println_int (Bytes.length b);
+let c = Bytes.of_string "hello" in
+Bytes.blit c 1 b 0 2;
+println_str (Bytes.to_string b);
+let buf = Buffer.create 16 in
+Buffer.add_string buf "foo";
+Buffer.add_char buf '!';
+println_int (Buffer.length buf);
+println_str (Buffer.contents buf);
+let f = Buffer.add_char in
+f buf 'a'
@@ -0,0 +1,15 @@
+let c = 'a' in
+let s = "hello" in
+print_char c;
+print_char '\n';
+print_char '\'';
+print_char '"';
+print_char '\\';
+print_char '\065';
+print_char '\x42';
+print_char s.[0];
+print_char (s.[1 + 2]);
+println_int (Char.code 'a');
+print_char (Char.chr 99);
+let f = Char.code in
+f 'x' = 120; ()
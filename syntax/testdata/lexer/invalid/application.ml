@@ -0,0 +1,2 @@
+let rec f x = x in
+f @ 42
@@ -0,0 +1 @@
+'ab'
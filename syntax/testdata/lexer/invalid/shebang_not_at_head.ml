@@ -0,0 +1,2 @@
+println_int 42
+#!/usr/bin/env gocaml run
@@ -0,0 +1,7 @@
+let rec succ x = x + 1 in
+let rec twice f x = f (f x) in
+3 |> succ |> print_int;
+print_int @@ twice succ @@ 1 + 2;
+10 |> (fun x -> x * 2) |> print_int;
+let s = "foo" ^ "bar" ^ int_to_str (1 |> succ) in
+println_str @@ s ^ "!"
@@ -0,0 +1,5 @@
+let n = 42 in
+Printf.printf "%d: %s\n" n "foo";
+Printf.printf "hello\n";
+let s = Printf.sprintf "%-5d|%.2f|%b" (n + 1) 3.14 true in
+println_str (Printf.sprintf "%s" s)
@@ -0,0 +1,2 @@
+#!/usr/bin/env gocaml run
+println_int 42
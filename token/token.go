@@ -62,6 +62,12 @@ const (
 	LBRACKET
 	RBRACKET
 	EXTERNAL
+	BAR_GREATER
+	AT_AT
+	CARET
+	PRINTF
+	SPRINTF
+	CHAR_LITERAL
 	EOF
 )
 
@@ -120,6 +126,12 @@ var tokenTable = [...]string{
 	LBRACKET:       "[",
 	RBRACKET:       "]",
 	EXTERNAL:       "external",
+	BAR_GREATER:    "|>",
+	AT_AT:          "@@",
+	CARET:          "^",
+	PRINTF:         "Printf.printf",
+	SPRINTF:        "Printf.sprintf",
+	CHAR_LITERAL:   "CHAR_LITERAL",
 }
 
 // Token instance for GoCaml.
@@ -1,9 +1,12 @@
 package types
 
-// TODO:
-// 'builtin' does not mean 'external' always. For example, we might introduce polymorphic function
-// `print: 'a -> ()` in the future as built-in function. We need to separate external symbols and
-// built-in symbols for implementing special functions or intrinsics.
+// Intrinsics are built-in polymorphic functions which are not external symbols. They can't be
+// implemented as C functions since they need to know the concrete type of their argument. Instead,
+// compiler expands them after monomorphization. They can be used only as callee of application.
+var Intrinsics = map[string]*Fun{
+	"show":      &Fun{StringType, []Type{NewGeneric()}},
+	"print_any": &Fun{UnitType, []Type{NewGeneric()}},
+}
 
 func builtinPopulatedTable() map[string]*External {
 	return map[string]*External{
@@ -12,17 +15,34 @@ func builtinPopulatedTable() map[string]*External {
 		"nan":                        &External{FloatType, "gocaml_nan"},
 		"print_int":                  &External{&Fun{UnitType, []Type{IntType}}, "print_int"},
 		"print_bool":                 &External{&Fun{UnitType, []Type{BoolType}}, "print_bool"},
+		"print_char":                 &External{&Fun{UnitType, []Type{CharType}}, "print_char"},
 		"print_float":                &External{&Fun{UnitType, []Type{FloatType}}, "print_float"},
 		"print_str":                  &External{&Fun{UnitType, []Type{StringType}}, "print_str"},
 		"println_int":                &External{&Fun{UnitType, []Type{IntType}}, "println_int"},
 		"println_bool":               &External{&Fun{UnitType, []Type{BoolType}}, "println_bool"},
 		"println_float":              &External{&Fun{UnitType, []Type{FloatType}}, "println_float"},
 		"println_str":                &External{&Fun{UnitType, []Type{StringType}}, "println_str"},
+		"print_backtrace":            &External{&Fun{UnitType, []Type{UnitType}}, "print_backtrace"},
 		"float_to_int":               &External{&Fun{IntType, []Type{FloatType}}, "float_to_int"},
 		"int_to_float":               &External{&Fun{FloatType, []Type{IntType}}, "int_to_float"},
 		"str_length":                 &External{&Fun{IntType, []Type{StringType}}, "str_length"},
 		"__str_equal$builtin":        &External{&Fun{BoolType, []Type{StringType, StringType}}, "__str_equal"},
 		"str_concat":                 &External{&Fun{StringType, []Type{StringType, StringType}}, "str_concat"},
+		"__str_join$builtin":         &External{&Fun{StringType, []Type{&Array{StringType}}}, "__str_join"},
+		"__format_int$builtin":       &External{&Fun{StringType, []Type{StringType, IntType}}, "__format_int"},
+		"__format_float$builtin":     &External{&Fun{StringType, []Type{StringType, FloatType}}, "__format_float"},
+		"__format_str$builtin":       &External{&Fun{StringType, []Type{StringType, StringType}}, "__format_str"},
+		"__format_char$builtin":      &External{&Fun{StringType, []Type{StringType, CharType}}, "__format_char"},
+		"__format_bool$builtin":      &External{&Fun{StringType, []Type{StringType, BoolType}}, "__format_bool"},
+		"__arith_error$builtin":      &External{&Fun{UnitType, []Type{StringType, StringType, IntType, IntType}}, "__arith_error"},
+		"__show_bool$builtin":        &External{&Fun{StringType, []Type{BoolType}}, "__show_bool"},
+		"__show_float$builtin":       &External{&Fun{StringType, []Type{FloatType}}, "__show_float"},
+		"__show_char$builtin":        &External{&Fun{StringType, []Type{CharType}}, "__show_char"},
+		"__show_str$builtin":         &External{&Fun{StringType, []Type{StringType}}, "__show_str"},
+		"__show_tuple$builtin":       &External{&Fun{StringType, []Type{&Array{StringType}}}, "__show_tuple"},
+		"__show_array$builtin":       &External{&Fun{StringType, []Type{&Array{StringType}}}, "__show_array"},
+		"__show_some$builtin":        &External{&Fun{StringType, []Type{StringType}}, "__show_some"},
+		"__show_bytes$builtin":       &External{&Fun{StringType, []Type{BytesType}}, "__show_bytes"},
 		"str_sub":                    &External{&Fun{StringType, []Type{StringType, IntType, IntType}}, "str_sub"},
 		"int_to_str":                 &External{&Fun{StringType, []Type{IntType}}, "int_to_str"},
 		"float_to_str":               &External{&Fun{StringType, []Type{FloatType}}, "float_to_str"},
@@ -30,6 +50,20 @@ func builtinPopulatedTable() map[string]*External {
 		"str_to_float":               &External{&Fun{FloatType, []Type{StringType}}, "str_to_float"},
 		"get_line":                   &External{&Fun{StringType, []Type{UnitType}}, "get_line"},
 		"get_char":                   &External{&Fun{StringType, []Type{UnitType}}, "get_char"},
+		"Char.code":                  &External{&Fun{IntType, []Type{CharType}}, "char_code"},
+		"Char.chr":                   &External{&Fun{CharType, []Type{IntType}}, "char_chr"},
+		"Bytes.create":               &External{&Fun{BytesType, []Type{IntType}}, "bytes_create"},
+		"Bytes.length":               &External{&Fun{IntType, []Type{BytesType}}, "bytes_length"},
+		"Bytes.get":                  &External{&Fun{CharType, []Type{BytesType, IntType}}, "bytes_get"},
+		"Bytes.set":                  &External{&Fun{UnitType, []Type{BytesType, IntType, CharType}}, "bytes_set"},
+		"Bytes.blit":                 &External{&Fun{UnitType, []Type{BytesType, IntType, BytesType, IntType, IntType}}, "bytes_blit"},
+		"Bytes.of_string":            &External{&Fun{BytesType, []Type{StringType}}, "bytes_of_string"},
+		"Bytes.to_string":            &External{&Fun{StringType, []Type{BytesType}}, "bytes_to_string"},
+		"Buffer.create":              &External{&Fun{BufferType, []Type{IntType}}, "buffer_create"},
+		"Buffer.length":              &External{&Fun{IntType, []Type{BufferType}}, "buffer_length"},
+		"Buffer.add_char":            &External{&Fun{UnitType, []Type{BufferType, CharType}}, "buffer_add_char"},
+		"Buffer.add_string":          &External{&Fun{UnitType, []Type{BufferType, StringType}}, "buffer_add_string"},
+		"Buffer.contents":            &External{&Fun{StringType, []Type{BufferType}}, "buffer_contents"},
 		"to_char_code":               &External{&Fun{IntType, []Type{StringType}}, "to_char_code"},
 		"from_char_code":             &External{&Fun{StringType, []Type{IntType}}, "from_char_code"},
 		"bit_and":                    &External{&Fun{IntType, []Type{IntType, IntType}}, "bit_and"},
@@ -71,3 +105,19 @@ func builtinPopulatedTable() map[string]*External {
 		"disable_garbage_collection": &External{&Fun{UnitType, []Type{UnitType}}, "disable_garbage_collection"},
 	}
 }
+
+var builtinCNames = func() map[string]struct{} {
+	names := map[string]struct{}{}
+	for _, e := range builtinPopulatedTable() {
+		names[e.CName] = struct{}{}
+	}
+	return names
+}()
+
+// IsBuiltin returns true when the C symbol name is of a built-in external symbol defined in runtime.
+// C symbol names of external symbols are unique. So user-defined external symbols never have the
+// same C names as built-in ones.
+func IsBuiltin(cName string) bool {
+	_, ok := builtinCNames[cName]
+	return ok
+}
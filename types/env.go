@@ -52,6 +52,17 @@ type Env struct {
 	//
 	// Note: This is set in sema/deref.go
 	PolyTypes map[Type][]*Instantiation
+	// Mappings from alpha-transformed variable names to their names in source (e.g. 'x$t2' => 'x').
+	// They are used for debug information.
+	//
+	// Note: This is set in sema/alpha_transform.go
+	DisplayNames map[string]string
+	// Mappings from function names to their mangled symbol names (e.g. 'g$t3' => '_GC1f1g').
+	// They are used as symbol names of functions in generated code. Please see package mangle
+	// for the mangling scheme.
+	//
+	// Note: This is set in sema/alpha_transform.go and updated in mono/monomorphize.go
+	MangledNames map[string]string
 }
 
 // NewEnv creates empty Env instance.
@@ -61,6 +72,8 @@ func NewEnv() *Env {
 		builtinPopulatedTable(),
 		map[string]*Instantiation{},
 		nil,
+		map[string]string{},
+		map[string]string{},
 	}
 }
 
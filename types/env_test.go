@@ -58,3 +58,14 @@ func TestEnvHasBuiltins(t *testing.T) {
 		t.Fatal("'print_int' is not found though it is builtin:", env.Externals)
 	}
 }
+
+func TestIsBuiltin(t *testing.T) {
+	for _, e := range NewEnv().Externals {
+		if !IsBuiltin(e.CName) {
+			t.Error("Builtin symbol is not regarded as builtin:", e.CName)
+		}
+	}
+	if IsBuiltin("my_external_func") {
+		t.Error("Symbol not defined in runtime should not be builtin")
+	}
+}
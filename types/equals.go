@@ -4,7 +4,7 @@ package types
 // not seen, but free or bound (.IsGeneric() or not) is seen.
 func Equals(l, r Type) bool {
 	switch l := l.(type) {
-	case *Unit, *Int, *Float, *Bool, *String:
+	case *Unit, *Int, *Float, *Bool, *Char, *String, *Bytes, *Buffer:
 		return l == r
 	case *Tuple:
 		r, ok := r.(*Tuple)
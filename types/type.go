@@ -37,6 +37,13 @@ func (t *Float) String() string {
 	return "float"
 }
 
+type Char struct {
+}
+
+func (t *Char) String() string {
+	return "char"
+}
+
 type String struct {
 }
 
@@ -44,6 +51,22 @@ func (t *String) String() string {
 	return "string"
 }
 
+// Mutable byte sequence
+type Bytes struct {
+}
+
+func (t *Bytes) String() string {
+	return "bytes"
+}
+
+// Growable string buffer. Its representation is hidden in runtime.
+type Buffer struct {
+}
+
+func (t *Buffer) String() string {
+	return "buffer"
+}
+
 type Fun struct {
 	Ret    Type
 	Params []Type
@@ -123,7 +146,10 @@ var (
 	BoolType   = &Bool{}
 	IntType    = &Int{}
 	FloatType  = &Float{}
+	CharType   = &Char{}
 	StringType = &String{}
+	BytesType  = &Bytes{}
+	BufferType = &Buffer{}
 )
 
 type toString struct {
@@ -155,7 +181,7 @@ func (toStr *toString) newGenName() string {
 
 func (toStr *toString) ofType(t Type) string {
 	switch t := t.(type) {
-	case *Unit, *Bool, *Int, *Float, *String:
+	case *Unit, *Bool, *Int, *Float, *Char, *String, *Bytes, *Buffer:
 		// Monomorphic types
 		return t.String()
 	case *Fun:
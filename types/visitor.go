@@ -42,3 +42,27 @@ func Visit(vis Visitor, t Type) {
 
 	vis.VisitBottomup(t)
 }
+
+type typeVarFinder struct {
+	found bool
+}
+
+func (f *typeVarFinder) VisitTopdown(t Type) Visitor {
+	if v, ok := t.(*Var); ok && v.Ref == nil {
+		f.found = true
+	}
+	if f.found {
+		return nil
+	}
+	return f
+}
+
+func (f *typeVarFinder) VisitBottomup(t Type) {}
+
+// HasTypeVar returns whether the type contains unresolved type variables. Types containing them
+// are polymorphic and should be instantiated by monomorphization.
+func HasTypeVar(t Type) bool {
+	f := &typeVarFinder{}
+	Visit(f, t)
+	return f.found
+}
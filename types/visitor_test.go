@@ -83,3 +83,22 @@ func TestVisitStop(t *testing.T) {
 		t.Fatal("Only root should be visited:", v.last.String())
 	}
 }
+
+func TestHasTypeVar(t *testing.T) {
+	cases := []struct {
+		input    Type
+		expected bool
+	}{
+		{IntType, false},
+		{&Fun{IntType, []Type{&Tuple{[]Type{BoolType, NewVar(FloatType, 0)}}}}, false},
+		{NewGeneric(), true},
+		{&Fun{UnitType, []Type{IntType, &Array{NewGeneric()}}}, true},
+		{&Option{NewVar(nil, 1)}, true},
+	}
+
+	for _, tc := range cases {
+		if actual := HasTypeVar(tc.input); actual != tc.expected {
+			t.Errorf("Expected HasTypeVar(%s) to be %v but actually %v", tc.input.String(), tc.expected, actual)
+		}
+	}
+}